@@ -2,12 +2,54 @@
 // Client.
 package agdc
 
-// UpstreamGroupName is a type for the name of an upstream group.
-//
-// TODO(e.burkov):  Add validation method, consider using the rules for
-// prometheus labels.
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/validate"
+)
+
+// UpstreamGroupName is a type for the name of an upstream group.  A valid name
+// is also a valid and readable value for Prometheus labels.
 type UpstreamGroupName string
 
+// MaxUpstreamGroupNameLen is the maximum length of a valid upstream group name
+// in bytes.
+const MaxUpstreamGroupNameLen = 128
+
+// type check
+var _ validate.Interface = UpstreamGroupName("")
+
+// Validate implements the [validate.Interface] interface for
+// UpstreamGroupName.  A valid name is a non-empty UTF-8 string of printable
+// characters no longer than [MaxUpstreamGroupNameLen] bytes.
+func (n UpstreamGroupName) Validate() (err error) {
+	// Don't wrap the error, because it's informative enough as is.
+	return validateName(string(n), MaxUpstreamGroupNameLen)
+}
+
+// validateName returns an error if n is not a non-empty UTF-8 string of
+// printable characters no longer than maxLen bytes.
+func validateName(n string, maxLen int) (err error) {
+	if n == "" {
+		return errors.ErrEmptyValue
+	} else if l := len(n); l > maxLen {
+		return fmt.Errorf("too long: got %d bytes, max %d", l, maxLen)
+	} else if !utf8.ValidString(n) {
+		return errors.Error("not a valid utf-8 string")
+	}
+
+	for i, r := range n {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("bad rune %q at index %d", r, i)
+		}
+	}
+
+	return nil
+}
+
 const (
 	// UpstreamGroupNameDefault is the reserved name for an upstream group that
 	// matches all requests and must appear in the configuration.
@@ -18,3 +60,20 @@ const (
 	// configuration.
 	UpstreamGroupNamePrivate UpstreamGroupName = "private"
 )
+
+// ClientName is a type for the name of a persistent client.
+type ClientName string
+
+// MaxClientNameLen is the maximum length of a valid client name in bytes.
+const MaxClientNameLen = 128
+
+// type check
+var _ validate.Interface = ClientName("")
+
+// Validate implements the [validate.Interface] interface for ClientName.  A
+// valid name is a non-empty UTF-8 string of printable characters no longer
+// than [MaxClientNameLen] bytes.
+func (n ClientName) Validate() (err error) {
+	// Don't wrap the error, because it's informative enough as is.
+	return validateName(string(n), MaxClientNameLen)
+}
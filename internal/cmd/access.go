@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/validate"
+)
+
+// accessConfig is the configuration of the access control of the clients.
+type accessConfig struct {
+	// Mode is the way of handling the rejected requests.
+	Mode dnssvc.RejectMode `yaml:"mode"`
+
+	// AllowedClients are the subnets of the clients allowed to send requests.
+	// If not empty, the requests from other clients are rejected.
+	AllowedClients []netutil.Prefix `yaml:"allowed_clients"`
+
+	// DisallowedClients are the subnets of the clients, which requests are
+	// rejected.  Those take precedence over AllowedClients.
+	DisallowedClients []netutil.Prefix `yaml:"disallowed_clients"`
+
+	// BlockedDomains are the domains, which requests for those and their
+	// subdomains are rejected.
+	BlockedDomains []string `yaml:"blocked_domains"`
+}
+
+// type check
+var _ validate.Interface = (*accessConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *accessConfig.
+func (c *accessConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	var errs []error
+	errs = append(errs, validatePrefixes("allowed_clients", c.AllowedClients)...)
+	errs = append(errs, validatePrefixes("disallowed_clients", c.DisallowedClients)...)
+
+	for i, d := range c.BlockedDomains {
+		err = netutil.ValidateDomainName(d)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("blocked_domains: at index %d: %w", i, err))
+		}
+	}
+
+	errs = append(errs, validateRejectMode(c.Mode))
+
+	return errors.Join(errs...)
+}
+
+// validateRejectMode returns an error if m isn't a valid reject mode.
+func validateRejectMode(m dnssvc.RejectMode) (err error) {
+	switch m {
+	case dnssvc.RejectModeRefused, dnssvc.RejectModeDrop:
+		return nil
+	default:
+		return fmt.Errorf("mode: %w: %q", errors.ErrBadEnumValue, m)
+	}
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c *accessConfig) toInternal() (conf *dnssvc.AccessConfig) {
+	return &dnssvc.AccessConfig{
+		AllowedClients:    netutil.UnembedPrefixes(c.AllowedClients),
+		DisallowedClients: netutil.UnembedPrefixes(c.DisallowedClients),
+		BlockedDomains:    c.BlockedDomains,
+		Mode:              c.Mode,
+	}
+}
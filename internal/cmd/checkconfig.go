@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/configmigrate"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/querylog"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/osutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"gopkg.in/yaml.v3"
+)
+
+// checkConfig validates the configuration file specified by opts and prints
+// all the errors found to stderr.  It doesn't modify the file, doesn't bind
+// any sockets, and doesn't download the rule lists or transfer the response
+// policy zones.
+func checkConfig(ctx context.Context, opts *options) (exitCode osutil.ExitCode) {
+	_, confPath, err := absolutePaths(opts.confPath)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+
+		return osutil.ExitCodeFailure
+	}
+
+	errs := checkConfigFile(ctx, confPath)
+	if len(errs) > 0 {
+		for _, e := range errs {
+			_, _ = fmt.Fprintln(os.Stderr, e)
+		}
+
+		return osutil.ExitCodeFailure
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "configuration file %q is valid\n", confPath)
+
+	return osutil.ExitCodeSuccess
+}
+
+// checkConfigFile returns all the errors found in the configuration file
+// located at confPath.  The errors are located within the file, where
+// possible.
+func checkConfigFile(ctx context.Context, confPath string) (errs []error) {
+	l := slogutil.NewDiscardLogger()
+
+	migrator := configmigrate.New(&configmigrate.Config{
+		Clock:      timeutil.SystemClock{},
+		Logger:     l,
+		ConfigPath: confPath,
+	})
+	data, current, err := migrator.DryRun(ctx, configmigrate.VersionLatest)
+	if err != nil {
+		return []error{&locatedError{err: err, path: confPath}}
+	}
+
+	if current != configmigrate.VersionLatest {
+		_, _ = fmt.Fprintf(
+			os.Stderr,
+			"configuration file needs migration from schema version %d to %d, "+
+				"positions refer to the migrated configuration\n",
+			current,
+			configmigrate.VersionLatest,
+		)
+	}
+
+	root := &yaml.Node{}
+	conf := &configuration{}
+	errs = decodeStrict(confPath, data, root, conf)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return locateErrors(confPath, root, err, nil)
+	}
+
+	dnsConf := conf.dnsToInternal(l, dnssvc.EmptyMetrics{}, querylog.Empty{})
+	dnsConf.CheckOnly = true
+
+	svc, err := dnssvc.New(ctx, dnsConf)
+	if err != nil {
+		return locateErrors(confPath, root, fmt.Errorf("dns: %w", err), nil)
+	}
+
+	err = svc.Shutdown(ctx)
+	if err != nil {
+		return []error{fmt.Errorf("releasing dns service: %w", err)}
+	}
+
+	return nil
+}
+
+// locateErrors splits err into the separate errors and locates each of them
+// within root.  keys are the keys of the enclosing nodes, as reported by the
+// validation errors.
+func locateErrors(confPath string, root *yaml.Node, err error, keys []string) (errs []error) {
+	if joined, ok := err.(errors.WrapperSlice); ok && isJoined(err, joined) {
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, locateErrors(confPath, root, e, keys)...)
+		}
+
+		return errs
+	}
+
+	if innerKeys, ok := unwrapKeys(err, keys); ok {
+		return locateErrors(confPath, root, errors.Unwrap(err), innerKeys)
+	}
+
+	// The message of the error itself may also start with a key, e.g. the
+	// ones returned by [validate.Positive].
+	n := findNode(root, append(slices.Clip(keys), strings.Split(err.Error(), ": ")...))
+	if scalar := findQuotedScalar(n, err.Error()); scalar != nil {
+		n = scalar
+	}
+
+	if len(keys) > 0 {
+		err = fmt.Errorf("%s: %w", strings.Join(keys, ": "), err)
+	}
+
+	return []error{&locatedError{
+		err:    err,
+		path:   confPath,
+		line:   n.Line,
+		column: n.Column,
+	}}
+}
+
+// unwrapKeys returns the keys err prefixes the error it wraps with, appended to
+// keys.  ok is false if err doesn't just prefix the wrapped error.
+func unwrapKeys(err error, keys []string) (innerKeys []string, ok bool) {
+	inner := errors.Unwrap(err)
+	if inner == nil {
+		return nil, false
+	}
+
+	prefix, ok := strings.CutSuffix(err.Error(), ": "+inner.Error())
+	if !ok {
+		return nil, false
+	}
+
+	return append(slices.Clip(keys), strings.Split(prefix, ": ")...), true
+}
+
+// isJoined returns true if err is the result of [errors.Join] of the errors
+// unwrapped from joined.
+func isJoined(err error, joined errors.WrapperSlice) (ok bool) {
+	var msgs []string
+	for _, e := range joined.Unwrap() {
+		msgs = append(msgs, e.Error())
+	}
+
+	return err.Error() == strings.Join(msgs, "\n")
+}
+
+// findNode returns the deepest node within root matching the validation error
+// keys.  root must not be nil.
+func findNode(root *yaml.Node, keys []string) (n *yaml.Node) {
+	n = root
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+
+	for _, k := range keys {
+		next := childNode(n, k)
+		if next == nil {
+			break
+		}
+
+		n = next
+	}
+
+	return n
+}
+
+// childNode returns the child of n corresponding to the validation error key
+// k, or nil if there is no such child.  k is either a mapping key, optionally
+// preceded by a word describing it, like `group "name"`, or an index within a
+// sequence in the form of "at index N".
+func childNode(n *yaml.Node, k string) (child *yaml.Node) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return mappingChild(n, k)
+	case yaml.SequenceNode:
+		return sequenceChild(n, k)
+	default:
+		return nil
+	}
+}
+
+// mappingChild returns the value of the mapping node n for the validation
+// error key k, or nil if there is no such value.
+func mappingChild(n *yaml.Node, k string) (child *yaml.Node) {
+	if _, quoted, ok := strings.Cut(k, ` "`); ok {
+		if unquoted, err := strconv.Unquote(`"` + quoted); err == nil {
+			k = unquoted
+		}
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == k {
+			return n.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// sequenceChild returns the item of the sequence node n for the validation
+// error key k, or nil if there is no such item.
+func sequenceChild(n *yaml.Node, k string) (child *yaml.Node) {
+	var idx int
+	_, err := fmt.Sscanf(k, "at index %d", &idx)
+	if err != nil || idx < 0 || idx >= len(n.Content) {
+		return nil
+	}
+
+	return n.Content[idx]
+}
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/validate"
+)
+
+// clientsConfig is the configuration of the named persistent clients.
+type clientsConfig map[agdc.ClientName]*clientConfig
+
+// type check
+var _ validate.Interface = (clientsConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for clientsConfig.
+func (c clientsConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	var errs []error
+	claimed := &[]claimedID{}
+	for _, name := range slices.Sorted(maps.Keys(c)) {
+		err = c[name].validate(name, claimed)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("client %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateGroups returns an error if c refers to the upstream groups missing
+// from groups or predefined, or if the identifiers of c are matched by groups
+// on their own.
+func (c clientsConfig) validateGroups(groups upstreamGroupsConfig) (err error) {
+	matched := clientOnlyMatches(groups)
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(c)) {
+		cli := c[name]
+		if cli == nil {
+			// Reported by the validation of the clients.
+			continue
+		}
+
+		for _, err = range cli.validateGroupRefs(groups, matched) {
+			errs = append(errs, fmt.Errorf("client %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// clientOnlyMatches returns the subnets matched by groups without any other
+// criteria mapped to the names of those groups.
+func clientOnlyMatches(groups upstreamGroupsConfig) (matched map[netip.Prefix]agdc.UpstreamGroupName) {
+	matched = map[netip.Prefix]agdc.UpstreamGroupName{}
+	for name, g := range groups {
+		if g == nil {
+			// Reported by the validation of the groups.
+			continue
+		}
+
+		for _, m := range g.Match {
+			if m != nil && m.toIndexedMatch() == (indexedMatch{client: m.Client.Prefix}) {
+				matched[unmapPrefix(m.Client.Prefix)] = name
+			}
+		}
+	}
+
+	return matched
+}
+
+// clientConfig is the configuration of a named persistent client.
+type clientConfig struct {
+	// UpstreamGroup is the name of the upstream group to route the requests
+	// from the client to.  If empty, the client is routed like any other.
+	UpstreamGroup agdc.UpstreamGroupName `yaml:"upstream_group"`
+
+	// IDs are the IP addresses and subnets identifying the client.
+	IDs []netutil.Prefix `yaml:"ids"`
+}
+
+// claimedID is an identifier of a client.
+type claimedID struct {
+	// prefix is the identifier with the IPv4-mapped IPv6 address unmapped.
+	prefix netip.Prefix
+
+	// name is the name of the client.
+	name agdc.ClientName
+}
+
+// validate returns an error if c is not a valid configuration of the client
+// named name.  claimed are the identifiers of the clients validated before, the
+// identifiers of c are appended to it.  claimed must not be nil.
+func (c *clientConfig) validate(name agdc.ClientName, claimed *[]claimedID) (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	var errs []error
+	err = name.Validate()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("name: %w", err))
+	}
+
+	if c.UpstreamGroup != "" {
+		err = c.UpstreamGroup.Validate()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("upstream_group: %w", err))
+		}
+	}
+
+	errs = append(errs, validate.NotEmptySlice("ids", c.IDs))
+	errs = append(errs, validatePrefixes("ids", c.IDs)...)
+	errs = append(errs, c.claimIDs(name, claimed)...)
+
+	return errors.Join(errs...)
+}
+
+// claimIDs appends the identifiers of c to claimed and returns the errors
+// about the ones already claimed by c and the ones overlapping the identifiers
+// of other clients.  c and claimed must not be nil.
+func (c *clientConfig) claimIDs(name agdc.ClientName, claimed *[]claimedID) (errs []error) {
+	for i, id := range c.IDs {
+		p := unmapPrefix(id.Prefix)
+		err := checkClaimed(name, p, *claimed)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ids: at index %d: %s: %w", i, id, err))
+
+			continue
+		}
+
+		*claimed = append(*claimed, claimedID{
+			prefix: p,
+			name:   name,
+		})
+	}
+
+	return errs
+}
+
+// checkClaimed returns an error if p is already claimed by the client named
+// name or overlaps an identifier of another client within claimed.
+func checkClaimed(name agdc.ClientName, p netip.Prefix, claimed []claimedID) (err error) {
+	for _, id := range claimed {
+		switch {
+		case id.name == name && id.prefix == p:
+			return errors.ErrDuplicated
+		case id.name != name && id.prefix.Overlaps(p):
+			return fmt.Errorf("overlaps %s of client %q", id.prefix, id.name)
+		default:
+			// Go on.
+		}
+	}
+
+	return nil
+}
+
+// unmapPrefix returns p with the address unmapped, if it's an IPv4-mapped IPv6
+// subnet not shorter than the mapping prefix.  Otherwise, p is returned as is.
+func unmapPrefix(p netip.Prefix) (res netip.Prefix) {
+	// mappedBits is the length of the prefix of the IPv4-mapped IPv6
+	// addresses.
+	const mappedBits = 96
+
+	if addr := p.Addr(); addr.Is4In6() && p.Bits() >= mappedBits {
+		return netip.PrefixFrom(addr.Unmap(), p.Bits()-mappedBits)
+	}
+
+	return p
+}
+
+// validateGroupRefs returns the errors of validating c against groups.
+// matched maps the subnets matched by groups on their own to the names of
+// those groups.  c must not be nil.
+func (c *clientConfig) validateGroupRefs(
+	groups upstreamGroupsConfig,
+	matched map[netip.Prefix]agdc.UpstreamGroupName,
+) (errs []error) {
+	if slices.Contains(predefinedGroups, c.UpstreamGroup) {
+		errs = append(errs, fmt.Errorf("upstream_group: predefined group %q", c.UpstreamGroup))
+	} else if _, ok := groups[c.UpstreamGroup]; c.UpstreamGroup != "" && !ok {
+		errs = append(errs, fmt.Errorf("upstream_group: no group named %q", c.UpstreamGroup))
+	}
+
+	for i, id := range c.IDs {
+		if name, ok := matched[unmapPrefix(id.Prefix)]; ok {
+			err := fmt.Errorf("%s: conflicts with match of group %q", id, name)
+			errs = append(errs, fmt.Errorf("ids: at index %d: %w", i, err))
+		}
+	}
+
+	return errs
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c clientsConfig) toInternal() (conf []*dnssvc.ClientConfig) {
+	for _, name := range slices.Sorted(maps.Keys(c)) {
+		cli := c[name]
+
+		prefixes := make([]netip.Prefix, 0, len(cli.IDs))
+		for _, id := range cli.IDs {
+			prefixes = append(prefixes, unmapPrefix(id.Prefix))
+		}
+
+		conf = append(conf, &dnssvc.ClientConfig{
+			Name:          name,
+			UpstreamGroup: cli.UpstreamGroup,
+			Prefixes:      prefixes,
+		})
+	}
+
+	return conf
+}
@@ -33,25 +33,30 @@ func Main() {
 		os.Exit(osutil.ExitCodeSuccess)
 	}
 
+	if opts.checkConfig {
+		os.Exit(checkConfig(ctx, opts))
+	}
+
 	envs, envsErrs := parseLogEnvs()
 	l, logFile, envsLoggerErr := newEnvLogger(ctx, opts, envs)
 
-	conf, err := handleServiceConfig(ctx, l, opts.serviceAction)
+	conf, confPath, err := handleServiceConfig(ctx, l, opts)
 	l, logFile, confLoggerErrs := newConfigLogger(ctx, l, logFile, opts, envs, conf)
 
 	reportPrevErrs(ctx, l, envsErrs, envsLoggerErr, confLoggerErrs)
 
 	prog := &program{
-		conf:    conf,
-		done:    make(chan struct{}),
-		errCh:   make(chan error),
-		logger:  l,
-		logFile: logFile,
+		conf:     conf,
+		confPath: confPath,
+		done:     make(chan struct{}),
+		errCh:    make(chan error),
+		logger:   l,
+		logFile:  logFile,
 	}
 
 	check(ctx, prog, err)
 
-	svc, err := osservice.New(prog, newServiceConfig())
+	svc, err := osservice.New(prog, newServiceConfig(confPath))
 	check(ctx, prog, err)
 
 	if opts.serviceAction != "" {
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
@@ -9,6 +10,8 @@ import (
 
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdcos"
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/configmigrate"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/querylog"
 	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
@@ -24,26 +27,40 @@ type configuration struct {
 	// DNS configures processing of DNS requests.
 	DNS *dnsConfig `yaml:"dns"`
 
+	// Clients configures the named persistent clients.
+	Clients clientsConfig `yaml:"clients,omitempty"`
+
+	// Control configures the control HTTP API.
+	Control *controlConfig `yaml:"control"`
+
 	// Debug configures debugging features.
 	Debug *debugConfig `yaml:"debug"`
 
 	// Log configures logging.
 	Log *logConfig `yaml:"log"`
 
+	// QueryLog configures the query log.
+	QueryLog *queryLogConfig `yaml:"query_log"`
+
+	// Reload configures reloading of the configuration without restarting.
+	Reload *reloadConfig `yaml:"reload"`
+
 	// SchemaVersion is the current version of this structure.  This is bumped
 	// each time the configuration changes breaking backwards compatibility.
 	SchemaVersion configmigrate.SchemaVersion `yaml:"schema_version"`
 }
 
-// defaultConfigName is the path to the configuration file.
-//
-// TODO(e.burkov):  Make configurable via flags or environment.
+// defaultConfigName is the name of the configuration file used when no path
+// is specified.  The file is expected to be in the same directory as the
+// executable.
 const defaultConfigName = "config.yaml"
 
-// absolutePaths return the default path to the configuration file.  It assumes
-// that the configuration file is located in the same directory as the
-// executable.
-func absolutePaths() (execPath, workDir string, err error) {
+// absolutePaths returns the absolute paths to the executable and to the
+// configuration file.  optPath is the path to the configuration file from the
+// command-line options, it takes precedence over the [envConfigPath]
+// environment variable.  If neither is set, the configuration file is assumed
+// to be located in the same directory as the executable.
+func absolutePaths(optPath string) (execPath, confPath string, err error) {
 	execPath, err = os.Executable()
 	if err != nil {
 		return "", "", fmt.Errorf("getting executable path: %w", err)
@@ -54,47 +71,56 @@ func absolutePaths() (execPath, workDir string, err error) {
 		return "", "", fmt.Errorf("getting absolute path of %q: %w", execPath, err)
 	}
 
-	workDir = filepath.Dir(absExecPath)
+	confPath = cmp.Or(optPath, os.Getenv(envConfigPath))
+	if confPath == "" {
+		return absExecPath, filepath.Join(filepath.Dir(absExecPath), defaultConfigName), nil
+	}
+
+	absConfPath, err := filepath.Abs(confPath)
+	if err != nil {
+		return "", "", fmt.Errorf("getting absolute path of %q: %w", confPath, err)
+	}
 
-	return absExecPath, workDir, nil
+	return absExecPath, absConfPath, nil
 }
 
 // handleServiceConfig returns the service configuration based on the specified
-// [serviceAction].
+// options.  confPath is the absolute path to the configuration file.
 func handleServiceConfig(
 	ctx context.Context,
 	l *slog.Logger,
-	action serviceAction,
-) (conf *configuration, err error) {
-	execPath, workDir, err := absolutePaths()
+	opts *options,
+) (conf *configuration, confPath string, err error) {
+	execPath, confPath, err := absolutePaths(opts.confPath)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
-		return nil, err
+		return nil, "", err
 	}
 
-	switch action {
+	switch opts.serviceAction {
 	case serviceActionNone:
-		return handleConfig(ctx, l, workDir)
+		conf, err = handleConfig(ctx, l, confPath)
+
+		return conf, confPath, err
 	case serviceActionInstall:
-		return nil, handleInstall(execPath, workDir)
+		return nil, confPath, handleInstall(execPath, confPath)
 	default:
 		// No service actions require configuration.
-		return nil, nil
+		return nil, confPath, nil
 	}
 }
 
-// handleConfig parses the configuration file located in the [workDir]
-// directory.
+// handleConfig migrates, parses, and validates the configuration file located
+// at confPath.
 func handleConfig(
 	ctx context.Context,
 	l *slog.Logger,
-	workDir string,
+	confPath string,
 ) (conf *configuration, err error) {
 	migrator := configmigrate.New(&configmigrate.Config{
-		Clock:          timeutil.SystemClock{},
-		Logger:         l.With(slogutil.KeyPrefix, "configmigrate"),
-		WorkingDir:     workDir,
-		ConfigFileName: defaultConfigName,
+		Clock:      timeutil.SystemClock{},
+		Logger:     l.With(slogutil.KeyPrefix, "configmigrate"),
+		ConfigPath: confPath,
 	})
 	err = migrator.Run(ctx, configmigrate.VersionLatest)
 	if err != nil {
@@ -102,7 +128,7 @@ func handleConfig(
 		return nil, err
 	}
 
-	conf, err = parseConfig(filepath.Join(workDir, defaultConfigName))
+	conf, err = parseConfig(confPath)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return nil, err
@@ -116,9 +142,9 @@ func handleConfig(
 	return conf, nil
 }
 
-// handleInstall creates and writes the default configuration file to the
-// [workDir] directory.
-func handleInstall(execPath, workDir string) (err error) {
+// handleInstall creates and writes the default configuration file to confPath,
+// unless it already exists.
+func handleInstall(execPath, confPath string) (err error) {
 	err = agdcos.ValidateExecPath(execPath)
 	if err != nil {
 		// locWarnMsg is a warning message that is printed to stderr when the
@@ -135,7 +161,7 @@ func handleInstall(execPath, workDir string) (err error) {
 		return err
 	}
 
-	err = writeDefaultConfig(filepath.Join(workDir, defaultConfigName))
+	err = writeDefaultConfig(confPath)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return err
@@ -144,23 +170,23 @@ func handleInstall(execPath, workDir string) (err error) {
 	return nil
 }
 
-// parseConfig parses the YAML configuration file located at path.
+// parseConfig parses the YAML configuration file located at path.  The unknown
+// fields are reported as errors, see [decodeStrict].
 func parseConfig(path string) (conf *configuration, err error) {
 	defer func() { err = errors.Annotate(err, "parsing configuration: %w") }()
 
-	// #nosec G304 -- Trust the path to the configuration file that is currently
-	// expected to be in the same directory as the binary.
-	f, err := os.Open(path)
+	// #nosec G304 -- Trust the path to the configuration file that is specified
+	// by the user or is located in the same directory as the binary.
+	data, err := os.ReadFile(path)
 	if err != nil {
 		// Don't wrap the error since there is already an annotation deferred.
 		return nil, err
 	}
-	defer func() { err = errors.WithDeferred(err, f.Close()) }()
 
 	conf = &configuration{}
-	err = yaml.NewDecoder(f).Decode(conf)
-	if err != nil {
-		return nil, err
+	errs := decodeStrict(path, data, &yaml.Node{}, conf)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 
 	return conf, nil
@@ -178,12 +204,21 @@ func (c *configuration) Validate() (err error) {
 	validators := container.KeyValues[string, validate.Interface]{{
 		Key:   "dns",
 		Value: c.DNS,
+	}, {
+		Key:   "control",
+		Value: c.Control,
 	}, {
 		Key:   "log",
 		Value: c.Log,
 	}, {
 		Key:   "debug",
 		Value: c.Debug,
+	}, {
+		Key:   "query_log",
+		Value: c.QueryLog,
+	}, {
+		Key:   "reload",
+		Value: c.Reload,
 	}}
 
 	var errs []error
@@ -191,5 +226,59 @@ func (c *configuration) Validate() (err error) {
 		errs = validate.Append(errs, v.Key, v.Value)
 	}
 
+	if c.Clients != nil {
+		errs = validate.Append(errs, "clients", c.Clients)
+		errs = append(errs, c.validateClientGroups())
+	}
+
+	errs = append(errs, c.validateRateLimitRefs())
+
 	return errors.Join(errs...)
 }
+
+// validateRateLimitRefs returns an error if the rate limit allowlists of c
+// refer to the clients or the upstream groups of c improperly.  c must not be
+// nil.
+func (c *configuration) validateRateLimitRefs() (err error) {
+	if c.DNS == nil || c.DNS.Server == nil || c.DNS.Server.RateLimit == nil || c.DNS.Upstream == nil {
+		// Either not configured or reported by the validation of the DNS
+		// configuration.
+		return nil
+	}
+
+	errs := c.DNS.Server.RateLimit.validateRefs(c.Clients, c.DNS.Upstream.Groups)
+	if len(errs) > 0 {
+		return fmt.Errorf("dns: server: rate_limit: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// validateClientGroups returns an error if the clients of c refer to the
+// upstream groups of c improperly.  c must not be nil.
+func (c *configuration) validateClientGroups() (err error) {
+	if c.DNS == nil || c.DNS.Upstream == nil {
+		// Reported by the validation of the DNS configuration.
+		return nil
+	}
+
+	err = c.Clients.validateGroups(c.DNS.Upstream.Groups)
+	if err != nil {
+		return fmt.Errorf("clients: %w", err)
+	}
+
+	return nil
+}
+
+// dnsToInternal converts the DNS configuration and the clients of c to the
+// internal representation.  c must be valid, m and ql must not be nil.
+func (c *configuration) dnsToInternal(
+	logger *slog.Logger,
+	m dnssvc.Metrics,
+	ql querylog.Interface,
+) (conf *dnssvc.Config) {
+	conf = c.DNS.toInternal(logger, m, ql)
+	conf.Clients = c.Clients.toInternal()
+
+	return conf
+}
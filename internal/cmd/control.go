@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/ctrlsvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/validate"
+	"gopkg.in/yaml.v3"
+)
+
+// controlConfig is the configuration for the control HTTP API.
+type controlConfig struct {
+	// BindAddress is the IP address to serve the control API on.
+	BindAddress netip.Addr `yaml:"bind_address"`
+
+	// Token is the bearer token required to use the control API.
+	Token string `yaml:"token"`
+
+	// Port is used to serve the control API.
+	Port uint16 `yaml:"port"`
+
+	// Enabled specifies if the control API is served.
+	Enabled bool `yaml:"enabled"`
+}
+
+// type check
+var _ validate.Interface = (*controlConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *controlConfig.
+func (c *controlConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	} else if !c.Enabled {
+		return nil
+	}
+
+	return errors.Join(
+		validate.NotEmpty("bind_address", c.BindAddress),
+		validate.Positive("port", c.Port),
+		validate.NotEmpty("token", c.Token),
+	)
+}
+
+// toInternal converts the configuration to the control service configuration.
+// c must be valid, dnsSvc and conf must not be nil.
+func (c *controlConfig) toInternal(
+	logger *slog.Logger,
+	dnsSvc ctrlsvc.DNSService,
+	conf ctrlsvc.Configurator,
+) (svcConf *ctrlsvc.Config) {
+	return &ctrlsvc.Config{
+		Logger:       logger.With(slogutil.KeyPrefix, "ctrlsvc"),
+		DNSService:   dnsSvc,
+		Configurator: conf,
+		Token:        c.Token,
+		Addr:         netip.AddrPortFrom(c.BindAddress, c.Port),
+	}
+}
+
+// redacted is the replacement for the secret values of the configuration.
+const redacted = "********"
+
+// redacted returns the YAML representation of c decoded into generic values,
+// with the secrets replaced: the control API token, and the user information
+// and the query values of all the URLs, such as the upstream addresses and the
+// rule list URLs.
+func (c *configuration) redacted() (conf map[string]any, err error) {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("encoding configuration: %w", err)
+	}
+
+	conf = map[string]any{}
+	err = yaml.Unmarshal(b, &conf)
+	if err != nil {
+		return nil, fmt.Errorf("decoding configuration: %w", err)
+	}
+
+	if ctrl, ok := conf["control"].(map[string]any); ok && ctrl["token"] != "" {
+		ctrl["token"] = redacted
+	}
+
+	redactURLs(conf)
+
+	return conf, nil
+}
+
+// redactURLs returns v with the secrets of all the URLs within it replaced,
+// see [redactURL].  The objects and the arrays are modified in place.
+func redactURLs(v any) (res any) {
+	switch v := v.(type) {
+	case string:
+		return redactURL(v)
+	case map[string]any:
+		for key, val := range v {
+			v[key] = redactURLs(val)
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = redactURLs(val)
+		}
+	default:
+		// Nothing to redact.
+	}
+
+	return v
+}
+
+// redactURL returns s with the user information and the query values replaced
+// if it's an absolute URL with any of those.  Otherwise, s is returned as is.
+func redactURL(s string) (res string) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" || (u.User == nil && u.RawQuery == "") {
+		return s
+	}
+
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+
+	if u.RawQuery != "" {
+		var params []string
+		for _, key := range slices.Sorted(maps.Keys(u.Query())) {
+			params = append(params, url.QueryEscape(key)+"="+redacted)
+		}
+
+		u.RawQuery = strings.Join(params, "&")
+	}
+
+	return u.String()
+}
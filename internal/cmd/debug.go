@@ -1,16 +1,23 @@
 package cmd
 
 import (
-	"fmt"
+	"log/slog"
+	"net/netip"
 
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/debugsvc"
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/validate"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // debugConfig is the configuration for debugging features.
 type debugConfig struct {
 	// Pprof configures profiling of the application.
 	Pprof *pprofConfig `yaml:"pprof"`
+
+	// Metrics configures the Prometheus metrics HTTP endpoint.
+	Metrics *metricsConfig `yaml:"metrics"`
 }
 
 // type check
@@ -22,16 +29,18 @@ func (c *debugConfig) Validate() (err error) {
 		return errors.ErrNoValue
 	}
 
-	err = c.Pprof.Validate()
-	if err != nil {
-		return fmt.Errorf("pprof: %w", err)
-	}
+	var errs []error
+	errs = validate.Append(errs, "pprof", c.Pprof)
+	errs = validate.Append(errs, "metrics", c.Metrics)
 
-	return nil
+	return errors.Join(errs...)
 }
 
 // pprofConfig is the configuration for Go-provided runtime profiling tool.
 type pprofConfig struct {
+	// BindAddress is the IP address to serve debug HTTP API on.
+	BindAddress netip.Addr `yaml:"bind_address"`
+
 	// Port is used to serve debug HTTP API.
 	Port uint16 `yaml:"port"`
 
@@ -46,7 +55,80 @@ var _ validate.Interface = (*pprofConfig)(nil)
 func (c *pprofConfig) Validate() (err error) {
 	if c == nil {
 		return errors.ErrNoValue
+	} else if !c.Enabled {
+		return nil
+	}
+
+	return errors.Join(
+		validate.NotEmpty("bind_address", c.BindAddress),
+		validate.Positive("port", c.Port),
+	)
+}
+
+// metricsConfig is the configuration for the Prometheus metrics HTTP
+// endpoint.
+type metricsConfig struct {
+	// BindAddress is the IP address to serve the metrics on.
+	BindAddress netip.Addr `yaml:"bind_address"`
+
+	// Port is used to serve the metrics.
+	Port uint16 `yaml:"port"`
+
+	// Enabled specifies if the metrics are served.
+	Enabled bool `yaml:"enabled"`
+}
+
+// type check
+var _ validate.Interface = (*metricsConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *metricsConfig.
+func (c *metricsConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	} else if !c.Enabled {
+		return nil
+	}
+
+	return errors.Join(
+		validate.NotEmpty("bind_address", c.BindAddress),
+		validate.Positive("port", c.Port),
+	)
+}
+
+// toInternal converts the debug configuration to the configurations of the
+// debug services.  The pprof handlers and the metrics are served by the same
+// service if their addresses are equal.  gatherer is used to gather the
+// metrics, if they're enabled.  c must be valid.
+func (c *debugConfig) toInternal(
+	logger *slog.Logger,
+	gatherer prometheus.Gatherer,
+) (confs []*debugsvc.Config) {
+	logger = logger.With(slogutil.KeyPrefix, "debugsvc")
+
+	var pprofConf *debugsvc.Config
+	if c.Pprof.Enabled {
+		pprofConf = &debugsvc.Config{
+			Logger: logger,
+			Addr:   netip.AddrPortFrom(c.Pprof.BindAddress, c.Pprof.Port),
+			Pprof:  true,
+		}
+		confs = append(confs, pprofConf)
+	}
+
+	if !c.Metrics.Enabled {
+		return confs
+	}
+
+	addr := netip.AddrPortFrom(c.Metrics.BindAddress, c.Metrics.Port)
+	if pprofConf != nil && pprofConf.Addr == addr {
+		pprofConf.Metrics = gatherer
+
+		return confs
 	}
 
-	return nil
+	return append(confs, &debugsvc.Config{
+		Logger:  logger,
+		Metrics: gatherer,
+		Addr:    addr,
+	})
 }
@@ -5,10 +5,12 @@ import (
 	"net"
 	"net/netip"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/configmigrate"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/netutil"
@@ -53,8 +55,38 @@ const (
 	// defaultPendingRequestsEnabled is the default value for the pending
 	// requests feature to be enabled.
 	defaultPendingRequestsEnabled = true
+
+	// defaultUsePrivateRDNS is the default value for resolving the private PTR
+	// requests using the private upstream group.
+	defaultUsePrivateRDNS = true
 )
 
+// defaultPrivateSubnets are the default subnets considered private, which are
+// the networks defined by RFC 6303.
+var defaultPrivateSubnets = []netutil.Prefix{
+	{Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+	{Prefix: netip.MustParsePrefix("127.0.0.0/8")},
+	{Prefix: netip.MustParsePrefix("169.254.0.0/16")},
+	{Prefix: netip.MustParsePrefix("172.16.0.0/12")},
+	{Prefix: netip.MustParsePrefix("192.0.2.0/24")},
+	{Prefix: netip.MustParsePrefix("192.168.0.0/16")},
+	{Prefix: netip.MustParsePrefix("198.51.100.0/24")},
+	{Prefix: netip.MustParsePrefix("203.0.113.0/24")},
+	{Prefix: netip.MustParsePrefix("255.255.255.255/32")},
+	{Prefix: netip.MustParsePrefix("::/128")},
+	{Prefix: netip.MustParsePrefix("::1/128")},
+	{Prefix: netip.MustParsePrefix("2001:db8::/32")},
+	{Prefix: netip.MustParsePrefix("fd00::/8")},
+	{Prefix: netip.MustParsePrefix("fe80::/10")},
+}
+
+// defaultTrustedProxies are the default subnets of the trusted proxies, which
+// only include the loopback addresses.
+var defaultTrustedProxies = []netutil.Prefix{
+	{Prefix: netip.MustParsePrefix("127.0.0.0/8")},
+	{Prefix: netip.PrefixFrom(netip.IPv6Loopback(), 128)},
+}
+
 // Values for the default cache configuration.
 const (
 	// defaultCacheEnabled is the default value for the cache usage.
@@ -77,6 +109,23 @@ const (
 	defaultPprofPort uint16 = 6060
 )
 
+// defaultPprofBindAddress is the default address to serve pprof handlers on.
+var defaultPprofBindAddress = netutil.IPv4Localhost()
+
+// Values for the default metrics configuration.
+const (
+	// defaultMetricsEnabled is the default value for the metrics to be served.
+	defaultMetricsEnabled = false
+
+	// defaultMetricsPort is the default port to serve the metrics locally.
+	// It's the same as the pprof one, so that both are served by a single
+	// server.
+	defaultMetricsPort uint16 = defaultPprofPort
+)
+
+// defaultMetricsBindAddress is the default address to serve the metrics on.
+var defaultMetricsBindAddress = netutil.IPv4Localhost()
+
 // Values for the default log configuration.
 const (
 	// defaultLogOutput is the default output for the logs.
@@ -93,16 +142,70 @@ const (
 	defaultLogVerbose = false
 )
 
+// Values for the default reload configuration.
+const (
+	// defaultReloadWatch is the default value for the configuration file
+	// watching to be enabled.
+	defaultReloadWatch = false
+
+	// defaultReloadInterval is the default interval between the checks of the
+	// configuration file for changes.
+	defaultReloadInterval = 10 * time.Second
+)
+
+// Values for the default control API configuration.
+const (
+	// defaultControlEnabled is the default value for the control API to be
+	// served.
+	defaultControlEnabled = false
+
+	// defaultControlPort is the default port to serve the control API locally.
+	defaultControlPort uint16 = 8053
+)
+
+// defaultControlBindAddress is the default address to serve the control API
+// on.
+var defaultControlBindAddress = netutil.IPv4Localhost()
+
+// Values for the default query log configuration.
+const (
+	// defaultQueryLogEnabled is the default value for the query log to be
+	// written.
+	defaultQueryLogEnabled = false
+
+	// defaultQueryLogFile is the default path to the query log file relative
+	// to the directory of the configuration file.
+	defaultQueryLogFile = "querylog.jsonl"
+
+	// defaultQueryLogMaxSize is the default maximum size of the query log file.
+	defaultQueryLogMaxSize = 100 * datasize.MB
+
+	// defaultQueryLogMaxAge is the default maximum age of the query log file.
+	defaultQueryLogMaxAge = 7 * timeutil.Day
+
+	// defaultQueryLogMaxBackups is the default maximum number of rotated query
+	// log files.
+	defaultQueryLogMaxBackups = 5
+
+	// defaultQueryLogBufferSize is the default maximum number of query log
+	// entries waiting to be written.
+	defaultQueryLogBufferSize = 1024
+
+	// defaultQueryLogAnonymizeClientIP is the default value for the
+	// anonymization of client IP addresses in the query log.
+	defaultQueryLogAnonymizeClientIP = false
+)
+
 // filterInterfaceAddrs gets the addresses as given by [net.InterfaceAddrs] and
-// filters out the ones that are not in the set.  It returns the [ipPortConfig]s
-// for the eligible addresses created using port p.
+// filters out the ones that are not in the set.  It returns the
+// [listenAddressConfig]s for the eligible addresses created using port p.
 //
 // TODO(e.burkov):  Use logger instead of [fmt.Fprintf].
 func filterInterfaceAddrs(
 	addrs []net.Addr,
 	set netutil.SubnetSet,
 	p uint16,
-) (confs []*ipPortConfig) {
+) (confs []*listenAddressConfig) {
 	for _, a := range addrs {
 		addrStr := a.String()
 		pref, err := netip.ParsePrefix(addrStr)
@@ -119,8 +222,9 @@ func filterInterfaceAddrs(
 			continue
 		}
 
-		confs = append(confs, &ipPortConfig{
-			Address: netip.AddrPortFrom(addr, p),
+		confs = append(confs, &listenAddressConfig{
+			Protocol: dnssvc.ProtocolDNS,
+			Address:  netip.AddrPortFrom(addr, p),
 		})
 
 		_, _ = fmt.Fprintf(os.Stderr, "adding %s to default listening addresses\n", addr)
@@ -137,7 +241,7 @@ func isListenable(addr netip.Addr) (ok bool) {
 
 // allListenableAddresses returns all the addresses of network interfaces that
 // are local and are not link-local unicast addresses.
-func allListenableAddresses() (laddrs []*ipPortConfig, err error) {
+func allListenableAddresses() (laddrs []*listenAddressConfig, err error) {
 	netAddrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return nil, fmt.Errorf("getting interfaces addresses: %w", err)
@@ -169,6 +273,9 @@ func newDefaultServerConfig() (c *serverConfig, err error) {
 		PendingRequests: &pendingRequestsConfig{
 			Enabled: defaultPendingRequestsEnabled,
 		},
+		PrivateSubnets: slices.Clone(defaultPrivateSubnets),
+		TrustedProxies: slices.Clone(defaultTrustedProxies),
+		UsePrivateRDNS: defaultUsePrivateRDNS,
 	}, nil
 }
 
@@ -193,7 +300,10 @@ func newDefaultDNSConfig() (c *dnsConfig, err error) {
 	}}
 	upstreamGroups := upstreamGroupsConfig{
 		agdc.UpstreamGroupNameDefault: &upstreamGroupConfig{
-			Address: defaultUpstreamAddress,
+			Mode: dnssvc.UpstreamModeLoadBalance,
+			Servers: []*urlConfig{{
+				Address: defaultUpstreamAddress,
+			}},
 			// TODO(e.burkov):  It marshals into an empty slice, but should not
 			// appear in the configuration file at all.
 			Match: nil,
@@ -238,10 +348,22 @@ func newDefaultConfig() (c *configuration, err error) {
 
 	return &configuration{
 		DNS: dnsConf,
+		Control: &controlConfig{
+			BindAddress: defaultControlBindAddress,
+			Token:       "",
+			Port:        defaultControlPort,
+			Enabled:     defaultControlEnabled,
+		},
 		Debug: &debugConfig{
 			Pprof: &pprofConfig{
-				Enabled: defaultPprofEnabled,
-				Port:    defaultPprofPort,
+				BindAddress: defaultPprofBindAddress,
+				Enabled:     defaultPprofEnabled,
+				Port:        defaultPprofPort,
+			},
+			Metrics: &metricsConfig{
+				BindAddress: defaultMetricsBindAddress,
+				Enabled:     defaultMetricsEnabled,
+				Port:        defaultMetricsPort,
 			},
 		},
 		Log: &logConfig{
@@ -250,6 +372,19 @@ func newDefaultConfig() (c *configuration, err error) {
 			Timestamp: defaultLogTimestamp,
 			Verbose:   defaultLogVerbose,
 		},
+		QueryLog: &queryLogConfig{
+			File:              defaultQueryLogFile,
+			MaxSize:           defaultQueryLogMaxSize,
+			MaxAge:            timeutil.Duration(defaultQueryLogMaxAge),
+			MaxBackups:        defaultQueryLogMaxBackups,
+			BufferSize:        defaultQueryLogBufferSize,
+			Enabled:           defaultQueryLogEnabled,
+			AnonymizeClientIP: defaultQueryLogAnonymizeClientIP,
+		},
+		Reload: &reloadConfig{
+			Interval: timeutil.Duration(defaultReloadInterval),
+			Watch:    defaultReloadWatch,
+		},
 		SchemaVersion: configmigrate.VersionLatest,
 	}, nil
 }
@@ -259,8 +394,8 @@ func newDefaultConfig() (c *configuration, err error) {
 func writeDefaultConfig(path string) (err error) {
 	defer func() { err = errors.Annotate(err, "writing default configuration: %w") }()
 
-	// #nosec G304 -- Trust the path to the configuration file that is currently
-	// expected to be in the same directory as the binary.
+	// #nosec G304 -- Trust the path to the configuration file that is specified
+	// by the user or is located in the same directory as the binary.
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
 	if err != nil {
 		if errors.Is(err, os.ErrExist) {
@@ -1,14 +1,15 @@
 package cmd
 
 import (
+	"fmt"
 	"log/slog"
 	"net/netip"
 
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/querylog"
 	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
-	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/validate"
 )
 
@@ -28,6 +29,27 @@ type dnsConfig struct {
 
 	// Fallback configures the fallback DNS upstream servers.
 	Fallback *fallbackConfig `yaml:"fallback"`
+
+	// Filtering configures filtering of the DNS requests.  If it's nil, the
+	// requests aren't filtered.
+	Filtering *filteringConfig `yaml:"filtering,omitempty"`
+
+	// LocalRecords configures the records answered without using the
+	// upstreams.  If it's nil, all the requests are forwarded to the
+	// upstreams.
+	LocalRecords *localRecordsConfig `yaml:"local_records,omitempty"`
+
+	// Rewrites configures the rewrites of the requests.  If it's nil, no
+	// requests are rewritten.
+	Rewrites *rewritesConfig `yaml:"rewrites,omitempty"`
+
+	// RPZ configures the response policy zones applied to the requests
+	// resolved using the upstreams.  If it's nil, no policies are applied.
+	RPZ *rpzConfig `yaml:"rpz,omitempty"`
+
+	// Zones configures the zones answered authoritatively without using the
+	// upstreams.
+	Zones zonesConfig `yaml:"zones,omitempty"`
 }
 
 // type check
@@ -54,6 +76,9 @@ func (c *dnsConfig) Validate() (err error) {
 	}, {
 		Key:   "fallback",
 		Value: c.Fallback,
+	}, {
+		Key:   "zones",
+		Value: c.Zones,
 	}}
 
 	var errs []error
@@ -61,31 +86,99 @@ func (c *dnsConfig) Validate() (err error) {
 		errs = validate.Append(errs, v.Key, v.Value)
 	}
 
+	if c.Filtering != nil {
+		errs = validate.Append(errs, "filtering", c.Filtering)
+	}
+
+	if c.LocalRecords != nil {
+		errs = validate.Append(errs, "local_records", c.LocalRecords)
+	}
+
+	if c.Rewrites != nil {
+		errs = validate.Append(errs, "rewrites", c.Rewrites)
+	}
+
+	if c.RPZ != nil {
+		errs = validate.Append(errs, "rpz", c.RPZ)
+	}
+
+	errs = append(errs, c.validateGroupRefs()...)
+
 	return errors.Join(errs...)
 }
 
+// validateGroupRefs returns the errors of validating the upstream groups of c
+// against the filtering and the zones configurations.  c must not be nil.
+func (c *dnsConfig) validateGroupRefs() (errs []error) {
+	if c.Upstream == nil {
+		return nil
+	}
+
+	groups := c.Upstream.Groups
+	for _, err := range []error{
+		c.Filtering.validateGroups(groups),
+		c.Zones.validateGroups(groups),
+	} {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("upstream: groups: %w", err))
+		}
+	}
+
+	return errs
+}
+
 // toInternal converts the DNS configuration to the internal representation.  c
-// must be valid.
-func (c *dnsConfig) toInternal(logger *slog.Logger) (conf *dnssvc.Config) {
-	listenAddrs := make([]netip.AddrPort, 0, len(c.Server.ListenAddresses))
-	for _, s := range c.Server.ListenAddresses {
-		listenAddrs = append(listenAddrs, s.Address)
-	}
-
-	return &dnssvc.Config{
-		BaseLogger: logger,
-		Logger:     logger.With(slogutil.KeyPrefix, "dnssvc"),
-		// TODO(e.burkov):  Consider making configurable.
-		PrivateSubnets:  netutil.SubnetSetFunc(netutil.IsLocallyServed),
+// must be valid, m and ql must not be nil.
+func (c *dnsConfig) toInternal(
+	logger *slog.Logger,
+	m dnssvc.Metrics,
+	ql querylog.Interface,
+) (conf *dnssvc.Config) {
+	conf = &dnssvc.Config{
+		BaseLogger:      logger,
+		Logger:          logger.With(slogutil.KeyPrefix, "dnssvc"),
+		PrivateSubnets:  c.Server.privateSubnets(),
+		TrustedProxies:  c.Server.trustedProxies(),
 		Cache:           c.Cache.toInternal(),
 		Bootstrap:       c.Bootstrap.toInternal(),
 		Upstreams:       c.Upstream.toInternal(),
 		Fallbacks:       c.Fallback.toInternal(),
+		Metrics:         m,
+		QueryLog:        ql,
 		ClientGetter:    dnssvc.DefaultClientGetter{},
-		ListenAddrs:     listenAddrs,
+		ListenAddrs:     c.Server.toInternal(),
 		BindRetry:       c.Server.BindRetry.toInternal(),
 		PendingRequests: c.Server.PendingRequests.toInternal(),
+		UsePrivateRDNS:  c.Server.UsePrivateRDNS,
+	}
+
+	if c.Filtering != nil {
+		conf.Filtering = c.Filtering.toInternal(logger)
+	}
+
+	if c.LocalRecords != nil {
+		conf.LocalRecords = c.LocalRecords.toInternal()
+	}
+
+	if c.Rewrites != nil {
+		conf.Rewrites = c.Rewrites.toInternal()
 	}
+
+	if c.Server.Access != nil {
+		conf.Access = c.Server.Access.toInternal()
+	}
+
+	if c.Server.RateLimit != nil {
+		conf.RateLimit = c.Server.RateLimit.toInternal()
+	}
+
+	conf.Zones = c.Zones.toInternal()
+
+	if c.RPZ != nil {
+		conf.ResponsePolicies = c.RPZ.toInternal(logger)
+	}
+
+	return conf
 }
 
 // ipPortConfig is the object for configuring an entity having an IP address
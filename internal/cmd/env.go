@@ -9,6 +9,10 @@ import (
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 )
 
+// envConfigPath is the environment variable that specifies the path to the
+// configuration file.  The command-line option takes precedence over it.
+const envConfigPath = "CONFIG_PATH"
+
 // Constants that define the log environment variables
 const (
 	envLogOutput    = "LOG_OUTPUT"
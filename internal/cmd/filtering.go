@@ -0,0 +1,322 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/filter"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/netutil/urlutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/AdguardTeam/golibs/validate"
+)
+
+// filteringConfig is the configuration for filtering the DNS requests.
+type filteringConfig struct {
+	// Lists are the rule lists by their names.
+	Lists filterListsConfig `yaml:"lists"`
+
+	// BlockedResponse configures the responses to the blocked requests.
+	BlockedResponse *blockedResponseConfig `yaml:"blocked_response"`
+
+	// Clients are the rule lists for the client subnets, which take precedence
+	// over the lists of the upstream groups.
+	Clients []*filteringClientConfig `yaml:"clients"`
+
+	// RefreshInterval is the interval between the downloads of a list from
+	// its URL.
+	RefreshInterval timeutil.Duration `yaml:"refresh_interval"`
+
+	// Timeout constrains the time for downloading a list.
+	Timeout timeutil.Duration `yaml:"timeout"`
+}
+
+// type check
+var _ validate.Interface = (*filteringConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *filteringConfig.
+func (c *filteringConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	errs := []error{
+		validate.Positive("refresh_interval", c.RefreshInterval),
+		validate.Positive("timeout", c.Timeout),
+	}
+	errs = validate.Append(errs, "lists", c.Lists)
+	errs = validate.Append(errs, "blocked_response", c.BlockedResponse)
+
+	prefixes := container.NewMapSet[netip.Prefix]()
+	for i, cli := range c.Clients {
+		err = c.validateClient(cli, prefixes)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("clients: at index %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateClient returns an error if cli is not a valid client configuration
+// within c.  prefixes are the subnets of the clients validated earlier.
+func (c *filteringConfig) validateClient(
+	cli *filteringClientConfig,
+	prefixes *container.MapSet[netip.Prefix],
+) (err error) {
+	if cli == nil {
+		return errors.ErrNoValue
+	}
+
+	p := cli.Client.Prefix
+	if !p.IsValid() {
+		return fmt.Errorf("client: %w", errors.ErrEmptyValue)
+	} else if p != p.Masked() {
+		return fmt.Errorf("client: %s must has at most %d significant bits", p, p.Bits())
+	} else if prefixes.Has(p) {
+		return fmt.Errorf("client: %w: %s", errors.ErrDuplicated, p)
+	}
+
+	prefixes.Add(p)
+
+	// Don't wrap the error, because it's informative enough as is.
+	return c.validateListNames("lists", cli.Lists)
+}
+
+// validateListNames returns an error if names contain a list missing from c.
+// key is the name of the property containing names.  c may be nil.
+func (c *filteringConfig) validateListNames(key string, names []filter.ListName) (err error) {
+	var errs []error
+	for i, name := range names {
+		if c == nil {
+			err = fmt.Errorf("%s: at index %d: filtering is not configured", key, i)
+		} else if _, ok := c.Lists[name]; !ok {
+			err = fmt.Errorf("%s: at index %d: no list named %q", key, i, name)
+		} else {
+			continue
+		}
+
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateGroups returns an error if groups refer to the lists missing from c.
+// c may be nil.
+func (c *filteringConfig) validateGroups(groups upstreamGroupsConfig) (err error) {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(groups)) {
+		g := groups[name]
+		if g == nil {
+			// Reported by the validation of the groups.
+			continue
+		}
+
+		err = c.validateListNames("filter_lists", g.FilterLists)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("group %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c *filteringConfig) toInternal(logger *slog.Logger) (conf *dnssvc.FilteringConfig) {
+	conf = &dnssvc.FilteringConfig{
+		Filter: &filter.Config{
+			Logger:          logger,
+			Lists:           c.Lists.toInternal(),
+			Timeout:         time.Duration(c.Timeout),
+			RefreshInterval: time.Duration(c.RefreshInterval),
+		},
+		BlockedResponse: c.BlockedResponse.toInternal(),
+	}
+
+	for _, cli := range c.Clients {
+		conf.Clients = append(conf.Clients, &dnssvc.FilteringClientConfig{
+			Prefix: cli.Client.Prefix,
+			Lists:  cli.Lists,
+		})
+	}
+
+	return conf
+}
+
+// filterListsConfig is the configuration for a set of rule lists.
+type filterListsConfig map[filter.ListName]*filterListConfig
+
+// type check
+var _ validate.Interface = (filterListsConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for filterListsConfig.
+func (c filterListsConfig) Validate() (err error) {
+	if len(c) == 0 {
+		return errors.ErrEmptyValue
+	}
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(c)) {
+		if name == "" {
+			errs = append(errs, fmt.Errorf("list %q: name: %w", name, errors.ErrEmptyValue))
+		}
+
+		err = c[name].Validate()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("list %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c filterListsConfig) toInternal() (conf map[filter.ListName]*filter.ListConfig) {
+	conf = make(map[filter.ListName]*filter.ListConfig, len(c))
+	for name, l := range c {
+		lc := &filter.ListConfig{
+			Path: l.Path,
+		}
+		if l.URL != nil {
+			lc.URL = &l.URL.URL
+		}
+
+		conf[name] = lc
+	}
+
+	return conf
+}
+
+// filterListConfig is the configuration for a single rule list.  Exactly one
+// of the properties must be set.
+type filterListConfig struct {
+	// URL is the HTTP or HTTPS URL to download the list from.
+	URL *urlutil.URL `yaml:"url"`
+
+	// Path is the absolute path to the file containing the list.
+	Path string `yaml:"path"`
+}
+
+// type check
+var _ validate.Interface = (*filterListConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *filterListConfig.
+func (c *filterListConfig) Validate() (err error) {
+	switch {
+	case c == nil:
+		return errors.ErrNoValue
+	case c.URL != nil && c.Path != "":
+		return fmt.Errorf("path: %w along with url", errors.ErrNotEmpty)
+	case c.URL != nil:
+		err = urlutil.ValidateHTTPURL(&c.URL.URL)
+		if err != nil {
+			return fmt.Errorf("url: %w", err)
+		}
+
+		return nil
+	case c.Path == "":
+		return fmt.Errorf("path: %w", errors.ErrEmptyValue)
+	case !filepath.IsAbs(c.Path):
+		return fmt.Errorf("path: %q must be absolute", c.Path)
+	default:
+		return nil
+	}
+}
+
+// filteringClientConfig is the configuration of the rule lists for a client
+// subnet.
+type filteringClientConfig struct {
+	// Client is the client's subnet.  Prefix itself should be masked.
+	Client netutil.Prefix `yaml:"client"`
+
+	// Lists are the names of the rule lists to filter the requests from the
+	// subnet with.  Empty lists disable filtering for the subnet.
+	Lists []filter.ListName `yaml:"lists"`
+}
+
+// blockedResponseConfig is the configuration of the responses to the blocked
+// requests.
+type blockedResponseConfig struct {
+	// IPv4 is the address to respond to the blocked A requests with in the
+	// [dnssvc.BlockedResponseModeCustomIP] mode.
+	IPv4 netip.Addr `yaml:"ipv4"`
+
+	// IPv6 is the address to respond to the blocked AAAA requests with in the
+	// [dnssvc.BlockedResponseModeCustomIP] mode.
+	IPv6 netip.Addr `yaml:"ipv6"`
+
+	// Mode is the way of responding to the blocked requests.
+	Mode dnssvc.BlockedResponseMode `yaml:"mode"`
+
+	// TTL is the TTL of the answers to the blocked requests.
+	TTL timeutil.Duration `yaml:"ttl"`
+}
+
+// type check
+var _ validate.Interface = (*blockedResponseConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for
+// *blockedResponseConfig.
+func (c *blockedResponseConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	errs := []error{
+		validate.InRange("ttl", c.TTL, 0, maxAnswerTTL),
+	}
+
+	switch c.Mode {
+	case
+		dnssvc.BlockedResponseModeNXDOMAIN,
+		dnssvc.BlockedResponseModeRefused,
+		dnssvc.BlockedResponseModeNullIP:
+		errs = append(errs, validate.Empty("ipv4", c.IPv4), validate.Empty("ipv6", c.IPv6))
+	case dnssvc.BlockedResponseModeCustomIP:
+		errs = append(errs, c.validateCustomIPs()...)
+	default:
+		errs = append(errs, fmt.Errorf("mode: %w: %q", errors.ErrBadEnumValue, c.Mode))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateCustomIPs returns the errors of validating the addresses of c for
+// the [dnssvc.BlockedResponseModeCustomIP] mode.  c must not be nil.
+func (c *blockedResponseConfig) validateCustomIPs() (errs []error) {
+	if !c.IPv4.IsValid() && !c.IPv6.IsValid() {
+		return []error{fmt.Errorf("ipv4: %w along with ipv6", errors.ErrEmptyValue)}
+	}
+
+	if c.IPv4.IsValid() && !c.IPv4.Is4() {
+		errs = append(errs, fmt.Errorf("ipv4: %s is not an ipv4 address", c.IPv4))
+	}
+
+	if c.IPv6.IsValid() && !c.IPv6.Is6() {
+		errs = append(errs, fmt.Errorf("ipv6: %s is not an ipv6 address", c.IPv6))
+	}
+
+	return errs
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c *blockedResponseConfig) toInternal() (conf *dnssvc.BlockedResponseConfig) {
+	return &dnssvc.BlockedResponseConfig{
+		IPv4: c.IPv4,
+		IPv6: c.IPv6,
+		Mode: c.Mode,
+		TTL:  time.Duration(c.TTL),
+	}
+}
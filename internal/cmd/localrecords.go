@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"path/filepath"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/AdguardTeam/golibs/validate"
+)
+
+// maxAnswerTTL is the maximum TTL of the answers made by the service itself,
+// which fits the TTL field of a resource record.
+const maxAnswerTTL = timeutil.Duration(math.MaxUint32 * time.Second)
+
+// localRecordsConfig is the configuration of the records answered without
+// using the upstreams.
+type localRecordsConfig struct {
+	// Records are the static records.
+	Records []*localRecordConfig `yaml:"records"`
+
+	// HostsFiles are the absolute paths to the files in the hosts format.
+	HostsFiles []string `yaml:"hosts_files"`
+
+	// TTL is the TTL of the answers.
+	TTL timeutil.Duration `yaml:"ttl"`
+}
+
+// type check
+var _ validate.Interface = (*localRecordsConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for
+// *localRecordsConfig.
+func (c *localRecordsConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	errs := []error{
+		validate.InRange("ttl", c.TTL, 0, maxAnswerTTL),
+	}
+	errs = validate.AppendSlice(errs, "records", c.Records)
+
+	for i, path := range c.HostsFiles {
+		if !filepath.IsAbs(path) {
+			err = fmt.Errorf("hosts_files: at index %d: path %q must be absolute", i, path)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c *localRecordsConfig) toInternal() (conf *dnssvc.LocalRecordsConfig) {
+	conf = &dnssvc.LocalRecordsConfig{
+		HostsFiles: c.HostsFiles,
+		TTL:        time.Duration(c.TTL),
+	}
+
+	for _, r := range c.Records {
+		conf.Records = append(conf.Records, &dnssvc.LocalRecord{
+			Name:  r.Name,
+			Addrs: r.Addresses,
+		})
+	}
+
+	return conf
+}
+
+// localRecordConfig is the configuration of a static record of a hostname.
+type localRecordConfig struct {
+	// Name is the hostname of the record.
+	Name string `yaml:"name"`
+
+	// Addresses are the IP addresses of the hostname.
+	Addresses []netip.Addr `yaml:"addresses"`
+}
+
+// type check
+var _ validate.Interface = (*localRecordConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for
+// *localRecordConfig.
+func (c *localRecordConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	errs := []error{
+		validate.NotEmptySlice("addresses", c.Addresses),
+	}
+
+	err = netutil.ValidateHostname(c.Name)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("name: %w", err))
+	}
+
+	for i, addr := range c.Addresses {
+		if !addr.IsValid() {
+			errs = append(errs, fmt.Errorf("addresses: at index %d: %w", i, errors.ErrEmptyValue))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -11,13 +11,19 @@ import (
 )
 
 // options specifies the command-line options.
-//
-// TODO(e.burkov):  Add an option to prevalidate configuration file.
 type options struct {
+	// confPath is the path to the configuration file.  Empty string indicates
+	// that the option is not set.
+	confPath string
+
 	// serviceAction specifies the action to perform with the service.  See
 	// [serviceAction] for the list of possible values.
 	serviceAction serviceAction
 
+	// checkConfig makes the application validate the configuration file and
+	// exit with a status-code reflecting the result.
+	checkConfig bool
+
 	// verbose specifies whether to enable verbose output.
 	verbose bool
 
@@ -30,11 +36,23 @@ type options struct {
 	help bool
 }
 
+// Names of the configuration file path options.
+const (
+	optionConfig     = "c"
+	optionConfigLong = "config"
+)
+
 // parseOptions parses the command-line options.
 //
 // TODO(e.burkov):  Use [flag.NewFlagSet].
 func parseOptions() (opts *options, err error) {
 	const (
+		descriptionConfig = "path to the configuration file, overrides the " +
+			envConfigPath + " environment variable"
+
+		optionCheckConfig      = "check-config"
+		descriptionCheckConfig = "validate the configuration file, print all the errors, and exit"
+
 		optionService      = "s"
 		descriptionService = "service action to perform, one of: " +
 			string(serviceActionInstall) + ", " +
@@ -57,6 +75,9 @@ func parseOptions() (opts *options, err error) {
 	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
 	opts = &options{}
 
+	flag.StringVar(&opts.confPath, optionConfig, "", descriptionConfig)
+	flag.StringVar(&opts.confPath, optionConfigLong, "", descriptionConfig)
+	flag.BoolVar(&opts.checkConfig, optionCheckConfig, false, descriptionCheckConfig)
 	flag.Var(&opts.serviceAction, optionService, descriptionService)
 	flag.BoolVar(&opts.verbose, optionVerbose, false, descriptionVerbose)
 	flag.BoolVar(&opts.version, optionVersion, false, descriptionVersion)
@@ -74,6 +95,11 @@ func parseOptions() (opts *options, err error) {
 		errs = append(errs, err)
 	}
 
+	if opts.checkConfig && opts.serviceAction != serviceActionNone {
+		err = fmt.Errorf("-%s cannot be used with -%s", optionCheckConfig, optionService)
+		errs = append(errs, err)
+	}
+
 	return opts, errors.Join(errs...)
 }
 
@@ -14,12 +14,14 @@ import (
 const serviceName = "AdGuardDNSClient"
 
 // newServiceConfig creates a configuration that the OS service manager uses to
-// control the service.
-func newServiceConfig() (conf *osservice.Config) {
+// control the service.  confPath is the absolute path to the configuration
+// file, it's persisted in the arguments of the installed service.
+func newServiceConfig(confPath string) (conf *osservice.Config) {
 	return &osservice.Config{
 		Name:        serviceName,
 		DisplayName: "AdGuardDNS Client",
 		Description: "A DNS client for AdGuardDNS",
+		Arguments:   []string{"-" + optionConfigLong, confPath},
 	}
 }
 
@@ -6,12 +6,22 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/ctrlsvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/debugsvc"
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/metrics"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/querylog"
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/version"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/service"
+	"github.com/AdguardTeam/golibs/timeutil"
 	osservice "github.com/kardianos/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 // program is the implementation of the [osservice.Interface] interface for
@@ -28,6 +38,9 @@ type program struct {
 	logFile *os.File
 	done    chan struct{}
 	errCh   chan error
+
+	// confPath is the absolute path to the configuration file.
+	confPath string
 }
 
 // type check
@@ -55,23 +68,236 @@ func (prog *program) Start(_ osservice.Service) (err error) {
 	)
 
 	svcHdlr := newServiceHandler(prog.done, service.SignalHandlerShutdownTimeout)
+	svcHdlrLog := prog.logger.With(slogutil.KeyPrefix, "service_handler")
+
+	err = prog.startServices(ctx, l, svcHdlr)
+	if err != nil {
+		// Shut down the services started before the failure, so that the
+		// listeners and the files are released.
+		shutdownCtx, cancel := context.WithTimeout(ctx, svcHdlr.shutdownTimeout)
+		defer cancel()
+
+		return errors.Join(err, svcHdlr.shutdown(shutdownCtx, svcHdlrLog))
+	}
+
+	go svcHdlr.handle(ctx, svcHdlrLog, prog.errCh)
+
+	return nil
+}
 
-	dnsSvc, err := dnssvc.New(prog.conf.DNS.toInternal(prog.logger))
+// startServices starts all the services of the program and adds them to
+// svcHdlr, including the ones started before a failure, so that those can be
+// shut down.
+func (prog *program) startServices(
+	ctx context.Context,
+	l *slog.Logger,
+	svcHdlr *serviceHandler,
+) (err error) {
+	reg, dnsMtrc, err := newMetrics(prog.conf.Debug.Metrics)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+
+	ql, err := prog.startQueryLog(ctx, svcHdlr)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+
+	dnsSvc, err := dnssvc.New(ctx, prog.conf.dnsToInternal(prog.logger, dnsMtrc, ql))
 	if err != nil {
 		return fmt.Errorf("creating dns service: %w", err)
 	}
 
+	// Add the service before starting it, since it holds the upstreams to
+	// close even if it fails to start.
+	svcHdlr.add(dnsSvc)
+
 	err = dnsSvc.Start(ctx)
 	if err != nil {
 		return fmt.Errorf("starting dns service: %w", err)
 	}
 
-	svcHdlr.add(dnsSvc)
 	l.DebugContext(ctx, "dns service started")
 
-	svcHdlrLog := prog.logger.With(slogutil.KeyPrefix, "service_handler")
+	err = prog.startRefresh(ctx, svcHdlr, dnsSvc)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
 
-	go svcHdlr.handle(ctx, svcHdlrLog, prog.errCh)
+	err = prog.startDebug(ctx, svcHdlr, reg)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+
+	r, err := prog.startReload(ctx, svcHdlr, dnsSvc, dnsMtrc, ql)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+
+	err = prog.startControl(ctx, svcHdlr, dnsSvc, r)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+
+	return nil
+}
+
+// newMetrics returns the registry of the Prometheus metrics and the metrics of
+// the DNS service according to conf.  reg is nil and m is a no-op if the
+// metrics are disabled.  conf must be valid.
+func newMetrics(conf *metricsConfig) (reg *prometheus.Registry, m dnssvc.Metrics, err error) {
+	if !conf.Enabled {
+		return nil, dnssvc.EmptyMetrics{}, nil
+	}
+
+	reg = prometheus.NewRegistry()
+	err = errors.Join(
+		reg.Register(collectors.NewGoCollector()),
+		reg.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("registering runtime metrics: %w", err)
+	}
+
+	m, err = metrics.NewDNSSvc(metrics.Namespace, reg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("registering dns service metrics: %w", err)
+	}
+
+	return reg, m, nil
+}
+
+// startQueryLog starts writing the query log, if it's enabled, and adds it to
+// svcHdlr.  It must be started before the DNS service, so that it's shut down
+// after it.  ql is a no-op if the query log is disabled.
+func (prog *program) startQueryLog(
+	ctx context.Context,
+	svcHdlr *serviceHandler,
+) (ql querylog.Interface, err error) {
+	conf := prog.conf.QueryLog
+	if !conf.Enabled {
+		return querylog.Empty{}, nil
+	}
+
+	f := querylog.NewFile(conf.toInternal(prog.logger, filepath.Dir(prog.confPath)))
+	err = f.Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("starting query log: %w", err)
+	}
+
+	svcHdlr.add(f)
+
+	return f, nil
+}
+
+// startDebug starts the debug HTTP services, if any are enabled, and adds them
+// to svcHdlr.  gatherer is used to serve the metrics, if they're enabled.
+func (prog *program) startDebug(
+	ctx context.Context,
+	svcHdlr *serviceHandler,
+	gatherer prometheus.Gatherer,
+) (err error) {
+	for _, c := range prog.conf.Debug.toInternal(prog.logger, gatherer) {
+		debugSvc := debugsvc.New(c)
+		err = debugSvc.Start(ctx)
+		if err != nil {
+			return fmt.Errorf("starting debug service: %w", err)
+		}
+
+		svcHdlr.add(debugSvc)
+	}
+
+	return nil
+}
+
+// startRefresh starts refreshing the files, the rule lists, and the response
+// policy zones used by dnsSvc and adds the refresh worker to svcHdlr.
+func (prog *program) startRefresh(
+	ctx context.Context,
+	svcHdlr *serviceHandler,
+	dnsSvc *dnssvc.DNSService,
+) (err error) {
+	l := prog.logger.With(slogutil.KeyPrefix, "refresh")
+	worker := service.NewRefreshWorker(&service.RefreshWorkerConfig{
+		ErrorHandler: service.NewSlogErrorHandler(l, slog.LevelError, "refreshing"),
+		Refresher:    dnsSvc,
+		Schedule:     dnsSvc,
+	})
+	err = worker.Start(ctx)
+	if err != nil {
+		return fmt.Errorf("starting refresh worker: %w", err)
+	}
+
+	svcHdlr.add(worker)
+
+	return nil
+}
+
+// startReload starts reloading the configuration of dnsSvc on reconfigure
+// signals and, if enabled, on changes of the configuration file.  It adds the
+// started services to svcHdlr and returns the started reloader.  m and ql are
+// used by the reconfigured dnsSvc.
+func (prog *program) startReload(
+	ctx context.Context,
+	svcHdlr *serviceHandler,
+	dnsSvc *dnssvc.DNSService,
+	m dnssvc.Metrics,
+	ql querylog.Interface,
+) (r *reloader, err error) {
+	r = newReloader(prog.logger, prog.conf, dnsSvc, m, ql, prog.confPath)
+	err = r.Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("starting reloader: %w", err)
+	}
+
+	svcHdlr.add(r)
+
+	reloadConf := prog.conf.Reload
+	if !reloadConf.Watch {
+		return r, nil
+	}
+
+	worker := service.NewRefreshWorker(&service.RefreshWorkerConfig{
+		ErrorHandler: service.NewSlogErrorHandler(r.logger, slog.LevelError, "watching"),
+		Refresher:    r,
+		Schedule:     timeutil.NewConstSchedule(time.Duration(reloadConf.Interval)),
+	})
+	err = worker.Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("starting configuration watcher: %w", err)
+	}
+
+	svcHdlr.add(worker)
+
+	return r, nil
+}
+
+// startControl starts the control HTTP API for dnsSvc, if it's enabled, and
+// adds it to svcHdlr.  r is used to provide and reload the configuration.
+func (prog *program) startControl(
+	ctx context.Context,
+	svcHdlr *serviceHandler,
+	dnsSvc *dnssvc.DNSService,
+	r *reloader,
+) (err error) {
+	ctrlConf := prog.conf.Control
+	if !ctrlConf.Enabled {
+		return nil
+	}
+
+	ctrlSvc := ctrlsvc.New(ctrlConf.toInternal(prog.logger, dnsSvc, r))
+	err = ctrlSvc.Start(ctx)
+	if err != nil {
+		return fmt.Errorf("starting control service: %w", err)
+	}
+
+	svcHdlr.add(ctrlSvc)
 
 	return nil
 }
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/querylog"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/AdguardTeam/golibs/validate"
+	"github.com/c2h5oh/datasize"
+)
+
+// queryLogConfig is the configuration for the query log.
+type queryLogConfig struct {
+	// File is the path to the query log file.  A relative path is resolved
+	// against the directory of the configuration file.
+	File string `yaml:"file"`
+
+	// MaxSize is the maximum size of the query log file, after which it's
+	// rotated.  Zero means no rotation by size.
+	MaxSize datasize.ByteSize `yaml:"max_size"`
+
+	// MaxAge is the maximum age of the query log file, after which it's
+	// rotated.  Zero means no rotation by age.
+	MaxAge timeutil.Duration `yaml:"max_age"`
+
+	// MaxBackups is the maximum number of rotated files to keep.  Zero means
+	// keeping all of them.
+	MaxBackups uint `yaml:"max_backups"`
+
+	// BufferSize is the maximum number of entries waiting to be written.
+	BufferSize uint `yaml:"buffer_size"`
+
+	// Enabled specifies if the query log should be written.
+	Enabled bool `yaml:"enabled"`
+
+	// AnonymizeClientIP specifies if the client IP addresses should be
+	// anonymized.
+	AnonymizeClientIP bool `yaml:"anonymize_client_ip"`
+}
+
+// type check
+var _ validate.Interface = (*queryLogConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *queryLogConfig.
+func (c *queryLogConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	} else if !c.Enabled {
+		return nil
+	}
+
+	return errors.Join(
+		validate.NotEmpty("file", c.File),
+		validate.NotNegative("max_age", c.MaxAge),
+		validate.Positive("buffer_size", c.BufferSize),
+	)
+}
+
+// toInternal converts the configuration to the query log file configuration.
+// confDir is the directory of the configuration file, it's used to resolve the
+// relative path to the file.  c must be valid.
+func (c *queryLogConfig) toInternal(
+	logger *slog.Logger,
+	confDir string,
+) (conf *querylog.FileConfig) {
+	path := c.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(confDir, path)
+	}
+
+	return &querylog.FileConfig{
+		Logger:            logger.With(slogutil.KeyPrefix, "querylog"),
+		Clock:             timeutil.SystemClock{},
+		Path:              path,
+		MaxSize:           uint64(c.MaxSize),
+		MaxAge:            time.Duration(c.MaxAge),
+		MaxBackups:        c.MaxBackups,
+		BufferSize:        c.BufferSize,
+		AnonymizeClientIP: c.AnonymizeClientIP,
+	}
+}
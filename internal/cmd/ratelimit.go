@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/validate"
+)
+
+// Maximum lengths of the subnets to aggregate the clients by.
+const (
+	maxIPv4PrefixLen = 32
+	maxIPv6PrefixLen = 128
+)
+
+// rateLimitConfig is the configuration of the rate limiting of the clients.
+type rateLimitConfig struct {
+	// Mode is the way of handling the requests exceeding the limit.
+	Mode dnssvc.RejectMode `yaml:"mode"`
+
+	// Allowlist are the subnets of the clients, which requests aren't
+	// limited.
+	Allowlist []netutil.Prefix `yaml:"allowlist"`
+
+	// AllowlistClients are the names of the persistent clients, which
+	// requests aren't limited.
+	AllowlistClients []agdc.ClientName `yaml:"allowlist_clients,omitempty"`
+
+	// AllowlistGroups are the names of the upstream groups, which requests
+	// routed to aren't limited.
+	AllowlistGroups []agdc.UpstreamGroupName `yaml:"allowlist_groups,omitempty"`
+
+	// RPS is the number of requests per second allowed for each subnet.
+	RPS uint `yaml:"rps"`
+
+	// Burst is the number of requests allowed for each subnet at once.
+	Burst uint `yaml:"burst"`
+
+	// IPv4PrefixLen is the length of the IPv4 subnets the clients are
+	// aggregated by.
+	IPv4PrefixLen int `yaml:"ipv4_prefix_length"`
+
+	// IPv6PrefixLen is the length of the IPv6 subnets the clients are
+	// aggregated by.
+	IPv6PrefixLen int `yaml:"ipv6_prefix_length"`
+}
+
+// type check
+var _ validate.Interface = (*rateLimitConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *rateLimitConfig.
+func (c *rateLimitConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	errs := []error{
+		validate.Positive("rps", c.RPS),
+		validate.Positive("burst", c.Burst),
+		validate.InRange("ipv4_prefix_length", c.IPv4PrefixLen, 0, maxIPv4PrefixLen),
+		validate.InRange("ipv6_prefix_length", c.IPv6PrefixLen, 0, maxIPv6PrefixLen),
+		validateRejectMode(c.Mode),
+	}
+
+	errs = append(errs, validatePrefixes("allowlist", c.Allowlist)...)
+
+	for i, name := range c.AllowlistClients {
+		err = name.Validate()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("allowlist_clients: at index %d: %w", i, err))
+		}
+	}
+
+	for i, name := range c.AllowlistGroups {
+		err = name.Validate()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("allowlist_groups: at index %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateRefs returns the errors about the names in the allowlists of c
+// missing from clients and groups, or referring to the predefined groups.  c
+// must not be nil.
+func (c *rateLimitConfig) validateRefs(
+	clients clientsConfig,
+	groups upstreamGroupsConfig,
+) (errs []error) {
+	for i, name := range c.AllowlistClients {
+		if _, ok := clients[name]; !ok {
+			errs = append(errs, fmt.Errorf("allowlist_clients: at index %d: no client named %q", i, name))
+		}
+	}
+
+	for i, name := range c.AllowlistGroups {
+		var err error
+		if slices.Contains(predefinedGroups, name) {
+			err = fmt.Errorf("predefined group %q", name)
+		} else if _, ok := groups[name]; !ok {
+			err = fmt.Errorf("no group named %q", name)
+		} else {
+			continue
+		}
+
+		errs = append(errs, fmt.Errorf("allowlist_groups: at index %d: %w", i, err))
+	}
+
+	return errs
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c *rateLimitConfig) toInternal() (conf *dnssvc.RateLimitConfig) {
+	return &dnssvc.RateLimitConfig{
+		Mode:             c.Mode,
+		Allowlist:        netutil.UnembedPrefixes(c.Allowlist),
+		AllowlistClients: c.AllowlistClients,
+		AllowlistGroups:  c.AllowlistGroups,
+		RPS:              c.RPS,
+		Burst:            c.Burst,
+		IPv4PrefixLen:    c.IPv4PrefixLen,
+		IPv6PrefixLen:    c.IPv6PrefixLen,
+	}
+}
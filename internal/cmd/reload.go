@@ -0,0 +1,336 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/ctrlsvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/querylog"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/osutil"
+	"github.com/AdguardTeam/golibs/service"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/AdguardTeam/golibs/validate"
+	"gopkg.in/yaml.v3"
+)
+
+// reloadConfig is the configuration for reloading the configuration file
+// without restarting.
+type reloadConfig struct {
+	// Interval is the interval between the checks of the configuration file
+	// for changes.  It's only used when Watch is true.
+	Interval timeutil.Duration `yaml:"interval"`
+
+	// Watch specifies if the configuration file should be checked for changes
+	// and reloaded automatically.
+	Watch bool `yaml:"watch"`
+}
+
+// type check
+var _ validate.Interface = (*reloadConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *reloadConfig.
+func (c *reloadConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	} else if !c.Watch {
+		return nil
+	}
+
+	return validate.Positive("interval", c.Interval)
+}
+
+// reloader reloads the configuration of the DNS service on receiving a
+// reconfigure signal and, if enabled, on changes of the configuration file.
+// If the new configuration is invalid, the previous one stays in place.
+type reloader struct {
+	// logger is used to log the operation of the reloader.
+	logger *slog.Logger
+
+	// baseLogger is used to create the loggers for the reloaded
+	// configuration.
+	baseLogger *slog.Logger
+
+	// dnsSvc is the service to reconfigure.
+	dnsSvc *dnssvc.DNSService
+
+	// metrics is used to collect the statistics of the reconfigured service.
+	metrics dnssvc.Metrics
+
+	// queryLog is used to record the requests processed by the reconfigured
+	// service.
+	queryLog querylog.Interface
+
+	// mu serializes the reloads and protects conf and modTime.
+	mu *sync.Mutex
+
+	// conf is the current effective configuration.  Only the parts of it
+	// applied by [dnssvc.DNSService.Reconfigure] are replaced on reload, since
+	// the other parts require a restart.
+	conf *configuration
+
+	// signals receives the reconfigure signals.
+	signals chan os.Signal
+
+	// done is closed on shutdown.
+	done chan struct{}
+
+	// modTime is the modification time of the configuration file at the time
+	// of the last reload.
+	modTime time.Time
+
+	// confPath is the absolute path to the configuration file.
+	confPath string
+}
+
+// newReloader returns a new properly initialized *reloader.  baseLogger,
+// conf, dnsSvc, m, and ql must not be nil.  conf is the configuration dnsSvc
+// has been started with.
+func newReloader(
+	baseLogger *slog.Logger,
+	conf *configuration,
+	dnsSvc *dnssvc.DNSService,
+	m dnssvc.Metrics,
+	ql querylog.Interface,
+	confPath string,
+) (r *reloader) {
+	return &reloader{
+		logger:     baseLogger.With(slogutil.KeyPrefix, "reload"),
+		baseLogger: baseLogger,
+		dnsSvc:     dnsSvc,
+		metrics:    m,
+		queryLog:   ql,
+		mu:         &sync.Mutex{},
+		conf:       conf,
+		signals:    make(chan os.Signal, 1),
+		done:       make(chan struct{}),
+		confPath:   confPath,
+	}
+}
+
+// type check
+var _ service.Interface = (*reloader)(nil)
+
+// Start implements the [service.Interface] interface for *reloader.  It starts
+// handling the reconfigure signals.
+func (r *reloader) Start(ctx context.Context) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.modTime, err = r.confModTime()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	osutil.NotifyReconfigureSignal(osutil.DefaultSignalNotifier{}, r.signals)
+
+	go r.handleSignals(ctx)
+
+	return nil
+}
+
+// handleSignals reloads the configuration on each reconfigure signal until
+// the reloader is shut down.  It's intended to be used as a goroutine.
+func (r *reloader) handleSignals(ctx context.Context) {
+	defer slogutil.RecoverAndLog(ctx, r.logger)
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case sig := <-r.signals:
+			r.logger.InfoContext(ctx, "received signal", "signal", sig)
+
+			err := r.reload(ctx)
+			if err != nil {
+				r.logger.ErrorContext(ctx, "keeping previous configuration", slogutil.KeyError, err)
+			}
+		}
+	}
+}
+
+// Shutdown implements the [service.Interface] interface for *reloader.
+func (r *reloader) Shutdown(_ context.Context) (err error) {
+	osutil.DefaultSignalNotifier{}.Stop(r.signals)
+	close(r.done)
+
+	return nil
+}
+
+// type check
+var _ service.Refresher = (*reloader)(nil)
+
+// Refresh implements the [service.Refresher] interface for *reloader.  It
+// reloads the configuration if the configuration file has been modified since
+// the last reload.  The files used by the DNS service are refreshed
+// separately, see [dnssvc.DNSService.UntilNext].
+func (r *reloader) Refresh(ctx context.Context) (err error) {
+	modTime, err := r.confModTime()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	r.mu.Lock()
+	changed := !modTime.Equal(r.modTime)
+	r.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	r.logger.InfoContext(ctx, "configuration file changed")
+
+	err = r.reload(ctx)
+	if err != nil {
+		return fmt.Errorf("keeping previous configuration: %w", err)
+	}
+
+	return nil
+}
+
+// reload parses and validates the configuration file and reconfigures the DNS
+// service with it.
+func (r *reloader) reload(ctx context.Context) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Remember the modification time before reading the file to not miss the
+	// changes made during the reload, and to not reload an invalid file again
+	// until it's changed.
+	r.modTime, err = r.confModTime()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	conf, err := handleConfig(ctx, r.baseLogger, r.confPath)
+	if err != nil {
+		return fmt.Errorf("reloading configuration: %w", err)
+	}
+
+	err = r.dnsSvc.Reconfigure(ctx, conf.dnsToInternal(r.baseLogger, r.metrics, r.queryLog))
+	if err != nil {
+		return fmt.Errorf("reloading configuration: %w", err)
+	}
+
+	r.warnRestart(ctx, conf)
+
+	// The server and the bootstrap parts aren't applied by the DNS service on
+	// reconfiguration.
+	dnsConf := *conf.DNS
+	dnsConf.Server = r.conf.DNS.Server
+	dnsConf.Bootstrap = r.conf.DNS.Bootstrap
+
+	effective := *r.conf
+	effective.DNS = &dnsConf
+	effective.Clients = conf.Clients
+	r.conf = &effective
+
+	r.logger.InfoContext(ctx, "configuration reloaded")
+
+	return nil
+}
+
+// warnRestart logs a warning if conf changes the parts of the effective
+// configuration, which are only applied on restart.  r.mu must be locked.
+func (r *reloader) warnRestart(ctx context.Context, conf *configuration) {
+	sections := []struct {
+		prev any
+		next any
+		name string
+	}{{
+		prev: r.conf.DNS.Server,
+		next: conf.DNS.Server,
+		name: "dns.server",
+	}, {
+		prev: r.conf.DNS.Bootstrap,
+		next: conf.DNS.Bootstrap,
+		name: "dns.bootstrap",
+	}, {
+		prev: r.conf.Control,
+		next: conf.Control,
+		name: "control",
+	}, {
+		prev: r.conf.Debug,
+		next: conf.Debug,
+		name: "debug",
+	}, {
+		prev: r.conf.Log,
+		next: conf.Log,
+		name: "log",
+	}, {
+		prev: r.conf.QueryLog,
+		next: conf.QueryLog,
+		name: "query_log",
+	}, {
+		prev: r.conf.Reload,
+		next: conf.Reload,
+		name: "reload",
+	}}
+
+	var changed []string
+	for _, s := range sections {
+		eq, err := yamlEqual(s.prev, s.next)
+		if err != nil {
+			r.logger.ErrorContext(ctx, "comparing configuration", "section", s.name, slogutil.KeyError, err)
+		} else if !eq {
+			changed = append(changed, s.name)
+		}
+	}
+
+	if len(changed) > 0 {
+		r.logger.WarnContext(ctx, "restart required to apply changes", "sections", changed)
+	}
+}
+
+// yamlEqual returns true if a and b are encoded into the same YAML document.
+func yamlEqual(a, b any) (ok bool, err error) {
+	aData, err := yaml.Marshal(a)
+	if err != nil {
+		return false, fmt.Errorf("encoding previous: %w", err)
+	}
+
+	bData, err := yaml.Marshal(b)
+	if err != nil {
+		return false, fmt.Errorf("encoding new: %w", err)
+	}
+
+	return bytes.Equal(aData, bData), nil
+}
+
+// type check
+var _ ctrlsvc.Configurator = (*reloader)(nil)
+
+// Config implements the [ctrlsvc.Configurator] interface for *reloader.
+func (r *reloader) Config(_ context.Context) (conf any, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.conf.redacted()
+}
+
+// Reload implements the [ctrlsvc.Configurator] interface for *reloader.
+func (r *reloader) Reload(ctx context.Context) (err error) {
+	r.logger.InfoContext(ctx, "reload requested")
+
+	return r.reload(ctx)
+}
+
+// confModTime returns the modification time of the configuration file.
+func (r *reloader) confModTime() (modTime time.Time, err error) {
+	fi, err := os.Stat(r.confPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("checking configuration file: %w", err)
+	}
+
+	return fi.ModTime(), nil
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/AdguardTeam/golibs/validate"
+)
+
+// rewritesConfig is the configuration of the rewrites of the requests.
+type rewritesConfig struct {
+	// Rules are the rewrite rules.
+	Rules []*rewriteConfig `yaml:"rules"`
+
+	// TTL is the TTL of the synthesized answers.
+	TTL timeutil.Duration `yaml:"ttl"`
+}
+
+// type check
+var _ validate.Interface = (*rewritesConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *rewritesConfig.
+func (c *rewritesConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	errs := []error{
+		validate.NotEmptySlice("rules", c.Rules),
+		validate.InRange("ttl", c.TTL, 0, maxAnswerTTL),
+	}
+
+	// hasCNAME tracks whether the rules for the same client, domain, and the
+	// way it matches answer with a CNAME target.
+	hasCNAME := map[rewriteKey]bool{}
+	for i, r := range c.Rules {
+		err = r.Validate()
+		if err == nil {
+			err = r.validateCombined(hasCNAME)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rules: at index %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c *rewritesConfig) toInternal() (conf *dnssvc.RewritesConfig) {
+	conf = &dnssvc.RewritesConfig{
+		TTL: time.Duration(c.TTL),
+	}
+
+	for _, r := range c.Rules {
+		domain, domainMatch, _ := parseDomainPattern(r.Domain)
+		rule := &dnssvc.RewriteRule{
+			Client:      r.Client.Prefix,
+			Domain:      domain,
+			DomainMatch: domainMatch,
+		}
+
+		if addr, err := netip.ParseAddr(r.Answer); err == nil {
+			rule.Addr = addr
+		} else {
+			rule.Target = r.Answer
+		}
+
+		conf.Rules = append(conf.Rules, rule)
+	}
+
+	return conf
+}
+
+// rewriteKey is the key to combine the rewrite rules by.
+type rewriteKey struct {
+	client      netip.Prefix
+	domain      string
+	domainMatch dnssvc.DomainMatch
+}
+
+// rewriteConfig is the configuration of a single rewrite rule.
+type rewriteConfig struct {
+	// Client is the subnet of the clients the rule applies to.  Prefix itself
+	// should be masked.  Empty value means any client.
+	Client netutil.Prefix `yaml:"client"`
+
+	// Domain is the pattern of the domain name from request's question to
+	// match.  The domain name itself matches the domain and all its
+	// subdomains, the one prefixed with [domainPrefixExact] only matches the
+	// domain itself, and the one prefixed with [domainPrefixSubdomains] only
+	// matches its subdomains.
+	Domain string `yaml:"domain"`
+
+	// Answer is either the IP address to answer the A or AAAA requests with,
+	// or the hostname to answer with the CNAME record for.
+	Answer string `yaml:"answer"`
+}
+
+// type check
+var _ validate.Interface = (*rewriteConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *rewriteConfig.
+func (c *rewriteConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	var errs []error
+
+	domain, _, exclude := parseDomainPattern(c.Domain)
+	if exclude {
+		errs = append(errs, fmt.Errorf("domain: exclusions are not supported: %q", c.Domain))
+	} else if err = netutil.ValidateDomainName(domain); err != nil {
+		errs = append(errs, fmt.Errorf("domain: %w", err))
+	}
+
+	if c.Client.Prefix != c.Client.Masked() {
+		bitNum := c.Client.Bits()
+		err = fmt.Errorf("client: %s must has at most %d significant bits", c.Client, bitNum)
+		errs = append(errs, err)
+	}
+
+	if _, parseErr := netip.ParseAddr(c.Answer); parseErr != nil {
+		err = netutil.ValidateHostname(c.Answer)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("answer: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateCombined returns an error if c answers with a CNAME target along with
+// any other answer of the previous rules for the same client and domain
+// pattern, which are tracked in hasCNAME.  c must be valid.
+func (c *rewriteConfig) validateCombined(hasCNAME map[rewriteKey]bool) (err error) {
+	domain, domainMatch, _ := parseDomainPattern(c.Domain)
+	key := rewriteKey{
+		client:      c.Client.Prefix,
+		domain:      strings.ToLower(strings.TrimSuffix(domain, ".")),
+		domainMatch: domainMatch,
+	}
+
+	_, parseErr := netip.ParseAddr(c.Answer)
+	isCNAME := parseErr != nil
+
+	prevCNAME, ok := hasCNAME[key]
+	if ok && (isCNAME || prevCNAME) {
+		return fmt.Errorf("answer: cname target along with other answers for domain %q", c.Domain)
+	}
+
+	hasCNAME[key] = isCNAME
+
+	return nil
+}
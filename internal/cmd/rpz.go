@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/rpz"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/AdguardTeam/golibs/validate"
+)
+
+// rpzConfig is the configuration of the response policy zones.
+type rpzConfig struct {
+	// Policies are the response policy zones in the order of precedence.
+	Policies []*rpzPolicyConfig `yaml:"policies"`
+
+	// Timeout constrains each of connecting to the primary, writing the
+	// request, and reading the response during a zone transfer.
+	Timeout timeutil.Duration `yaml:"timeout"`
+}
+
+// type check
+var _ validate.Interface = (*rpzConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *rpzConfig.
+func (c *rpzConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	errs := []error{
+		validate.NotEmptySlice("policies", c.Policies),
+		validate.Positive("timeout", c.Timeout),
+	}
+
+	names := container.NewMapSet[string]()
+	for i, p := range c.Policies {
+		err = p.Validate()
+		if err == nil && names.Has(p.Name) {
+			err = fmt.Errorf("name: %w: %q", errors.ErrDuplicated, p.Name)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("policies: at index %d: %w", i, err))
+
+			continue
+		}
+
+		names.Add(p.Name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c *rpzConfig) toInternal(logger *slog.Logger) (conf *rpz.Config) {
+	conf = &rpz.Config{
+		Logger:  logger,
+		Timeout: time.Duration(c.Timeout),
+	}
+
+	for _, p := range c.Policies {
+		conf.Policies = append(conf.Policies, &rpz.PolicyConfig{
+			Name:    p.Name,
+			Zone:    p.Zone,
+			Path:    p.File,
+			Primary: p.Primary,
+		})
+	}
+
+	return conf
+}
+
+// rpzPolicyConfig is the configuration of a single response policy zone.
+// Exactly one of File and Primary must be set.
+type rpzPolicyConfig struct {
+	// Name is the name of the policy used in the logs and the query log.
+	Name string `yaml:"name"`
+
+	// Zone is the domain name of the policy zone's apex.
+	Zone string `yaml:"zone"`
+
+	// File is the absolute path to the zone file in the RFC 1035 format.
+	File string `yaml:"file"`
+
+	// Primary is the address of the primary server to transfer the zone from.
+	Primary netip.AddrPort `yaml:"primary"`
+}
+
+// type check
+var _ validate.Interface = (*rpzPolicyConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *rpzPolicyConfig.
+func (c *rpzPolicyConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("name: %w", errors.ErrEmptyValue)
+	}
+
+	err = netutil.ValidateDomainName(c.Zone)
+	if err != nil {
+		return fmt.Errorf("zone: %w", err)
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return c.validateSource()
+}
+
+// validateSource returns an error if c doesn't have exactly one valid source
+// of the zone.  c must not be nil.
+func (c *rpzPolicyConfig) validateSource() (err error) {
+	switch {
+	case c.File != "" && c.Primary.IsValid():
+		return fmt.Errorf("primary: %w along with file", errors.ErrNotEmpty)
+	case c.Primary.IsValid():
+		err = validate.Positive("port", c.Primary.Port())
+		if err != nil {
+			return fmt.Errorf("primary: %w", err)
+		}
+
+		return nil
+	case c.File == "":
+		return fmt.Errorf("file: %w along with primary", errors.ErrEmptyValue)
+	case !filepath.IsAbs(c.File):
+		return fmt.Errorf("file: path %q must be absolute", c.File)
+	default:
+		err = rpz.ValidateFile(c.Zone, c.File)
+		if err != nil {
+			return fmt.Errorf("file: %w", err)
+		}
+
+		return nil
+	}
+}
@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"slices"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/AdguardTeam/golibs/validate"
 )
@@ -17,8 +22,31 @@ type serverConfig struct {
 	// PendingRequests configures duplicate requests handling.
 	PendingRequests *pendingRequestsConfig `yaml:"pending_requests"`
 
+	// Access configures the access control of the clients.  If it's nil, the
+	// requests from all the clients are served.
+	Access *accessConfig `yaml:"access,omitempty"`
+
+	// RateLimit configures the rate limiting of the clients.  If it's nil,
+	// the requests aren't limited.
+	RateLimit *rateLimitConfig `yaml:"rate_limit,omitempty"`
+
 	// ListenAddresses is the addresses server listens for requests.
-	ListenAddresses []*ipPortConfig `yaml:"listen_addresses"`
+	ListenAddresses []*listenAddressConfig `yaml:"listen_addresses"`
+
+	// PrivateSubnets are the subnets considered private.  The PTR requests for
+	// the addresses within those from the clients within those are resolved
+	// using the private upstream group.
+	PrivateSubnets []netutil.Prefix `yaml:"private_subnets"`
+
+	// TrustedProxies are the subnets of the proxies allowed to pass the
+	// addresses of the original clients, either within the HTTP headers of the
+	// DNS-over-HTTPS requests or within the PROXY protocol header.
+	TrustedProxies []netutil.Prefix `yaml:"trusted_proxies"`
+
+	// UsePrivateRDNS defines if the private PTR requests should be resolved
+	// using the private upstream group.  If false, those are answered with
+	// NXDOMAIN, unless answered with the local records.
+	UsePrivateRDNS bool `yaml:"use_private_rdns"`
 }
 
 // type check
@@ -32,12 +60,306 @@ func (c *serverConfig) Validate() (err error) {
 
 	var errs []error
 	errs = validate.AppendSlice(errs, "listen_addresses", c.ListenAddresses)
+	errs = append(errs, validateListenConflicts(c.ListenAddresses)...)
 	errs = validate.Append(errs, "bind_retry", c.BindRetry)
 	errs = validate.Append(errs, "pending_requests", c.PendingRequests)
 
+	if c.Access != nil {
+		errs = validate.Append(errs, "access", c.Access)
+	}
+
+	if c.RateLimit != nil {
+		errs = validate.Append(errs, "rate_limit", c.RateLimit)
+	}
+
+	errs = append(errs, validatePrefixes("private_subnets", c.PrivateSubnets)...)
+	errs = append(errs, validatePrefixes("trusted_proxies", c.TrustedProxies)...)
+
+	errs = append(errs, c.validateProxyProtocol()...)
+
+	return errors.Join(errs...)
+}
+
+// validateProxyProtocol returns errors about the listen addresses accepting the
+// PROXY protocol, which require trusted_proxies to be set and to not contain
+// the prefixes matching any address, since the header allows the proxy to pass
+// any client address.
+func (c *serverConfig) validateProxyProtocol() (errs []error) {
+	if !slices.ContainsFunc(c.ListenAddresses, (*listenAddressConfig).acceptsProxyProtocol) {
+		return nil
+	}
+
+	if len(c.TrustedProxies) == 0 {
+		for i, a := range c.ListenAddresses {
+			if a.acceptsProxyProtocol() {
+				err := errors.Error("proxy_protocol: no trusted_proxies")
+				errs = append(errs, fmt.Errorf("listen_addresses: at index %d: %w", i, err))
+			}
+		}
+
+		return errs
+	}
+
+	for i, p := range c.TrustedProxies {
+		if p.Bits() == 0 {
+			err := fmt.Errorf("%s trusts any address, but proxy_protocol is used", p)
+			errs = append(errs, fmt.Errorf("trusted_proxies: at index %d: %w", i, err))
+		}
+	}
+
+	return errs
+}
+
+// validatePrefixes returns errors about the prefixes within the property with
+// name having the bits set beyond their lengths.
+func validatePrefixes(name string, prefixes []netutil.Prefix) (errs []error) {
+	for i, p := range prefixes {
+		if p.Prefix != p.Masked() {
+			bitNum := p.Bits()
+			err := fmt.Errorf("%s must has at most %d significant bits", p, bitNum)
+			errs = append(errs, fmt.Errorf("%s: at index %d: %w", name, i, err))
+		}
+	}
+
+	return errs
+}
+
+// privateSubnets returns the set of the private subnets of c.  c must be
+// valid.
+func (c *serverConfig) privateSubnets() (s netutil.SubnetSet) {
+	return netutil.SliceSubnetSet(netutil.UnembedPrefixes(c.PrivateSubnets))
+}
+
+// trustedProxies returns the set of the subnets of the trusted proxies of c.  c
+// must be valid.
+func (c *serverConfig) trustedProxies() (s netutil.SubnetSet) {
+	return netutil.SliceSubnetSet(netutil.UnembedPrefixes(c.TrustedProxies))
+}
+
+// toInternal converts the listen addresses to the internal representation.  c
+// must be valid.
+func (c *serverConfig) toInternal() (confs []*dnssvc.ListenAddrConfig) {
+	confs = make([]*dnssvc.ListenAddrConfig, 0, len(c.ListenAddresses))
+	for _, a := range c.ListenAddresses {
+		confs = append(confs, a.toInternal())
+	}
+
+	return confs
+}
+
+// listenAddressConfig is the configuration for an address to serve DNS
+// requests on.
+type listenAddressConfig struct {
+	// TLS configures the certificate for the encrypted protocols.  It must
+	// only be set for those.
+	TLS *tlsConfig `yaml:"tls,omitempty"`
+
+	// Protocol is the protocol to serve on the address.  Empty value means
+	// [dnssvc.ProtocolDNS].
+	Protocol dnssvc.Protocol `yaml:"protocol"`
+
+	// Address is the address to listen on.
+	Address netip.AddrPort `yaml:"address"`
+
+	// ProxyProtocol defines if the TCP connections to the address must start
+	// with the PROXY protocol header.  It must only be set for
+	// [dnssvc.ProtocolDNS].
+	ProxyProtocol bool `yaml:"proxy_protocol,omitempty"`
+}
+
+// type check
+var _ validate.Interface = (*listenAddressConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for
+// *listenAddressConfig.
+func (c *listenAddressConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	errs := []error{
+		validate.NotEmpty("address", c.Address),
+	}
+
+	switch p := c.protocol(); p {
+	case dnssvc.ProtocolDNS:
+		errs = append(errs, validate.Nil("tls", c.TLS))
+	case dnssvc.ProtocolHTTPS, dnssvc.ProtocolQUIC, dnssvc.ProtocolTLS:
+		errs = validate.Append(errs, "tls", c.TLS)
+		if c.ProxyProtocol {
+			errs = append(errs, fmt.Errorf("proxy_protocol: not supported for protocol %q", p))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("protocol: %w: %q", errors.ErrBadEnumValue, p))
+	}
+
 	return errors.Join(errs...)
 }
 
+// acceptsProxyProtocol returns true if c is not nil and requires the PROXY
+// protocol header.
+func (c *listenAddressConfig) acceptsProxyProtocol() (ok bool) {
+	return c != nil && c.ProxyProtocol
+}
+
+// protocol returns the protocol to serve on the address, substituting the
+// default one for the empty value.
+func (c *listenAddressConfig) protocol() (p dnssvc.Protocol) {
+	return cmp.Or(c.Protocol, dnssvc.ProtocolDNS)
+}
+
+// Transport networks used by the listeners.
+const (
+	networkTCP = "tcp"
+	networkUDP = "udp"
+)
+
+// networks returns the transport networks the listener for c binds to.
+func (c *listenAddressConfig) networks() (nets []string) {
+	switch c.protocol() {
+	case dnssvc.ProtocolDNS:
+		return []string{networkUDP, networkTCP}
+	case dnssvc.ProtocolQUIC:
+		return []string{networkUDP}
+	default:
+		return []string{networkTCP}
+	}
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c *listenAddressConfig) toInternal() (conf *dnssvc.ListenAddrConfig) {
+	conf = &dnssvc.ListenAddrConfig{
+		Protocol:      c.protocol(),
+		Address:       c.Address,
+		ProxyProtocol: c.ProxyProtocol,
+	}
+
+	if c.TLS != nil {
+		conf.TLS = &dnssvc.TLSConfig{
+			CertificatePath: c.TLS.CertificatePath,
+			PrivateKeyPath:  c.TLS.PrivateKeyPath,
+		}
+	}
+
+	return conf
+}
+
+// validateListenConflicts returns errors about the listen addresses that can't
+// be bound together with the previous ones.  Encrypted listeners also must not
+// share the network and the port with different certificates, since the
+// certificate is chosen by those.  nil entries are skipped, since those are
+// reported by their own validation.
+func validateListenConflicts(confs []*listenAddressConfig) (errs []error) {
+	ports := encryptedPorts{}
+	for i, c := range confs {
+		if c == nil {
+			continue
+		}
+
+		if j, ok := findListenConflict(confs[:i], c); ok {
+			err := fmt.Errorf("conflicts with address at index %d", j)
+			errs = append(errs, fmt.Errorf("listen_addresses: at index %d: %w", i, err))
+		}
+
+		if err := ports.add(confs, i); err != nil {
+			errs = append(errs, fmt.Errorf("listen_addresses: at index %d: %w", i, err))
+		}
+	}
+
+	return errs
+}
+
+// encryptedPort is the network and the port of an encrypted listener, which
+// its certificate is chosen by.
+type encryptedPort struct {
+	// network is the transport network of the listener.
+	network string
+
+	// port is the port of the listener.
+	port uint16
+}
+
+// encryptedPorts maps the networks and the ports of the encrypted listeners to
+// the indexes of the first ones using those.
+type encryptedPorts map[encryptedPort]int
+
+// add adds the listener at index i within confs to ports.  It returns an error
+// if the listener shares the network and the port with a previous one using
+// another certificate.  confs[i] must not be nil.
+func (ports encryptedPorts) add(confs []*listenAddressConfig, i int) (err error) {
+	c := confs[i]
+	if !c.protocol().IsEncrypted() || c.TLS == nil {
+		return nil
+	}
+
+	p := encryptedPort{
+		network: c.networks()[0],
+		port:    c.Address.Port(),
+	}
+
+	j, ok := ports[p]
+	if !ok {
+		ports[p] = i
+
+		return nil
+	} else if *confs[j].TLS == *c.TLS {
+		return nil
+	}
+
+	return fmt.Errorf("%s port %d: certificate differs from the one at index %d", p.network, p.port, j)
+}
+
+// findListenConflict returns the index of the first configuration within prev
+// that binds the same transport address as c.
+func findListenConflict(prev []*listenAddressConfig, c *listenAddressConfig) (idx int, ok bool) {
+	for i, p := range prev {
+		if p == nil || p.Address.Port() != c.Address.Port() || !addrsOverlap(p, c) {
+			continue
+		}
+
+		for _, n := range c.networks() {
+			if slices.Contains(p.networks(), n) {
+				return i, true
+			}
+		}
+	}
+
+	return -1, false
+}
+
+// addrsOverlap returns true if a and b are the same IP address or either of
+// them is unspecified, and thus occupies the port on all addresses.
+func addrsOverlap(a, b *listenAddressConfig) (ok bool) {
+	aIP, bIP := a.Address.Addr(), b.Address.Addr()
+
+	return aIP == bIP || aIP.IsUnspecified() || bIP.IsUnspecified()
+}
+
+// tlsConfig is the configuration for the certificate of an encrypted listener.
+type tlsConfig struct {
+	// CertificatePath is the path to the PEM-encoded certificate chain.
+	CertificatePath string `yaml:"certificate_path"`
+
+	// PrivateKeyPath is the path to the PEM-encoded private key.
+	PrivateKeyPath string `yaml:"private_key_path"`
+}
+
+// type check
+var _ validate.Interface = (*tlsConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *tlsConfig.
+func (c *tlsConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	return errors.Join(
+		validate.NotEmpty("certificate_path", c.CertificatePath),
+		validate.NotEmpty("private_key_path", c.PrivateKeyPath),
+	)
+}
+
 // bindRetryConfig is the configuration for retrying to bind to listen
 // addresses.
 type bindRetryConfig struct {
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/testutil"
+)
+
+func TestValidateListenConflicts(t *testing.T) {
+	t.Parallel()
+
+	addr := netip.MustParseAddrPort("0.0.0.0:853")
+	otherAddr := netip.MustParseAddrPort("192.0.2.1:853")
+	localAddr := netip.MustParseAddrPort("127.0.0.1:853")
+
+	cert := &tlsConfig{
+		CertificatePath: "/etc/adguarddnsclient/cert.pem",
+		PrivateKeyPath:  "/etc/adguarddnsclient/key.pem",
+	}
+	otherCert := &tlsConfig{
+		CertificatePath: "/etc/adguarddnsclient/other.pem",
+		PrivateKeyPath:  "/etc/adguarddnsclient/other-key.pem",
+	}
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []*listenAddressConfig
+	}{{
+		name:       "tls_and_quic",
+		wantErrMsg: "",
+		confs: []*listenAddressConfig{{
+			TLS:      cert,
+			Protocol: dnssvc.ProtocolTLS,
+			Address:  addr,
+		}, {
+			TLS:      otherCert,
+			Protocol: dnssvc.ProtocolQUIC,
+			Address:  addr,
+		}},
+	}, {
+		name:       "tls_same_certificate",
+		wantErrMsg: "",
+		confs: []*listenAddressConfig{{
+			TLS:      cert,
+			Protocol: dnssvc.ProtocolTLS,
+			Address:  otherAddr,
+		}, {
+			TLS:      cert,
+			Protocol: dnssvc.ProtocolTLS,
+			Address:  localAddr,
+		}},
+	}, {
+		name: "tls_other_certificate",
+		wantErrMsg: "listen_addresses: at index 1: " +
+			"tcp port 853: certificate differs from the one at index 0",
+		confs: []*listenAddressConfig{{
+			TLS:      cert,
+			Protocol: dnssvc.ProtocolTLS,
+			Address:  otherAddr,
+		}, {
+			TLS:      otherCert,
+			Protocol: dnssvc.ProtocolTLS,
+			Address:  localAddr,
+		}},
+	}, {
+		name:       "tls_and_https",
+		wantErrMsg: "listen_addresses: at index 1: conflicts with address at index 0",
+		confs: []*listenAddressConfig{{
+			TLS:      cert,
+			Protocol: dnssvc.ProtocolTLS,
+			Address:  addr,
+		}, {
+			TLS:      cert,
+			Protocol: dnssvc.ProtocolHTTPS,
+			Address:  addr,
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := errors.Join(validateListenConflicts(tc.confs)...)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestServerConfig_validateProxyProtocol(t *testing.T) {
+	t.Parallel()
+
+	listenAddrs := []*listenAddressConfig{{
+		Address:       netip.MustParseAddrPort("0.0.0.0:53"),
+		ProxyProtocol: true,
+	}}
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		proxies    []netutil.Prefix
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		proxies: []netutil.Prefix{{
+			Prefix: netip.MustParsePrefix("192.0.2.0/24"),
+		}},
+	}, {
+		name:       "no_proxies",
+		wantErrMsg: "listen_addresses: at index 0: proxy_protocol: no trusted_proxies",
+		proxies:    nil,
+	}, {
+		name: "any_address",
+		wantErrMsg: "trusted_proxies: at index 1: " +
+			"::/0 trusts any address, but proxy_protocol is used",
+		proxies: []netutil.Prefix{{
+			Prefix: netip.MustParsePrefix("192.0.2.0/24"),
+		}, {
+			Prefix: netip.MustParsePrefix("::/0"),
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := &serverConfig{
+				ListenAddresses: listenAddrs,
+				TrustedProxies:  tc.proxies,
+			}
+
+			err := errors.Join(c.validateProxyProtocol()...)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
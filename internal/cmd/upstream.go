@@ -1,19 +1,24 @@
 package cmd
 
 import (
+	"cmp"
 	"fmt"
 	"maps"
 	"net/netip"
+	"path/filepath"
 	"slices"
 	"strings"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/filter"
+	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/AdguardTeam/golibs/validate"
+	"github.com/miekg/dns"
 )
 
 // upstreamConfig is the configuration for the DNS upstream servers.
@@ -34,13 +39,30 @@ func (c *upstreamConfig) toInternal() (conf *dnssvc.UpstreamConfig) {
 
 	for name, g := range c.Groups {
 		grpConf := &dnssvc.UpstreamGroupConfig{
-			Name:    name,
-			Address: g.Address,
+			Name:        name,
+			Mode:        g.mode(),
+			FilterLists: g.FilterLists,
+			Timeout:     time.Duration(g.Timeout),
+		}
+		if g.Bootstrap != nil {
+			grpConf.Bootstrap = g.Bootstrap.toInternal()
+		}
+		if g.Fallback != nil {
+			grpConf.Fallback = g.Fallback.toInternal()
+		}
+		for _, srv := range g.Servers {
+			grpConf.Addresses = append(grpConf.Addresses, srv.Address)
 		}
 		for _, m := range g.Match {
+			domain, domainMatch, exclude := m.domainPattern()
 			grpConf.Match = append(grpConf.Match, dnssvc.MatchCriteria{
 				Client:         m.Client.Prefix,
-				QuestionDomain: m.QuestionDomain,
+				QuestionDomain: domain,
+				DomainFile:     m.QuestionDomainFile,
+				ClientFile:     m.ClientFile,
+				QuestionType:   m.questionType(),
+				DomainMatch:    domainMatch,
+				Exclude:        exclude,
 			})
 		}
 
@@ -68,10 +90,17 @@ func (c *upstreamConfig) Validate() (err error) {
 }
 
 // indexedMatch is a key for matchSet.  It's essentially an
-// [upstreamMatchConfig] with a lowercased question domain.
+// [upstreamMatchConfig] with a lowercased and parsed question domain pattern
+// and a parsed question type.  The exclusions have the same keys as the
+// subtree matches of the same domain, since both are mapped to the same
+// domain-specific upstreams.
 type indexedMatch struct {
-	domain string
-	client netip.Prefix
+	domain      string
+	domainFile  string
+	clientFile  string
+	client      netip.Prefix
+	qtype       uint16
+	domainMatch dnssvc.DomainMatch
 }
 
 // matchSet validates that no two matches have the same domain and client in
@@ -143,9 +172,14 @@ func (c upstreamGroupsConfig) validateGroups(errs []error) (res []error) {
 	for _, name := range slices.Sorted(maps.Keys(c)) {
 		g := c[name]
 
-		var err error
+		err := name.Validate()
+		if err != nil {
+			err = fmt.Errorf("group %q: name: %w", name, err)
+			errs = append(errs, err)
+		}
+
 		if slices.Contains(predefinedGroups, name) {
-			err = g.validateAsPredefined()
+			err = g.validateAsPredefined(name)
 		} else {
 			err = g.validateAsCustom(ms, name)
 		}
@@ -160,24 +194,103 @@ func (c upstreamGroupsConfig) validateGroups(errs []error) (res []error) {
 
 // upstreamGroupConfig is the configuration for a group of DNS upstream servers.
 type upstreamGroupConfig struct {
-	// Address is the URL of the upstream server for this group.
-	Address string `yaml:"address"`
+	// Bootstrap is the group-specific configuration for resolving the
+	// hostnames of the group's servers and fallbacks.  If it's nil, the common
+	// one is used.
+	Bootstrap *bootstrapConfig `yaml:"bootstrap"`
+
+	// Fallback is the group-specific configuration for the fallback servers.
+	// If it's nil, the common one is used.
+	Fallback *fallbackConfig `yaml:"fallback"`
+
+	// Mode is the mode of using the servers of this group.  Empty value means
+	// [dnssvc.UpstreamModeLoadBalance].
+	Mode dnssvc.UpstreamMode `yaml:"mode"`
+
+	// Servers is the list of upstream servers for this group.
+	Servers []*urlConfig `yaml:"servers"`
 
 	// Match is the set of criteria for choosing this group.
 	Match []*upstreamMatchConfig `yaml:"match"`
+
+	// FilterLists are the names of the rule lists to filter the requests
+	// routed to this group with, unless the client's subnet has its own lists.
+	FilterLists []filter.ListName `yaml:"filter_lists"`
+
+	// Timeout is the group-specific timeout for sending requests and receiving
+	// responses.  Zero value means the common timeout.
+	Timeout timeutil.Duration `yaml:"timeout"`
+}
+
+// mode returns the mode of using the servers of this group, substituting the
+// default one for the empty value.
+func (c *upstreamGroupConfig) mode() (m dnssvc.UpstreamMode) {
+	return cmp.Or(c.Mode, dnssvc.UpstreamModeLoadBalance)
 }
 
-// validateAsPredefined returns an error if c is not a valid predefined group
-// configuration that should have no match criteria.
-func (c *upstreamGroupConfig) validateAsPredefined() (err error) {
+// validateServers returns the errors of validating the servers, the mode, and
+// the group-specific timeout and bootstrap of c.  c must not be nil.
+func (c *upstreamGroupConfig) validateServers() (errs []error) {
+	errs = []error{
+		validate.NotEmptySlice("servers", c.Servers),
+	}
+	errs = validate.AppendSlice(errs, "servers", c.Servers)
+
+	addrs := container.NewMapSet[string]()
+	for i, srv := range c.Servers {
+		if srv == nil || srv.Address == "" {
+			continue
+		}
+
+		if addrs.Has(srv.Address) {
+			err := fmt.Errorf("servers: at index %d: address: %w: %q", i, errors.ErrDuplicated, srv.Address)
+			errs = append(errs, err)
+		}
+
+		addrs.Add(srv.Address)
+	}
+
+	errs = append(errs, validate.NotNegative("timeout", c.Timeout))
+	if c.Bootstrap != nil {
+		errs = validate.Append(errs, "bootstrap", c.Bootstrap)
+	}
+
+	switch m := c.mode(); m {
+	case
+		dnssvc.UpstreamModeFastestAddr,
+		dnssvc.UpstreamModeLoadBalance,
+		dnssvc.UpstreamModeParallel,
+		dnssvc.UpstreamModeSequential:
+		// Go on.
+	default:
+		errs = append(errs, fmt.Errorf("mode: %w: %q", errors.ErrBadEnumValue, m))
+	}
+
+	return errs
+}
+
+// validateAsPredefined returns an error if c is not a valid configuration of
+// the predefined group named name that should have no match criteria.
+func (c *upstreamGroupConfig) validateAsPredefined(name agdc.UpstreamGroupName) (err error) {
 	if c == nil {
 		return errors.ErrNoValue
 	}
 
-	return errors.Join(
-		validate.NotEmpty("address", c.Address),
-		validate.EmptySlice("match", c.Match),
-	)
+	errs := c.validateServers()
+	errs = append(errs, validate.EmptySlice("match", c.Match))
+	if name == agdc.UpstreamGroupNamePrivate {
+		// The fallbacks are never used and the filtering is never applied to
+		// the private requests.
+		errs = append(
+			errs,
+			validate.Nil("fallback", c.Fallback),
+			validate.EmptySlice("filter_lists", c.FilterLists),
+		)
+	} else if c.Fallback != nil {
+		errs = validate.Append(errs, "fallback", c.Fallback)
+	}
+
+	return errors.Join(errs...)
 }
 
 // validateAsCustom returns an error if c is not a valid custom group
@@ -187,12 +300,17 @@ func (c *upstreamGroupConfig) validateAsCustom(s matchSet, n agdc.UpstreamGroupN
 		return errors.ErrNoValue
 	}
 
-	errs := []error{
-		validate.NotEmpty("address", c.Address),
+	errs := c.validateServers()
+	if c.Fallback != nil {
+		errs = validate.Append(errs, "fallback", c.Fallback)
 	}
 
 	for i, m := range c.Match {
 		err = m.validate(s, n)
+		if err == nil {
+			err = c.validateExclusion(m)
+		}
+
 		if err != nil {
 			err = fmt.Errorf("match: at index %d: %w", i, err)
 			errs = append(errs, err)
@@ -202,14 +320,112 @@ func (c *upstreamGroupConfig) validateAsCustom(s matchSet, n agdc.UpstreamGroupN
 	return errors.Join(errs...)
 }
 
+// validateExclusion returns an error if m is an exclusion, which doesn't narrow
+// any other match of c.  m must be valid.
+func (c *upstreamGroupConfig) validateExclusion(m *upstreamMatchConfig) (err error) {
+	excl, _, ok := m.domainPattern()
+	if !ok {
+		return nil
+	}
+
+	excl = strings.ToLower(excl)
+	for _, other := range c.Match {
+		if other != nil && other.isNarrowedBy(m, excl) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"question_domain: exclusion %q doesn't narrow any other match of the group",
+		m.QuestionDomain,
+	)
+}
+
+// isNarrowedBy returns true if c is a match, which the exclusion m of the
+// lowercased domain excl narrows.  m must be valid.
+func (c *upstreamMatchConfig) isNarrowedBy(m *upstreamMatchConfig, excl string) (ok bool) {
+	domain, domainMatch, exclude := c.domainPattern()
+	if exclude || c.Client != m.Client || c.ClientFile != "" {
+		return false
+	} else if c.QuestionDomainFile != "" {
+		// The contents of the file may change, so it can't be checked here.
+		return true
+	}
+
+	return domainMatch != dnssvc.DomainMatchExact &&
+		domain != "" &&
+		strings.HasSuffix(excl, "."+strings.ToLower(domain))
+}
+
 // upstreamMatchConfig is the configuration for criteria for choosing an
 // upstream group.
 type upstreamMatchConfig struct {
 	// Client is the client's subnet to match.  Prefix itself should be masked.
 	Client netutil.Prefix `yaml:"client"`
 
-	// QuestionDomain is the domain name from request's question to match.
+	// QuestionDomain is the pattern of the domain name from request's question
+	// to match.  The domain name itself matches the domain and all its
+	// subdomains, the one prefixed with [domainPrefixExact] only matches the
+	// domain itself, and the one prefixed with [domainPrefixSubdomains] only
+	// matches its subdomains.  The one prefixed with [domainPrefixExclude]
+	// excludes the domain and all its subdomains from the other matches of
+	// the group for the same client.
 	QuestionDomain string `yaml:"question_domain"`
+
+	// QuestionType is the type of the request's question to match, e.g.
+	// "AAAA" or "HTTPS".
+	QuestionType string `yaml:"question_type"`
+
+	// QuestionDomainFile is the absolute path to the file containing the
+	// domain names to match along with their subdomains, one per line.  It's
+	// mutually exclusive with QuestionDomain.
+	QuestionDomainFile string `yaml:"question_domain_file"`
+
+	// ClientFile is the absolute path to the file containing the IP addresses
+	// and subnets of the clients to match, one per line.  It's mutually
+	// exclusive with Client.
+	ClientFile string `yaml:"client_file"`
+}
+
+// Prefixes of the question domain patterns.
+const (
+	domainPrefixExact      = "="
+	domainPrefixSubdomains = "*."
+	domainPrefixExclude    = "!"
+)
+
+// domainPattern returns the domain of the question domain pattern of c, the
+// way it should match, and whether it's an exclusion.
+func (c *upstreamMatchConfig) domainPattern() (
+	domain string,
+	domainMatch dnssvc.DomainMatch,
+	exclude bool,
+) {
+	return parseDomainPattern(c.QuestionDomain)
+}
+
+// parseDomainPattern returns the domain of the domain pattern q, the way it
+// should match, and whether it's an exclusion.
+func parseDomainPattern(q string) (domain string, domainMatch dnssvc.DomainMatch, exclude bool) {
+	if d, ok := strings.CutPrefix(q, domainPrefixExclude); ok {
+		return d, dnssvc.DomainMatchSubtree, true
+	}
+
+	if d, ok := strings.CutPrefix(q, domainPrefixExact); ok {
+		return d, dnssvc.DomainMatchExact, false
+	}
+
+	if d, ok := strings.CutPrefix(q, domainPrefixSubdomains); ok {
+		return d, dnssvc.DomainMatchSubdomains, false
+	}
+
+	return q, dnssvc.DomainMatchSubtree, false
+}
+
+// questionType returns the parsed question type of c, or zero if it's empty or
+// invalid.
+func (c *upstreamMatchConfig) questionType() (qtype uint16) {
+	return dns.StringToType[strings.ToUpper(c.QuestionType)]
 }
 
 // validate returns error if c is not valid.
@@ -229,13 +445,8 @@ func (c *upstreamMatchConfig) validate(s matchSet, name agdc.UpstreamGroupName)
 func (c *upstreamMatchConfig) validateValues(s matchSet, name agdc.UpstreamGroupName) (err error) {
 	var errs []error
 
-	if c.QuestionDomain != "" {
-		err = netutil.ValidateDomainName(c.QuestionDomain)
-		if err != nil {
-			err = fmt.Errorf("question_domain: %w", err)
-			errs = append(errs, err)
-		}
-	}
+	errs = append(errs, c.validateQuestion()...)
+	errs = append(errs, c.validateFiles()...)
 
 	// TODO(e.burkov):  It may be useful to be able to specify the whole address
 	// and only change the mask.
@@ -250,11 +461,91 @@ func (c *upstreamMatchConfig) validateValues(s matchSet, name agdc.UpstreamGroup
 	return errors.Join(errs...)
 }
 
+// validateQuestion returns the errors of validating the question domain
+// pattern and the question type of c.  c must not be nil.
+func (c *upstreamMatchConfig) validateQuestion() (errs []error) {
+	domain, _, exclude := c.domainPattern()
+	if c.QuestionDomain != "" {
+		err := netutil.ValidateDomainName(domain)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("question_domain: %w", err))
+		}
+	}
+
+	if c.QuestionType == "" {
+		return errs
+	}
+
+	if exclude {
+		err := fmt.Errorf("question_type: %w for exclusion", errors.ErrNotEmpty)
+		errs = append(errs, err)
+	} else if c.questionType() == 0 {
+		err := fmt.Errorf("question_type: %w: %q", errors.ErrBadEnumValue, c.QuestionType)
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// validateFiles returns the errors of validating the match list files of c.
+// c must not be nil.
+func (c *upstreamMatchConfig) validateFiles() (errs []error) {
+	if c.QuestionDomainFile != "" {
+		err := validateListFile(c.QuestionDomainFile, dnssvc.ValidateDomainListFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("question_domain_file: %w", err))
+		}
+
+		if c.QuestionDomain != "" {
+			err = fmt.Errorf("question_domain: %w along with question_domain_file", errors.ErrNotEmpty)
+			errs = append(errs, err)
+		}
+	}
+
+	if c.ClientFile == "" {
+		return errs
+	}
+
+	err := validateListFile(c.ClientFile, dnssvc.ValidateClientListFile)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("client_file: %w", err))
+	}
+
+	if c.Client.Prefix != (netip.Prefix{}) {
+		err = fmt.Errorf("client: %w along with client_file", errors.ErrNotEmpty)
+		errs = append(errs, err)
+	}
+
+	if _, _, exclude := c.domainPattern(); exclude {
+		err = fmt.Errorf("client_file: %w for exclusion", errors.ErrNotEmpty)
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// validateListFile returns an error if path is not absolute or the file at it
+// is not a valid match list according to validateContent.
+func validateListFile(path string, validateContent func(path string) (err error)) (err error) {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("path %q must be absolute", path)
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return validateContent(path)
+}
+
 // toIndexedMatch converts the upstream match configuration to a key for
 // [matchSet].
 func (c *upstreamMatchConfig) toIndexedMatch() (im indexedMatch) {
+	domain, domainMatch, _ := c.domainPattern()
+
 	return indexedMatch{
-		domain: strings.ToLower(c.QuestionDomain),
-		client: c.Client.Prefix,
+		domain:      strings.ToLower(domain),
+		domainFile:  c.QuestionDomainFile,
+		clientFile:  c.ClientFile,
+		client:      c.Client.Prefix,
+		qtype:       c.questionType(),
+		domainMatch: domainMatch,
 	}
 }
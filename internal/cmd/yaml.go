@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// extensionPrefix is the prefix of the configuration keys that are ignored
+// instead of being reported as unknown.  It allows keeping the properties meant
+// for other versions of AdGuard DNS Client, as well as any user-defined data,
+// within the configuration file.
+const extensionPrefix = "x-"
+
+// decodeStrict decodes data into both root and conf, reporting the unknown
+// fields, except for the ones prefixed with [extensionPrefix], as errors.
+// confPath is used to locate the errors.
+func decodeStrict(confPath string, data []byte, root *yaml.Node, conf *configuration) (errs []error) {
+	err := yaml.Unmarshal(data, root)
+	if err != nil {
+		return []error{newDecodeError(confPath, err.Error())}
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	err = dec.Decode(conf)
+	if err == nil {
+		return nil
+	}
+
+	typeErr := &yaml.TypeError{}
+	if !errors.As(err, &typeErr) {
+		// The errors returned by the unmarshalers of the field types carry no
+		// position, so try to find the value they complain about.
+		decErr := &locatedError{err: err, path: confPath}
+		if n := findQuotedScalar(root, err.Error()); n != nil {
+			decErr.line, decErr.column = n.Line, n.Column
+		}
+
+		return []error{decErr}
+	}
+
+	for _, msg := range typeErr.Errors {
+		if decErr := newTypeError(confPath, root, msg); decErr != nil {
+			errs = append(errs, decErr)
+		}
+	}
+
+	return errs
+}
+
+// locatedError is an error found at the specific position of the
+// configuration file.
+type locatedError struct {
+	// err is the underlying error.
+	err error
+
+	// path is the path to the configuration file.
+	path string
+
+	// line is the line number of the position, starting from 1.  Zero means
+	// the position is unknown.
+	line int
+
+	// column is the column number of the position, starting from 1.  Zero
+	// means the column is unknown.
+	column int
+}
+
+// type check
+var _ errors.Wrapper = (*locatedError)(nil)
+
+// Error implements the [error] interface for *locatedError.
+func (e *locatedError) Error() (msg string) {
+	switch {
+	case e.line == 0:
+		return fmt.Sprintf("%s: %s", e.path, e.err)
+	case e.column == 0:
+		return fmt.Sprintf("%s:%d: %s", e.path, e.line, e.err)
+	default:
+		return fmt.Sprintf("%s:%d:%d: %s", e.path, e.line, e.column, e.err)
+	}
+}
+
+// Unwrap implements the [errors.Wrapper] interface for *locatedError.
+func (e *locatedError) Unwrap() (unwrapped error) { return e.err }
+
+// newDecodeError returns a *locatedError for msg reported by the YAML decoder.
+// The decoder only reports the line numbers in the form of "line N: ...".
+func newDecodeError(confPath, msg string) (err *locatedError) {
+	msg = strings.TrimPrefix(msg, "yaml: ")
+	err = &locatedError{
+		err:  errors.Error(msg),
+		path: confPath,
+	}
+
+	lineStr, rest, ok := strings.Cut(strings.TrimPrefix(msg, "line "), ": ")
+	if !ok || !strings.HasPrefix(msg, "line ") {
+		return err
+	}
+
+	line, convErr := strconv.Atoi(lineStr)
+	if convErr != nil {
+		return err
+	}
+
+	err.err = errors.Error(rest)
+	err.line = line
+
+	return err
+}
+
+// unknownFieldRe matches the message of the YAML decoder about an unknown
+// field.  The submatches are the line number, the field name, and the name of
+// the Go type.
+var unknownFieldRe = regexp.MustCompile(`^line (\d+): field (.+) not found in type (\S+)$`)
+
+// newTypeError returns an error for msg from [yaml.TypeError].  The unknown
+// fields are reported along with their keys and the closest known field, if
+// any.  err is nil if the field is an extension one.
+func newTypeError(confPath string, root *yaml.Node, msg string) (err *locatedError) {
+	m := unknownFieldRe.FindStringSubmatch(msg)
+	if m == nil {
+		return newDecodeError(confPath, msg)
+	}
+
+	field, typeName := m[2], m[3]
+	if strings.HasPrefix(field, extensionPrefix) {
+		return nil
+	}
+
+	err = newDecodeError(confPath, msg)
+	errMsg := fmt.Sprintf("unknown field %q", field)
+	if suggestion := closestField(field, typeName); suggestion != "" {
+		errMsg += fmt.Sprintf(", did you mean %q?", suggestion)
+	}
+
+	key, keys := findKey(root, field, err.line, nil)
+	if key != nil {
+		errMsg = strings.Join(append(keys, errMsg), ": ")
+		err.column = key.Column
+	}
+
+	err.err = errors.Error(errMsg)
+
+	return err
+}
+
+// closestField returns the known field of the Go type named typeName closest
+// to field, or an empty string if there is no field close enough.
+func closestField(field, typeName string) (closest string) {
+	// maxDist is the maximum edit distance for a field to be suggested.
+	maxDist := max(2, len(field)/3)
+
+	for _, known := range knownFields()[typeName] {
+		dist := editDistance(field, known)
+		if dist <= maxDist {
+			closest, maxDist = known, dist
+		}
+	}
+
+	return closest
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) (dist int) {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := range len(a) {
+		curr[0] = i + 1
+		for j := range len(b) {
+			cost := 1
+			if a[i] == b[j] {
+				cost = 0
+			}
+
+			curr[j+1] = min(prev[j+1]+1, curr[j]+1, prev[j]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// findKey returns the mapping key node with the value field located on the
+// line within n, and the keys of the nodes enclosing it in the same form as
+// the validation errors report them.  key is nil if there is no such node.
+func findKey(n *yaml.Node, field string, line int, parents []string) (key *yaml.Node, keys []string) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) > 0 {
+			return findKey(n.Content[0], field, line, parents)
+		}
+	case yaml.MappingNode:
+		return findMappingKey(n, field, line, parents)
+	case yaml.SequenceNode:
+		return findSequenceKey(n, field, line, parents)
+	default:
+		// Go on.
+	}
+
+	return nil, nil
+}
+
+// findSequenceKey is the [findKey] implementation for sequence nodes.
+func findSequenceKey(n *yaml.Node, field string, line int, parents []string) (key *yaml.Node, keys []string) {
+	for i, child := range n.Content {
+		childKeys := append(slices.Clip(parents), fmt.Sprintf("at index %d", i))
+		if key, keys = findKey(child, field, line, childKeys); key != nil {
+			return key, keys
+		}
+	}
+
+	return nil, nil
+}
+
+// findMappingKey is the [findKey] implementation for mapping nodes.
+func findMappingKey(n *yaml.Node, field string, line int, parents []string) (key *yaml.Node, keys []string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k := n.Content[i]
+		if k.Value == field && k.Line == line {
+			return k, parents
+		}
+
+		childKeys := append(slices.Clip(parents), k.Value)
+		if key, keys = findKey(n.Content[i+1], field, line, childKeys); key != nil {
+			return key, keys
+		}
+	}
+
+	return nil, nil
+}
+
+// findQuotedScalar returns the first scalar value node within n, which value
+// is contained in msg in the quoted form, or nil if there is no such node.
+func findQuotedScalar(n *yaml.Node, msg string) (found *yaml.Node) {
+	if n.Kind == yaml.ScalarNode {
+		if n.Value != "" && strings.Contains(msg, strconv.Quote(n.Value)) {
+			return n
+		}
+
+		return nil
+	}
+
+	for _, child := range valueNodes(n) {
+		if found = findQuotedScalar(child, msg); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+// valueNodes returns the child nodes of n, skipping the keys of mappings.
+func valueNodes(n *yaml.Node) (values []*yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		return n.Content
+	}
+
+	for i := 1; i < len(n.Content); i += 2 {
+		values = append(values, n.Content[i])
+	}
+
+	return values
+}
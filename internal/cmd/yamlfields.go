@@ -0,0 +1,71 @@
+package cmd
+
+// NOTE:  Package reflect is blocklisted by the linter, and this file is the
+// documented exception.  Keep the reflection-based code here.
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// knownFields returns the YAML keys of the structures used within
+// [configuration] by the names of their Go types, as reported by the YAML
+// decoder.
+var knownFields = sync.OnceValue(func() (fields map[string][]string) {
+	fields = map[string][]string{}
+	addKnownFields(fields, reflect.TypeFor[configuration]())
+
+	return fields
+})
+
+// addKnownFields adds the YAML keys of t and all the structures it contains to
+// fields.
+func addKnownFields(fields map[string][]string, t reflect.Type) {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		addKnownFields(fields, t.Elem())
+
+		return
+	case reflect.Struct:
+		// Go on.
+	default:
+		return
+	}
+
+	if _, ok := fields[t.String()]; ok {
+		return
+	}
+
+	var keys []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name := yamlFieldName(f)
+		if name == "" {
+			continue
+		}
+
+		keys = append(keys, name)
+		addKnownFields(fields, f.Type)
+	}
+
+	fields[t.String()] = keys
+}
+
+// yamlFieldName returns the YAML key of the structure field f the same way the
+// YAML decoder does, or an empty string if the field isn't decoded.
+func yamlFieldName(f reflect.StructField) (name string) {
+	if !f.IsExported() {
+		return ""
+	}
+
+	name, _, _ = strings.Cut(f.Tag.Get("yaml"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(f.Name)
+	default:
+		return name
+	}
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/validate"
+	"github.com/miekg/dns"
+)
+
+// zonesConfig is the configuration of the zones answered authoritatively.
+type zonesConfig []*zoneConfig
+
+// type check
+var _ validate.Interface = zonesConfig(nil)
+
+// Validate implements the [validate.Interface] interface for zonesConfig.  The
+// zones must not overlap.
+func (c zonesConfig) Validate() (err error) {
+	var errs []error
+	for i, z := range c {
+		err = z.Validate()
+		if err == nil {
+			err = c[:i].validateOverlap(z)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("at index %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateOverlap returns an error if the valid z overlaps any zone of c.
+func (c zonesConfig) validateOverlap(z *zoneConfig) (err error) {
+	name := z.fqdn()
+	for _, other := range c {
+		if other == nil {
+			continue
+		}
+
+		otherName := other.fqdn()
+		if dns.IsSubDomain(otherName, name) || dns.IsSubDomain(name, otherName) {
+			return fmt.Errorf("name: %q overlaps zone %q", z.Name, other.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateGroups returns an error if a match of groups is shadowed by a zone of
+// c, since the zones take precedence over the upstream groups.
+func (c zonesConfig) validateGroups(groups upstreamGroupsConfig) (err error) {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(groups)) {
+		g := groups[name]
+		if g == nil {
+			// Reported by the validation of the groups.
+			continue
+		}
+
+		for i, m := range g.Match {
+			err = c.validateMatch(m)
+			if err != nil {
+				err = fmt.Errorf("group %q: match: at index %d: question_domain: %w", name, i, err)
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateMatch returns an error if the question domain of m is within a zone
+// of c.
+func (c zonesConfig) validateMatch(m *upstreamMatchConfig) (err error) {
+	if m == nil {
+		return nil
+	}
+
+	domain, _, exclude := m.domainPattern()
+	if domain == "" || exclude {
+		return nil
+	}
+
+	domain = dns.Fqdn(strings.ToLower(domain))
+	for _, z := range c {
+		if z != nil && dns.IsSubDomain(z.fqdn(), domain) {
+			return fmt.Errorf("%q conflicts with zone %q", m.QuestionDomain, z.Name)
+		}
+	}
+
+	return nil
+}
+
+// toInternal converts the configuration to the internal representation.  c
+// must be valid.
+func (c zonesConfig) toInternal() (confs []*dnssvc.ZoneConfig) {
+	for _, z := range c {
+		confs = append(confs, &dnssvc.ZoneConfig{
+			Name: z.Name,
+			Path: z.File,
+		})
+	}
+
+	return confs
+}
+
+// zoneConfig is the configuration of a single zone answered authoritatively.
+type zoneConfig struct {
+	// Name is the domain name of the zone's apex.
+	Name string `yaml:"name"`
+
+	// File is the absolute path to the zone file in the RFC 1035 format.
+	File string `yaml:"file"`
+}
+
+// fqdn returns the lowercased FQDN of the zone's apex.
+func (c *zoneConfig) fqdn() (name string) {
+	return dns.Fqdn(strings.ToLower(c.Name))
+}
+
+// type check
+var _ validate.Interface = (*zoneConfig)(nil)
+
+// Validate implements the [validate.Interface] interface for *zoneConfig.
+func (c *zoneConfig) Validate() (err error) {
+	if c == nil {
+		return errors.ErrNoValue
+	}
+
+	err = netutil.ValidateDomainName(c.Name)
+	if err != nil {
+		return fmt.Errorf("name: %w", err)
+	}
+
+	if !filepath.IsAbs(c.File) {
+		return fmt.Errorf("file: path %q must be absolute", c.File)
+	}
+
+	err = dnssvc.ValidateZoneFile(c.Name, c.File)
+	if err != nil {
+		return fmt.Errorf("file: %w", err)
+	}
+
+	return nil
+}
@@ -26,30 +26,24 @@ type Config struct {
 	// Logger used to log migrator operations.
 	Logger *slog.Logger
 
-	// WorkingDir is the absolute path to the working directory of AdGuardDNS
-	// Client.
-	WorkingDir string
-
-	// ConfigFileName is the name of the configuration file within the working
-	// directory.
-	ConfigFileName string
+	// ConfigPath is the absolute path to the configuration file.  Backups of
+	// the configuration file are created in its directory.
+	ConfigPath string
 }
 
 // Migrator performs the YAML configuration file migrations.
 type Migrator struct {
-	clock      timeutil.Clock
-	logger     *slog.Logger
-	workingDir string
-	configName string
+	clock    timeutil.Clock
+	logger   *slog.Logger
+	confPath string
 }
 
 // New creates a new Migrator.
 func New(c *Config) (m *Migrator) {
 	return &Migrator{
-		clock:      c.Clock,
-		logger:     c.Logger,
-		workingDir: c.WorkingDir,
-		configName: c.ConfigFileName,
+		clock:    c.Clock,
+		logger:   c.Logger,
+		confPath: c.ConfigPath,
 	}
 }
 
@@ -58,7 +52,7 @@ func New(c *Config) (m *Migrator) {
 func (m *Migrator) Run(ctx context.Context, target SchemaVersion) (err error) {
 	defer func() { err = errors.Annotate(err, "migrating: %w") }()
 
-	confPath := filepath.Join(m.workingDir, m.configName)
+	confPath := m.confPath
 	logger := m.logger.With("config_path", confPath)
 
 	conf, confData, err := readYAML(confPath)
@@ -95,6 +89,46 @@ func (m *Migrator) Run(ctx context.Context, target SchemaVersion) (err error) {
 	return m.writeMigrated(ctx, logger, conf, confData, confPath, current, target)
 }
 
+// DryRun performs the migrations of the configuration file up to target schema
+// version in memory, without writing the file or creating the backup.  data is
+// the migrated configuration, or the original one if it needs no migration.
+// current is the schema version of the original configuration file.  Unlike
+// [Migrator.Run], it returns an error if the file doesn't exist.
+func (m *Migrator) DryRun(
+	ctx context.Context,
+	target SchemaVersion,
+) (data []byte, current SchemaVersion, err error) {
+	defer func() { err = errors.Annotate(err, "migrating: %w") }()
+
+	conf, confData, err := readYAML(m.confPath)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, 0, err
+	}
+
+	current, err = m.getVersion(conf)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, 0, err
+	} else if current == target {
+		return confData, current, nil
+	}
+
+	err = m.migrate(ctx, conf, current, target)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, 0, err
+	}
+
+	data, err = encodeYAML(conf)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, 0, err
+	}
+
+	return data, current, nil
+}
+
 // getVersion returns the schema version from the configuration object.
 func (m *Migrator) getVersion(conf yObj) (v SchemaVersion, err error) {
 	verInt, err := fieldVal[int](conf, SchemaVersionKey)
@@ -119,9 +153,17 @@ type migrateFunc = func(ctx context.Context, conf yObj) (err error)
 func (m *Migrator) migrate(ctx context.Context, conf yObj, curr, targ SchemaVersion) (err error) {
 	migrations := [VersionLatest]migrateFunc{
 		// There is obviously no migration to the initial version.
-		0: nil,
-		1: m.migrateTo2,
-		2: m.migrateTo3,
+		0:  nil,
+		1:  m.migrateTo2,
+		2:  m.migrateTo3,
+		3:  m.migrateTo4,
+		4:  m.migrateTo5,
+		5:  m.migrateTo6,
+		6:  m.migrateTo7,
+		7:  m.migrateTo8,
+		8:  m.migrateTo9,
+		9:  m.migrateTo10,
+		10: m.migrateTo11,
 	}
 
 	for i, migrate := range migrations[curr:targ] {
@@ -161,17 +203,17 @@ func (m *Migrator) backupConfig(
 	// Create a backup directory if it doesn't exist.
 	bkpTime := m.clock.Now().Format(BackupDateTimeFormat)
 	bkpDirName := fmt.Sprintf(BackupDirNameFormat, curr, targ, bkpTime)
-	bkpDirPath := filepath.Join(m.workingDir, bkpDirName)
+	bkpDirPath := filepath.Join(filepath.Dir(m.confPath), bkpDirName)
 
 	// Don't use [os.MkdirAll] since the backup directory is expected to be
-	// right in the working directory and should not yet exist.
+	// right next to the configuration file and should not yet exist.
 	err = os.Mkdir(bkpDirPath, agdcos.DefaultPermDir)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return fmt.Errorf("creating backup directory: %w", err)
 	}
 
-	bkpPath := filepath.Join(bkpDirPath, m.configName)
+	bkpPath := filepath.Join(bkpDirPath, filepath.Base(m.confPath))
 
 	err = maybe.WriteFile(bkpPath, origData, agdcos.DefaultPermFile)
 	if err != nil {
@@ -195,17 +237,10 @@ func (m *Migrator) writeMigrated(
 	curr SchemaVersion,
 	targ SchemaVersion,
 ) (err error) {
-	buf := &bytes.Buffer{}
-	enc := yaml.NewEncoder(buf)
-
-	err = enc.Encode(conf)
-	if err != nil {
-		return fmt.Errorf("encoding migrated configuration: %w", err)
-	}
-
-	err = enc.Close()
+	data, err := encodeYAML(conf)
 	if err != nil {
-		return fmt.Errorf("closing the encoder: %w", err)
+		// Don't wrap the error since it's informative enough as is.
+		return err
 	}
 
 	err = m.backupConfig(ctx, origData, curr, targ)
@@ -217,7 +252,7 @@ func (m *Migrator) writeMigrated(
 
 	// TODO(e.burkov):  Take care of permissions on Windows.
 
-	err = maybe.WriteFile(origPath, buf.Bytes(), agdcos.DefaultPermFile)
+	err = maybe.WriteFile(origPath, data, agdcos.DefaultPermFile)
 	if err != nil {
 		return fmt.Errorf("writing migrated configuration: %w", err)
 	}
@@ -226,3 +261,21 @@ func (m *Migrator) writeMigrated(
 
 	return nil
 }
+
+// encodeYAML returns the YAML encoding of the migrated configuration.
+func encodeYAML(conf yObj) (data []byte, err error) {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+
+	err = enc.Encode(conf)
+	if err != nil {
+		return nil, fmt.Errorf("encoding migrated configuration: %w", err)
+	}
+
+	err = enc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("closing the encoder: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
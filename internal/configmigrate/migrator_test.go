@@ -14,6 +14,7 @@ import (
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/AdguardTeam/golibs/testutil/faketime"
+	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -120,10 +121,9 @@ func TestMigrator_Run_success(t *testing.T) {
 			t.Parallel()
 
 			migrator := configmigrate.New(&configmigrate.Config{
-				Clock:          clock,
-				Logger:         slogutil.NewDiscardLogger(),
-				WorkingDir:     tempDir,
-				ConfigFileName: configFilename,
+				Clock:      clock,
+				Logger:     slogutil.NewDiscardLogger(),
+				ConfigPath: outPath,
 			})
 
 			ctx := testutil.ContextWithTimeout(t, testTimeout)
@@ -135,3 +135,68 @@ func TestMigrator_Run_success(t *testing.T) {
 		})
 	}
 }
+
+func TestMigrator_DryRun(t *testing.T) {
+	t.Parallel()
+
+	var (
+		inPath   = filepath.Join(testdataName, "TestMigrator_Run_success", "v8", "in.yaml")
+		wantPath = filepath.Join(testdataName, "TestMigrator_Run_success", "v8", "want.yaml")
+	)
+
+	tempDir := t.TempDir()
+	confPath := filepath.Join(tempDir, "config.yaml")
+	copyFile(t, inPath, confPath)
+
+	migrator := configmigrate.New(&configmigrate.Config{
+		Clock:      timeutil.SystemClock{},
+		Logger:     slogutil.NewDiscardLogger(),
+		ConfigPath: confPath,
+	})
+
+	t.Run("migrate", func(t *testing.T) {
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		data, current, err := migrator.DryRun(ctx, configmigrate.SchemaVersion(8))
+		require.NoError(t, err)
+
+		assert.Equal(t, configmigrate.SchemaVersion(7), current)
+
+		wantData, err := os.ReadFile(wantPath)
+		require.NoError(t, err)
+
+		assert.YAMLEq(t, string(wantData), string(data))
+
+		// Make sure the file is neither modified nor backed up.
+		assertEqualYAML(t, inPath, confPath)
+
+		entries, err := os.ReadDir(tempDir)
+		require.NoError(t, err)
+
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("no_migration", func(t *testing.T) {
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		data, current, err := migrator.DryRun(ctx, configmigrate.SchemaVersion(7))
+		require.NoError(t, err)
+
+		assert.Equal(t, configmigrate.SchemaVersion(7), current)
+
+		wantData, err := os.ReadFile(inPath)
+		require.NoError(t, err)
+
+		assert.Equal(t, wantData, data)
+	})
+
+	t.Run("not_exist", func(t *testing.T) {
+		m := configmigrate.New(&configmigrate.Config{
+			Clock:      timeutil.SystemClock{},
+			Logger:     slogutil.NewDiscardLogger(),
+			ConfigPath: filepath.Join(tempDir, "nonexistent.yaml"),
+		})
+
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		_, _, err := m.DryRun(ctx, configmigrate.VersionLatest)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
@@ -0,0 +1,81 @@
+package configmigrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// migrateTo10 migrates the configuration from version 9 to version 10.  It adds
+// the private_subnets and use_private_rdns properties of the dns.server object
+// with the values used before those became configurable:
+//
+// # Before:
+//
+//	dns:
+//	    server:
+//	        # …
+//	    # …
+//	# …
+//	schema_version: 9
+//
+// # After:
+//
+//	dns:
+//	    server:
+//	        private_subnets:
+//	          - '10.0.0.0/8'
+//	          # …
+//	          - 'fe80::/10'
+//	        use_private_rdns: true
+//	        # …
+//	    # …
+//	# …
+//	schema_version: 10
+func (m *Migrator) migrateTo10(ctx context.Context, conf yObj) (err error) {
+	const target SchemaVersion = 10
+
+	dnsVal, err := fieldVal[yObj](conf, "dns")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	serverVal, err := fieldVal[yObj](dnsVal, "server")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	for _, key := range []string{"private_subnets", "use_private_rdns"} {
+		if _, ok := serverVal[key]; ok {
+			// TODO(e.burkov):  Add errors.ErrNotNil.
+			return fmt.Errorf("%s: %w", key, errors.ErrNotEmpty)
+		}
+	}
+
+	// These are the networks defined by RFC 6303, which were considered
+	// private before.
+	serverVal["private_subnets"] = []any{
+		"10.0.0.0/8",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.0.2.0/24",
+		"192.168.0.0/16",
+		"198.51.100.0/24",
+		"203.0.113.0/24",
+		"255.255.255.255/32",
+		"::/128",
+		"::1/128",
+		"2001:db8::/32",
+		"fd00::/8",
+		"fe80::/10",
+	}
+	serverVal["use_private_rdns"] = true
+
+	conf[SchemaVersionKey] = target
+
+	return nil
+}
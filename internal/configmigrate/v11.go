@@ -0,0 +1,61 @@
+package configmigrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// migrateTo11 migrates the configuration from version 10 to version 11.  It
+// adds the trusted_proxies property of the dns.server object with the value
+// used before it became configurable:
+//
+// # Before:
+//
+//	dns:
+//	    server:
+//	        # …
+//	    # …
+//	# …
+//	schema_version: 10
+//
+// # After:
+//
+//	dns:
+//	    server:
+//	        trusted_proxies:
+//	          - '0.0.0.0/0'
+//	          - '::/0'
+//	        # …
+//	    # …
+//	# …
+//	schema_version: 11
+func (m *Migrator) migrateTo11(ctx context.Context, conf yObj) (err error) {
+	const target SchemaVersion = 11
+
+	dnsVal, err := fieldVal[yObj](conf, "dns")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	serverVal, err := fieldVal[yObj](dnsVal, "server")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	const key = "trusted_proxies"
+	if _, ok := serverVal[key]; ok {
+		// TODO(e.burkov):  Add errors.ErrNotNil.
+		return fmt.Errorf("%s: %w", key, errors.ErrNotEmpty)
+	}
+
+	// All the proxies were trusted before.
+	serverVal[key] = []any{"0.0.0.0/0", "::/0"}
+
+	conf[SchemaVersionKey] = target
+
+	return nil
+}
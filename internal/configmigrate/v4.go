@@ -0,0 +1,57 @@
+package configmigrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// migrateTo4 migrates the configuration from version 3 to version 4.  It adds
+// the bind_address property to the debug.pprof object:
+//
+// # Before:
+//
+//	debug:
+//	    pprof:
+//	        # …
+//	# …
+//	schema_version: 3
+//
+// # After:
+//
+//	debug:
+//	    pprof:
+//	        bind_address: '127.0.0.1'
+//	        # …
+//	# …
+//	schema_version: 4
+func (m *Migrator) migrateTo4(ctx context.Context, conf yObj) (err error) {
+	const target SchemaVersion = 4
+
+	debugVal, err := fieldVal[yObj](conf, "debug")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	pprofVal, err := fieldVal[yObj](debugVal, "pprof")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	const key = "bind_address"
+
+	_, ok := pprofVal[key]
+	if ok {
+		// TODO(e.burkov):  Add errors.ErrNotNil.
+		return fmt.Errorf("%s: %w", key, errors.ErrNotEmpty)
+	}
+
+	pprofVal[key] = "127.0.0.1"
+
+	conf[SchemaVersionKey] = target
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package configmigrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// migrateTo5 migrates the configuration from version 4 to version 5.  It adds
+// the reload object:
+//
+// # Before:
+//
+//	dns:
+//	    # …
+//	# …
+//	schema_version: 4
+//
+// # After:
+//
+//	dns:
+//	    # …
+//	# …
+//	reload:
+//	    watch: false
+//	    interval: 10s
+//	schema_version: 5
+func (m *Migrator) migrateTo5(ctx context.Context, conf yObj) (err error) {
+	const target SchemaVersion = 5
+
+	const key = "reload"
+
+	_, ok := conf[key]
+	if ok {
+		// TODO(e.burkov):  Add errors.ErrNotNil.
+		return fmt.Errorf("%s: %w", key, errors.ErrNotEmpty)
+	}
+
+	conf[key] = yObj{
+		"watch":    false,
+		"interval": "10s",
+	}
+
+	conf[SchemaVersionKey] = target
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package configmigrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// migrateTo6 migrates the configuration from version 5 to version 6.  It adds
+// the debug.metrics object:
+//
+// # Before:
+//
+//	debug:
+//	    pprof:
+//	        # …
+//	# …
+//	schema_version: 5
+//
+// # After:
+//
+//	debug:
+//	    pprof:
+//	        # …
+//	    metrics:
+//	        bind_address: '127.0.0.1'
+//	        port: 6060
+//	        enabled: false
+//	# …
+//	schema_version: 6
+func (m *Migrator) migrateTo6(ctx context.Context, conf yObj) (err error) {
+	const target SchemaVersion = 6
+
+	debugVal, err := fieldVal[yObj](conf, "debug")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	const key = "metrics"
+
+	_, ok := debugVal[key]
+	if ok {
+		// TODO(e.burkov):  Add errors.ErrNotNil.
+		return fmt.Errorf("%s: %w", key, errors.ErrNotEmpty)
+	}
+
+	debugVal[key] = yObj{
+		"bind_address": "127.0.0.1",
+		"port":         6060,
+		"enabled":      false,
+	}
+
+	conf[SchemaVersionKey] = target
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package configmigrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// migrateTo7 migrates the configuration from version 6 to version 7.  It adds
+// the query_log object:
+//
+// # Before:
+//
+//	dns:
+//	    # …
+//	# …
+//	schema_version: 6
+//
+// # After:
+//
+//	dns:
+//	    # …
+//	# …
+//	query_log:
+//	    enabled: false
+//	    file: 'querylog.jsonl'
+//	    max_size: 100MB
+//	    max_age: 168h
+//	    max_backups: 5
+//	    buffer_size: 1024
+//	    anonymize_client_ip: false
+//	schema_version: 7
+func (m *Migrator) migrateTo7(ctx context.Context, conf yObj) (err error) {
+	const target SchemaVersion = 7
+
+	const key = "query_log"
+
+	_, ok := conf[key]
+	if ok {
+		// TODO(e.burkov):  Add errors.ErrNotNil.
+		return fmt.Errorf("%s: %w", key, errors.ErrNotEmpty)
+	}
+
+	conf[key] = yObj{
+		"enabled":             false,
+		"file":                "querylog.jsonl",
+		"max_size":            "100MB",
+		"max_age":             "168h",
+		"max_backups":         5,
+		"buffer_size":         1024,
+		"anonymize_client_ip": false,
+	}
+
+	conf[SchemaVersionKey] = target
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package configmigrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// migrateTo8 migrates the configuration from version 7 to version 8.  It adds
+// the control object:
+//
+// # Before:
+//
+//	dns:
+//	    # …
+//	# …
+//	schema_version: 7
+//
+// # After:
+//
+//	dns:
+//	    # …
+//	# …
+//	control:
+//	    bind_address: '127.0.0.1'
+//	    token: ''
+//	    port: 8053
+//	    enabled: false
+//	schema_version: 8
+func (m *Migrator) migrateTo8(ctx context.Context, conf yObj) (err error) {
+	const target SchemaVersion = 8
+
+	const key = "control"
+
+	_, ok := conf[key]
+	if ok {
+		// TODO(e.burkov):  Add errors.ErrNotNil.
+		return fmt.Errorf("%s: %w", key, errors.ErrNotEmpty)
+	}
+
+	conf[key] = yObj{
+		"bind_address": "127.0.0.1",
+		"token":        "",
+		"port":         8053,
+		"enabled":      false,
+	}
+
+	conf[SchemaVersionKey] = target
+
+	return nil
+}
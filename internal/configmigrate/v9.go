@@ -0,0 +1,110 @@
+package configmigrate
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// migrateTo9 migrates the configuration from version 8 to version 9.  It
+// replaces the address property of each upstream group with the servers list
+// and adds the mode property:
+//
+// # Before:
+//
+//	dns:
+//	    upstream:
+//	        groups:
+//	            'default':
+//	                address: 'https://unfiltered.adguard-dns.com/dns-query'
+//	                # …
+//	            # …
+//	        # …
+//	    # …
+//	# …
+//	schema_version: 8
+//
+// # After:
+//
+//	dns:
+//	    upstream:
+//	        groups:
+//	            'default':
+//	                servers:
+//	                  - address: 'https://unfiltered.adguard-dns.com/dns-query'
+//	                mode: 'load_balance'
+//	                # …
+//	            # …
+//	        # …
+//	    # …
+//	# …
+//	schema_version: 9
+func (m *Migrator) migrateTo9(ctx context.Context, conf yObj) (err error) {
+	const target SchemaVersion = 9
+
+	dnsVal, err := fieldVal[yObj](conf, "dns")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	upsVal, err := fieldVal[yObj](dnsVal, "upstream")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	groupsVal, err := fieldVal[yObj](upsVal, "groups")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(groupsVal)) {
+		err = migrateGroupTo9(groupsVal, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("group %q: %w", name, err))
+		}
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		return err
+	}
+
+	conf[SchemaVersionKey] = target
+
+	return nil
+}
+
+// migrateGroupTo9 migrates the upstream group with the given name within
+// groups to version 9.
+func migrateGroupTo9(groups yObj, name string) (err error) {
+	groupVal, err := fieldVal[yObj](groups, name)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	addr, err := fieldVal[string](groupVal, "address")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	for _, key := range []string{"servers", "mode"} {
+		if _, ok := groupVal[key]; ok {
+			// TODO(e.burkov):  Add errors.ErrNotNil.
+			return fmt.Errorf("%s: %w", key, errors.ErrNotEmpty)
+		}
+	}
+
+	delete(groupVal, "address")
+	groupVal["servers"] = []any{yObj{"address": addr}}
+	groupVal["mode"] = "load_balance"
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package ctrlsvc
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/netutil/httputil"
+)
+
+// authMiddleware is the [httputil.Middleware] that requires the requests to
+// have the bearer token.
+type authMiddleware struct {
+	// logger is used to log the errors of writing responses.
+	logger *slog.Logger
+
+	// token is the required bearer token.
+	token []byte
+}
+
+// newAuthMiddleware returns a new properly initialized *authMiddleware.  l
+// must not be nil and token must not be empty.
+func newAuthMiddleware(l *slog.Logger, token string) (mw *authMiddleware) {
+	return &authMiddleware{
+		logger: l,
+		token:  []byte(token),
+	}
+}
+
+// type check
+var _ httputil.Middleware = (*authMiddleware)(nil)
+
+// Wrap implements the [httputil.Middleware] interface for *authMiddleware.
+func (mw *authMiddleware) Wrap(h http.Handler) (wrapped http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !mw.isAuthorized(r) {
+			w.Header().Set(httphdr.WWWAuthenticate, `Bearer realm="control"`)
+			writeError(r.Context(), mw.logger, w, http.StatusUnauthorized, errUnauthorized)
+
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isAuthorized returns true if r has the valid bearer token.
+func (mw *authMiddleware) isAuthorized(r *http.Request) (ok bool) {
+	const prefix = "Bearer "
+
+	hdr := r.Header.Get(httphdr.Authorization)
+	if len(hdr) < len(prefix) || !strings.EqualFold(hdr[:len(prefix)], prefix) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hdr[len(prefix):]), mw.token) == 1
+}
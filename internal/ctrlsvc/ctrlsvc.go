@@ -0,0 +1,172 @@
+// Package ctrlsvc contains the HTTP API for controlling a running
+// AdGuardDNSClient.
+package ctrlsvc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil/httputil"
+	"github.com/AdguardTeam/golibs/service"
+)
+
+// DNSService is the DNS service controlled by the API.
+type DNSService interface {
+	// Listeners returns the addresses the service listens on.
+	Listeners() (ls []*dnssvc.Listener)
+
+	// UpstreamStatuses returns the health of the upstreams.
+	UpstreamStatuses() (statuses []*dnssvc.UpstreamStatus)
+
+	// FlushCache clears the cache of the client with prefix or all the caches
+	// if prefix is zero.  It returns [dnssvc.ErrNoClient] if there is no such
+	// client.
+	FlushCache(ctx context.Context, prefix netip.Prefix) (err error)
+}
+
+// Configurator provides and reloads the configuration.
+type Configurator interface {
+	// Config returns the current effective configuration with the secrets
+	// redacted.  conf must be encodable to JSON.
+	Config(ctx context.Context) (conf any, err error)
+
+	// Reload reads and applies the configuration.  If the configuration is
+	// invalid, the current one must stay in place.
+	Reload(ctx context.Context) (err error)
+}
+
+// Config is the configuration for [Service].
+type Config struct {
+	// Logger is used to log the operation of the service.  It must not be
+	// nil.
+	Logger *slog.Logger
+
+	// DNSService is the controlled DNS service.  It must not be nil.
+	DNSService DNSService
+
+	// Configurator provides and reloads the configuration.  It must not be
+	// nil.
+	Configurator Configurator
+
+	// Token is the bearer token required to use the API.  It must not be
+	// empty.
+	Token string
+
+	// Addr is the address to serve the API on.  It must be valid.
+	Addr netip.AddrPort
+}
+
+// Paths of the API.
+const (
+	PathCacheFlush = "/control/cache/flush"
+	PathConfig     = "/control/config"
+	PathListeners  = "/control/listeners"
+	PathReload     = "/control/reload"
+	PathStatus     = "/control/status"
+	PathUpstreams  = "/control/upstreams"
+)
+
+// Service is the control HTTP API service.
+type Service struct {
+	logger *slog.Logger
+	server *http.Server
+	dnsSvc DNSService
+	conf   Configurator
+
+	// listener is the actual listener of the service.  It's set on start.
+	listener net.Listener
+
+	// started is the time the service has been created.
+	started time.Time
+
+	addr netip.AddrPort
+}
+
+// readHeaderTimeout is the timeout for reading the headers of incoming
+// requests.
+const readHeaderTimeout = 10 * time.Second
+
+// New creates a new properly initialized *Service.  c must not be nil and must
+// be valid.
+func New(c *Config) (svc *Service) {
+	svc = &Service{
+		logger:  c.Logger,
+		dnsSvc:  c.DNSService,
+		conf:    c.Configurator,
+		started: time.Now(),
+		addr:    c.Addr,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(http.MethodGet+" "+PathStatus, svc.handleStatus)
+	mux.HandleFunc(http.MethodGet+" "+PathConfig, svc.handleConfig)
+	mux.HandleFunc(http.MethodGet+" "+PathListeners, svc.handleListeners)
+	mux.HandleFunc(http.MethodGet+" "+PathUpstreams, svc.handleUpstreams)
+	mux.HandleFunc(http.MethodPost+" "+PathCacheFlush, svc.handleCacheFlush)
+	mux.HandleFunc(http.MethodPost+" "+PathReload, svc.handleReload)
+
+	handler := httputil.Wrap(
+		mux,
+		httputil.NewLogMiddleware(c.Logger, slog.LevelDebug),
+		newAuthMiddleware(c.Logger, c.Token),
+	)
+
+	svc.server = &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ErrorLog:          slog.NewLogLogger(c.Logger.Handler(), slog.LevelDebug),
+	}
+
+	return svc
+}
+
+// type check
+var _ service.Interface = (*Service)(nil)
+
+// Start implements the [service.Interface] interface for *Service.  It returns
+// after the address is bound.
+func (svc *Service) Start(ctx context.Context) (err error) {
+	svc.logger.DebugContext(ctx, "starting", "addr", svc.addr)
+
+	svc.listener, err = net.Listen("tcp", svc.addr.String())
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", svc.addr, err)
+	}
+
+	go svc.serve(ctx)
+
+	svc.logger.InfoContext(ctx, "listening", "addr", svc.listener.Addr())
+
+	return nil
+}
+
+// serve serves the HTTP API until the server is shut down.  It's intended to be
+// used as a goroutine.
+func (svc *Service) serve(ctx context.Context) {
+	defer slogutil.RecoverAndLog(ctx, svc.logger)
+
+	err := svc.server.Serve(svc.listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		svc.logger.ErrorContext(ctx, "serving", slogutil.KeyError, err)
+	}
+}
+
+// Shutdown implements the [service.Interface] interface for *Service.
+func (svc *Service) Shutdown(ctx context.Context) (err error) {
+	svc.logger.DebugContext(ctx, "shutting down")
+
+	err = svc.server.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("shutting down http server: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,308 @@
+package ctrlsvc_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdcslog"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/ctrlsvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// testToken is the bearer token for tests.
+const testToken = "test-token"
+
+// testDNSService is a mock implementation of [ctrlsvc.DNSService] for tests.
+type testDNSService struct {
+	OnListeners        func() (ls []*dnssvc.Listener)
+	OnUpstreamStatuses func() (statuses []*dnssvc.UpstreamStatus)
+	OnFlushCache       func(ctx context.Context, prefix netip.Prefix) (err error)
+}
+
+// type check
+var _ ctrlsvc.DNSService = (*testDNSService)(nil)
+
+// Listeners implements the [ctrlsvc.DNSService] interface for
+// *testDNSService.
+func (s *testDNSService) Listeners() (ls []*dnssvc.Listener) {
+	return s.OnListeners()
+}
+
+// UpstreamStatuses implements the [ctrlsvc.DNSService] interface for
+// *testDNSService.
+func (s *testDNSService) UpstreamStatuses() (statuses []*dnssvc.UpstreamStatus) {
+	return s.OnUpstreamStatuses()
+}
+
+// FlushCache implements the [ctrlsvc.DNSService] interface for
+// *testDNSService.
+func (s *testDNSService) FlushCache(ctx context.Context, prefix netip.Prefix) (err error) {
+	return s.OnFlushCache(ctx, prefix)
+}
+
+// testConfigurator is a mock implementation of [ctrlsvc.Configurator] for
+// tests.
+type testConfigurator struct {
+	OnConfig func(ctx context.Context) (conf any, err error)
+	OnReload func(ctx context.Context) (err error)
+}
+
+// type check
+var _ ctrlsvc.Configurator = (*testConfigurator)(nil)
+
+// Config implements the [ctrlsvc.Configurator] interface for
+// *testConfigurator.
+func (c *testConfigurator) Config(ctx context.Context) (conf any, err error) {
+	return c.OnConfig(ctx)
+}
+
+// Reload implements the [ctrlsvc.Configurator] interface for
+// *testConfigurator.
+func (c *testConfigurator) Reload(ctx context.Context) (err error) {
+	return c.OnReload(ctx)
+}
+
+// testPrefix is the client prefix for tests.
+var testPrefix = netip.MustParsePrefix("192.0.2.0/24")
+
+// newTestService starts a new service for tests and returns its address.
+func newTestService(t *testing.T) (host string) {
+	t.Helper()
+
+	dnsSvc := &testDNSService{
+		OnListeners: func() (ls []*dnssvc.Listener) {
+			return []*dnssvc.Listener{{
+				Proto: "udp",
+				Addr:  netip.MustParseAddrPort("127.0.0.1:53"),
+			}}
+		},
+		OnUpstreamStatuses: func() (statuses []*dnssvc.UpstreamStatus) {
+			return []*dnssvc.UpstreamStatus{{
+				LastFailure: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+				Group:       agdc.UpstreamGroupNameDefault,
+				Type:        agdcslog.UpstreamTypeMain,
+				Address:     "udp://192.0.2.1:53",
+				LastError:   "test error",
+				Failures:    1,
+				Healthy:     false,
+			}}
+		},
+		OnFlushCache: func(_ context.Context, prefix netip.Prefix) (err error) {
+			if prefix == (netip.Prefix{}) || prefix == testPrefix {
+				return nil
+			}
+
+			return dnssvc.ErrNoClient
+		},
+	}
+
+	conf := &testConfigurator{
+		OnConfig: func(_ context.Context) (conf any, err error) {
+			return map[string]any{"token": "********"}, nil
+		},
+		OnReload: func(_ context.Context) (err error) {
+			return errors.Error("bad config")
+		},
+	}
+
+	svc := ctrlsvc.New(&ctrlsvc.Config{
+		Logger:       slogutil.NewDiscardLogger(),
+		DNSService:   dnsSvc,
+		Configurator: conf,
+		Token:        testToken,
+		Addr:         netip.AddrPortFrom(netutil.IPv4Localhost(), 0),
+	})
+
+	err := svc.Start(testutil.ContextWithTimeout(t, testTimeout))
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return svc.Shutdown(context.Background())
+	})
+
+	return svc.Addr().String()
+}
+
+func TestService(t *testing.T) {
+	t.Parallel()
+
+	host := newTestService(t)
+	cli := &http.Client{
+		Timeout: testTimeout,
+	}
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		body     string
+		wantBody string
+		wantCode int
+	}{{
+		name:     "no_token",
+		method:   http.MethodGet,
+		path:     ctrlsvc.PathListeners,
+		token:    "",
+		body:     "",
+		wantBody: `{"error":"missing or invalid bearer token"}`,
+		wantCode: http.StatusUnauthorized,
+	}, {
+		name:     "bad_token",
+		method:   http.MethodGet,
+		path:     ctrlsvc.PathListeners,
+		token:    "bad",
+		body:     "",
+		wantBody: `{"error":"missing or invalid bearer token"}`,
+		wantCode: http.StatusUnauthorized,
+	}, {
+		name:     "config",
+		method:   http.MethodGet,
+		path:     ctrlsvc.PathConfig,
+		token:    testToken,
+		body:     "",
+		wantBody: `{"token":"********"}`,
+		wantCode: http.StatusOK,
+	}, {
+		name:     "listeners",
+		method:   http.MethodGet,
+		path:     ctrlsvc.PathListeners,
+		token:    testToken,
+		body:     "",
+		wantBody: `[{"proto":"udp","address":"127.0.0.1:53"}]`,
+		wantCode: http.StatusOK,
+	}, {
+		name:   "upstreams",
+		method: http.MethodGet,
+		path:   ctrlsvc.PathUpstreams,
+		token:  testToken,
+		body:   "",
+		wantBody: `[{"group":"default","type":"main","address":"udp://192.0.2.1:53",` +
+			`"last_error":"test error","last_failure":"2025-01-01T00:00:00Z",` +
+			`"failures":1,"healthy":false}]`,
+		wantCode: http.StatusOK,
+	}, {
+		name:     "flush_all",
+		method:   http.MethodPost,
+		path:     ctrlsvc.PathCacheFlush,
+		token:    testToken,
+		body:     "",
+		wantBody: "",
+		wantCode: http.StatusNoContent,
+	}, {
+		name:     "flush_client",
+		method:   http.MethodPost,
+		path:     ctrlsvc.PathCacheFlush,
+		token:    testToken,
+		body:     `{"client":"192.0.2.0/24"}`,
+		wantBody: "",
+		wantCode: http.StatusNoContent,
+	}, {
+		name:     "flush_unknown_client",
+		method:   http.MethodPost,
+		path:     ctrlsvc.PathCacheFlush,
+		token:    testToken,
+		body:     `{"client":"198.51.100.0/24"}`,
+		wantBody: `{"error":"no client-specific configuration for prefix"}`,
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "flush_bad_client",
+		method:   http.MethodPost,
+		path:     ctrlsvc.PathCacheFlush,
+		token:    testToken,
+		body:     `{"client":"bad"}`,
+		wantBody: `{"error":"client: netip.ParsePrefix(\"bad\"): no '/'"}`,
+		wantCode: http.StatusBadRequest,
+	}, {
+		name:     "reload_error",
+		method:   http.MethodPost,
+		path:     ctrlsvc.PathReload,
+		token:    testToken,
+		body:     "",
+		wantBody: `{"error":"bad config"}`,
+		wantCode: http.StatusUnprocessableEntity,
+	}, {
+		name:     "bad_method",
+		method:   http.MethodGet,
+		path:     ctrlsvc.PathReload,
+		token:    testToken,
+		body:     "",
+		wantBody: "Method Not Allowed",
+		wantCode: http.StatusMethodNotAllowed,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			u := &url.URL{
+				Scheme: "http",
+				Host:   host,
+				Path:   tc.path,
+			}
+
+			ctx := testutil.ContextWithTimeout(t, testTimeout)
+			req, err := http.NewRequestWithContext(ctx, tc.method, u.String(), strings.NewReader(tc.body))
+			require.NoError(t, err)
+
+			if tc.token != "" {
+				req.Header.Set(httphdr.Authorization, "Bearer "+tc.token)
+			}
+
+			resp, err := cli.Do(req)
+			require.NoError(t, err)
+			testutil.CleanupAndRequireSuccess(t, resp.Body.Close)
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantCode, resp.StatusCode)
+			assert.Equal(t, tc.wantBody, strings.TrimSpace(string(body)))
+		})
+	}
+
+	t.Run("status", func(t *testing.T) {
+		t.Parallel()
+
+		u := &url.URL{
+			Scheme: "http",
+			Host:   host,
+			Path:   ctrlsvc.PathStatus,
+		}
+
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		require.NoError(t, err)
+
+		req.Header.Set(httphdr.Authorization, "Bearer "+testToken)
+
+		resp, err := cli.Do(req)
+		require.NoError(t, err)
+		testutil.CleanupAndRequireSuccess(t, resp.Body.Close)
+
+		status := map[string]any{}
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, status, "version")
+		assert.Contains(t, status, "uptime_seconds")
+	})
+}
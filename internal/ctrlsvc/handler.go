@@ -0,0 +1,209 @@
+package ctrlsvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/version"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+)
+
+// errUnauthorized is returned when the request doesn't have a valid token.
+const errUnauthorized errors.Error = "missing or invalid bearer token"
+
+// maxBodySize is the maximum size of a request body.
+const maxBodySize = 4 * 1024
+
+// errorResp is the body of an error response.
+type errorResp struct {
+	Error string `json:"error"`
+}
+
+// statusResp is the body of the status response.
+type statusResp struct {
+	Version  string  `json:"version"`
+	Revision string  `json:"revision"`
+	Started  string  `json:"started"`
+	Uptime   float64 `json:"uptime_seconds"`
+}
+
+// handleStatus handles the GET requests to [PathStatus].
+func (svc *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(r.Context(), svc.logger, w, http.StatusOK, &statusResp{
+		Version:  version.Version(),
+		Revision: version.Revision(),
+		Started:  svc.started.UTC().Format(time.RFC3339),
+		Uptime:   time.Since(svc.started).Seconds(),
+	})
+}
+
+// handleConfig handles the GET requests to [PathConfig].
+func (svc *Service) handleConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	conf, err := svc.conf.Config(ctx)
+	if err != nil {
+		writeError(ctx, svc.logger, w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	writeJSON(ctx, svc.logger, w, http.StatusOK, conf)
+}
+
+// listenerResp is a single listener in the listeners response.
+type listenerResp struct {
+	Proto   string `json:"proto"`
+	Address string `json:"address"`
+}
+
+// handleListeners handles the GET requests to [PathListeners].
+func (svc *Service) handleListeners(w http.ResponseWriter, r *http.Request) {
+	resp := []*listenerResp{}
+	for _, l := range svc.dnsSvc.Listeners() {
+		resp = append(resp, &listenerResp{
+			Proto:   l.Proto,
+			Address: l.Addr.String(),
+		})
+	}
+
+	writeJSON(r.Context(), svc.logger, w, http.StatusOK, resp)
+}
+
+// upstreamResp is a single upstream in the upstreams response.
+type upstreamResp struct {
+	Group       string `json:"group,omitempty"`
+	Type        string `json:"type"`
+	Address     string `json:"address"`
+	LastError   string `json:"last_error,omitempty"`
+	LastSuccess string `json:"last_success,omitempty"`
+	LastFailure string `json:"last_failure,omitempty"`
+	Failures    uint   `json:"failures"`
+	Healthy     bool   `json:"healthy"`
+}
+
+// handleUpstreams handles the GET requests to [PathUpstreams].
+func (svc *Service) handleUpstreams(w http.ResponseWriter, r *http.Request) {
+	resp := []*upstreamResp{}
+	for _, s := range svc.dnsSvc.UpstreamStatuses() {
+		resp = append(resp, &upstreamResp{
+			Group:       string(s.Group),
+			Type:        s.Type,
+			Address:     s.Address,
+			LastError:   s.LastError,
+			LastSuccess: formatTime(s.LastSuccess),
+			LastFailure: formatTime(s.LastFailure),
+			Failures:    s.Failures,
+			Healthy:     s.Healthy,
+		})
+	}
+
+	writeJSON(r.Context(), svc.logger, w, http.StatusOK, resp)
+}
+
+// formatTime returns the textual representation of t or an empty string if t
+// is zero.
+func formatTime(t time.Time) (s string) {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// cacheFlushReq is the body of the cache flush request.
+type cacheFlushReq struct {
+	// Client is the prefix of the client-specific configuration to flush the
+	// cache of.  If it's empty, all the caches are flushed.
+	Client string `json:"client"`
+}
+
+// handleCacheFlush handles the POST requests to [PathCacheFlush].  The body is
+// optional.
+func (svc *Service) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	prefix, err := decodeCacheFlushReq(r)
+	if err != nil {
+		writeError(ctx, svc.logger, w, http.StatusBadRequest, err)
+
+		return
+	}
+
+	err = svc.dnsSvc.FlushCache(ctx, prefix)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, dnssvc.ErrNoClient) {
+			code = http.StatusNotFound
+		}
+
+		writeError(ctx, svc.logger, w, code, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeCacheFlushReq decodes the optional body of the cache flush request.
+// prefix is zero if the body is empty or has no client.
+func decodeCacheFlushReq(r *http.Request) (prefix netip.Prefix, err error) {
+	req := &cacheFlushReq{}
+	err = json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return netip.Prefix{}, fmt.Errorf("decoding request: %w", err)
+	} else if req.Client == "" {
+		return netip.Prefix{}, nil
+	}
+
+	prefix, err = netip.ParsePrefix(req.Client)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("client: %w", err)
+	}
+
+	return prefix, nil
+}
+
+// handleReload handles the POST requests to [PathReload].
+func (svc *Service) handleReload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	err := svc.conf.Reload(ctx)
+	if err != nil {
+		writeError(ctx, svc.logger, w, http.StatusUnprocessableEntity, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes v as the JSON body of the response with code.  l is used
+// to log the errors of writing.
+func writeJSON(ctx context.Context, l *slog.Logger, w http.ResponseWriter, code int, v any) {
+	w.Header().Set(httphdr.ContentType, "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		// The headers are already written, so just log the error.
+		l.DebugContext(ctx, "writing response", slogutil.KeyError, err)
+	}
+}
+
+// writeError writes err as the JSON body of the response with code.  l is
+// used to log the errors of writing.
+func writeError(ctx context.Context, l *slog.Logger, w http.ResponseWriter, code int, err error) {
+	writeJSON(ctx, l, w, code, &errorResp{
+		Error: err.Error(),
+	})
+}
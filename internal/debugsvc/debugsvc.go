@@ -0,0 +1,125 @@
+// Package debugsvc contains the debug HTTP service of AdGuardDNSClient.
+package debugsvc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil/httputil"
+	"github.com/AdguardTeam/golibs/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Config is the configuration for [Service].
+type Config struct {
+	// Logger is used to log the operation of the service.  It must not be
+	// nil.
+	Logger *slog.Logger
+
+	// Metrics is used to gather the Prometheus metrics served on
+	// [MetricsPath].  If it's nil, the metrics aren't served.
+	Metrics prometheus.Gatherer
+
+	// Addr is the address to serve the debug HTTP API on.  It must be valid.
+	Addr netip.AddrPort
+
+	// Pprof specifies if the pprof handlers should be served.
+	Pprof bool
+}
+
+// MetricsPath is the path to serve the Prometheus metrics on.
+const MetricsPath = "/metrics"
+
+// Service is the debug HTTP service that serves the pprof handlers and the
+// Prometheus metrics.
+type Service struct {
+	logger *slog.Logger
+	server *http.Server
+
+	// listener is the actual listener of the service.  It's set on start.
+	listener net.Listener
+
+	addr netip.AddrPort
+}
+
+// readHeaderTimeout is the timeout for reading the headers of incoming
+// requests.
+const readHeaderTimeout = 10 * time.Second
+
+// New creates a new properly initialized *Service.  c must not be nil and must
+// be valid.
+func New(c *Config) (svc *Service) {
+	mux := http.NewServeMux()
+	if c.Pprof {
+		httputil.RoutePprof(mux)
+	}
+
+	if c.Metrics != nil {
+		mux.Handle(MetricsPath, promhttp.HandlerFor(c.Metrics, promhttp.HandlerOpts{
+			ErrorLog: slog.NewLogLogger(c.Logger.Handler(), slog.LevelError),
+		}))
+	}
+
+	handler := httputil.Wrap(mux, httputil.NewLogMiddleware(c.Logger, slog.LevelDebug))
+
+	return &Service{
+		logger: c.Logger,
+		server: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: readHeaderTimeout,
+			ErrorLog:          slog.NewLogLogger(c.Logger.Handler(), slog.LevelDebug),
+		},
+		addr: c.Addr,
+	}
+}
+
+// type check
+var _ service.Interface = (*Service)(nil)
+
+// Start implements the [service.Interface] interface for *Service.  It returns
+// after the address is bound.
+func (svc *Service) Start(ctx context.Context) (err error) {
+	svc.logger.DebugContext(ctx, "starting", "addr", svc.addr)
+
+	svc.listener, err = net.Listen("tcp", svc.addr.String())
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", svc.addr, err)
+	}
+
+	go svc.serve(ctx)
+
+	svc.logger.InfoContext(ctx, "listening", "addr", svc.listener.Addr())
+
+	return nil
+}
+
+// serve serves the HTTP API until the server is shut down.  It's intended to be
+// used as a goroutine.
+func (svc *Service) serve(ctx context.Context) {
+	defer slogutil.RecoverAndLog(ctx, svc.logger)
+
+	err := svc.server.Serve(svc.listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		svc.logger.ErrorContext(ctx, "serving", slogutil.KeyError, err)
+	}
+}
+
+// Shutdown implements the [service.Interface] interface for *Service.
+func (svc *Service) Shutdown(ctx context.Context) (err error) {
+	svc.logger.DebugContext(ctx, "shutting down")
+
+	err = svc.server.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("shutting down http server: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+package debugsvc
+
+import (
+	"net"
+)
+
+// Addr returns the actual address the service listens on.  This is only needed
+// for testing.
+func (svc *Service) Addr() (addr net.Addr) {
+	return svc.listener.Addr()
+}
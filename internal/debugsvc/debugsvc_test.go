@@ -0,0 +1,97 @@
+package debugsvc_test
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/debugsvc"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/netutil/httputil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+func TestService(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		metrics    prometheus.Gatherer
+		name       string
+		wantPprof  int
+		wantMetric int
+		pprof      bool
+	}{{
+		metrics:    nil,
+		name:       "pprof",
+		wantPprof:  http.StatusOK,
+		wantMetric: http.StatusNotFound,
+		pprof:      true,
+	}, {
+		metrics:    prometheus.NewRegistry(),
+		name:       "metrics",
+		wantPprof:  http.StatusNotFound,
+		wantMetric: http.StatusOK,
+		pprof:      false,
+	}, {
+		metrics:    prometheus.NewRegistry(),
+		name:       "both",
+		wantPprof:  http.StatusOK,
+		wantMetric: http.StatusOK,
+		pprof:      true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := debugsvc.New(&debugsvc.Config{
+				Logger:  slogutil.NewDiscardLogger(),
+				Metrics: tc.metrics,
+				Addr:    netip.AddrPortFrom(netutil.IPv4Localhost(), 0),
+				Pprof:   tc.pprof,
+			})
+
+			err := svc.Start(testutil.ContextWithTimeout(t, testTimeout))
+			require.NoError(t, err)
+			testutil.CleanupAndRequireSuccess(t, func() (err error) {
+				return svc.Shutdown(context.Background())
+			})
+
+			host := svc.Addr().String()
+			assert.Equal(t, tc.wantPprof, getStatus(t, host, httputil.PprofBasePath))
+			assert.Equal(t, tc.wantMetric, getStatus(t, host, debugsvc.MetricsPath))
+		})
+	}
+}
+
+// getStatus returns the status code of the response to the GET request to the
+// path on host.
+func getStatus(tb testing.TB, host, path string) (code int) {
+	tb.Helper()
+
+	u := &url.URL{
+		Scheme: "http",
+		Host:   host,
+		Path:   path,
+	}
+
+	cli := &http.Client{
+		Timeout: testTimeout,
+	}
+
+	resp, err := cli.Get(u.String())
+	require.NoError(tb, err)
+	testutil.CleanupAndRequireSuccess(tb, resp.Body.Close)
+
+	return resp.StatusCode
+}
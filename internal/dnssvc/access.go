@@ -0,0 +1,210 @@
+package dnssvc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// RejectMode is the way of handling the rejected requests.
+type RejectMode string
+
+// Valid reject modes.
+const (
+	// RejectModeRefused makes the service respond to the rejected requests
+	// with REFUSED.
+	RejectModeRefused RejectMode = "refused"
+
+	// RejectModeDrop makes the service drop the rejected requests without
+	// responding.
+	RejectModeDrop RejectMode = "drop"
+)
+
+// RejectReason is the reason of rejecting a request.
+type RejectReason string
+
+// Valid reject reasons.
+const (
+	// RejectReasonDisallowedClient means that the client isn't allowed to
+	// send requests.
+	RejectReasonDisallowedClient RejectReason = "disallowed_client"
+
+	// RejectReasonBlockedDomain means that the question domain is blocked.
+	RejectReasonBlockedDomain RejectReason = "blocked_domain"
+)
+
+// errRejected is returned by [DNSService.HandleBefore] for the rejected
+// requests.
+const errRejected errors.Error = "request rejected"
+
+// AccessConfig is the configuration of the access control of the clients.
+type AccessConfig struct {
+	// Mode is the way of handling the rejected requests.  It must be valid.
+	Mode RejectMode
+
+	// AllowedClients are the subnets of the clients allowed to send requests.
+	// If not empty, the requests from other clients are rejected.
+	AllowedClients []netip.Prefix
+
+	// DisallowedClients are the subnets of the clients, which requests are
+	// rejected.  Those take precedence over AllowedClients.
+	DisallowedClients []netip.Prefix
+
+	// BlockedDomains are the domains, which requests for those and their
+	// subdomains are rejected.  Those must be valid domain names.
+	BlockedDomains []string
+}
+
+// access rejects the requests according to the access control configuration.
+type access struct {
+	// allowed are the subnets of the allowed clients.  It's nil if all the
+	// clients are allowed.
+	allowed netutil.SubnetSet
+
+	// disallowed are the subnets of the disallowed clients.
+	disallowed netutil.SubnetSet
+
+	// blocked contains the blocked domains.
+	blocked *domainTrie
+
+	// mode is the way of handling the rejected requests.
+	mode RejectMode
+}
+
+// newAccess returns a new *access for conf.  a is nil if conf is nil.
+func newAccess(conf *AccessConfig) (a *access) {
+	if conf == nil {
+		return nil
+	}
+
+	a = &access{
+		disallowed: netutil.SliceSubnetSet(conf.DisallowedClients),
+		blocked:    newDomainTrie(),
+		mode:       conf.Mode,
+	}
+
+	if len(conf.AllowedClients) > 0 {
+		a.allowed = netutil.SliceSubnetSet(conf.AllowedClients)
+	}
+
+	for _, d := range conf.BlockedDomains {
+		a.blocked.insert(strings.ToLower(dns.Fqdn(d)))
+	}
+
+	return a
+}
+
+// check returns the reason of rejecting the request from client for the
+// question domain host, if it should be rejected.  host is empty if the
+// request has no question.  a may be nil.
+func (a *access) check(client netip.Addr, host string) (reason RejectReason, ok bool) {
+	if a == nil {
+		return "", false
+	}
+
+	if a.disallowed.Contains(client) || (a.allowed != nil && !a.allowed.Contains(client)) {
+		return RejectReasonDisallowedClient, true
+	}
+
+	if host == "" {
+		return "", false
+	}
+
+	if _, ok = a.blocked.lookup(strings.ToLower(host)); ok {
+		return RejectReasonBlockedDomain, true
+	}
+
+	return "", false
+}
+
+// rejectLogIvl is the minimum interval between logging the rejected requests
+// from the same address.
+const rejectLogIvl = 1 * time.Minute
+
+// maxRejectLogged is the maximum number of the addresses tracked by
+// [rejectLogger] at once.
+const maxRejectLogged = 1024
+
+// rejectLogger logs the rejected requests, at most once per [rejectLogIvl]
+// for each address, so that flooding clients don't flood the log.
+type rejectLogger struct {
+	// logger is used to log the rejected requests.
+	logger *slog.Logger
+
+	// mu protects logged.
+	mu *sync.Mutex
+
+	// logged maps the addresses to the time their rejected requests have been
+	// logged last.
+	logged map[netip.Addr]time.Time
+}
+
+// newRejectLogger returns a new properly initialized *rejectLogger.
+func newRejectLogger(logger *slog.Logger) (l *rejectLogger) {
+	return &rejectLogger{
+		logger: logger,
+		mu:     &sync.Mutex{},
+		logged: map[netip.Addr]time.Time{},
+	}
+}
+
+// log logs the request from addr rejected for reason, unless another one from
+// addr has been logged recently.
+func (l *rejectLogger) log(ctx context.Context, addr netip.Addr, reason RejectReason) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if last, ok := l.logged[addr]; ok && now.Sub(last) < rejectLogIvl {
+		return
+	}
+
+	if len(l.logged) >= maxRejectLogged {
+		for a, last := range l.logged {
+			if now.Sub(last) >= rejectLogIvl {
+				delete(l.logged, a)
+			}
+		}
+	}
+
+	if len(l.logged) >= maxRejectLogged {
+		// Too many addresses are being rejected at once, so stop logging
+		// those until some of them expire.
+		return
+	}
+
+	l.logged[addr] = now
+	l.logger.InfoContext(ctx, "rejecting requests", "addr", addr, "reason", reason)
+}
+
+// reject reports the request of dctx rejected for reason and returns the error
+// making the proxy handle it according to mode.
+func (svc *DNSService) reject(
+	ctx context.Context,
+	dctx *proxy.DNSContext,
+	reason RejectReason,
+	mode RejectMode,
+) (err error) {
+	svc.metrics.ObserveRejected(ctx, reason)
+	svc.rejectLog.log(ctx, dctx.Addr.Addr(), reason)
+
+	err = fmt.Errorf("%w: %s", errRejected, reason)
+	if mode == RejectModeDrop {
+		return err
+	}
+
+	return &proxy.BeforeRequestError{
+		Err:      err,
+		Response: (&dns.Msg{}).SetRcode(dctx.Req, dns.RcodeRefused),
+	}
+}
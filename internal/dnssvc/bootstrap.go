@@ -22,10 +22,12 @@ type BootstrapConfig struct {
 }
 
 // newResolvers creates a new bootstrap resolver and a list of upstreams to
-// close on shutdown.  conf and l must not be nil.
+// close on shutdown.  The statistics of the bootstraps are reported to m.
+// conf, l, and m must not be nil.
 func newResolvers(
 	conf *BootstrapConfig,
 	l *slog.Logger,
+	m Metrics,
 ) (boot upstream.Resolver, closers []io.Closer, err error) {
 	defer func() { err = errors.Annotate(err, "creating bootstraps: %w") }()
 
@@ -48,8 +50,10 @@ func newResolvers(
 			continue
 		}
 
-		resolvers = append(resolvers, upstream.NewCachingResolver(b))
 		closers = append(closers, b.Upstream)
+
+		b.Upstream = newObservedUpstream(b.Upstream, m, agdcslog.UpstreamTypeBootstrap, "")
+		resolvers = append(resolvers, upstream.NewCachingResolver(b))
 	}
 
 	return resolvers, closers, errors.Join(errs...)
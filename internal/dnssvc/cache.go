@@ -1,5 +1,15 @@
 package dnssvc
 
+import (
+	"net/netip"
+	"sync"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
 // CacheConfig is the configuration for the DNS results cache.
 type CacheConfig struct {
 	// Enabled specifies if the cache should be used.
@@ -16,4 +26,141 @@ type CacheConfig struct {
 	ClientSize int
 }
 
+// cacheKey identifies a custom upstream configuration along with its cache
+// within [caches].
+type cacheKey struct {
+	// prefix is the client subnet of the client-specific configuration or the
+	// route.
+	prefix netip.Prefix
+
+	// group is the name of the upstream group of the route, or the name of the
+	// private group for the private configuration.  It's empty for the general
+	// and the client-specific configurations.
+	group agdc.UpstreamGroupName
+
+	// match is the index of the match criteria of the route within its group.
+	match int
+}
+
+// keyPrivate is the key of the configuration for the private PTR requests.
+var keyPrivate = cacheKey{group: agdc.UpstreamGroupNamePrivate}
+
+// isGeneral returns true if k is the key of the general or the private
+// configuration, which use the common cache size.
+func (k cacheKey) isGeneral() (ok bool) {
+	return k == cacheKey{} || k == keyPrivate
+}
+
+// choose returns the upstreams for req from the configuration of st with key
+// k.  If st has no such configuration, since the request has been routed
+// before the reconfiguration, the general one is used, unless k is the key of
+// the private configuration.
+func (k cacheKey) choose(st *upstreamState, req *dns.Msg) (ups []upstream.Upstream) {
+	conf, ok := st.configs[k]
+	if !ok {
+		if k == keyPrivate {
+			return nil
+		}
+
+		conf = st.general
+	}
+
+	ups, _ = selectUpstreams(conf, req)
+
+	return ups
+}
+
+// caches stores the custom upstream configurations used for the requests along
+// with their caches.  Those configurations use the upstreams from the current
+// upstream state of the service, see [stateUpstream], so that the caches are
+// kept on reconfiguration, unless the cache settings change.
+type caches struct {
+	// svc is the service to get the current upstream state from.
+	svc *DNSService
+
+	// mu protects configs and conf.
+	mu *sync.Mutex
+
+	// configs are the custom upstream configurations by their keys.
+	configs map[cacheKey]*proxy.CustomUpstreamConfig
+
+	// conf is the current cache settings.
+	conf CacheConfig
+}
+
+// newCaches returns a new properly initialized *caches for svc.
+func newCaches(svc *DNSService) (c *caches) {
+	return &caches{
+		svc:     svc,
+		mu:      &sync.Mutex{},
+		configs: map[cacheKey]*proxy.CustomUpstreamConfig{},
+	}
+}
+
+// update applies conf to c, dropping the configurations, which caches have
+// different settings.  conf must not be nil.
+func (c *caches) update(conf *CacheConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.configs {
+		if conf.Enabled != c.conf.Enabled ||
+			(k.isGeneral() && conf.Size != c.conf.Size) ||
+			(!k.isGeneral() && conf.ClientSize != c.conf.ClientSize) {
+			delete(c.configs, k)
+		}
+	}
+
+	c.conf = *conf
+}
+
+// get returns the custom upstream configuration with key k, creating it if
+// there is none.
+func (c *caches) get(k cacheKey) (conf *proxy.CustomUpstreamConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conf = c.configs[k]
+	if conf != nil {
+		return conf
+	}
+
+	size := c.conf.ClientSize
+	if k.isGeneral() {
+		size = c.conf.Size
+	}
+
+	conf = proxy.NewCustomUpstreamConfig(
+		c.svc.newStateUpstreamConfig(string(k.group), k.choose),
+		c.conf.Enabled,
+		size,
+		false,
+	)
+	c.configs[k] = conf
+
+	return conf
+}
+
+// prune removes the configurations not used by st.  st must not be nil.
+func (c *caches) prune(st *upstreamState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.configs {
+		if _, ok := st.configs[k]; !ok {
+			delete(c.configs, k)
+		}
+	}
+}
+
+// clear clears all the caches of c.
+func (c *caches) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, conf := range c.configs {
+		conf.ClearCache()
+	}
+}
+
 // TODO(e.burkov):  Add tests.
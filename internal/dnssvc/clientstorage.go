@@ -3,43 +3,167 @@ package dnssvc
 import (
 	"fmt"
 	"net/netip"
+	"slices"
+	"strings"
 
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 )
 
+// ClientConfig is the configuration of a named persistent client.
+type ClientConfig struct {
+	// Name is the name of the client.  It must be valid.
+	Name agdc.ClientName
+
+	// UpstreamGroup is the name of the upstream group to route the requests
+	// from the client to, unless those match a more specific criteria.  If
+	// empty, the client is routed like any other.  Otherwise, it must be the
+	// name of a group other than the predefined ones.
+	UpstreamGroup agdc.UpstreamGroupName
+
+	// Prefixes are the addresses and subnets identifying the client.  Those
+	// must not be empty and must be unique among all the clients.
+	Prefixes []netip.Prefix
+}
+
+// withClientMatches returns conf with the match criteria for the prefixes of
+// clients added to their upstream groups.  conf itself isn't modified.
+func withClientMatches(conf *UpstreamConfig, clients []*ClientConfig) (res *UpstreamConfig) {
+	byGroup := map[agdc.UpstreamGroupName][]netip.Prefix{}
+	for _, c := range clients {
+		if c.UpstreamGroup != "" {
+			byGroup[c.UpstreamGroup] = append(byGroup[c.UpstreamGroup], c.Prefixes...)
+		}
+	}
+
+	if len(byGroup) == 0 {
+		return conf
+	}
+
+	res = &UpstreamConfig{
+		Groups:  make([]*UpstreamGroupConfig, 0, len(conf.Groups)),
+		Timeout: conf.Timeout,
+	}
+
+	for _, g := range conf.Groups {
+		if prefixes := byGroup[g.Name]; len(prefixes) > 0 {
+			withClients := *g
+			withClients.Match = slices.Clip(g.Match)
+			for _, p := range prefixes {
+				withClients.Match = append(withClients.Match, MatchCriteria{Client: p})
+			}
+
+			g = &withClients
+		}
+
+		res.Groups = append(res.Groups, g)
+	}
+
+	return res
+}
+
 // upstreamConfigs is a set of client-specific upstream configurations.
 type upstreamConfigs map[netip.Prefix]*proxy.UpstreamConfig
 
-// clients creates a list of clients from confs.
-func (confs upstreamConfigs) clients(cacheConf *CacheConfig) (clients []*client) {
+// clients creates a list of clients from confs.  Each client's configuration
+// is complemented with general, so that it's self-sufficient.  The custom
+// configurations of the clients are taken from cs.
+func (confs upstreamConfigs) clients(
+	cs *caches,
+	general *proxy.UpstreamConfig,
+) (clients []*client) {
 	for cli, conf := range confs {
-		cliConf := proxy.NewCustomUpstreamConfig(
-			conf,
-			cacheConf.Enabled,
-			cacheConf.ClientSize,
-			false,
-		)
+		conf = withGeneral(conf, general)
 		clients = append(clients, &client{
-			conf:   cliConf,
-			prefix: cli,
+			conf:      cs.get(cacheKey{prefix: cli}),
+			upstreams: conf,
+			prefix:    cli,
 		})
 	}
 
 	return clients
 }
 
+// withGeneral complements conf with general, so that it selects the same
+// upstreams as the proxy does when conf is used as the custom configuration
+// and general is used as the proxy's own one.  That is, if conf has no default
+// upstreams, the general ones are used, and so are the domain-specific ones
+// not shadowed by conf.  conf is modified and returned.
+func withGeneral(conf, general *proxy.UpstreamConfig) (res *proxy.UpstreamConfig) {
+	if len(conf.Upstreams) > 0 {
+		return conf
+	}
+
+	conf.Upstreams = general.Upstreams
+	for domain, ups := range general.DomainReservedUpstreams {
+		if isShadowed(conf, domain) {
+			continue
+		}
+
+		conf.DomainReservedUpstreams[domain] = ups
+		conf.SpecifiedDomainUpstreams[domain] = general.SpecifiedDomainUpstreams[domain]
+	}
+
+	return conf
+}
+
+// isShadowed returns true if the requests for domain and all its subdomains
+// are routed by the domain-specific upstreams of conf.  The domains excluded
+// from the reserved ones don't shadow anything, since the general upstreams
+// apply to those.
+func isShadowed(conf *proxy.UpstreamConfig, domain string) (ok bool) {
+	for ; domain != ""; _, domain, _ = strings.Cut(domain, ".") {
+		if len(conf.DomainReservedUpstreams[domain]) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
 // clientStorage stores clients and their upstream configurations.
 type clientStorage struct {
+	// prefixes maps the prefixes of clients to the clients for the
+	// longest-prefix match.
+	prefixes *prefixTrie[*client]
+
+	// names maps the prefixes of the named persistent clients to their names
+	// for the longest-prefix match.
+	names *prefixTrie[agdc.ClientName]
+
 	// clients is the actual list of existing clients.
-	//
-	// TODO(e.burkov):  Think of a way to make search more efficient.
 	clients []*client
+
+	// routes are the routes by question type, which take precedence over the
+	// clients when they're at least as specific.
+	routes []*route
 }
 
-// newClientStorage creates a new storage of clients.
-func newClientStorage(clients []*client) (cs *clientStorage) {
+// newClientStorage creates a new storage of clients, routes, and named
+// persistent clients.  clients should have unique prefixes, and so should
+// named.
+func newClientStorage(
+	clients []*client,
+	routes []*route,
+	named []*ClientConfig,
+) (cs *clientStorage) {
+	prefixes := newPrefixTrie[*client]()
+	for _, c := range clients {
+		prefixes.insert(c.prefix, c)
+	}
+
+	names := newPrefixTrie[agdc.ClientName]()
+	for _, c := range named {
+		for _, p := range c.Prefixes {
+			names.insert(p, c.Name)
+		}
+	}
+
 	return &clientStorage{
-		clients: clients,
+		prefixes: prefixes,
+		names:    names,
+		clients:  clients,
+		routes:   routes,
 	}
 }
 
@@ -48,36 +172,61 @@ func newClientStorage(clients []*client) (cs *clientStorage) {
 //
 // TODO(e.burkov):  Think of a better name for this type.
 type client struct {
-	conf   *proxy.CustomUpstreamConfig
+	conf *proxy.CustomUpstreamConfig
+
+	// upstreams is the upstream configuration within conf.
+	upstreams *proxy.UpstreamConfig
+
 	prefix netip.Prefix
 }
 
-// find returns the client by its address or nil if no such clients exist.  The
-// returned client is not a copy, so it must not be modified.
+// find returns the client with the narrowest prefix containing addr or nil if
+// no such clients exist.  The returned client is not a copy, so it must not be
+// modified.
 func (cs *clientStorage) find(addr netip.Addr) (c *client) {
-	// TODO(e.burkov):  Handle overlapping prefixes.  Perhaps, choose the
-	// narrowest.
-	for _, cli := range cs.clients {
-		if cli.prefix.Contains(addr) {
-			return cli
-		}
+	c, _ = cs.prefixes.lookup(addr)
+
+	return c
+}
+
+// name returns the name of the named persistent client with the narrowest
+// prefix containing addr, or an empty string if there is no such client.
+func (cs *clientStorage) name(addr netip.Addr) (n agdc.ClientName) {
+	n, _ = cs.names.lookup(addr)
+
+	return n
+}
+
+// all returns the clients of cs along with the ones of its routes.
+func (cs *clientStorage) all() (clients []*client) {
+	clients = slices.Clip(cs.clients)
+	for _, r := range cs.routes {
+		clients = append(clients, r.client)
 	}
 
-	return nil
+	return clients
 }
 
-// close closes the storage and the upstream configurations of all its clients.
-// It returns a slice of errors that occurred during the closing.  It must not
-// be used concurrently with any existing client, i.e. any DNS processing must
-// be stopped before the call.
+// close closes the storage and the upstreams of all its clients and routes.  It
+// returns a slice of errors that occurred during the closing.  It must not be
+// used concurrently with any existing client, i.e. any DNS processing must be
+// stopped before the call.
 func (cs *clientStorage) close() (errs []error) {
 	for _, c := range cs.clients {
-		err := c.conf.Close()
+		err := c.upstreams.Close()
 		if err != nil {
 			err = fmt.Errorf("closing upstreams for client %s: %w", c.prefix, err)
 			errs = append(errs, err)
 		}
 	}
 
+	for _, r := range cs.routes {
+		err := r.client.upstreams.Close()
+		if err != nil {
+			err = fmt.Errorf("closing upstreams for route of group %q: %w", r.group, err)
+			errs = append(errs, err)
+		}
+	}
+
 	return errs
 }
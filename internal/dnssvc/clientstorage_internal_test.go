@@ -5,8 +5,10 @@ import (
 	"testing"
 
 	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,13 +23,46 @@ func TestClientStorage(t *testing.T) {
 	cli2Pref := netip.PrefixFrom(cli2Addr1, 32)
 	absentAddr := cli2Addr1.Next()
 
+	widePref := netip.MustParsePrefix("1.2.0.0/16")
+	wideAddr := netip.MustParseAddr("1.2.4.1")
+	narrowAddr := netip.MustParseAddr("1.2.3.5")
+	narrowPref := netip.PrefixFrom(narrowAddr, 32)
+
+	cli6Pref := netip.MustParsePrefix("2001:db8::/32")
+	cli6Addr := netip.MustParseAddr("2001:db8::1")
+	narrow6Pref := netip.MustParsePrefix("2001:db8:1::/48")
+	narrow6Addr := netip.MustParseAddr("2001:db8:1::1")
+
 	cli1 := &client{
-		prefix: cli1Pref,
-		conf:   &proxy.CustomUpstreamConfig{},
+		prefix:    cli1Pref,
+		conf:      &proxy.CustomUpstreamConfig{},
+		upstreams: &proxy.UpstreamConfig{},
 	}
 	cli2 := &client{
-		prefix: cli2Pref,
-		conf:   &proxy.CustomUpstreamConfig{},
+		prefix:    cli2Pref,
+		conf:      &proxy.CustomUpstreamConfig{},
+		upstreams: &proxy.UpstreamConfig{},
+	}
+
+	wide := &client{
+		prefix:    widePref,
+		conf:      &proxy.CustomUpstreamConfig{},
+		upstreams: &proxy.UpstreamConfig{},
+	}
+	narrow := &client{
+		prefix:    narrowPref,
+		conf:      &proxy.CustomUpstreamConfig{},
+		upstreams: &proxy.UpstreamConfig{},
+	}
+	cli6 := &client{
+		prefix:    cli6Pref,
+		conf:      &proxy.CustomUpstreamConfig{},
+		upstreams: &proxy.UpstreamConfig{},
+	}
+	narrow6 := &client{
+		prefix:    narrow6Pref,
+		conf:      &proxy.CustomUpstreamConfig{},
+		upstreams: &proxy.UpstreamConfig{},
 	}
 
 	// search is a case of searching through a particular clients set.
@@ -93,13 +128,73 @@ func TestClientStorage(t *testing.T) {
 			addr: absentAddr,
 			want: nil,
 		}},
+	}, {
+		name: "overlapping",
+		clients: []*client{
+			narrow,
+			wide,
+			cli1,
+		},
+		searches: []search{{
+			addr: narrowAddr,
+			want: narrow,
+		}, {
+			addr: cli1Addr1,
+			want: cli1,
+		}, {
+			addr: wideAddr,
+			want: wide,
+		}, {
+			addr: cli2Addr1,
+			want: nil,
+		}},
+	}, {
+		name: "overlapping_reversed",
+		clients: []*client{
+			cli1,
+			wide,
+			narrow,
+		},
+		searches: []search{{
+			addr: narrowAddr,
+			want: narrow,
+		}, {
+			addr: cli1Addr1,
+			want: cli1,
+		}, {
+			addr: wideAddr,
+			want: wide,
+		}},
+	}, {
+		name: "ipv6",
+		clients: []*client{
+			cli6,
+			narrow6,
+			cli1,
+		},
+		searches: []search{{
+			addr: cli6Addr,
+			want: cli6,
+		}, {
+			addr: narrow6Addr,
+			want: narrow6,
+		}, {
+			addr: cli1Addr1,
+			want: cli1,
+		}, {
+			addr: netip.AddrFrom16(cli1Addr1.As16()),
+			want: nil,
+		}, {
+			addr: netip.MustParseAddr("2001:db9::1"),
+			want: nil,
+		}},
 	}}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			cs := newClientStorage(tc.clients)
+			cs := newClientStorage(tc.clients, nil, nil)
 			testutil.CleanupAndRequireSuccess(t, func() (err error) {
 				return errors.Join(cs.close()...)
 			})
@@ -114,3 +209,82 @@ func TestClientStorage(t *testing.T) {
 		})
 	}
 }
+
+// testUpstream is a stub implementation of [upstream.Upstream] for tests.
+type testUpstream struct {
+	addr string
+}
+
+// type check
+var _ upstream.Upstream = (*testUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *testUpstream.
+func (u *testUpstream) Exchange(_ *dns.Msg) (resp *dns.Msg, err error) {
+	panic("not implemented")
+}
+
+// Address implements the [upstream.Upstream] interface for *testUpstream.
+func (u *testUpstream) Address() (addr string) { return u.addr }
+
+// Close implements the [upstream.Upstream] interface for *testUpstream.
+func (u *testUpstream) Close() (err error) { return nil }
+
+func TestWithGeneral(t *testing.T) {
+	t.Parallel()
+
+	genDefault := &testUpstream{addr: "general"}
+	genDomain := &testUpstream{addr: "general_domain"}
+	genSubdomain := &testUpstream{addr: "general_subdomain"}
+	cliDefault := &testUpstream{addr: "client"}
+	cliDomain := &testUpstream{addr: "client_domain"}
+
+	newGeneral := func() (conf *proxy.UpstreamConfig) {
+		reserved := map[string][]upstream.Upstream{
+			"example.org.":      {genDomain},
+			"sub.example.com.":  {genSubdomain},
+			"deep.example.net.": {genDomain},
+		}
+
+		return &proxy.UpstreamConfig{
+			Upstreams:                []upstream.Upstream{genDefault},
+			DomainReservedUpstreams:  reserved,
+			SpecifiedDomainUpstreams: reserved,
+		}
+	}
+
+	t.Run("with_default", func(t *testing.T) {
+		t.Parallel()
+
+		conf := &proxy.UpstreamConfig{
+			Upstreams: []upstream.Upstream{cliDefault},
+		}
+
+		got := withGeneral(conf, newGeneral())
+		assert.Equal(t, []upstream.Upstream{cliDefault}, got.Upstreams)
+		assert.Empty(t, got.DomainReservedUpstreams)
+	})
+
+	t.Run("domains_only", func(t *testing.T) {
+		t.Parallel()
+
+		newReserved := func() (reserved map[string][]upstream.Upstream) {
+			return map[string][]upstream.Upstream{
+				"example.com.": {cliDomain},
+				"example.net.": {cliDomain},
+			}
+		}
+
+		conf := &proxy.UpstreamConfig{
+			DomainReservedUpstreams:  newReserved(),
+			SpecifiedDomainUpstreams: newReserved(),
+		}
+
+		got := withGeneral(conf, newGeneral())
+		assert.Equal(t, []upstream.Upstream{genDefault}, got.Upstreams)
+		assert.Equal(t, map[string][]upstream.Upstream{
+			"example.com.": {cliDomain},
+			"example.net.": {cliDomain},
+			"example.org.": {genDomain},
+		}, got.DomainReservedUpstreams)
+	})
+}
@@ -2,9 +2,10 @@ package dnssvc
 
 import (
 	"log/slog"
-	"net/netip"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/querylog"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/rpz"
 	"github.com/AdguardTeam/golibs/netutil"
 )
 
@@ -20,9 +21,15 @@ type Config struct {
 	// PrivateSubnets is the set of IP networks considered private.  The PTR
 	// requests for ARPA domains considered private if the domain contains an IP
 	// from one of the networks and the request came from the client within one
-	// of the networks.  It must not be nil.
+	// of the networks.  It must not be nil.  See also UsePrivateRDNS.
 	PrivateSubnets netutil.SubnetSet
 
+	// TrustedProxies is the set of IP networks of the proxies allowed to pass
+	// the addresses of the original clients, either within the HTTP headers of
+	// the DNS-over-HTTPS requests or within the PROXY protocol header, see
+	// [ListenAddrConfig.ProxyProtocol].  It must not be nil.
+	TrustedProxies netutil.SubnetSet
+
 	// Cache is the configuration for the DNS results cache.  It must not be
 	// nil.
 	Cache *CacheConfig
@@ -36,6 +43,38 @@ type Config struct {
 	// Fallbacks describes DNS fallback upstream servers.  It must not be nil.
 	Fallbacks *FallbackConfig
 
+	// Filtering is the configuration for filtering the requests.  If nil, the
+	// filtering is disabled.
+	Filtering *FilteringConfig
+
+	// LocalRecords is the configuration of the records answered without using
+	// the upstreams.  If nil, all the requests are forwarded to the upstreams.
+	LocalRecords *LocalRecordsConfig
+
+	// Zones are the zones the service answers the requests for
+	// authoritatively, without using the upstreams.  Those must not be nil
+	// and must not overlap.
+	Zones []*ZoneConfig
+
+	// Rewrites is the configuration of the rewrites of the requests.  If nil,
+	// no requests are rewritten.
+	Rewrites *RewritesConfig
+
+	// ResponsePolicies is the configuration of the response policy zones
+	// applied to the requests resolved using the upstreams.  If nil, no
+	// policies are applied.
+	ResponsePolicies *rpz.Config
+
+	// Clients are the named persistent clients.  Those must not be nil.
+	Clients []*ClientConfig
+
+	// Metrics is used to collect the statistics of the service.  It must not
+	// be nil.
+	Metrics Metrics
+
+	// QueryLog is used to record the processed requests.  It must not be nil.
+	QueryLog querylog.Interface
+
 	// ClientGetter is the function to get the client for a request.  It must
 	// not be nil.
 	ClientGetter ClientGetter
@@ -48,9 +87,28 @@ type Config struct {
 	// It must not be nil.
 	PendingRequests *PendingRequestsConfig
 
+	// Access is the configuration of the access control of the clients.  If
+	// nil, the requests from all the clients are served.
+	Access *AccessConfig
+
+	// RateLimit is the configuration of the rate limiting of the clients.  If
+	// nil, the requests aren't limited.
+	RateLimit *RateLimitConfig
+
 	// ListenAddrs is the list of served addresses.  It must contain at least
-	// one entry.  It must not be empty and must contain only valid addresses.
-	ListenAddrs []netip.AddrPort
+	// one entry and must not contain nil entries.
+	ListenAddrs []*ListenAddrConfig
+
+	// UsePrivateRDNS defines if the private PTR requests should be resolved
+	// using the private upstreams.  If false, those are answered with
+	// NXDOMAIN, unless answered with the local records.
+	UsePrivateRDNS bool
+
+	// CheckOnly, if true, makes [New] only validate the configuration without
+	// downloading the rule lists and transferring the response policy zones.
+	// It overrides the CheckOnly properties of Filtering and ResponsePolicies.
+	// The service created with it must not be started, but must be shut down.
+	CheckOnly bool
 }
 
 // BindRetryConfig configures retrying to bind to listen addresses.
@@ -0,0 +1,254 @@
+package dnssvc
+
+import (
+	"cmp"
+	"context"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// Listener describes an address the service listens on.
+type Listener struct {
+	// Proto is the network protocol of the listener, one of udp, tcp, tls,
+	// https, and quic.
+	Proto string
+
+	// Addr is the actual address the service listens on.
+	Addr netip.AddrPort
+}
+
+// Listeners returns the addresses the service actually listens on.  The TCP
+// listeners accepting the PROXY protocol are reported by their own addresses.
+func (svc *DNSService) Listeners() (ls []*Listener) {
+	protos := []proxy.Proto{
+		proxy.ProtoUDP,
+		proxy.ProtoTCP,
+		proxy.ProtoTLS,
+		proxy.ProtoHTTPS,
+		proxy.ProtoQUIC,
+	}
+
+	for _, proto := range protos {
+		for i, addr := range svc.proxy.Addrs(proto) {
+			l := &Listener{
+				Proto: string(proto),
+				Addr:  netutil.NetAddrToAddrPort(addr),
+			}
+
+			// Report the listeners accepting the PROXY protocol instead of the
+			// internal ones they relay the connections to.
+			if proxied, ok := svc.proxyProto.listenAddr(i); ok && proto == proxy.ProtoTCP {
+				l.Addr = proxied
+			}
+
+			ls = append(ls, l)
+		}
+	}
+
+	return ls
+}
+
+// UpstreamStatus describes the health of an upstream.
+type UpstreamStatus struct {
+	// LastSuccess is the time of the last successful exchange, if any.
+	LastSuccess time.Time
+
+	// LastFailure is the time of the last failed exchange, if any.
+	LastFailure time.Time
+
+	// Group is the name of the upstream group the upstream belongs to.  It's
+	// empty for the common fallback upstreams.
+	Group agdc.UpstreamGroupName
+
+	// Type is the type of the upstream, one of the agdcslog.UpstreamType*
+	// constants.
+	Type string
+
+	// Address is the address of the upstream.
+	Address string
+
+	// LastError is the error of the last failed exchange, if any.
+	LastError string
+
+	// Failures is the number of consecutive failed exchanges.
+	Failures uint
+
+	// Healthy is true if the last exchange with the upstream succeeded or
+	// there have been no exchanges yet.
+	Healthy bool
+}
+
+// UpstreamStatuses returns the health of the main and fallback upstreams of
+// the current configuration.  The statuses are sorted by type, group, and
+// address.
+func (svc *DNSService) UpstreamStatuses() (statuses []*UpstreamStatus) {
+	st := svc.acquireState()
+	if st == nil {
+		return nil
+	}
+	defer st.release()
+
+	for _, u := range st.observedUpstreams() {
+		statuses = append(statuses, u.status())
+	}
+
+	slices.SortFunc(statuses, func(a, b *UpstreamStatus) (res int) {
+		return cmp.Or(
+			cmp.Compare(a.Type, b.Type),
+			cmp.Compare(a.Group, b.Group),
+			cmp.Compare(a.Address, b.Address),
+		)
+	})
+
+	return statuses
+}
+
+// ErrNoClient is returned when there is no client-specific configuration for
+// the requested client prefix.
+const ErrNoClient errors.Error = "no client-specific configuration for prefix"
+
+// FlushCache clears the caches of the client-specific configuration and the
+// routes by question type with exactly prefix.  If prefix is zero, it clears
+// all the caches.  It returns [ErrNoClient] if there is no such configuration.
+func (svc *DNSService) FlushCache(ctx context.Context, prefix netip.Prefix) (err error) {
+	st := svc.acquireState()
+	if st == nil {
+		return errShutdown
+	}
+	defer st.release()
+
+	if prefix == (netip.Prefix{}) {
+		svc.caches.clear()
+
+		svc.logger.InfoContext(ctx, "cache flushed")
+
+		return nil
+	}
+
+	prefix = prefix.Masked()
+	found := false
+	for _, c := range st.clients.all() {
+		if c.prefix.Masked() == prefix {
+			c.conf.ClearCache()
+			found = true
+		}
+	}
+
+	if !found {
+		return ErrNoClient
+	}
+
+	svc.logger.InfoContext(ctx, "cache flushed", "client", prefix)
+
+	return nil
+}
+
+// observedUpstreams returns all the distinct main and fallback upstreams of
+// st.
+func (st *upstreamState) observedUpstreams() (ups []*observedUpstream) {
+	confs := []*proxy.UpstreamConfig{st.general, st.private, st.fallbacks}
+	for _, c := range st.clients.all() {
+		confs = append(confs, c.upstreams)
+	}
+
+	seen := container.NewMapSet[*observedUpstream]()
+	for _, conf := range confs {
+		if conf == nil {
+			continue
+		}
+
+		ups = appendObserved(ups, seen, conf.Upstreams)
+		for _, us := range conf.DomainReservedUpstreams {
+			ups = appendObserved(ups, seen, us)
+		}
+	}
+
+	return ups
+}
+
+// appendObserved appends the observed upstreams from us to ups, unless they're
+// already in seen, and adds them to seen.
+func appendObserved(
+	ups []*observedUpstream,
+	seen *container.MapSet[*observedUpstream],
+	us []upstream.Upstream,
+) (res []*observedUpstream) {
+	for _, u := range us {
+		switch u := u.(type) {
+		case *observedUpstream:
+			if !seen.Has(u) {
+				seen.Add(u)
+				ups = append(ups, u)
+			}
+		case *groupUpstream:
+			ups = appendObserved(ups, seen, u.ups)
+		case *fallbackUpstream:
+			ups = appendObserved(ups, seen, []upstream.Upstream{u.main})
+			ups = appendObserved(ups, seen, u.fallbacks)
+		default:
+			// Go on.
+		}
+	}
+
+	return ups
+}
+
+// upstreamHealth tracks the results of exchanges with an upstream.
+type upstreamHealth struct {
+	// mu protects the fields below.
+	mu *sync.Mutex
+
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastErr     error
+	failures    uint
+}
+
+// update records the result of an exchange finished at now with err.
+func (h *upstreamHealth) update(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		h.lastSuccess = now
+		h.failures = 0
+
+		return
+	}
+
+	h.lastFailure, h.lastErr = now, err
+	h.failures++
+}
+
+// status returns the current health of u.
+func (u *observedUpstream) status() (s *UpstreamStatus) {
+	h := u.health
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s = &UpstreamStatus{
+		LastSuccess: h.lastSuccess,
+		LastFailure: h.lastFailure,
+		Group:       u.group,
+		Type:        u.upstreamType,
+		Address:     u.Address(),
+		Failures:    h.failures,
+		Healthy:     h.failures == 0,
+	}
+
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+
+	return s
+}
@@ -3,18 +3,24 @@ package dnssvc
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log/slog"
-	"net"
 	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/querylog"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
-	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/service"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
 )
 
 // DNSService is a service that provides DNS handling functionality.
@@ -25,9 +31,27 @@ type DNSService struct {
 	// proxy forwards DNS requests.
 	proxy *proxy.Proxy
 
-	// clients stores upstream configurations associated with clients'
-	// addresses.
-	clients *clientStorage
+	// metrics is used to collect the statistics of the service.
+	metrics Metrics
+
+	// queryLog is used to record the processed requests.
+	queryLog querylog.Interface
+
+	// boot resolves the hostnames of upstreams, including the ones created on
+	// reconfiguration.
+	boot upstream.Resolver
+
+	// caches are the custom upstream configurations with the caches, which are
+	// kept across reconfigurations.
+	caches *caches
+
+	// stateMu serializes the replacements of state.
+	stateMu *sync.Mutex
+
+	// state is the current set of upstream configurations, including the ones
+	// associated with clients' addresses.  It's nil after the service is shut
+	// down.
+	state atomic.Pointer[upstreamState]
 
 	// clientGetter is used to get the client's address from the request's
 	// context.  It's only used for testing.
@@ -36,89 +60,110 @@ type DNSService struct {
 	// start supporting the [context.Context].  Then get rid of this interface.
 	clientGetter ClientGetter
 
+	// access rejects the requests according to the access control
+	// configuration.  It's nil if all the requests are served.
+	access *access
+
+	// rateLimit limits the rate of requests from the clients.  It's nil if the
+	// requests aren't limited.
+	rateLimit *rateLimiter
+
+	// rejectLog logs the rejected requests.
+	rejectLog *rejectLogger
+
+	// proxyProto relays the connections accepted with the PROXY protocol to
+	// proxy.  It's nil if there are no such listen addresses.
+	proxyProto *proxyProtoServer
+
 	// bootstrapUpstreams is a list of upstreams to close on shutdown.
 	bootstrapUpstreams []io.Closer
 }
 
 // New creates a new DNSService.  conf must not be nil.
-func New(conf *Config) (svc *DNSService, err error) {
-	boot, bootUps, err := newResolvers(conf.Bootstrap, conf.Logger)
+func New(ctx context.Context, conf *Config) (svc *DNSService, err error) {
+	boot, bootUps, err := newResolvers(conf.Bootstrap, conf.Logger, conf.Metrics)
 	if err != nil {
 		// Don't wrap the error, because it's informative enough as is.
 		return nil, err
 	}
 
-	prxConf, clients, err := newProxyConfig(conf, boot)
+	tlsConf, err := newTLSConfig(conf.ListenAddrs)
 	if err != nil {
-		// Don't wrap the error, because it's informative enough as is.
-		return nil, err
+		err = fmt.Errorf("creating proxy configuration: %w", err)
+
+		return nil, errors.Join(append([]error{err}, closeBootstraps(bootUps)...)...)
 	}
 
+	addrs := newListenAddrs(conf.ListenAddrs)
 	svc = &DNSService{
-		logger:       conf.Logger,
-		clientGetter: conf.ClientGetter,
-		clients:      newClientStorage(clients),
+		logger:             conf.Logger,
+		metrics:            conf.Metrics,
+		queryLog:           conf.QueryLog,
+		boot:               boot,
+		stateMu:            &sync.Mutex{},
+		clientGetter:       conf.ClientGetter,
+		access:             newAccess(conf.Access),
+		rateLimit:          newRateLimiter(conf.RateLimit),
+		rejectLog:          newRejectLogger(conf.Logger),
+		proxyProto:         newProxyProtoServer(conf.Logger, conf.TrustedProxies, addrs),
+		bootstrapUpstreams: bootUps,
 	}
+	svc.caches = newCaches(svc)
+
+	st, err := newUpstreamState(ctx, conf, boot, svc.caches)
+	if err != nil {
+		err = fmt.Errorf("creating proxy configuration: %w", err)
+
+		return nil, errors.Join(append([]error{err}, closeBootstraps(bootUps)...)...)
+	}
+
+	svc.state.Store(st)
+
+	prxConf := svc.newProxyConfig(conf, tlsConf, addrs)
 	prxConf.BeforeRequestHandler = svc
 	prxConf.RequestHandler = svc.handleRequest
 
 	prx, err := proxy.New(prxConf)
 	if err != nil {
-		return nil, fmt.Errorf("creating proxy: %w", err)
+		err = fmt.Errorf("creating proxy: %w", err)
+		errs := append([]error{err}, st.retire()...)
+
+		return nil, errors.Join(append(errs, closeBootstraps(bootUps)...)...)
 	}
 
 	svc.proxy = prx
-	svc.bootstrapUpstreams = bootUps
 
 	return svc, nil
 }
 
-// newProxyConfig creates a new [proxy.Config] from conf using boot for all
-// upstream configurations.  It returns a ready-to-use configuration and clients
-// with their specific upstream configurations.
-func newProxyConfig(
+// newProxyConfig creates a new ready-to-use [proxy.Config] from conf, tlsConf,
+// and addrs.  The upstream configurations of the proxy are backed by the
+// current upstream state of svc.
+func (svc *DNSService) newProxyConfig(
 	conf *Config,
-	boot upstream.Resolver,
-) (prxConf *proxy.Config, clients []*client, err error) {
-	defer func() { err = errors.Annotate(err, "creating proxy configuration: %w") }()
-
-	ups, private, err := newUpstreams(conf.Upstreams, conf.Logger, boot)
-	if err != nil {
-		// Don't wrap the error, because it's informative enough as is.
-		return nil, nil, err
-	}
-
-	falls, err := newFallbacks(conf.Fallbacks, conf.Logger, boot)
-	if err != nil {
-		// Don't wrap the error, because it's informative enough as is.
-		return nil, nil, err
-	}
-
-	// Use the upstream configuration with no client specification as the
-	// general one.  Also remove it from the map, to build the clients list.
-	general := ups[netip.Prefix{}]
-	delete(ups, netip.Prefix{})
-
-	udp, tcp := newListenAddrs(conf.ListenAddrs)
-	// TODO(e.burkov):  Consider making configurable.
-	trusted := netutil.SliceSubnetSet{
-		netip.PrefixFrom(netip.IPv4Unspecified(), 0),
-		netip.PrefixFrom(netip.IPv6Unspecified(), 0),
-	}
-
+	tlsConf *tls.Config,
+	addrs *listenAddrs,
+) (prxConf *proxy.Config) {
 	return &proxy.Config{
 		Logger:                    conf.BaseLogger.With(slogutil.KeyPrefix, "dnsproxy"),
 		UpstreamMode:              proxy.UpstreamModeLoadBalance,
-		UDPListenAddr:             udp,
-		TCPListenAddr:             tcp,
-		UpstreamConfig:            general,
-		PrivateRDNSUpstreamConfig: private,
+		UDPListenAddr:             addrs.udp,
+		TCPListenAddr:             addrs.tcp,
+		TLSListenAddr:             addrs.tls,
+		HTTPSListenAddr:           addrs.https,
+		QUICListenAddr:            addrs.quic,
+		TLSConfig:                 tlsConf,
+		UpstreamConfig:            svc.newStateUpstreamConfig("general", cacheKey{}.choose),
+		PrivateRDNSUpstreamConfig: svc.newStateUpstreamConfig("private", keyPrivate.choose),
 		PrivateSubnets:            conf.PrivateSubnets,
-		UsePrivateRDNS:            private != nil,
-		Fallbacks:                 falls,
-		TrustedProxies:            trusted,
-		CacheSizeBytes:            conf.Cache.Size,
-		CacheEnabled:              conf.Cache.Enabled,
+		UsePrivateRDNS:            conf.UsePrivateRDNS,
+		// Fallbacks are handled by the upstreams of each group, so that the
+		// requests routed to a group only use its own fallbacks.
+		Fallbacks:      nil,
+		TrustedProxies: conf.TrustedProxies,
+		// Caching is performed by the custom upstream configurations, since
+		// those are chosen per client and kept across reconfigurations.
+		CacheEnabled: false,
 		BindRetryConfig: &proxy.BindRetryConfig{
 			Enabled:  conf.BindRetry.Enabled,
 			Interval: conf.BindRetry.Interval,
@@ -127,21 +172,75 @@ func newProxyConfig(
 		PendingRequests: &proxy.PendingRequestsConfig{
 			Enabled: conf.PendingRequests.Enabled,
 		},
-	}, ups.clients(conf.Cache), nil
+	}
+}
+
+// newStateUpstreamConfig returns a proxy upstream configuration which uses the
+// upstreams chosen by choose from the current upstream state of svc.
+func (svc *DNSService) newStateUpstreamConfig(
+	name string,
+	choose func(st *upstreamState, req *dns.Msg) (ups []upstream.Upstream),
+) (conf *proxy.UpstreamConfig) {
+	return &proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{&stateUpstream{
+			svc:    svc,
+			choose: choose,
+			name:   name,
+		}},
+	}
+}
+
+// acquireState returns the current upstream state marked as used by a
+// request.  It returns nil if the service is shut down.  If st is not nil,
+// [upstreamState.release] must be called after the request is processed.
+func (svc *DNSService) acquireState() (st *upstreamState) {
+	for {
+		st = svc.state.Load()
+		if st == nil || st.acquire() {
+			return st
+		}
+
+		// The state has been retired after loading it, so the new one is
+		// already stored.
+	}
 }
 
-// newListenAddrs creates a new list of UDP and TCP addresses from addrs.
-//
-// TODO(e.burkov):  Support other protos.
-func newListenAddrs(addrs []netip.AddrPort) (udp []*net.UDPAddr, tcp []*net.TCPAddr) {
-	udp = make([]*net.UDPAddr, 0, len(addrs))
-	tcp = make([]*net.TCPAddr, 0, len(addrs))
-	for _, addr := range addrs {
-		udp = append(udp, net.UDPAddrFromAddrPort(addr))
-		tcp = append(tcp, net.TCPAddrFromAddrPort(addr))
+// Reconfigure replaces the upstreams, the fallbacks, the cache, the filtering,
+// the local records, the zones, the rewrites, the response policies, and the
+// clients of svc with the ones from conf.  Other parts of conf, including the
+// listeners, the access control, the rate limit, and the bootstrap, are
+// ignored, since those are only applied on restart.  Requests being processed
+// are finished with the previous configuration.  If err is not nil, the
+// previous configuration stays in place.  conf must not be nil.
+func (svc *DNSService) Reconfigure(ctx context.Context, conf *Config) (err error) {
+	svc.logger.DebugContext(ctx, "reconfiguring")
+
+	svc.stateMu.Lock()
+	defer svc.stateMu.Unlock()
+
+	st, err := newUpstreamState(ctx, conf, svc.boot, svc.caches)
+	if err != nil {
+		return fmt.Errorf("reconfiguring: %w", err)
+	}
+
+	prev := svc.state.Load()
+	if prev == nil {
+		return errors.Join(append([]error{errShutdown}, st.retire()...)...)
 	}
 
-	return udp, tcp
+	svc.state.Store(st)
+	svc.caches.prune(st)
+
+	err = errors.Join(prev.retire()...)
+	if err != nil {
+		// Don't return the error, since the new configuration is already in
+		// place.
+		svc.logger.ErrorContext(ctx, "closing previous upstreams", slogutil.KeyError, err)
+	}
+
+	svc.logger.InfoContext(ctx, "reconfigured")
+
+	return nil
 }
 
 // type check
@@ -151,7 +250,18 @@ var _ service.Interface = (*DNSService)(nil)
 func (svc *DNSService) Start(ctx context.Context) (err error) {
 	svc.logger.DebugContext(ctx, "starting")
 
-	return svc.proxy.Start(ctx)
+	err = svc.proxy.Start(ctx)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = svc.proxyProto.start(ctx, svc.proxy)
+	if err != nil {
+		return errors.Join(err, svc.proxy.Shutdown(ctx))
+	}
+
+	return nil
 }
 
 // Shutdown implements the [service.Interface] interface for *DNSService.
@@ -159,20 +269,118 @@ func (svc *DNSService) Shutdown(ctx context.Context) (err error) {
 	svc.logger.DebugContext(ctx, "shutting down")
 
 	var errs []error
+	err = svc.proxyProto.shutdown()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
 	err = svc.proxy.Shutdown(ctx)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("stopping proxy: %w", err))
 	}
 
-	errs = append(errs, svc.clients.close()...)
-	errs = append(errs, svc.closeBootstraps()...)
+	svc.stateMu.Lock()
+	st := svc.state.Swap(nil)
+	svc.stateMu.Unlock()
+
+	if st != nil {
+		errs = append(errs, st.retire()...)
+	}
+
+	errs = append(errs, closeBootstraps(svc.bootstrapUpstreams)...)
 
 	return errors.Join(errs...)
 }
 
-// closeBootstraps closes all bootstraps and returns all the errors joined.
-func (svc *DNSService) closeBootstraps() (errs []error) {
-	for i, u := range svc.bootstrapUpstreams {
+// type check
+var _ service.Refresher = (*DNSService)(nil)
+
+// Refresh implements the [service.Refresher] interface for *DNSService.  It
+// re-reads the match list files, the hosts files, the zone files, the response
+// policy zone files, and the filtering rule lists, which have been modified
+// since they were last read, re-downloads the outdated rule lists, and
+// transfers the response policy zones due to refresh.  The invalid files are
+// reported, while the previous contents of those stay in place.
+func (svc *DNSService) Refresh(ctx context.Context) (err error) {
+	st := svc.acquireState()
+	if st == nil {
+		return errShutdown
+	}
+	defer st.release()
+
+	var errs []error
+	for _, l := range st.lists {
+		err = l.refresh(ctx, svc.logger)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	err = st.local.refresh(ctx)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	err = st.zones.refresh(ctx, svc.logger)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	err = st.policies.refresh(ctx)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if st.filter != nil {
+		err = st.filter.filter.Refresh(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("refreshing filter: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// refreshIvl is the interval between the checks of the files used by the
+// service for changes.
+const refreshIvl = 10 * time.Second
+
+// type check
+var _ timeutil.Schedule = (*DNSService)(nil)
+
+// UntilNext implements the [timeutil.Schedule] interface for *DNSService.  It
+// returns the duration until the next [DNSService.Refresh] should be called,
+// which is the earliest of the next check of the files, the next download of a
+// rule list, and the next transfer of a response policy zone.
+func (svc *DNSService) UntilNext(now time.Time) (d time.Duration) {
+	d = refreshIvl
+
+	st := svc.acquireState()
+	if st == nil {
+		return d
+	}
+	defer st.release()
+
+	if st.filter != nil {
+		d = untilNext(now, st.filter.filter.NextRefresh(), d)
+	}
+
+	return untilNext(now, st.policies.nextRefresh(), d)
+}
+
+// untilNext returns the duration from now until next, if next is earlier than
+// d from now, and d otherwise.  next may be zero.
+func untilNext(now, next time.Time, d time.Duration) (res time.Duration) {
+	if next.IsZero() {
+		return d
+	}
+
+	return max(min(next.Sub(now), d), 0)
+}
+
+// closeBootstraps closes all bootstraps and returns all the errors.
+func closeBootstraps(bootUps []io.Closer) (errs []error) {
+	for i, u := range bootUps {
 		err := u.Close()
 		if err != nil {
 			err = fmt.Errorf("closing bootstrap at index %d: %w", i, err)
@@ -189,27 +397,171 @@ var _ proxy.BeforeRequestHandler = (*DNSService)(nil)
 // HandleBefore implements the [proxy.BeforeRequestHandler] interface for
 // *DNSService.
 func (svc *DNSService) HandleBefore(p *proxy.Proxy, dctx *proxy.DNSContext) (err error) {
+	// Restore the address of the original client of the connection relayed
+	// from a trusted proxy, so that it's used to route the request.
+	dctx.Addr = svc.proxyProto.clientAddr(dctx.Addr)
+
 	// This is used to substitute the client's address in tests.
 	dctx.Addr = svc.clientGetter.Address(dctx)
 
+	// Unmap the IPv4-mapped IPv6 addresses, so that those match the IPv4
+	// prefixes of the access control, the rate limit, the private subnets, and
+	// the clients.
+	dctx.Addr = netip.AddrPortFrom(dctx.Addr.Addr().Unmap(), dctx.Addr.Port())
+
 	// Check the address privateness because proxy does it before substitution.
 	// See TODO on [DNSService.clientGetter].
 	dctx.IsPrivateClient = svc.proxy.PrivateSubnets.Contains(dctx.Addr.Addr())
 
+	var host string
+	if len(dctx.Req.Question) > 0 {
+		host = dctx.Req.Question[0].Name
+	}
+
+	// TODO(e.burkov):  Use the request's context when the proxy starts
+	// supporting it.
+	ctx := context.TODO()
+
+	if reason, ok := svc.access.check(dctx.Addr.Addr(), host); ok {
+		return svc.reject(ctx, dctx, reason, svc.access.mode)
+	}
+
+	// Only account the requests not rejected by the access control.
+	if svc.rateLimited(dctx) {
+		return svc.reject(ctx, dctx, RejectReasonRateLimited, svc.rateLimit.mode)
+	}
+
 	return nil
 }
 
+// rateLimited returns true if the request of dctx exceeds the rate limit.  The
+// requests of the allowlisted persistent clients and the ones routed to the
+// allowlisted upstream groups aren't limited.
+func (svc *DNSService) rateLimited(dctx *proxy.DNSContext) (ok bool) {
+	l := svc.rateLimit
+	if l == nil {
+		return false
+	}
+
+	if l.clients.Len() > 0 || l.groups.Len() > 0 {
+		st := svc.acquireState()
+		if st != nil {
+			defer st.release()
+		}
+
+		if l.allowlisted(st, dctx) {
+			return false
+		}
+	}
+
+	return l.exceeded(dctx.Addr.Addr(), time.Now())
+}
+
 // handleRequest is a [proxy.RequestHandler].
 func (svc *DNSService) handleRequest(p *proxy.Proxy, dctx *proxy.DNSContext) (err error) {
-	if dctx.RequestedPrivateRDNS != (netip.Prefix{}) {
-		// Don't match client for private PTR request.
-		return p.Resolve(dctx)
+	st := svc.acquireState()
+	if st == nil {
+		return errShutdown
+	}
+	defer st.release()
+
+	start := time.Now()
+	ri := st.routeRequest(dctx, p.UsePrivateRDNS)
+
+	// The response is already set for the blocked requests and the ones
+	// answered with the local records, the zones, the rewrites, or the
+	// response policies.
+	answered := dctx.Res != nil
+	if !answered {
+		err = st.resolve(p, dctx, ri)
+	}
+
+	ri.rewritten.restore(dctx)
+	elapsed := time.Since(start)
+
+	var prefix netip.Prefix
+	if ri.client != nil {
+		prefix = ri.client.prefix
+	}
+
+	svc.observeQuery(dctx, prefix, ri.group)
+
+	cached := isCached(dctx)
+	if !answered && dctx.CustomUpstreamConfig != nil && st.cacheEnabled && !dctx.Req.CheckingDisabled {
+		// TODO(e.burkov):  Use the request's context when the proxy starts
+		// supporting it.
+		svc.metrics.ObserveCacheLookup(context.TODO(), ri.client != nil, cached)
+	}
+
+	svc.writeQueryLog(dctx, ri, start, elapsed, cached)
+
+	return err
+}
+
+// observeQuery reports the statistics of the request processed using the
+// configuration of the client with prefix and the upstream group.
+func (svc *DNSService) observeQuery(
+	dctx *proxy.DNSContext,
+	prefix netip.Prefix,
+	group agdc.UpstreamGroupName,
+) {
+	var rcode string
+	if dctx.Res != nil {
+		rcode = dns.RcodeToString[dctx.Res.Rcode]
+	}
+
+	// TODO(e.burkov):  Use the request's context when the proxy starts
+	// supporting it.
+	svc.metrics.ObserveQuery(context.TODO(), prefix, group, rcode)
+}
+
+// isCached returns true if the response to the processed request has been
+// taken from the cache.
+func isCached(dctx *proxy.DNSContext) (ok bool) {
+	stats := dctx.QueryStatistics()
+
+	return stats != nil && len(stats.Main()) > 0 && stats.Main()[0].IsCached
+}
+
+// writeQueryLog writes the query log entry for the processed request with ri
+// gathered during elapsed since start.
+func (svc *DNSService) writeQueryLog(
+	dctx *proxy.DNSContext,
+	ri *requestInfo,
+	start time.Time,
+	elapsed time.Duration,
+	cached bool,
+) {
+	q := dctx.Req.Question[0]
+	e := &querylog.Entry{
+		Time:       start,
+		ClientIP:   dctx.Addr.Addr(),
+		ClientName: ri.clientName,
+		Name:       q.Name,
+		QType:      dns.Type(q.Qtype).String(),
+		Proto:      string(dctx.Proto),
+		Group:      ri.group,
+		Elapsed:    elapsed,
+		Cached:     cached,
+	}
+
+	if u := ri.upstream; u != nil && dctx.Upstream != nil {
+		e.Upstream = u.Address()
+	}
+
+	if res := ri.filter; res != nil {
+		e.FilterList, e.Rule, e.Blocked = string(res.List), res.Rule, res.Blocked
+	}
+
+	if m := ri.policy; m != nil {
+		e.Policy, e.PolicyTrigger, e.PolicyAction = m.Policy, m.Trigger, string(m.Action)
 	}
 
-	c := svc.clients.find(dctx.Addr.Addr())
-	if c != nil {
-		dctx.CustomUpstreamConfig = c.conf
+	if dctx.Res != nil {
+		e.Rcode = dns.RcodeToString[dctx.Res.Rcode]
 	}
 
-	return p.Resolve(dctx)
+	// TODO(e.burkov):  Use the request's context when the proxy starts
+	// supporting it.
+	svc.queryLog.Write(context.TODO(), e)
 }
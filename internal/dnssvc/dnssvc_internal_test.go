@@ -2,8 +2,11 @@ package dnssvc
 
 import (
 	"net"
+	"testing"
+	"time"
 
 	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/stretchr/testify/assert"
 )
 
 // Addr returns the address of the service for the given protocol.  This is only
@@ -11,3 +14,39 @@ import (
 func (svc *DNSService) Addr(proto proxy.Proto) (addr net.Addr) {
 	return svc.proxy.Addr(proto)
 }
+
+func TestUntilNext(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		next time.Time
+		name string
+		want time.Duration
+	}{{
+		next: time.Time{},
+		name: "none",
+		want: refreshIvl,
+	}, {
+		next: now.Add(time.Second),
+		name: "earlier",
+		want: time.Second,
+	}, {
+		next: now.Add(time.Hour),
+		name: "later",
+		want: refreshIvl,
+	}, {
+		next: now.Add(-time.Second),
+		name: "overdue",
+		want: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, untilNext(now, tc.next, refreshIvl))
+		})
+	}
+}
@@ -2,13 +2,28 @@ package dnssvc_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
 	"net/netip"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdcslog"
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/filter"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/querylog"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/rpz"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/netutil"
@@ -182,7 +197,7 @@ func TestDNSService(t *testing.T) {
 
 	// TODO(e.burkov):  Add a helper constructor with default values as the test
 	// suite grows.
-	svc, err := dnssvc.New(&dnssvc.Config{
+	svc, err := dnssvc.New(testutil.ContextWithTimeout(t, testTimeout), &dnssvc.Config{
 		BaseLogger:     slogutil.NewDiscardLogger(),
 		Logger:         slogutil.NewDiscardLogger(),
 		PrivateSubnets: privateNets,
@@ -192,26 +207,26 @@ func TestDNSService(t *testing.T) {
 		},
 		Upstreams: &dnssvc.UpstreamConfig{
 			Groups: []*dnssvc.UpstreamGroupConfig{{
-				Name:    agdc.UpstreamGroupNameDefault,
-				Address: commonURL,
+				Name:      agdc.UpstreamGroupNameDefault,
+				Addresses: []string{commonURL},
 			}, {
-				Name:    agdc.UpstreamGroupNamePrivate,
-				Address: privateURL,
+				Name:      agdc.UpstreamGroupNamePrivate,
+				Addresses: []string{privateURL},
 			}, {
-				Name:    "domain-group",
-				Address: subdomainURL,
+				Name:      "domain-group",
+				Addresses: []string{subdomainURL},
 				Match: []dnssvc.MatchCriteria{{
 					QuestionDomain: testSubdomain,
 				}},
 			}, {
-				Name:    "client-group",
-				Address: cliSpecURL,
+				Name:      "client-group",
+				Addresses: []string{cliSpecURL},
 				Match: []dnssvc.MatchCriteria{{
 					Client: cli2Pref,
 				}},
 			}, {
-				Name:    "domain-client-group",
-				Address: subdomainCliSpecURL,
+				Name:      "domain-client-group",
+				Addresses: []string{subdomainCliSpecURL},
 				Match: []dnssvc.MatchCriteria{{
 					Client:         cli2Pref,
 					QuestionDomain: testSubdomain,
@@ -219,6 +234,8 @@ func TestDNSService(t *testing.T) {
 			}},
 			Timeout: testTimeout,
 		},
+		Metrics:  dnssvc.EmptyMetrics{},
+		QueryLog: querylog.Empty{},
 		Fallbacks: &dnssvc.FallbackConfig{
 			Addresses: []string{
 				commonURL,
@@ -232,9 +249,11 @@ func TestDNSService(t *testing.T) {
 		PendingRequests: &dnssvc.PendingRequestsConfig{
 			Enabled: true,
 		},
-		ListenAddrs: []netip.AddrPort{
-			netip.AddrPortFrom(netutil.IPv4Localhost(), 0),
-		},
+		ListenAddrs: []*dnssvc.ListenAddrConfig{{
+			Protocol: dnssvc.ProtocolDNS,
+			Address:  netip.AddrPortFrom(netutil.IPv4Localhost(), 0),
+		}},
+		UsePrivateRDNS: true,
 	})
 	require.NoError(t, err)
 
@@ -292,3 +311,1672 @@ func TestDNSService(t *testing.T) {
 		})
 	}
 }
+
+// newTestCertificate is a test helper that generates a self-signed certificate
+// for localhost and writes it with its private key into the test's temporary
+// directory.  roots contains the generated certificate.
+func newTestCertificate(t *testing.T) (certPath, keyPath string, roots *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	require.NoError(t, os.WriteFile(certPath, certPEM, 0o600))
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0o600))
+
+	roots = x509.NewCertPool()
+	roots.AddCert(cert)
+
+	return certPath, keyPath, roots
+}
+
+func TestDNSService_encrypted(t *testing.T) {
+	t.Parallel()
+
+	req := (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)
+	resp := (&dns.Msg{}).SetReply(req)
+
+	pt := testutil.PanicT{}
+	upsURL := startLocalhostUpstream(t, dns.HandlerFunc(func(w dns.ResponseWriter, _ *dns.Msg) {
+		require.NoError(pt, w.WriteMsg(resp))
+	})).String()
+
+	certPath, keyPath, roots := newTestCertificate(t)
+
+	svc, err := dnssvc.New(testutil.ContextWithTimeout(t, testTimeout), &dnssvc.Config{
+		BaseLogger:     slogutil.NewDiscardLogger(),
+		Logger:         slogutil.NewDiscardLogger(),
+		PrivateSubnets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
+		Bootstrap:      &dnssvc.BootstrapConfig{},
+		Cache:          &dnssvc.CacheConfig{},
+		Upstreams: &dnssvc.UpstreamConfig{
+			Groups: []*dnssvc.UpstreamGroupConfig{{
+				Name:      agdc.UpstreamGroupNameDefault,
+				Addresses: []string{upsURL},
+			}},
+			Timeout: testTimeout,
+		},
+		Metrics:  dnssvc.EmptyMetrics{},
+		QueryLog: querylog.Empty{},
+		Fallbacks: &dnssvc.FallbackConfig{
+			Addresses: []string{upsURL},
+			Timeout:   testTimeout,
+		},
+		ClientGetter:    dnssvc.DefaultClientGetter{},
+		BindRetry:       &dnssvc.BindRetryConfig{},
+		PendingRequests: &dnssvc.PendingRequestsConfig{},
+		ListenAddrs: []*dnssvc.ListenAddrConfig{{
+			TLS: &dnssvc.TLSConfig{
+				CertificatePath: certPath,
+				PrivateKeyPath:  keyPath,
+			},
+			Protocol: dnssvc.ProtocolTLS,
+			Address:  netip.AddrPortFrom(netutil.IPv4Localhost(), 0),
+		}},
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	err = svc.Start(ctx)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) { return svc.Shutdown(ctx) })
+
+	cli := &dns.Client{
+		Net: "tcp-tls",
+		TLSConfig: &tls.Config{
+			RootCAs:    roots,
+			ServerName: "localhost",
+			MinVersion: tls.VersionTLS12,
+		},
+		Timeout: testTimeout,
+	}
+
+	received, _, err := cli.Exchange(req, svc.Addr(proxy.ProtoTLS).String())
+	require.NoError(t, err)
+
+	assert.Equal(t, resp.Answer, received.Answer)
+	assert.Equal(t, resp.Id, received.Id)
+}
+
+// newAnswerUpstream starts a DNS server on localhost that answers A requests
+// with the records of ttl and returns its URL.
+func newAnswerUpstream(t *testing.T, ttl uint32) (u string) {
+	t.Helper()
+
+	pt := testutil.PanicT{}
+
+	return startLocalhostUpstream(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := (&dns.Msg{}).SetReply(r)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   r.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			A: net.IP{1, 2, 3, 4},
+		})
+
+		require.NoError(pt, w.WriteMsg(resp))
+	})).String()
+}
+
+// newCachingConfig returns a configuration with the enabled cache and the
+// single default upstream group with upsURL for tests.
+func newCachingConfig(upsURL string) (conf *dnssvc.Config) {
+	return &dnssvc.Config{
+		BaseLogger:     slogutil.NewDiscardLogger(),
+		Logger:         slogutil.NewDiscardLogger(),
+		PrivateSubnets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
+		Bootstrap:      &dnssvc.BootstrapConfig{},
+		Cache: &dnssvc.CacheConfig{
+			Enabled:    true,
+			Size:       1024,
+			ClientSize: 1024,
+		},
+		Upstreams: &dnssvc.UpstreamConfig{
+			Groups: []*dnssvc.UpstreamGroupConfig{{
+				Name:      agdc.UpstreamGroupNameDefault,
+				Addresses: []string{upsURL},
+			}},
+			Timeout: testTimeout,
+		},
+		Metrics:  dnssvc.EmptyMetrics{},
+		QueryLog: querylog.Empty{},
+		Fallbacks: &dnssvc.FallbackConfig{
+			Addresses: []string{upsURL},
+			Timeout:   testTimeout,
+		},
+		ClientGetter:    dnssvc.DefaultClientGetter{},
+		BindRetry:       &dnssvc.BindRetryConfig{},
+		PendingRequests: &dnssvc.PendingRequestsConfig{},
+		ListenAddrs: []*dnssvc.ListenAddrConfig{{
+			Protocol: dnssvc.ProtocolDNS,
+			Address:  netip.AddrPortFrom(netutil.IPv4Localhost(), 0),
+		}},
+		UsePrivateRDNS: true,
+	}
+}
+
+// startService creates and starts the service with conf for tests.
+func startService(t *testing.T, conf *dnssvc.Config) (svc *dnssvc.DNSService) {
+	t.Helper()
+
+	svc, err := dnssvc.New(testutil.ContextWithTimeout(t, testTimeout), conf)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	err = svc.Start(ctx)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) { return svc.Shutdown(ctx) })
+
+	return svc
+}
+
+func TestDNSService_Reconfigure(t *testing.T) {
+	t.Parallel()
+
+	req := (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)
+
+	const (
+		oldTTL uint32 = 100
+		newTTL uint32 = 200
+	)
+
+	svc := startService(t, newCachingConfig(newAnswerUpstream(t, oldTTL)))
+	ctx := context.Background()
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	requireTTL := func(t *testing.T, want uint32) {
+		t.Helper()
+
+		resp, _, excErr := cli.Exchange(req, addr)
+		require.NoError(t, excErr)
+		require.Len(t, resp.Answer, 1)
+
+		// Allow the cached TTL to decrease.
+		assert.InDelta(t, want, resp.Answer[0].Header().Ttl, 1)
+	}
+
+	requireTTL(t, oldTTL)
+
+	t.Run("same_cache", func(t *testing.T) {
+		err := svc.Reconfigure(ctx, newCachingConfig(newAnswerUpstream(t, newTTL)))
+		require.NoError(t, err)
+
+		requireTTL(t, oldTTL)
+	})
+
+	t.Run("new_cache", func(t *testing.T) {
+		conf := newCachingConfig(newAnswerUpstream(t, newTTL))
+		conf.Cache.Size = 2048
+
+		err := svc.Reconfigure(ctx, conf)
+		require.NoError(t, err)
+
+		requireTTL(t, newTTL)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := svc.Reconfigure(ctx, newCachingConfig("bad://upstream"))
+		require.Error(t, err)
+
+		requireTTL(t, newTTL)
+	})
+}
+
+// testQueryLog is a mock implementation of [querylog.Interface] for tests.
+type testQueryLog struct {
+	OnWrite func(ctx context.Context, e *querylog.Entry)
+}
+
+// type check
+var _ querylog.Interface = (*testQueryLog)(nil)
+
+// Write implements the [querylog.Interface] interface for *testQueryLog.
+func (l *testQueryLog) Write(ctx context.Context, e *querylog.Entry) {
+	l.OnWrite(ctx, e)
+}
+
+func TestDNSService_queryLog(t *testing.T) {
+	t.Parallel()
+
+	entries := make(chan *querylog.Entry, 2)
+
+	conf := newCachingConfig(newAnswerUpstream(t, 100))
+	conf.QueryLog = &testQueryLog{
+		OnWrite: func(_ context.Context, e *querylog.Entry) { entries <- e },
+	}
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+	req := (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)
+
+	for _, wantCached := range []bool{false, true} {
+		_, _, err := cli.Exchange(req, addr)
+		require.NoError(t, err)
+
+		e, _ := testutil.RequireReceive(t, entries, testTimeout)
+		assert.Equal(t, netutil.IPv4Localhost(), e.ClientIP)
+		assert.Equal(t, "example.com.", e.Name)
+		assert.Equal(t, "A", e.QType)
+		assert.Equal(t, string(proxy.ProtoTCP), e.Proto)
+		assert.Equal(t, agdc.UpstreamGroupNameDefault, e.Group)
+		assert.Equal(t, "NOERROR", e.Rcode)
+		assert.Equal(t, wantCached, e.Cached)
+	}
+}
+
+func TestDNSService_control(t *testing.T) {
+	t.Parallel()
+
+	upsURL := newAnswerUpstream(t, 100)
+	badURL := "tcp://" + netip.AddrPortFrom(netutil.IPv4Localhost(), 1).String()
+
+	cliPrefix := netip.MustParsePrefix("192.0.2.0/24")
+
+	conf := newCachingConfig(upsURL)
+	conf.Upstreams.Groups = append(conf.Upstreams.Groups, &dnssvc.UpstreamGroupConfig{
+		Name:      "client-group",
+		Addresses: []string{upsURL},
+		Match: []dnssvc.MatchCriteria{{
+			Client: cliPrefix,
+		}},
+	}, &dnssvc.UpstreamGroupConfig{
+		Name:      "bad-group",
+		Addresses: []string{badURL},
+		Match: []dnssvc.MatchCriteria{{
+			QuestionDomain: "bad.example.",
+		}},
+	})
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	t.Run("listeners", func(t *testing.T) {
+		ls := svc.Listeners()
+		require.Len(t, ls, 2)
+
+		assert.Equal(t, string(proxy.ProtoUDP), ls[0].Proto)
+		assert.Equal(t, string(proxy.ProtoTCP), ls[1].Proto)
+		assert.Equal(t, addr, ls[1].Addr.String())
+	})
+
+	t.Run("upstreams", func(t *testing.T) {
+		for _, host := range []string{"example.com.", "bad.example."} {
+			_, _, err := cli.Exchange((&dns.Msg{}).SetQuestion(host, dns.TypeA), addr)
+			require.NoError(t, err)
+		}
+
+		statuses := map[agdc.UpstreamGroupName]*dnssvc.UpstreamStatus{}
+		for _, s := range svc.UpstreamStatuses() {
+			if s.Type == agdcslog.UpstreamTypeMain {
+				statuses[s.Group] = s
+			}
+		}
+
+		require.Len(t, statuses, 3)
+
+		def := statuses[agdc.UpstreamGroupNameDefault]
+		assert.True(t, def.Healthy)
+		assert.False(t, def.LastSuccess.IsZero())
+
+		bad := statuses["bad-group"]
+		assert.False(t, bad.Healthy)
+		assert.Equal(t, uint(1), bad.Failures)
+		assert.NotEmpty(t, bad.LastError)
+
+		cliGroup := statuses["client-group"]
+		assert.True(t, cliGroup.Healthy)
+		assert.True(t, cliGroup.LastSuccess.IsZero())
+	})
+
+	t.Run("flush_cache", func(t *testing.T) {
+		ctx := context.Background()
+
+		assert.NoError(t, svc.FlushCache(ctx, netip.Prefix{}))
+		assert.NoError(t, svc.FlushCache(ctx, cliPrefix))
+		assert.ErrorIs(t, svc.FlushCache(ctx, netip.MustParsePrefix("198.51.100.0/24")), dnssvc.ErrNoClient)
+	})
+}
+
+func TestDNSService_groupFallback(t *testing.T) {
+	t.Parallel()
+
+	const (
+		commonTTL uint32 = 100
+		groupTTL  uint32 = 200
+	)
+
+	badURL := "tcp://" + netip.AddrPortFrom(netutil.IPv4Localhost(), 1).String()
+
+	conf := newCachingConfig(badURL)
+	conf.Cache.Enabled = false
+	conf.Fallbacks.Addresses = []string{newAnswerUpstream(t, commonTTL)}
+	conf.Upstreams.Groups = append(conf.Upstreams.Groups, &dnssvc.UpstreamGroupConfig{
+		Fallback: &dnssvc.FallbackConfig{
+			Addresses: []string{newAnswerUpstream(t, groupTTL)},
+			Timeout:   testTimeout,
+		},
+		Name:      "office",
+		Addresses: []string{badURL},
+		Match: []dnssvc.MatchCriteria{{
+			QuestionDomain: "office.example",
+		}},
+		Timeout: testTimeout / 2,
+	}, &dnssvc.UpstreamGroupConfig{
+		Fallback: &dnssvc.FallbackConfig{
+			Timeout: testTimeout,
+		},
+		Name:      "isolated",
+		Addresses: []string{badURL},
+		Match: []dnssvc.MatchCriteria{{
+			QuestionDomain: "isolated.example",
+		}},
+	})
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	testCases := []struct {
+		name      string
+		host      string
+		wantRcode int
+		wantTTL   uint32
+	}{{
+		name:      "common",
+		host:      "example.com.",
+		wantRcode: dns.RcodeSuccess,
+		wantTTL:   commonTTL,
+	}, {
+		name:      "group",
+		host:      "www.office.example.",
+		wantRcode: dns.RcodeSuccess,
+		wantTTL:   groupTTL,
+	}, {
+		name:      "no_fallbacks",
+		host:      "www.isolated.example.",
+		wantRcode: dns.RcodeServerFailure,
+		wantTTL:   0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, _, err := cli.Exchange((&dns.Msg{}).SetQuestion(tc.host, dns.TypeA), addr)
+			require.NoError(t, err)
+
+			require.Equal(t, tc.wantRcode, resp.Rcode)
+			if tc.wantTTL == 0 {
+				assert.Empty(t, resp.Answer)
+
+				return
+			}
+
+			require.Len(t, resp.Answer, 1)
+			assert.Equal(t, tc.wantTTL, resp.Answer[0].Header().Ttl)
+		})
+	}
+}
+
+func TestDNSService_questionType(t *testing.T) {
+	t.Parallel()
+
+	const (
+		defaultTTL uint32 = 100
+		typeTTL    uint32 = 200
+	)
+
+	conf := newCachingConfig(newAnswerUpstream(t, defaultTTL))
+	conf.Upstreams.Groups = append(conf.Upstreams.Groups, &dnssvc.UpstreamGroupConfig{
+		Name:      "ipv6",
+		Addresses: []string{newAnswerUpstream(t, typeTTL)},
+		Match: []dnssvc.MatchCriteria{{
+			QuestionType: dns.TypeAAAA,
+		}},
+	})
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	for qtype, wantTTL := range map[uint16]uint32{
+		dns.TypeA:    defaultTTL,
+		dns.TypeAAAA: typeTTL,
+	} {
+		t.Run(dns.Type(qtype).String(), func(t *testing.T) {
+			resp, _, err := cli.Exchange((&dns.Msg{}).SetQuestion("example.com.", qtype), addr)
+			require.NoError(t, err)
+			require.Len(t, resp.Answer, 1)
+
+			assert.Equal(t, wantTTL, resp.Answer[0].Header().Ttl)
+		})
+	}
+}
+
+func TestDNSService_domainPatterns(t *testing.T) {
+	t.Parallel()
+
+	const (
+		defaultTTL  uint32 = 100
+		corpTTL     uint32 = 200
+		exactTTL    uint32 = 300
+		wildcardTTL uint32 = 400
+	)
+
+	conf := newCachingConfig(newAnswerUpstream(t, defaultTTL))
+	conf.Upstreams.Groups = append(conf.Upstreams.Groups, &dnssvc.UpstreamGroupConfig{
+		Name:      "corp",
+		Addresses: []string{newAnswerUpstream(t, corpTTL)},
+		Match: []dnssvc.MatchCriteria{{
+			QuestionDomain: "corp.example",
+		}, {
+			QuestionDomain: "public.corp.example",
+			Exclude:        true,
+		}},
+	}, &dnssvc.UpstreamGroupConfig{
+		Name:      "exact",
+		Addresses: []string{newAnswerUpstream(t, exactTTL)},
+		Match: []dnssvc.MatchCriteria{{
+			QuestionDomain: "exact.example",
+			DomainMatch:    dnssvc.DomainMatchExact,
+		}},
+	}, &dnssvc.UpstreamGroupConfig{
+		Name:      "wildcard",
+		Addresses: []string{newAnswerUpstream(t, wildcardTTL)},
+		Match: []dnssvc.MatchCriteria{{
+			QuestionDomain: "wildcard.example",
+			DomainMatch:    dnssvc.DomainMatchSubdomains,
+		}},
+	})
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	testCases := []struct {
+		host    string
+		wantTTL uint32
+	}{{
+		host:    "www.corp.example.",
+		wantTTL: corpTTL,
+	}, {
+		host:    "public.corp.example.",
+		wantTTL: defaultTTL,
+	}, {
+		host:    "www.public.corp.example.",
+		wantTTL: defaultTTL,
+	}, {
+		host:    "exact.example.",
+		wantTTL: exactTTL,
+	}, {
+		host:    "www.exact.example.",
+		wantTTL: defaultTTL,
+	}, {
+		host:    "wildcard.example.",
+		wantTTL: defaultTTL,
+	}, {
+		host:    "www.wildcard.example.",
+		wantTTL: wildcardTTL,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			resp, _, err := cli.Exchange((&dns.Msg{}).SetQuestion(tc.host, dns.TypeA), addr)
+			require.NoError(t, err)
+			require.Len(t, resp.Answer, 1)
+
+			assert.Equal(t, tc.wantTTL, resp.Answer[0].Header().Ttl)
+		})
+	}
+}
+
+func TestDNSService_Refresh(t *testing.T) {
+	t.Parallel()
+
+	const (
+		defaultTTL uint32 = 100
+		listTTL    uint32 = 200
+	)
+
+	listPath := filepath.Join(t.TempDir(), "domains.txt")
+	require.NoError(t, os.WriteFile(listPath, []byte("# Internal.\nfirst.example\n"), 0o600))
+
+	conf := newCachingConfig(newAnswerUpstream(t, defaultTTL))
+	conf.Upstreams.Groups = append(conf.Upstreams.Groups, &dnssvc.UpstreamGroupConfig{
+		Name:      "list",
+		Addresses: []string{newAnswerUpstream(t, listTTL)},
+		Match: []dnssvc.MatchCriteria{{
+			DomainFile: listPath,
+		}},
+	})
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	requireTTL := func(t *testing.T, host string, want uint32) {
+		t.Helper()
+
+		resp, _, err := cli.Exchange((&dns.Msg{}).SetQuestion(host, dns.TypeA), addr)
+		require.NoError(t, err)
+		require.Len(t, resp.Answer, 1)
+
+		assert.Equal(t, want, resp.Answer[0].Header().Ttl)
+	}
+
+	requireTTL(t, "www.first.example.", listTTL)
+	requireTTL(t, "www.second.example.", defaultTTL)
+
+	// Set the modification time explicitly, since its resolution may be too
+	// coarse to notice the change.
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.WriteFile(listPath, []byte("second.example\n"), 0o600))
+	require.NoError(t, os.Chtimes(listPath, modTime, modTime))
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	require.NoError(t, svc.Refresh(ctx))
+
+	requireTTL(t, "first.example.", defaultTTL)
+	requireTTL(t, "second.example.", listTTL)
+}
+
+// newFilteringConfig returns a filtering configuration with the single list
+// named listName read from the file at path and the blocked responses of mode
+// for tests.
+func newFilteringConfig(
+	listName filter.ListName,
+	path string,
+	mode dnssvc.BlockedResponseMode,
+) (conf *dnssvc.FilteringConfig) {
+	return &dnssvc.FilteringConfig{
+		Filter: &filter.Config{
+			Logger: slogutil.NewDiscardLogger(),
+			Lists: map[filter.ListName]*filter.ListConfig{
+				listName: {
+					Path: path,
+				},
+			},
+			Timeout:         testTimeout,
+			RefreshInterval: time.Hour,
+		},
+		BlockedResponse: &dnssvc.BlockedResponseConfig{
+			IPv4: netip.MustParseAddr("192.0.2.1"),
+			Mode: mode,
+			TTL:  10 * time.Second,
+		},
+	}
+}
+
+func TestDNSService_filtering(t *testing.T) {
+	t.Parallel()
+
+	const listName filter.ListName = "blocklist"
+
+	listPath := filepath.Join(t.TempDir(), "blocklist.txt")
+	rules := []byte("||blocked.example^\n@@||allowed.blocked.example^\n")
+	require.NoError(t, os.WriteFile(listPath, rules, 0o600))
+
+	upsURL := newAnswerUpstream(t, 100)
+
+	testCases := []struct {
+		wantAnswer dns.RR
+		name       string
+		mode       dnssvc.BlockedResponseMode
+		wantRcode  int
+	}{{
+		wantAnswer: nil,
+		name:       "nxdomain",
+		mode:       dnssvc.BlockedResponseModeNXDOMAIN,
+		wantRcode:  dns.RcodeNameError,
+	}, {
+		wantAnswer: nil,
+		name:       "refused",
+		mode:       dnssvc.BlockedResponseModeRefused,
+		wantRcode:  dns.RcodeRefused,
+	}, {
+		wantAnswer: &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   "www.blocked.example.",
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    10,
+			},
+			A: net.IPv4zero.To4(),
+		},
+		name:      "null_ip",
+		mode:      dnssvc.BlockedResponseModeNullIP,
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		wantAnswer: &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   "www.blocked.example.",
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    10,
+			},
+			A: net.IP{192, 0, 2, 1},
+		},
+		name:      "custom_ip",
+		mode:      dnssvc.BlockedResponseModeCustomIP,
+		wantRcode: dns.RcodeSuccess,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			entries := make(chan *querylog.Entry, 1)
+
+			conf := newCachingConfig(upsURL)
+			conf.Upstreams.Groups[0].FilterLists = []filter.ListName{listName}
+			conf.Filtering = newFilteringConfig(listName, listPath, tc.mode)
+			conf.QueryLog = &testQueryLog{
+				OnWrite: func(_ context.Context, e *querylog.Entry) { entries <- e },
+			}
+
+			svc := startService(t, conf)
+
+			cli := &dns.Client{
+				Net:     string(proxy.ProtoTCP),
+				Timeout: testTimeout,
+			}
+			addr := svc.Addr(proxy.ProtoTCP).String()
+
+			req := (&dns.Msg{}).SetQuestion("www.blocked.example.", dns.TypeA)
+			resp, _, err := cli.Exchange(req, addr)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			if tc.wantAnswer == nil {
+				assert.Empty(t, resp.Answer)
+			} else {
+				require.Len(t, resp.Answer, 1)
+				assert.Equal(t, tc.wantAnswer.String(), resp.Answer[0].String())
+			}
+
+			e, _ := testutil.RequireReceive(t, entries, testTimeout)
+			assert.Equal(t, string(listName), e.FilterList)
+			assert.Equal(t, "||blocked.example^", e.Rule)
+			assert.True(t, e.Blocked)
+		})
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		t.Parallel()
+
+		entries := make(chan *querylog.Entry, 1)
+
+		conf := newCachingConfig(upsURL)
+		conf.Upstreams.Groups[0].FilterLists = []filter.ListName{listName}
+		conf.Filtering = newFilteringConfig(listName, listPath, dnssvc.BlockedResponseModeRefused)
+		conf.QueryLog = &testQueryLog{
+			OnWrite: func(_ context.Context, e *querylog.Entry) { entries <- e },
+		}
+
+		svc := startService(t, conf)
+
+		cli := &dns.Client{
+			Net:     string(proxy.ProtoTCP),
+			Timeout: testTimeout,
+		}
+		addr := svc.Addr(proxy.ProtoTCP).String()
+
+		req := (&dns.Msg{}).SetQuestion("allowed.blocked.example.", dns.TypeA)
+		resp, _, err := cli.Exchange(req, addr)
+		require.NoError(t, err)
+
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Len(t, resp.Answer, 1)
+
+		e, _ := testutil.RequireReceive(t, entries, testTimeout)
+		assert.Equal(t, string(listName), e.FilterList)
+		assert.Equal(t, "@@||allowed.blocked.example^", e.Rule)
+		assert.False(t, e.Blocked)
+	})
+
+	t.Run("client_override", func(t *testing.T) {
+		t.Parallel()
+
+		conf := newCachingConfig(upsURL)
+		conf.Upstreams.Groups[0].FilterLists = []filter.ListName{listName}
+		conf.Filtering = newFilteringConfig(listName, listPath, dnssvc.BlockedResponseModeRefused)
+		conf.Filtering.Clients = []*dnssvc.FilteringClientConfig{{
+			Prefix: netip.PrefixFrom(netutil.IPv4Localhost(), 32),
+			Lists:  nil,
+		}}
+
+		svc := startService(t, conf)
+
+		cli := &dns.Client{
+			Net:     string(proxy.ProtoTCP),
+			Timeout: testTimeout,
+		}
+		addr := svc.Addr(proxy.ProtoTCP).String()
+
+		req := (&dns.Msg{}).SetQuestion("www.blocked.example.", dns.TypeA)
+		resp, _, err := cli.Exchange(req, addr)
+		require.NoError(t, err)
+
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Len(t, resp.Answer, 1)
+	})
+}
+
+func TestDNSService_localRecords(t *testing.T) {
+	t.Parallel()
+
+	const upsTTL uint32 = 100
+
+	hostsPath := filepath.Join(t.TempDir(), "hosts")
+	hosts := []byte("# LAN hosts.\n192.168.1.20 nas.lan storage.lan\ninvalid line\n")
+	require.NoError(t, os.WriteFile(hostsPath, hosts, 0o600))
+
+	conf := newCachingConfig(newAnswerUpstream(t, upsTTL))
+	conf.LocalRecords = &dnssvc.LocalRecordsConfig{
+		Records: []*dnssvc.LocalRecord{{
+			Name: "Printer.LAN",
+			Addrs: []netip.Addr{
+				netip.MustParseAddr("192.168.1.10"),
+				netip.MustParseAddr("fd00::10"),
+			},
+		}, {
+			Name:  "public.lan",
+			Addrs: []netip.Addr{netip.MustParseAddr("1.2.3.5")},
+		}},
+		HostsFiles: []string{hostsPath},
+		TTL:        10 * time.Second,
+	}
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	exchange := func(t *testing.T, host string, qtype uint16) (resp *dns.Msg) {
+		t.Helper()
+
+		resp, _, err := cli.Exchange((&dns.Msg{}).SetQuestion(host, qtype), addr)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantAnswer []string
+		qtype      uint16
+		wantRcode  int
+	}{{
+		name:       "a",
+		host:       "printer.lan.",
+		wantAnswer: []string{"printer.lan.\t10\tIN\tA\t192.168.1.10"},
+		qtype:      dns.TypeA,
+		wantRcode:  dns.RcodeSuccess,
+	}, {
+		name:       "aaaa",
+		host:       "PRINTER.lan.",
+		wantAnswer: []string{"PRINTER.lan.\t10\tIN\tAAAA\tfd00::10"},
+		qtype:      dns.TypeAAAA,
+		wantRcode:  dns.RcodeSuccess,
+	}, {
+		name:       "no_data",
+		host:       "nas.lan.",
+		wantAnswer: nil,
+		qtype:      dns.TypeAAAA,
+		wantRcode:  dns.RcodeSuccess,
+	}, {
+		name:       "hosts_file",
+		host:       "storage.lan.",
+		wantAnswer: []string{"storage.lan.\t10\tIN\tA\t192.168.1.20"},
+		qtype:      dns.TypeA,
+		wantRcode:  dns.RcodeSuccess,
+	}, {
+		name: "private_ptr",
+		host: "20.1.168.192.in-addr.arpa.",
+		wantAnswer: []string{
+			"20.1.168.192.in-addr.arpa.\t10\tIN\tPTR\tnas.lan.",
+			"20.1.168.192.in-addr.arpa.\t10\tIN\tPTR\tstorage.lan.",
+		},
+		qtype:     dns.TypePTR,
+		wantRcode: dns.RcodeSuccess,
+	}, {
+		name:       "unknown_private_ptr",
+		host:       "30.1.168.192.in-addr.arpa.",
+		wantAnswer: nil,
+		qtype:      dns.TypePTR,
+		wantRcode:  dns.RcodeNameError,
+	}, {
+		name:       "public_ptr",
+		host:       "5.3.2.1.in-addr.arpa.",
+		wantAnswer: []string{"5.3.2.1.in-addr.arpa.\t100\tIN\tA\t1.2.3.4"},
+		qtype:      dns.TypePTR,
+		wantRcode:  dns.RcodeSuccess,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := exchange(t, tc.host, tc.qtype)
+			require.Equal(t, tc.wantRcode, resp.Rcode)
+
+			var answer []string
+			for _, rr := range resp.Answer {
+				answer = append(answer, rr.String())
+			}
+
+			assert.Equal(t, tc.wantAnswer, answer)
+		})
+	}
+
+	t.Run("refresh", func(t *testing.T) {
+		// Set the modification time explicitly, since its resolution may be
+		// too coarse to notice the change.
+		modTime := time.Now().Add(time.Minute)
+		require.NoError(t, os.WriteFile(hostsPath, []byte("192.168.1.30 nas.lan\n"), 0o600))
+		require.NoError(t, os.Chtimes(hostsPath, modTime, modTime))
+
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+		require.NoError(t, svc.Refresh(ctx))
+
+		resp := exchange(t, "nas.lan.", dns.TypeA)
+		require.Len(t, resp.Answer, 1)
+
+		assert.Equal(t, "nas.lan.\t10\tIN\tA\t192.168.1.30", resp.Answer[0].String())
+
+		resp = exchange(t, "storage.lan.", dns.TypeA)
+		require.Len(t, resp.Answer, 1)
+
+		assert.Equal(t, "storage.lan.\t100\tIN\tA\t1.2.3.4", resp.Answer[0].String())
+	})
+}
+
+func TestDNSService_zones(t *testing.T) {
+	t.Parallel()
+
+	zonePath := filepath.Join(t.TempDir(), "lab.internal.zone")
+	zone := []byte("$TTL 60\n@ IN SOA ns admin 1 3600 600 86400 30\nwww IN A 192.168.10.3\n")
+	require.NoError(t, os.WriteFile(zonePath, zone, 0o600))
+
+	conf := newCachingConfig(newAnswerUpstream(t, 100))
+	conf.Upstreams.Groups = append(conf.Upstreams.Groups, &dnssvc.UpstreamGroupConfig{
+		Name:      "lab",
+		Addresses: []string{newAnswerUpstream(t, 200)},
+		Match: []dnssvc.MatchCriteria{{
+			QuestionDomain: "internal",
+		}},
+	})
+	conf.Zones = []*dnssvc.ZoneConfig{{
+		Name: "lab.internal",
+		Path: zonePath,
+	}}
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	exchange := func(t *testing.T, host string) (resp *dns.Msg) {
+		t.Helper()
+
+		resp, _, err := cli.Exchange((&dns.Msg{}).SetQuestion(host, dns.TypeA), addr)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	resp := exchange(t, "www.lab.internal.")
+	require.Len(t, resp.Answer, 1)
+
+	assert.True(t, resp.Authoritative)
+	assert.Equal(t, "www.lab.internal.\t60\tIN\tA\t192.168.10.3", resp.Answer[0].String())
+
+	resp = exchange(t, "mail.lab.internal.")
+	assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+	assert.Len(t, resp.Ns, 1)
+
+	resp = exchange(t, "www.other.internal.")
+	require.Len(t, resp.Answer, 1)
+
+	assert.Equal(t, uint32(200), resp.Answer[0].Header().Ttl)
+
+	// Set the modification time explicitly, since its resolution may be too
+	// coarse to notice the change.
+	modTime := time.Now().Add(time.Minute)
+	zone = append(zone, "mail IN A 192.168.10.2\n"...)
+	require.NoError(t, os.WriteFile(zonePath, zone, 0o600))
+	require.NoError(t, os.Chtimes(zonePath, modTime, modTime))
+
+	require.NoError(t, svc.Refresh(testutil.ContextWithTimeout(t, testTimeout)))
+
+	resp = exchange(t, "mail.lab.internal.")
+	require.Len(t, resp.Answer, 1)
+
+	assert.Equal(t, "mail.lab.internal.\t60\tIN\tA\t192.168.10.2", resp.Answer[0].String())
+}
+
+func TestDNSService_responsePolicies(t *testing.T) {
+	t.Parallel()
+
+	const policyName = "threats"
+
+	zonePath := filepath.Join(t.TempDir(), "rpz.zone")
+	zone := []byte(`$TTL 60
+@                   IN SOA   ns admin 1 3600 600 86400 30
+blocked.example     IN CNAME .
+allowed.example     IN CNAME rpz-passthru.
+walled.example      IN A     192.0.2.10
+32.4.3.2.1.rpz-ip   IN CNAME *.
+`)
+	require.NoError(t, os.WriteFile(zonePath, zone, 0o600))
+
+	entries := make(chan *querylog.Entry, 1)
+
+	conf := newCachingConfig(newAnswerUpstream(t, 100))
+	conf.QueryLog = &testQueryLog{
+		OnWrite: func(_ context.Context, e *querylog.Entry) { entries <- e },
+	}
+	conf.ResponsePolicies = &rpz.Config{
+		Logger: slogutil.NewDiscardLogger(),
+		Policies: []*rpz.PolicyConfig{{
+			Name: policyName,
+			Zone: "rpz.example",
+			Path: zonePath,
+		}},
+		Timeout: testTimeout,
+	}
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	testCases := []struct {
+		name        string
+		host        string
+		wantAnswer  string
+		wantTrigger string
+		wantAction  rpz.Action
+		wantRcode   int
+	}{{
+		name:        "nxdomain",
+		host:        "blocked.example.",
+		wantAnswer:  "",
+		wantTrigger: "blocked.example.rpz.example.",
+		wantAction:  rpz.ActionNXDOMAIN,
+		wantRcode:   dns.RcodeNameError,
+	}, {
+		name:        "passthru",
+		host:        "allowed.example.",
+		wantAnswer:  "allowed.example.\t100\tIN\tA\t1.2.3.4",
+		wantTrigger: "allowed.example.rpz.example.",
+		wantAction:  rpz.ActionPassthru,
+		wantRcode:   dns.RcodeSuccess,
+	}, {
+		name:        "local_data",
+		host:        "walled.example.",
+		wantAnswer:  "walled.example.\t60\tIN\tA\t192.0.2.10",
+		wantTrigger: "walled.example.rpz.example.",
+		wantAction:  rpz.ActionLocalData,
+		wantRcode:   dns.RcodeSuccess,
+	}, {
+		name:        "response_ip",
+		host:        "resolved.example.",
+		wantAnswer:  "",
+		wantTrigger: "32.4.3.2.1.rpz-ip.rpz.example.",
+		wantAction:  rpz.ActionNODATA,
+		wantRcode:   dns.RcodeSuccess,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, _, err := cli.Exchange((&dns.Msg{}).SetQuestion(tc.host, dns.TypeA), addr)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			if tc.wantAnswer == "" {
+				assert.Empty(t, resp.Answer)
+			} else {
+				require.Len(t, resp.Answer, 1)
+
+				assert.Equal(t, tc.wantAnswer, resp.Answer[0].String())
+			}
+
+			e, _ := testutil.RequireReceive(t, entries, testTimeout)
+			assert.Equal(t, policyName, e.Policy)
+			assert.Equal(t, tc.wantTrigger, e.PolicyTrigger)
+			assert.Equal(t, string(tc.wantAction), e.PolicyAction)
+		})
+	}
+}
+
+func TestDNSService_rewrites(t *testing.T) {
+	t.Parallel()
+
+	conf := newCachingConfig(newAnswerUpstream(t, 100))
+	conf.Rewrites = &dnssvc.RewritesConfig{
+		Rules: []*dnssvc.RewriteRule{{
+			Domain:      "git.example.com",
+			Addr:        netip.MustParseAddr("198.51.100.1"),
+			DomainMatch: dnssvc.DomainMatchExact,
+		}, {
+			Client:      netip.MustParsePrefix("127.0.0.0/8"),
+			Domain:      "git.example.com",
+			Addr:        netip.MustParseAddr("192.168.1.20"),
+			DomainMatch: dnssvc.DomainMatchExact,
+		}, {
+			Client: netip.MustParsePrefix("10.0.0.0/8"),
+			Domain: "other.example.com",
+			Addr:   netip.MustParseAddr("10.0.0.1"),
+		}, {
+			Domain: "cdn.example.com",
+			Target: "cdn.provider.example",
+		}, {
+			Domain: "alias.example.com",
+			Target: "git.example.com",
+		}},
+		TTL: 10 * time.Second,
+	}
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	testCases := []struct {
+		name        string
+		host        string
+		wantAnswers []string
+		qtype       uint16
+	}{{
+		name: "client_scoped",
+		host: "git.example.com.",
+		wantAnswers: []string{
+			"git.example.com.\t10\tIN\tA\t192.168.1.20",
+		},
+		qtype: dns.TypeA,
+	}, {
+		name:        "other_family",
+		host:        "git.example.com.",
+		wantAnswers: nil,
+		qtype:       dns.TypeAAAA,
+	}, {
+		name: "other_client",
+		host: "other.example.com.",
+		wantAnswers: []string{
+			"other.example.com.\t100\tIN\tA\t1.2.3.4",
+		},
+		qtype: dns.TypeA,
+	}, {
+		name: "cname_resolved",
+		host: "cdn.example.com.",
+		wantAnswers: []string{
+			"cdn.example.com.\t10\tIN\tCNAME\tcdn.provider.example.",
+			"cdn.provider.example.\t100\tIN\tA\t1.2.3.4",
+		},
+		qtype: dns.TypeA,
+	}, {
+		name: "cname_rewritten",
+		host: "alias.example.com.",
+		wantAnswers: []string{
+			"alias.example.com.\t10\tIN\tCNAME\tgit.example.com.",
+			"git.example.com.\t10\tIN\tA\t192.168.1.20",
+		},
+		qtype: dns.TypeA,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := (&dns.Msg{}).SetQuestion(tc.host, tc.qtype)
+			resp, _, err := cli.Exchange(req, addr)
+			require.NoError(t, err)
+
+			assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+			assert.Equal(t, req.Question, resp.Question)
+
+			var answers []string
+			for _, rr := range resp.Answer {
+				answers = append(answers, rr.String())
+			}
+
+			assert.Equal(t, tc.wantAnswers, answers)
+		})
+	}
+}
+
+func TestDNSService_privateSubnets(t *testing.T) {
+	t.Parallel()
+
+	sharedAddr := netip.MustParseAddr("100.64.0.1")
+	arpa, err := netutil.IPToReversedAddr(sharedAddr.AsSlice())
+	require.NoError(t, err)
+
+	req := (&dns.Msg{}).SetQuestion(dns.Fqdn(arpa), dns.TypePTR)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+
+	testCases := []struct {
+		name           string
+		wantRcode      int
+		wantTTL        uint32
+		usePrivateRDNS bool
+	}{{
+		name:           "private",
+		wantRcode:      dns.RcodeSuccess,
+		wantTTL:        200,
+		usePrivateRDNS: true,
+	}, {
+		name:           "private_rdns_disabled",
+		wantRcode:      dns.RcodeNameError,
+		wantTTL:        0,
+		usePrivateRDNS: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			conf := newCachingConfig(newAnswerUpstream(t, 100))
+			conf.PrivateSubnets = netutil.SliceSubnetSet{
+				netip.MustParsePrefix("100.64.0.0/10"),
+				netip.MustParsePrefix("127.0.0.0/8"),
+			}
+			conf.UsePrivateRDNS = tc.usePrivateRDNS
+			conf.Upstreams.Groups = append(conf.Upstreams.Groups, &dnssvc.UpstreamGroupConfig{
+				Name:      agdc.UpstreamGroupNamePrivate,
+				Addresses: []string{newAnswerUpstream(t, 200)},
+			})
+
+			svc := startService(t, conf)
+
+			resp, _, excErr := cli.Exchange(req, svc.Addr(proxy.ProtoTCP).String())
+			require.NoError(t, excErr)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			if tc.wantTTL == 0 {
+				assert.Empty(t, resp.Answer)
+
+				return
+			}
+
+			require.Len(t, resp.Answer, 1)
+
+			assert.Equal(t, tc.wantTTL, resp.Answer[0].Header().Ttl)
+		})
+	}
+}
+
+func TestDNSService_Reconfigure_privateCache(t *testing.T) {
+	t.Parallel()
+
+	arpa, err := netutil.IPToReversedAddr(net.IP{100, 64, 0, 1})
+	require.NoError(t, err)
+
+	req := (&dns.Msg{}).SetQuestion(dns.Fqdn(arpa), dns.TypePTR)
+
+	newConf := func(ttl uint32) (conf *dnssvc.Config) {
+		conf = newCachingConfig(newAnswerUpstream(t, 100))
+		conf.PrivateSubnets = netutil.SliceSubnetSet{
+			netip.MustParsePrefix("100.64.0.0/10"),
+			netip.MustParsePrefix("127.0.0.0/8"),
+		}
+		conf.Upstreams.Groups = append(conf.Upstreams.Groups, &dnssvc.UpstreamGroupConfig{
+			Name:      agdc.UpstreamGroupNamePrivate,
+			Addresses: []string{newAnswerUpstream(t, ttl)},
+		})
+
+		return conf
+	}
+
+	svc := startService(t, newConf(200))
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	addr := svc.Addr(proxy.ProtoTCP).String()
+
+	resp, _, err := cli.Exchange(req, addr)
+	require.NoError(t, err)
+	require.Len(t, resp.Answer, 1)
+
+	err = svc.Reconfigure(context.Background(), newConf(300))
+	require.NoError(t, err)
+
+	resp, _, err = cli.Exchange(req, addr)
+	require.NoError(t, err)
+	require.Len(t, resp.Answer, 1)
+
+	// Allow the cached TTL to decrease.
+	assert.InDelta(t, 200, resp.Answer[0].Header().Ttl, 1)
+}
+
+// tcpListenAddr returns the address of the single TCP listener reported by svc.
+func tcpListenAddr(t *testing.T, svc *dnssvc.DNSService) (addr netip.AddrPort) {
+	t.Helper()
+
+	for _, l := range svc.Listeners() {
+		if l.Proto == string(proxy.ProtoTCP) {
+			addr = l.Addr
+		}
+	}
+
+	require.True(t, addr.IsValid())
+
+	return addr
+}
+
+// exchangeProxied sends req to the TCP address addr of the service after the
+// PROXY protocol header and returns the response.
+func exchangeProxied(
+	t *testing.T,
+	addr netip.AddrPort,
+	header string,
+	req *dns.Msg,
+) (resp *dns.Msg, err error) {
+	t.Helper()
+
+	d := &net.Dialer{
+		Timeout: testTimeout,
+	}
+	conn, err := d.DialContext(testutil.ContextWithTimeout(t, testTimeout), "tcp", addr.String())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(testTimeout)))
+
+	_, err = conn.Write([]byte(header))
+	require.NoError(t, err)
+
+	dnsConn := &dns.Conn{
+		Conn: conn,
+	}
+	err = dnsConn.WriteMsg(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return dnsConn.ReadMsg()
+}
+
+func TestDNSService_proxyProtocol(t *testing.T) {
+	t.Parallel()
+
+	req := (&dns.Msg{}).SetQuestion("git.example.com.", dns.TypeA)
+
+	testCases := []struct {
+		trusted    netutil.SubnetSet
+		name       string
+		header     string
+		wantAnswer string
+	}{{
+		trusted:    netutil.SliceSubnetSet{netip.MustParsePrefix("127.0.0.0/8")},
+		name:       "client_scoped",
+		header:     "PROXY TCP4 192.0.2.1 127.0.0.1 12345 53\r\n",
+		wantAnswer: "git.example.com.\t10\tIN\tA\t192.168.1.20",
+	}, {
+		trusted:    netutil.SliceSubnetSet{netip.MustParsePrefix("127.0.0.0/8")},
+		name:       "unknown",
+		header:     "PROXY UNKNOWN\r\n",
+		wantAnswer: "git.example.com.\t100\tIN\tA\t1.2.3.4",
+	}, {
+		trusted:    netutil.SliceSubnetSet{netip.MustParsePrefix("10.0.0.0/8")},
+		name:       "untrusted",
+		header:     "PROXY TCP4 192.0.2.1 127.0.0.1 12345 53\r\n",
+		wantAnswer: "",
+	}, {
+		trusted:    netutil.SliceSubnetSet{netip.MustParsePrefix("127.0.0.0/8")},
+		name:       "no_header",
+		header:     "",
+		wantAnswer: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			conf := newCachingConfig(newAnswerUpstream(t, 100))
+			conf.TrustedProxies = tc.trusted
+			conf.ListenAddrs[0].ProxyProtocol = true
+			conf.Rewrites = &dnssvc.RewritesConfig{
+				Rules: []*dnssvc.RewriteRule{{
+					Client: netip.MustParsePrefix("192.0.2.0/24"),
+					Domain: "git.example.com",
+					Addr:   netip.MustParseAddr("192.168.1.20"),
+				}},
+				TTL: 10 * time.Second,
+			}
+
+			svc := startService(t, conf)
+			addr := tcpListenAddr(t, svc)
+			require.NotEqual(t, svc.Addr(proxy.ProtoTCP).String(), addr.String())
+
+			resp, err := exchangeProxied(t, addr, tc.header, req)
+			if tc.wantAnswer == "" {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, resp.Answer, 1)
+
+			assert.Equal(t, tc.wantAnswer, resp.Answer[0].String())
+		})
+	}
+}
+
+func TestDNSService_access(t *testing.T) {
+	t.Parallel()
+
+	allowedAddr := netip.MustParseAddr("192.0.2.1")
+
+	testCases := []struct {
+		client    netip.Addr
+		name      string
+		host      string
+		mode      dnssvc.RejectMode
+		wantRcode int
+		wantDrop  bool
+	}{{
+		client:    allowedAddr,
+		name:      "allowed",
+		host:      "example.org.",
+		mode:      dnssvc.RejectModeRefused,
+		wantRcode: dns.RcodeSuccess,
+		wantDrop:  false,
+	}, {
+		client:    netip.MustParseAddr("192.0.2.200"),
+		name:      "disallowed",
+		host:      "example.org.",
+		mode:      dnssvc.RejectModeRefused,
+		wantRcode: dns.RcodeRefused,
+		wantDrop:  false,
+	}, {
+		client:    netip.MustParseAddr("::ffff:192.0.2.200"),
+		name:      "disallowed_mapped",
+		host:      "example.org.",
+		mode:      dnssvc.RejectModeRefused,
+		wantRcode: dns.RcodeRefused,
+		wantDrop:  false,
+	}, {
+		client:    netip.MustParseAddr("::ffff:192.0.2.1"),
+		name:      "allowed_mapped",
+		host:      "example.org.",
+		mode:      dnssvc.RejectModeRefused,
+		wantRcode: dns.RcodeSuccess,
+		wantDrop:  false,
+	}, {
+		client:    netip.MustParseAddr("198.51.100.1"),
+		name:      "not_allowed",
+		host:      "example.org.",
+		mode:      dnssvc.RejectModeRefused,
+		wantRcode: dns.RcodeRefused,
+		wantDrop:  false,
+	}, {
+		client:    allowedAddr,
+		name:      "blocked_domain",
+		host:      "sub.Blocked.example.",
+		mode:      dnssvc.RejectModeRefused,
+		wantRcode: dns.RcodeRefused,
+		wantDrop:  false,
+	}, {
+		client:    allowedAddr,
+		name:      "blocked_domain_drop",
+		host:      "blocked.example.",
+		mode:      dnssvc.RejectModeDrop,
+		wantRcode: 0,
+		wantDrop:  true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			conf := newCachingConfig(newAnswerUpstream(t, 100))
+			conf.ClientGetter = &testClientGetter{
+				OnAddress: func(_ *proxy.DNSContext) (addr netip.AddrPort) {
+					return netip.AddrPortFrom(tc.client, 1)
+				},
+			}
+			conf.Access = &dnssvc.AccessConfig{
+				AllowedClients:    []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+				DisallowedClients: []netip.Prefix{netip.MustParsePrefix("192.0.2.128/25")},
+				BlockedDomains:    []string{"blocked.example"},
+				Mode:              tc.mode,
+			}
+
+			svc := startService(t, conf)
+
+			cli := &dns.Client{
+				Net:     string(proxy.ProtoTCP),
+				Timeout: testTimeout / 4,
+			}
+
+			req := (&dns.Msg{}).SetQuestion(tc.host, dns.TypeA)
+			resp, _, err := cli.Exchange(req, svc.Addr(proxy.ProtoTCP).String())
+			if tc.wantDrop {
+				assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+		})
+	}
+}
+
+func TestDNSService_rateLimit(t *testing.T) {
+	t.Parallel()
+
+	const (
+		cliName   agdc.ClientName        = "localhost"
+		groupName agdc.UpstreamGroupName = "allowed"
+	)
+
+	upsURL := newAnswerUpstream(t, 100)
+
+	testCases := []struct {
+		name       string
+		host       string
+		clients    []agdc.ClientName
+		groups     []agdc.UpstreamGroupName
+		wantSecond int
+	}{{
+		name:       "limited",
+		host:       "example.org.",
+		clients:    nil,
+		groups:     nil,
+		wantSecond: dns.RcodeRefused,
+	}, {
+		name:       "allowlisted_client",
+		host:       "example.org.",
+		clients:    []agdc.ClientName{cliName},
+		groups:     nil,
+		wantSecond: dns.RcodeSuccess,
+	}, {
+		name:       "allowlisted_group",
+		host:       "allowed.example.",
+		clients:    nil,
+		groups:     []agdc.UpstreamGroupName{groupName},
+		wantSecond: dns.RcodeSuccess,
+	}, {
+		name:       "other_group",
+		host:       "example.org.",
+		clients:    nil,
+		groups:     []agdc.UpstreamGroupName{groupName},
+		wantSecond: dns.RcodeRefused,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			conf := newCachingConfig(upsURL)
+			conf.Upstreams.Groups = append(conf.Upstreams.Groups, &dnssvc.UpstreamGroupConfig{
+				Name:      groupName,
+				Addresses: []string{upsURL},
+				Match: []dnssvc.MatchCriteria{{
+					QuestionDomain: "allowed.example.",
+				}},
+			})
+			conf.Clients = []*dnssvc.ClientConfig{{
+				Name:     cliName,
+				Prefixes: []netip.Prefix{netip.PrefixFrom(netutil.IPv4Localhost(), 32)},
+			}}
+			conf.RateLimit = &dnssvc.RateLimitConfig{
+				Mode:             dnssvc.RejectModeRefused,
+				AllowlistClients: tc.clients,
+				AllowlistGroups:  tc.groups,
+				RPS:              1,
+				Burst:            1,
+				IPv4PrefixLen:    24,
+				IPv6PrefixLen:    56,
+			}
+
+			svc := startService(t, conf)
+
+			cli := &dns.Client{
+				Net:     string(proxy.ProtoTCP),
+				Timeout: testTimeout,
+			}
+			addr := svc.Addr(proxy.ProtoTCP).String()
+			req := (&dns.Msg{}).SetQuestion(tc.host, dns.TypeA)
+
+			resp, _, err := cli.Exchange(req, addr)
+			require.NoError(t, err)
+
+			assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+
+			resp, _, err = cli.Exchange(req, addr)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantSecond, resp.Rcode)
+		})
+	}
+}
+
+func TestDNSService_clients(t *testing.T) {
+	t.Parallel()
+
+	const (
+		commonTTL uint32 = 100
+		groupTTL  uint32 = 200
+	)
+
+	entries := make(chan *querylog.Entry, 1)
+
+	conf := newCachingConfig(newAnswerUpstream(t, commonTTL))
+	conf.Cache.Enabled = false
+	conf.QueryLog = &testQueryLog{
+		OnWrite: func(_ context.Context, e *querylog.Entry) { entries <- e },
+	}
+	conf.Upstreams.Groups = append(conf.Upstreams.Groups, &dnssvc.UpstreamGroupConfig{
+		Name:      "laptops",
+		Addresses: []string{newAnswerUpstream(t, groupTTL)},
+	})
+	conf.Clients = []*dnssvc.ClientConfig{{
+		Name:          "laptop",
+		UpstreamGroup: "laptops",
+		Prefixes: []netip.Prefix{
+			netip.MustParsePrefix("192.0.2.1/32"),
+			netip.PrefixFrom(netutil.IPv4Localhost(), 32),
+		},
+	}}
+
+	svc := startService(t, conf)
+
+	cli := &dns.Client{
+		Net:     string(proxy.ProtoTCP),
+		Timeout: testTimeout,
+	}
+	req := (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)
+
+	resp, _, err := cli.Exchange(req, svc.Addr(proxy.ProtoTCP).String())
+	require.NoError(t, err)
+	require.Len(t, resp.Answer, 1)
+
+	assert.Equal(t, groupTTL, resp.Answer[0].Header().Ttl)
+
+	e, _ := testutil.RequireReceive(t, entries, testTimeout)
+	assert.Equal(t, agdc.ClientName("laptop"), e.ClientName)
+	assert.Equal(t, agdc.UpstreamGroupName("laptops"), e.Group)
+}
@@ -0,0 +1,76 @@
+package dnssvc
+
+import "strings"
+
+// domainTrie is a trie of domain names keyed by their labels starting from the
+// rightmost one, which looks up the longest inserted domain that is the host
+// itself or its parent.  It must be created with [newDomainTrie].  It's safe
+// for concurrent lookups, but not for concurrent modifications.
+type domainTrie struct {
+	root *domainNode
+}
+
+// domainNode is a single node of a [domainTrie].  The path from the root of
+// the trie to the node represents the labels of the domain.
+type domainNode struct {
+	// children are the nodes for the next label to the left.
+	children map[string]*domainNode
+
+	// isDomain is true if the node represents an inserted domain.
+	isDomain bool
+}
+
+// newDomainTrie returns a new empty *domainTrie.
+func newDomainTrie() (t *domainTrie) {
+	return &domainTrie{
+		root: &domainNode{},
+	}
+}
+
+// insert adds domain to t.  domain must be a lowercased FQDN.
+func (t *domainTrie) insert(domain string) {
+	name := strings.TrimSuffix(domain, ".")
+	n := t.root
+	for end := len(name); end > 0; {
+		start := strings.LastIndexByte(name[:end], '.') + 1
+		label := name[start:end]
+
+		next := n.children[label]
+		if next == nil {
+			if n.children == nil {
+				n.children = map[string]*domainNode{}
+			}
+
+			next = &domainNode{}
+			n.children[label] = next
+		}
+
+		n, end = next, start-1
+	}
+
+	n.isDomain = true
+}
+
+// lookup returns the length of the longest domain in t which is host itself or
+// its parent, as an FQDN.  ok is false if there is no such domain.  host must
+// be a lowercased FQDN.
+func (t *domainTrie) lookup(host string) (domainLen int, ok bool) {
+	name := strings.TrimSuffix(host, ".")
+	n := t.root
+	for end := len(name); end > 0; {
+		start := strings.LastIndexByte(name[:end], '.') + 1
+		n = n.children[name[start:end]]
+		if n == nil {
+			break
+		}
+
+		if n.isDomain {
+			// Account for the trailing dot.
+			domainLen, ok = len(name)-start+1, true
+		}
+
+		end = start - 1
+	}
+
+	return domainLen, ok
+}
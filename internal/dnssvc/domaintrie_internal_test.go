@@ -0,0 +1,69 @@
+package dnssvc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainTrie_lookup(t *testing.T) {
+	t.Parallel()
+
+	trie := newDomainTrie()
+	for _, d := range []string{
+		"example.",
+		"corp.example.",
+		"host.sub.corp.example.",
+		"org.",
+	} {
+		trie.insert(d)
+	}
+
+	testCases := []struct {
+		wantOK assert.BoolAssertionFunc
+		host   string
+		want   int
+	}{{
+		host:   "example.",
+		want:   len("example."),
+		wantOK: assert.True,
+	}, {
+		host:   "www.example.",
+		want:   len("example."),
+		wantOK: assert.True,
+	}, {
+		host:   "www.corp.example.",
+		want:   len("corp.example."),
+		wantOK: assert.True,
+	}, {
+		host:   "sub.corp.example.",
+		want:   len("corp.example."),
+		wantOK: assert.True,
+	}, {
+		host:   "a.host.sub.corp.example.",
+		want:   len("host.sub.corp.example."),
+		wantOK: assert.True,
+	}, {
+		host:   "notexample.",
+		want:   0,
+		wantOK: assert.False,
+	}, {
+		host:   "example.com.",
+		want:   0,
+		wantOK: assert.False,
+	}, {
+		host:   ".",
+		want:   0,
+		wantOK: assert.False,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := trie.lookup(tc.host)
+			tc.wantOK(t, ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
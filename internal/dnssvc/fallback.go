@@ -5,9 +5,12 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdcslog"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
 )
 
 // FallbackConfig is the configuration for DNS fallback upstream servers.
@@ -21,14 +24,23 @@ type FallbackConfig struct {
 }
 
 // newFallbacks creates a new fallback upstream configuration from conf using
-// boot.  conf and l must not be nil.
+// boot.  group is the name of the upstream group the fallbacks are specific
+// to, if any.  The statistics of the fallbacks are reported to m.  conf, l,
+// and m must not be nil.
 func newFallbacks(
 	conf *FallbackConfig,
 	l *slog.Logger,
 	boot upstream.Resolver,
+	m Metrics,
+	group agdc.UpstreamGroupName,
 ) (fallbacks *proxy.UpstreamConfig, err error) {
+	l = l.With(agdcslog.KeyUpstreamType, agdcslog.UpstreamTypeFallback)
+	if group != "" {
+		l = l.With(agdcslog.KeyUpstreamGroup, group)
+	}
+
 	opts := &upstream.Options{
-		Logger:    l.With(agdcslog.KeyUpstreamType, agdcslog.UpstreamTypeFallback),
+		Logger:    l,
 		Timeout:   conf.Timeout,
 		Bootstrap: boot,
 	}
@@ -38,5 +50,54 @@ func newFallbacks(
 		return nil, fmt.Errorf("creating fallbacks: %w", err)
 	}
 
+	for i, u := range fallbacks.Upstreams {
+		fallbacks.Upstreams[i] = newObservedUpstream(u, m, agdcslog.UpstreamTypeFallback, group)
+	}
+
 	return fallbacks, nil
 }
+
+// fallbackUpstream is an [upstream.Upstream] that exchanges requests with the
+// fallback upstreams of a group when the main upstream of the group fails.
+// Since the proxy only supports a single list of fallbacks for all the
+// requests, each group handles its fallbacks on its own, so that the requests
+// for a group never reach the fallbacks of another one.
+type fallbackUpstream struct {
+	// main is the upstream of the group.
+	main upstream.Upstream
+
+	// fallbacks are the upstreams to use when main fails.  If it's empty, the
+	// error of main is returned as is.
+	fallbacks []upstream.Upstream
+}
+
+// type check
+var _ upstream.Upstream = (*fallbackUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *fallbackUpstream.
+func (u *fallbackUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	resp, err = u.main.Exchange(req)
+	if err == nil || len(u.fallbacks) == 0 {
+		return resp, err
+	}
+
+	resp, _, fallErr := upstream.ExchangeParallel(u.fallbacks, req)
+	if fallErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("fallbacks: %w", fallErr))
+	}
+
+	return resp, nil
+}
+
+// Address implements the [upstream.Upstream] interface for *fallbackUpstream.
+// It returns the address of the main upstream.
+func (u *fallbackUpstream) Address() (addr string) {
+	return u.main.Address()
+}
+
+// Close implements the [upstream.Upstream] interface for *fallbackUpstream.  It
+// only closes the main upstream, since the fallbacks are closed along with the
+// configuration they belong to.
+func (u *fallbackUpstream) Close() (err error) {
+	return u.main.Close()
+}
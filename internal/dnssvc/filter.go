@@ -0,0 +1,204 @@
+package dnssvc
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/filter"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+)
+
+// BlockedResponseMode is the way of responding to the blocked requests.
+type BlockedResponseMode string
+
+// Valid blocked response modes.
+const (
+	// BlockedResponseModeNXDOMAIN makes the service respond to the blocked
+	// requests with NXDOMAIN.
+	BlockedResponseModeNXDOMAIN BlockedResponseMode = "nxdomain"
+
+	// BlockedResponseModeRefused makes the service respond to the blocked
+	// requests with REFUSED.
+	BlockedResponseModeRefused BlockedResponseMode = "refused"
+
+	// BlockedResponseModeNullIP makes the service respond to the blocked A and
+	// AAAA requests with the unspecified IP address, i.e. 0.0.0.0 or ::.
+	// Other blocked requests are responded with an empty NOERROR response.
+	BlockedResponseModeNullIP BlockedResponseMode = "null_ip"
+
+	// BlockedResponseModeCustomIP makes the service respond to the blocked A
+	// and AAAA requests with the configured IP addresses.  Other blocked
+	// requests, as well as the ones of the family without a configured
+	// address, are responded with an empty NOERROR response.
+	BlockedResponseModeCustomIP BlockedResponseMode = "custom_ip"
+)
+
+// FilteringConfig is the configuration for filtering the requests.
+type FilteringConfig struct {
+	// Filter is the configuration of the rule lists.  It must not be nil.
+	Filter *filter.Config
+
+	// BlockedResponse is the configuration of the responses to the blocked
+	// requests.  It must not be nil.
+	BlockedResponse *BlockedResponseConfig
+
+	// Clients are the rule lists for the client subnets, which take precedence
+	// over the lists of the upstream groups.
+	Clients []*FilteringClientConfig
+}
+
+// FilteringClientConfig is the configuration of the rule lists for a client
+// subnet.
+type FilteringClientConfig struct {
+	// Prefix is the client subnet.
+	Prefix netip.Prefix
+
+	// Lists are the names of the rule lists to filter the requests from the
+	// subnet with.  Empty lists disable filtering for the subnet.
+	Lists []filter.ListName
+}
+
+// BlockedResponseConfig is the configuration of the responses to the blocked
+// requests.
+type BlockedResponseConfig struct {
+	// IPv4 is the address to respond to the blocked A requests with in the
+	// [BlockedResponseModeCustomIP] mode, if valid.
+	IPv4 netip.Addr
+
+	// IPv6 is the address to respond to the blocked AAAA requests with in the
+	// [BlockedResponseModeCustomIP] mode, if valid.
+	IPv6 netip.Addr
+
+	// Mode is the way of responding to the blocked requests.
+	Mode BlockedResponseMode
+
+	// TTL is the TTL of the answers to the blocked requests.
+	TTL time.Duration
+}
+
+// requestFilter filters the requests according to the rule lists chosen for
+// the client or the upstream group.
+type requestFilter struct {
+	// filter matches the requests against the rule lists.
+	filter *filter.Filter
+
+	// blocked is the configuration of the responses to the blocked requests.
+	blocked *BlockedResponseConfig
+
+	// clients are the rule lists for the client subnets.
+	clients *prefixTrie[[]filter.ListName]
+
+	// groups are the rule lists for the upstream groups.
+	groups map[agdc.UpstreamGroupName][]filter.ListName
+}
+
+// newRequestFilter returns a new *requestFilter with the rule lists loaded
+// according to conf.  rf is nil if the filtering is disabled.  conf must not be
+// nil.
+func newRequestFilter(ctx context.Context, conf *Config) (rf *requestFilter, err error) {
+	fltConf := conf.Filtering
+	if fltConf == nil {
+		return nil, nil
+	}
+
+	filterConf := *fltConf.Filter
+	filterConf.CheckOnly = conf.CheckOnly
+
+	f, err := filter.New(ctx, &filterConf)
+	if err != nil {
+		return nil, fmt.Errorf("creating filter: %w", err)
+	}
+
+	rf = &requestFilter{
+		filter:  f,
+		blocked: fltConf.BlockedResponse,
+		clients: newPrefixTrie[[]filter.ListName](),
+		groups:  map[agdc.UpstreamGroupName][]filter.ListName{},
+	}
+
+	for _, c := range fltConf.Clients {
+		rf.clients.insert(c.Prefix, c.Lists)
+	}
+
+	for _, g := range conf.Upstreams.Groups {
+		if len(g.FilterLists) > 0 {
+			rf.groups[g.Name] = g.FilterLists
+		}
+	}
+
+	return rf, nil
+}
+
+// filterRequest matches the request of dctx routed to the upstream group
+// against the rule lists chosen for it and sets the response to it if it's
+// blocked.  res is nil if no rule matches the request.
+func (rf *requestFilter) filterRequest(
+	dctx *proxy.DNSContext,
+	group agdc.UpstreamGroupName,
+) (res *filter.Result) {
+	if rf == nil {
+		return nil
+	}
+
+	lists, ok := rf.clients.lookup(dctx.Addr.Addr())
+	if !ok {
+		lists = rf.groups[group]
+	}
+
+	if len(lists) == 0 {
+		return nil
+	}
+
+	res = rf.filter.Match(dctx.Req.Question[0].Name, lists)
+	if res != nil && res.Blocked {
+		dctx.Res = rf.blocked.newResponse(dctx.Req)
+	}
+
+	return res
+}
+
+// newResponse returns the response to the blocked req according to c.  req
+// must have a question.
+func (c *BlockedResponseConfig) newResponse(req *dns.Msg) (resp *dns.Msg) {
+	resp = (&dns.Msg{}).SetReply(req)
+	resp.RecursionAvailable = true
+
+	switch c.Mode {
+	case BlockedResponseModeNXDOMAIN:
+		resp.Rcode = dns.RcodeNameError
+	case BlockedResponseModeRefused:
+		resp.Rcode = dns.RcodeRefused
+	case BlockedResponseModeNullIP:
+		c.appendAnswer(resp, netip.IPv4Unspecified(), netip.IPv6Unspecified())
+	default:
+		c.appendAnswer(resp, c.IPv4, c.IPv6)
+	}
+
+	return resp
+}
+
+// appendAnswer appends the answer with ipv4 or ipv6 to resp according to the
+// question type, if the address is valid.
+func (c *BlockedResponseConfig) appendAnswer(resp *dns.Msg, ipv4, ipv6 netip.Addr) {
+	q := resp.Question[0]
+	hdr := dns.RR_Header{
+		Name:   q.Name,
+		Rrtype: q.Qtype,
+		Class:  dns.ClassINET,
+		// #nosec G115 -- The TTL is validated to fit.
+		Ttl: uint32(c.TTL.Seconds()),
+	}
+
+	switch {
+	case q.Qtype == dns.TypeA && ipv4.IsValid():
+		resp.Answer = append(resp.Answer, &dns.A{Hdr: hdr, A: ipv4.AsSlice()})
+	case q.Qtype == dns.TypeAAAA && ipv6.IsValid():
+		resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: hdr, AAAA: ipv6.AsSlice()})
+	default:
+		// Respond with NOERROR and no answers.
+	}
+}
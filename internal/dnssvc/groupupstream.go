@@ -0,0 +1,152 @@
+package dnssvc
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/dnsproxy/fastip"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/miekg/dns"
+)
+
+// UpstreamMode is the mode of using the upstreams within a single group.
+type UpstreamMode string
+
+// Valid upstream modes.
+const (
+	// UpstreamModeLoadBalance makes the group use its upstreams in turn,
+	// retrying the request with the next one on failure.
+	UpstreamModeLoadBalance UpstreamMode = "load_balance"
+
+	// UpstreamModeParallel makes the group send each request to all its
+	// upstreams simultaneously and use the first successful response.
+	UpstreamModeParallel UpstreamMode = "parallel"
+
+	// UpstreamModeFastestAddr makes the group send each A and AAAA request to
+	// all its upstreams and respond with the address which is the fastest to
+	// connect to.  Other requests are handled as in [UpstreamModeParallel].
+	UpstreamModeFastestAddr UpstreamMode = "fastest_addr"
+
+	// UpstreamModeSequential makes the group try its upstreams in the order
+	// they're specified until one of them responds successfully.
+	UpstreamModeSequential UpstreamMode = "sequential"
+)
+
+// groupUpstream is an [upstream.Upstream] that exchanges requests with the
+// upstreams of a single group according to the group's mode.
+type groupUpstream struct {
+	// fastest is used to find the fastest address in the
+	// [UpstreamModeFastestAddr] mode.  It's nil in other modes.
+	fastest *fastip.FastestAddr
+
+	// next is the index of the upstream to start with in the
+	// [UpstreamModeLoadBalance] mode.
+	next *atomic.Uint32
+
+	// mode is the mode of using ups.
+	mode UpstreamMode
+
+	// group is the name of the group.
+	group agdc.UpstreamGroupName
+
+	// ups are the upstreams of the group.  It contains at least two items.
+	ups []upstream.Upstream
+}
+
+// newGroupUpstream returns a new properly initialized *groupUpstream.  l must
+// not be nil, ups must contain at least two items.
+func newGroupUpstream(
+	l *slog.Logger,
+	mode UpstreamMode,
+	group agdc.UpstreamGroupName,
+	ups []upstream.Upstream,
+) (u *groupUpstream) {
+	u = &groupUpstream{
+		next:  &atomic.Uint32{},
+		mode:  mode,
+		group: group,
+		ups:   ups,
+	}
+
+	if mode == UpstreamModeFastestAddr {
+		u.fastest = fastip.New(&fastip.Config{
+			Logger: l.With(slogutil.KeyPrefix, fastip.LogPrefix),
+		})
+	}
+
+	return u
+}
+
+// type check
+var _ upstream.Upstream = (*groupUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *groupUpstream.
+func (u *groupUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	switch u.mode {
+	case UpstreamModeParallel:
+		resp, _, err = upstream.ExchangeParallel(u.ups, req)
+	case UpstreamModeFastestAddr:
+		switch req.Question[0].Qtype {
+		case dns.TypeA, dns.TypeAAAA:
+			resp, _, err = u.fastest.ExchangeFastest(req, u.ups)
+		default:
+			resp, _, err = upstream.ExchangeParallel(u.ups, req)
+		}
+	case UpstreamModeSequential:
+		resp, err = exchangeSequential(u.ups, 0, req)
+	default:
+		// #nosec G115 -- The number of upstreams is small.
+		n := uint32(len(u.ups))
+		start := (u.next.Add(1) - 1) % n
+		resp, err = exchangeSequential(u.ups, int(start), req)
+	}
+
+	return resp, err
+}
+
+// exchangeSequential exchanges req with each of ups in turn, starting from the
+// one at index start, until one of them succeeds.
+func exchangeSequential(
+	ups []upstream.Upstream,
+	start int,
+	req *dns.Msg,
+) (resp *dns.Msg, err error) {
+	var errs []error
+	for i := range ups {
+		u := ups[(start+i)%len(ups)]
+		resp, err = u.Exchange(req)
+		if err == nil {
+			return resp, nil
+		}
+
+		errs = append(errs, fmt.Errorf("upstream %q: %w", u.Address(), err))
+	}
+
+	return nil, fmt.Errorf("all upstreams failed: %w", errors.Join(errs...))
+}
+
+// Address implements the [upstream.Upstream] interface for *groupUpstream.  It
+// returns the addresses of all the upstreams of the group.
+func (u *groupUpstream) Address() (addr string) {
+	addrs := make([]string, 0, len(u.ups))
+	for _, ups := range u.ups {
+		addrs = append(addrs, ups.Address())
+	}
+
+	return strings.Join(addrs, ", ")
+}
+
+// Close implements the [upstream.Upstream] interface for *groupUpstream.
+func (u *groupUpstream) Close() (err error) {
+	var errs []error
+	for _, ups := range u.ups {
+		errs = append(errs, ups.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,167 @@
+package dnssvc
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testExchanger is an [upstream.Upstream] for tests that responds with the
+// answer containing its address or with an error.
+type testExchanger struct {
+	// err is returned from Exchange, if not nil.
+	err error
+
+	// addr is the address of the upstream.
+	addr string
+}
+
+// type check
+var _ upstream.Upstream = (*testExchanger)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *testExchanger.
+func (u *testExchanger) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	if u.err != nil {
+		return nil, u.err
+	}
+
+	resp = (&dns.Msg{}).SetReply(req)
+	resp.Answer = append(resp.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   req.Question[0].Name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+		},
+		Txt: []string{u.addr},
+	})
+
+	return resp, nil
+}
+
+// Address implements the [upstream.Upstream] interface for *testExchanger.
+func (u *testExchanger) Address() (addr string) { return u.addr }
+
+// Close implements the [upstream.Upstream] interface for *testExchanger.
+func (u *testExchanger) Close() (err error) { return nil }
+
+// answeredBy returns the address of the upstream that answered resp.
+func answeredBy(tb testing.TB, resp *dns.Msg) (addr string) {
+	tb.Helper()
+
+	require.Len(tb, resp.Answer, 1)
+
+	txt, ok := resp.Answer[0].(*dns.TXT)
+	require.True(tb, ok)
+	require.Len(tb, txt.Txt, 1)
+
+	return txt.Txt[0]
+}
+
+func TestGroupUpstream_Exchange(t *testing.T) {
+	t.Parallel()
+
+	const testErr errors.Error = "test error"
+
+	var (
+		first  = &testExchanger{addr: "first"}
+		second = &testExchanger{addr: "second"}
+		failed = &testExchanger{addr: "failed", err: testErr}
+	)
+
+	req := (&dns.Msg{}).SetQuestion("example.com.", dns.TypeTXT)
+	l := slogutil.NewDiscardLogger()
+
+	t.Run("sequential", func(t *testing.T) {
+		t.Parallel()
+
+		u := newGroupUpstream(l, UpstreamModeSequential, "group", []upstream.Upstream{
+			failed,
+			first,
+			second,
+		})
+
+		for range 2 {
+			resp, err := u.Exchange(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, first.addr, answeredBy(t, resp))
+		}
+	})
+
+	t.Run("load_balance", func(t *testing.T) {
+		t.Parallel()
+
+		u := newGroupUpstream(l, UpstreamModeLoadBalance, "group", []upstream.Upstream{
+			first,
+			second,
+			failed,
+		})
+
+		var got []string
+		for range 4 {
+			resp, err := u.Exchange(req)
+			require.NoError(t, err)
+
+			got = append(got, answeredBy(t, resp))
+		}
+
+		// The failed upstream is retried with the next one, which is the first.
+		assert.Equal(t, []string{first.addr, second.addr, first.addr, first.addr}, got)
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		t.Parallel()
+
+		u := newGroupUpstream(l, UpstreamModeParallel, "group", []upstream.Upstream{
+			failed,
+			first,
+		})
+
+		resp, err := u.Exchange(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, first.addr, answeredBy(t, resp))
+	})
+
+	t.Run("fastest_addr_not_address", func(t *testing.T) {
+		t.Parallel()
+
+		u := newGroupUpstream(l, UpstreamModeFastestAddr, "group", []upstream.Upstream{
+			failed,
+			first,
+		})
+
+		resp, err := u.Exchange(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, first.addr, answeredBy(t, resp))
+	})
+
+	t.Run("all_failed", func(t *testing.T) {
+		t.Parallel()
+
+		u := newGroupUpstream(l, UpstreamModeSequential, "group", []upstream.Upstream{
+			failed,
+			failed,
+		})
+
+		_, err := u.Exchange(req)
+		assert.ErrorIs(t, err, testErr)
+	})
+
+	t.Run("address", func(t *testing.T) {
+		t.Parallel()
+
+		u := newGroupUpstream(l, UpstreamModeParallel, "group", []upstream.Upstream{
+			first,
+			second,
+		})
+
+		assert.Equal(t, "first, second", u.Address())
+	})
+}
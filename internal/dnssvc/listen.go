@@ -0,0 +1,203 @@
+package dnssvc
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// Protocol is the type for the protocol of a listen address.
+type Protocol string
+
+// Supported protocols.
+const (
+	// ProtocolDNS is the plain DNS protocol served over both UDP and TCP.
+	ProtocolDNS Protocol = "dns"
+
+	// ProtocolHTTPS is the DNS-over-HTTPS protocol.
+	ProtocolHTTPS Protocol = "https"
+
+	// ProtocolQUIC is the DNS-over-QUIC protocol.
+	ProtocolQUIC Protocol = "quic"
+
+	// ProtocolTLS is the DNS-over-TLS protocol.
+	ProtocolTLS Protocol = "tls"
+)
+
+// IsEncrypted returns true if p requires a TLS certificate.
+func (p Protocol) IsEncrypted() (ok bool) {
+	switch p {
+	case ProtocolHTTPS, ProtocolQUIC, ProtocolTLS:
+		return true
+	default:
+		return false
+	}
+}
+
+// tlsNetwork returns the transport network of the listener for the encrypted
+// protocol p.
+func (p Protocol) tlsNetwork() (network string) {
+	if p == ProtocolQUIC {
+		return "udp"
+	}
+
+	return "tcp"
+}
+
+// ListenAddrConfig is the configuration for a single served address.
+type ListenAddrConfig struct {
+	// TLS is the certificate configuration for the encrypted protocols.  It
+	// must not be nil if Protocol is encrypted and is ignored otherwise.
+	TLS *TLSConfig
+
+	// Protocol is the protocol to serve on Address.  It must be one of the
+	// Protocol* constants.
+	Protocol Protocol
+
+	// Address is the address to listen on.  It must be valid.
+	Address netip.AddrPort
+
+	// ProxyProtocol defines if the TCP connections to Address must start with
+	// the PROXY protocol header of either version.  Only the connections from
+	// [Config.TrustedProxies] are accepted then.  It must only be set for
+	// [ProtocolDNS], and the UDP requests are served as usual.
+	ProxyProtocol bool
+}
+
+// TLSConfig is the configuration for the TLS certificate of an encrypted
+// listener.
+type TLSConfig struct {
+	// CertificatePath is the path to the PEM-encoded certificate chain.  It
+	// must not be empty.
+	CertificatePath string
+
+	// PrivateKeyPath is the path to the PEM-encoded private key.  It must not
+	// be empty.
+	PrivateKeyPath string
+}
+
+// listenAddrs is a set of addresses to listen on, split by protocol.
+type listenAddrs struct {
+	// proxied maps the indexes of the internal listeners within tcp to the
+	// addresses accepting the PROXY protocol, which are relayed to those.
+	proxied map[int]netip.AddrPort
+
+	udp   []*net.UDPAddr
+	tcp   []*net.TCPAddr
+	tls   []*net.TCPAddr
+	https []*net.TCPAddr
+	quic  []*net.UDPAddr
+}
+
+// newListenAddrs creates a new set of addresses to listen on from confs.  The
+// TCP addresses accepting the PROXY protocol are replaced with the internal
+// ones on the loopback interface.
+func newListenAddrs(confs []*ListenAddrConfig) (addrs *listenAddrs) {
+	addrs = &listenAddrs{
+		proxied: map[int]netip.AddrPort{},
+	}
+
+	for _, c := range confs {
+		addr := c.Address
+		switch c.Protocol {
+		case ProtocolHTTPS:
+			addrs.https = append(addrs.https, net.TCPAddrFromAddrPort(addr))
+		case ProtocolQUIC:
+			addrs.quic = append(addrs.quic, net.UDPAddrFromAddrPort(addr))
+		case ProtocolTLS:
+			addrs.tls = append(addrs.tls, net.TCPAddrFromAddrPort(addr))
+		default:
+			addrs.udp = append(addrs.udp, net.UDPAddrFromAddrPort(addr))
+			if c.ProxyProtocol {
+				addrs.proxied[len(addrs.tcp)] = addr
+				addr = netip.AddrPortFrom(netutil.IPv4Localhost(), 0)
+			}
+
+			addrs.tcp = append(addrs.tcp, net.TCPAddrFromAddrPort(addr))
+		}
+	}
+
+	return addrs
+}
+
+// newTLSConfig loads the certificates for the encrypted listeners in confs and
+// returns the TLS configuration choosing the certificate by the network and the
+// local port of the connection.  conf is nil if there are no encrypted
+// listeners.
+func newTLSConfig(confs []*ListenAddrConfig) (conf *tls.Config, err error) {
+	defer func() { err = errors.Annotate(err, "creating tls configuration: %w") }()
+
+	certs := portCertificates{}
+
+	var errs []error
+	for i, c := range confs {
+		if !c.Protocol.IsEncrypted() {
+			continue
+		}
+
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(c.TLS.CertificatePath, c.TLS.PrivateKeyPath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("listen address at index %d: %w", i, err))
+
+			continue
+		}
+
+		certs[certPort{network: c.Protocol.tlsNetwork(), port: c.Address.Port()}] = &cert
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		// Don't wrap the error since there is already an annotation deferred.
+		return nil, err
+	} else if len(certs) == 0 {
+		return nil, nil
+	}
+
+	return &tls.Config{
+		GetCertificate: certs.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}, nil
+}
+
+// certPort is the network and the local port of an encrypted listener.
+type certPort struct {
+	// network is the transport network of the listener, either "tcp" or
+	// "udp".
+	network string
+
+	// port is the local port of the listener.
+	port uint16
+}
+
+// portCertificates maps the networks and the local ports of the encrypted
+// listeners to their certificates.
+type portCertificates map[certPort]*tls.Certificate
+
+// getCertificate returns the certificate for the network and the local port of
+// the connection described by hello, so that, for example, DNS-over-TLS and
+// DNS-over-QUIC listeners may share a port.  If there is only a single
+// certificate, it's returned for any port, which is useful when the port is
+// chosen by the OS.  It's used as [tls.Config.GetCertificate].
+func (certs portCertificates) getCertificate(
+	hello *tls.ClientHelloInfo,
+) (cert *tls.Certificate, err error) {
+	local := hello.Conn.LocalAddr()
+	laddr := netutil.NetAddrToAddrPort(local)
+
+	cert, ok := certs[certPort{network: local.Network(), port: laddr.Port()}]
+	if ok {
+		return cert, nil
+	}
+
+	if len(certs) == 1 {
+		for _, cert = range certs {
+			return cert, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no certificate for local address %s", laddr)
+}
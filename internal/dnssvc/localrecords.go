@@ -0,0 +1,354 @@
+package dnssvc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/hostsfile"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/miekg/dns"
+)
+
+// LocalRecordsConfig is the configuration of the records the service answers
+// the requests with by itself, without using the upstreams.
+type LocalRecordsConfig struct {
+	// Records are the static records.  Those must not be nil.
+	Records []*LocalRecord
+
+	// HostsFiles are the absolute paths to the files in the hosts format.  The
+	// files are re-read by [DNSService.Refresh] when they change.
+	HostsFiles []string
+
+	// TTL is the TTL of the answers.
+	TTL time.Duration
+}
+
+// LocalRecord is a static record of a hostname.
+type LocalRecord struct {
+	// Name is the hostname of the record.  It must be a valid domain name.
+	Name string
+
+	// Addrs are the IP addresses of the hostname.  The IPv4 ones are used to
+	// answer the A requests, and the IPv6 ones are used to answer the AAAA
+	// requests.  Each of the addresses is also resolved to Name by the PTR
+	// requests, if it's private.
+	Addrs []netip.Addr
+}
+
+// localRecords answers the A, AAAA, and PTR requests using the static records
+// and the hosts files.
+type localRecords struct {
+	// data is the current set of the records.  It's never nil.
+	data atomic.Pointer[localRecordsData]
+
+	// logger is used to log the reloading of the hosts files and their invalid
+	// lines.
+	logger *slog.Logger
+
+	// mu serializes the refreshes and protects the modification times of
+	// files.
+	mu *sync.Mutex
+
+	// records are the static records.
+	records []*LocalRecord
+
+	// files are the hosts files.
+	files []*hostsFile
+
+	// ttl is the TTL of the answers.
+	ttl time.Duration
+}
+
+// hostsFile is a hosts file being watched for changes.
+type hostsFile struct {
+	// modTime is the modification time of the file at the time it was last
+	// read.
+	modTime time.Time
+
+	// path is the path to the file.
+	path string
+}
+
+// newLocalRecords returns a new *localRecords with the records and the hosts
+// files from conf loaded.  lr is nil if conf is nil.
+func newLocalRecords(
+	ctx context.Context,
+	logger *slog.Logger,
+	conf *LocalRecordsConfig,
+) (lr *localRecords, err error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	lr = &localRecords{
+		logger:  logger.With(slogutil.KeyPrefix, "local_records"),
+		mu:      &sync.Mutex{},
+		records: conf.Records,
+		ttl:     conf.TTL,
+	}
+
+	for _, path := range conf.HostsFiles {
+		lr.files = append(lr.files, &hostsFile{
+			path: path,
+		})
+	}
+
+	_, err = lr.updateModTimes()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	err = lr.load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("local records: %w", err)
+	}
+
+	return lr, nil
+}
+
+// refresh re-reads the hosts files of lr if any of them has been modified since
+// they were last read.  If a file is invalid, the previous records stay in
+// place until the file is modified again.  lr may be nil.
+func (lr *localRecords) refresh(ctx context.Context) (err error) {
+	if lr == nil {
+		return nil
+	}
+
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	// Remember the modification times before reading the files to not miss
+	// the changes made during the reading, and to not read an invalid file
+	// again until it's changed.
+	changed, err := lr.updateModTimes()
+	if err != nil || !changed {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = lr.load(ctx)
+	if err != nil {
+		return fmt.Errorf("keeping previous local records: %w", err)
+	}
+
+	lr.logger.InfoContext(ctx, "hosts files reloaded")
+
+	return nil
+}
+
+// updateModTimes sets the current modification times to the files of lr and
+// reports if any of them has changed.
+func (lr *localRecords) updateModTimes() (changed bool, err error) {
+	for _, f := range lr.files {
+		fi, statErr := os.Stat(f.path)
+		if statErr != nil {
+			return false, fmt.Errorf("checking hosts file: %w", statErr)
+		}
+
+		if modTime := fi.ModTime(); !modTime.Equal(f.modTime) {
+			f.modTime, changed = modTime, true
+		}
+	}
+
+	return changed, nil
+}
+
+// load builds the records from the static ones and the hosts files of lr and
+// replaces the current ones.
+func (lr *localRecords) load(ctx context.Context) (err error) {
+	data := &localRecordsData{
+		addrs: map[string][]netip.Addr{},
+		names: map[netip.Addr][]string{},
+	}
+
+	for _, r := range lr.records {
+		for _, addr := range r.Addrs {
+			data.Add(&hostsfile.Record{
+				Addr:  addr,
+				Names: []string{r.Name},
+			})
+		}
+	}
+
+	for _, f := range lr.files {
+		err = lr.read(ctx, data, f.path)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+	}
+
+	lr.data.Store(data)
+
+	return nil
+}
+
+// answer sets the response to the request of dctx if it's an A or AAAA request
+// for a hostname of lr, or a private PTR request for an address of lr.  ok is
+// true if the response is set.  lr may be nil.
+func (lr *localRecords) answer(dctx *proxy.DNSContext) (ok bool) {
+	if lr == nil {
+		return false
+	}
+
+	data := lr.data.Load()
+	q := dctx.Req.Question[0]
+
+	var rrs []dns.RR
+	switch q.Qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		addrs, found := data.addrs[strings.ToLower(q.Name)]
+		if !found {
+			return false
+		}
+
+		rrs = lr.addrAnswers(q, addrs)
+	case dns.TypePTR:
+		// The PTR requests for the addresses out of the private subnets are
+		// always forwarded to the upstreams.
+		pref := dctx.RequestedPrivateRDNS
+		names := data.names[pref.Addr()]
+		if !pref.IsSingleIP() || len(names) == 0 {
+			return false
+		}
+
+		rrs = lr.ptrAnswers(q, names)
+	default:
+		return false
+	}
+
+	resp := (&dns.Msg{}).SetReply(dctx.Req)
+	resp.RecursionAvailable = true
+	resp.Answer = rrs
+	dctx.Res = resp
+
+	return true
+}
+
+// header returns the header of the answer to q.
+func (lr *localRecords) header(q dns.Question) (hdr dns.RR_Header) {
+	return dns.RR_Header{
+		Name:   q.Name,
+		Rrtype: q.Qtype,
+		Class:  dns.ClassINET,
+		// #nosec G115 -- The TTL is validated to fit.
+		Ttl: uint32(lr.ttl.Seconds()),
+	}
+}
+
+// addrAnswers returns the A or AAAA answers to q with the addresses of the
+// corresponding family from addrs.  rrs is empty if there are no such
+// addresses, so that the hostname is still reported as existing.
+func (lr *localRecords) addrAnswers(q dns.Question, addrs []netip.Addr) (rrs []dns.RR) {
+	hdr := lr.header(q)
+	for _, addr := range addrs {
+		if q.Qtype == dns.TypeA && addr.Is4() {
+			rrs = append(rrs, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+		} else if q.Qtype == dns.TypeAAAA && addr.Is6() {
+			rrs = append(rrs, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+		}
+	}
+
+	return rrs
+}
+
+// ptrAnswers returns the PTR answers to q with names.
+func (lr *localRecords) ptrAnswers(q dns.Question, names []string) (rrs []dns.RR) {
+	hdr := lr.header(q)
+	for _, name := range names {
+		rrs = append(rrs, &dns.PTR{Hdr: hdr, Ptr: name})
+	}
+
+	return rrs
+}
+
+// localRecordsData is the set of local records indexed by both the hostnames
+// and the addresses.
+type localRecordsData struct {
+	// addrs maps the lowercased FQDNs to their addresses.
+	addrs map[string][]netip.Addr
+
+	// names maps the addresses to their lowercased FQDNs.
+	names map[netip.Addr][]string
+}
+
+// type check
+var _ hostsfile.Set = (*localRecordsData)(nil)
+
+// Add implements the [hostsfile.Set] interface for *localRecordsData.  It
+// ignores the duplicates.
+func (data *localRecordsData) Add(rec *hostsfile.Record) {
+	addr := rec.Addr.Unmap()
+	for _, name := range rec.Names {
+		name = normalizeDomain(name)
+
+		if !slices.Contains(data.addrs[name], addr) {
+			data.addrs[name] = append(data.addrs[name], addr)
+		}
+
+		if !slices.Contains(data.names[addr], name) {
+			data.names[addr] = append(data.names[addr], name)
+		}
+	}
+}
+
+// read reads the hosts file at path into data.
+func (lr *localRecords) read(ctx context.Context, data *localRecordsData, path string) (err error) {
+	// #nosec G304 -- Trust the path to the hosts file that is set in the
+	// configuration file.
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening hosts file: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	set := &hostsFileSet{
+		localRecordsData: data,
+		logger:           lr.logger,
+		ctx:              ctx,
+	}
+
+	err = hostsfile.Parse(set, f, nil)
+	if err != nil {
+		return fmt.Errorf("reading hosts file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// hostsFileSet is the [hostsfile.HandleSet] used to read a single hosts file.
+type hostsFileSet struct {
+	*localRecordsData
+
+	// logger is used to log the invalid lines.
+	logger *slog.Logger
+
+	// ctx is the context of the reading.
+	ctx context.Context
+}
+
+// type check
+var _ hostsfile.HandleSet = (*hostsFileSet)(nil)
+
+// HandleInvalid implements the [hostsfile.HandleSet] interface for
+// *hostsFileSet.  It ignores the empty lines and the comments, and logs the
+// other invalid lines, since the system hosts files may contain entries not
+// supported here.
+func (s *hostsFileSet) HandleInvalid(srcName string, _ []byte, err error) {
+	if errors.Is(err, hostsfile.ErrEmptyLine) {
+		return
+	}
+
+	s.logger.WarnContext(s.ctx, "skipping invalid hosts file line", "path", srcName, slogutil.KeyError, err)
+}
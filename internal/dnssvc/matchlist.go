@@ -0,0 +1,303 @@
+package dnssvc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// matchListKind is the kind of entries of a match list file.
+type matchListKind uint8
+
+// Valid match list kinds.
+const (
+	// matchListDomains is the kind of the files containing domain names.
+	matchListDomains matchListKind = iota
+
+	// matchListClients is the kind of the files containing IP addresses and
+	// subnets in CIDR notation.
+	matchListClients
+)
+
+// matchListComment is the prefix of the comments within the match list files.
+const matchListComment = "#"
+
+// ValidateDomainListFile returns an error if the file at path can't be read or
+// contains an invalid domain name.  The errors of the entries contain the path
+// and the line number.
+func ValidateDomainListFile(path string) (err error) {
+	_, err = readMatchList(path, matchListDomains)
+
+	// Don't wrap the error, because it's informative enough as is.
+	return err
+}
+
+// ValidateClientListFile returns an error if the file at path can't be read or
+// contains an invalid IP address or subnet.  The errors of the entries contain
+// the path and the line number.
+func ValidateClientListFile(path string) (err error) {
+	_, err = readMatchList(path, matchListClients)
+
+	// Don't wrap the error, because it's informative enough as is.
+	return err
+}
+
+// matchListKey is the key for the cache of match lists within a single
+// upstream state.
+type matchListKey struct {
+	path string
+	kind matchListKind
+}
+
+// matchLists is the cache of match lists, so that the file referenced by
+// several matches is only read once.
+type matchLists map[matchListKey]*matchList
+
+// get returns the match list for the file at path of kind, reading it if it's
+// not in ls yet.
+func (ls matchLists) get(path string, kind matchListKind) (l *matchList, err error) {
+	key := matchListKey{
+		path: path,
+		kind: kind,
+	}
+
+	l, ok := ls[key]
+	if ok {
+		return l, nil
+	}
+
+	l, err = newMatchList(path, kind)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	ls[key] = l
+
+	return l, nil
+}
+
+// matchList is a set of domain names or client subnets read from a file.  The
+// file is re-read by [matchList.refresh] when it changes.
+type matchList struct {
+	// data is the current content of the file.  It's never nil.
+	data atomic.Pointer[matchListData]
+
+	// mu serializes the refreshes and protects modTime.
+	mu *sync.Mutex
+
+	// modTime is the modification time of the file at the time it was last
+	// read.
+	modTime time.Time
+
+	// path is the path to the file.
+	path string
+
+	// kind is the kind of entries of the file.
+	kind matchListKind
+}
+
+// matchListData is the parsed content of a match list file.
+type matchListData struct {
+	// domains contains the domain names of a [matchListDomains] file.
+	domains *domainTrie
+
+	// clients contains the lengths of the subnets of a [matchListClients]
+	// file.
+	clients *prefixTrie[int]
+}
+
+// newMatchList reads the file at path of kind and returns a new properly
+// initialized *matchList.
+func newMatchList(path string, kind matchListKind) (l *matchList, err error) {
+	l = &matchList{
+		mu:   &sync.Mutex{},
+		path: path,
+		kind: kind,
+	}
+
+	l.modTime, err = fileModTime(path)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	data, err := readMatchList(path, kind)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	l.data.Store(data)
+
+	return l, nil
+}
+
+// refresh re-reads the file of l if it has been modified since it was last
+// read.  If the file is invalid, the previous content stays in place until the
+// file is modified again.  l must not be nil.
+func (l *matchList) refresh(ctx context.Context, logger *slog.Logger) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	modTime, err := fileModTime(l.path)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if modTime.Equal(l.modTime) {
+		return nil
+	}
+
+	// Remember the modification time before reading the file to not miss the
+	// changes made during the reading, and to not read an invalid file again
+	// until it's changed.
+	l.modTime = modTime
+
+	data, err := readMatchList(l.path, l.kind)
+	if err != nil {
+		return fmt.Errorf("keeping previous match list: %w", err)
+	}
+
+	l.data.Store(data)
+
+	logger.InfoContext(ctx, "match list reloaded", "path", l.path)
+
+	return nil
+}
+
+// matchDomain returns the length of the longest domain of l which is host
+// itself or its parent.  host must be a lowercased FQDN.
+func (l *matchList) matchDomain(host string) (domainLen int, ok bool) {
+	return l.data.Load().domains.lookup(host)
+}
+
+// matchClient returns the length of the longest subnet of l containing addr.
+func (l *matchList) matchClient(addr netip.Addr) (bits int, ok bool) {
+	return l.data.Load().clients.lookup(addr)
+}
+
+// fileModTime returns the modification time of the file at path.
+func fileModTime(path string) (modTime time.Time, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("checking match list file: %w", err)
+	}
+
+	return fi.ModTime(), nil
+}
+
+// readMatchList reads and parses the match list file at path of kind.
+func readMatchList(path string, kind matchListKind) (data *matchListData, err error) {
+	// #nosec G304 -- Trust the path to the match list file that is set in the
+	// configuration file.
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening match list file: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	return parseMatchList(f, path, kind)
+}
+
+// parseMatchList parses the match list of kind from r.  Each line of the list
+// contains a single entry, the empty lines and the ones starting with
+// [matchListComment] are ignored, as well as the comments following the
+// entries.  All the invalid entries are reported with path and the line
+// number.
+func parseMatchList(
+	r io.Reader,
+	path string,
+	kind matchListKind,
+) (data *matchListData, err error) {
+	data = &matchListData{
+		domains: newDomainTrie(),
+		clients: newPrefixTrie[int](),
+	}
+
+	var errs []error
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		entry, _, _ := strings.Cut(s.Text(), matchListComment)
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		err = data.add(entry, kind)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", path, lineNum, err))
+		}
+	}
+
+	err = s.Err()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("reading %s: %w", path, err))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return data, nil
+}
+
+// add parses entry of kind and adds it to data.
+func (data *matchListData) add(entry string, kind matchListKind) (err error) {
+	if kind == matchListDomains {
+		err = netutil.ValidateDomainName(entry)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+
+		data.domains.insert(normalizeDomain(entry))
+
+		return nil
+	}
+
+	p, err := parseClientEntry(entry)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	data.clients.insert(p, p.Bits())
+
+	return nil
+}
+
+// parseClientEntry parses entry as an IP address or a subnet in CIDR notation.
+// The subnet must be masked.
+func parseClientEntry(entry string) (p netip.Prefix, err error) {
+	if !strings.Contains(entry, "/") {
+		var addr netip.Addr
+		addr, err = netip.ParseAddr(entry)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return netip.Prefix{}, err
+		}
+
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	p, err = netip.ParsePrefix(entry)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return netip.Prefix{}, err
+	} else if p != p.Masked() {
+		return netip.Prefix{}, fmt.Errorf("%s must have at most %d significant bits", p, p.Bits())
+	}
+
+	return p, nil
+}
@@ -0,0 +1,131 @@
+package dnssvc
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMatchList(t *testing.T) {
+	t.Parallel()
+
+	const path = "list.txt"
+
+	t.Run("domains", func(t *testing.T) {
+		t.Parallel()
+
+		const content = "# Internal domains.\n" +
+			"\n" +
+			"Corp.Example\n" +
+			"  lab.example  # The test lab.\n"
+
+		data, err := parseMatchList(strings.NewReader(content), path, matchListDomains)
+		require.NoError(t, err)
+
+		n, ok := data.domains.lookup("www.corp.example.")
+		assert.True(t, ok)
+		assert.Equal(t, len("corp.example."), n)
+
+		_, ok = data.domains.lookup("lab.example.")
+		assert.True(t, ok)
+	})
+
+	t.Run("clients", func(t *testing.T) {
+		t.Parallel()
+
+		const content = "192.0.2.0/24\n" +
+			"198.51.100.1 # The gateway.\n" +
+			"2001:db8::/32\n"
+
+		data, err := parseMatchList(strings.NewReader(content), path, matchListClients)
+		require.NoError(t, err)
+
+		bits, ok := data.clients.lookup(netip.MustParseAddr("192.0.2.1"))
+		assert.True(t, ok)
+		assert.Equal(t, 24, bits)
+
+		bits, ok = data.clients.lookup(netip.MustParseAddr("198.51.100.1"))
+		assert.True(t, ok)
+		assert.Equal(t, 32, bits)
+
+		_, ok = data.clients.lookup(netip.MustParseAddr("198.51.100.2"))
+		assert.False(t, ok)
+	})
+
+	t.Run("bad_domains", func(t *testing.T) {
+		t.Parallel()
+
+		const content = "corp.example\n" +
+			"bad_domain!\n" +
+			"\n" +
+			"bad..example\n"
+
+		_, err := parseMatchList(strings.NewReader(content), path, matchListDomains)
+		require.Error(t, err)
+
+		assert.Contains(t, err.Error(), "list.txt:2: ")
+		assert.Contains(t, err.Error(), "list.txt:4: ")
+		assert.NotContains(t, err.Error(), "list.txt:1: ")
+	})
+
+	t.Run("bad_clients", func(t *testing.T) {
+		t.Parallel()
+
+		const content = "192.0.2.1/24\n" +
+			"not an address\n"
+
+		_, err := parseMatchList(strings.NewReader(content), path, matchListClients)
+		testutil.AssertErrorMsg(
+			t,
+			"list.txt:1: 192.0.2.1/24 must have at most 24 significant bits\n"+
+				`list.txt:2: ParseAddr("not an address"): unable to parse IP`,
+			err,
+		)
+	})
+}
+
+func TestMatchList_refresh(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "domains.txt")
+	require.NoError(t, os.WriteFile(path, []byte("old.example\n"), 0o600))
+
+	l, err := newMatchList(path, matchListDomains)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	logger := slogutil.NewDiscardLogger()
+
+	// Set the modification time explicitly, since its resolution may be too
+	// coarse to notice the change.
+	modTime := time.Now().Add(time.Minute)
+
+	require.NoError(t, os.WriteFile(path, []byte("new.example\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	require.NoError(t, l.refresh(ctx, logger))
+
+	_, ok := l.matchDomain("old.example.")
+	assert.False(t, ok)
+
+	_, ok = l.matchDomain("new.example.")
+	assert.True(t, ok)
+
+	modTime = modTime.Add(time.Minute)
+
+	require.NoError(t, os.WriteFile(path, []byte("bad_domain!\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	require.Error(t, l.refresh(ctx, logger))
+
+	// The previous content stays in place.
+	_, ok = l.matchDomain("new.example.")
+	assert.True(t, ok)
+}
@@ -0,0 +1,170 @@
+package dnssvc
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
+// Metrics is an interface for collecting the statistics of [DNSService].
+type Metrics interface {
+	// ObserveQuery updates the statistics of processed requests.  client is
+	// the prefix of the client-specific configuration used for the request, it
+	// is zero if the general one is used.  group is the name of the upstream
+	// group chosen for the request, if any.  rcode is the textual
+	// representation of the response code, if there is a response.
+	ObserveQuery(
+		ctx context.Context,
+		client netip.Prefix,
+		group agdc.UpstreamGroupName,
+		rcode string,
+	)
+
+	// ObserveUpstream updates the statistics of exchanges with upstreams of
+	// upstreamType, which is one of the agdcslog.UpstreamType* constants.  dur
+	// is the duration of the exchange and err is its error, if any.
+	ObserveUpstream(ctx context.Context, upstreamType string, dur time.Duration, err error)
+
+	// ObserveCacheLookup updates the statistics of cache lookups.  isClient is
+	// true if the per-client cache is used and false if the common one is.
+	// hit is true if the response has been found in the cache.
+	ObserveCacheLookup(ctx context.Context, isClient, hit bool)
+
+	// ObserveRejected updates the statistics of rejected requests.  reason is
+	// the reason of rejecting the request.
+	ObserveRejected(ctx context.Context, reason RejectReason)
+}
+
+// EmptyMetrics is the implementation of the [Metrics] interface that does
+// nothing.
+type EmptyMetrics struct{}
+
+// type check
+var _ Metrics = EmptyMetrics{}
+
+// ObserveQuery implements the [Metrics] interface for EmptyMetrics.
+func (EmptyMetrics) ObserveQuery(
+	_ context.Context,
+	_ netip.Prefix,
+	_ agdc.UpstreamGroupName,
+	_ string,
+) {
+}
+
+// ObserveUpstream implements the [Metrics] interface for EmptyMetrics.
+func (EmptyMetrics) ObserveUpstream(_ context.Context, _ string, _ time.Duration, _ error) {}
+
+// ObserveCacheLookup implements the [Metrics] interface for EmptyMetrics.
+func (EmptyMetrics) ObserveCacheLookup(_ context.Context, _, _ bool) {}
+
+// ObserveRejected implements the [Metrics] interface for EmptyMetrics.
+func (EmptyMetrics) ObserveRejected(_ context.Context, _ RejectReason) {}
+
+// observedUpstream is an [upstream.Upstream] that reports the statistics of
+// its exchanges and tracks its health.  It also keeps the name of the group it
+// belongs to.
+type observedUpstream struct {
+	upstream.Upstream
+
+	// metrics is used to report the statistics of exchanges.
+	metrics Metrics
+
+	// health tracks the results of exchanges.
+	health *upstreamHealth
+
+	// upstreamType is one of the agdcslog.UpstreamType* constants.
+	upstreamType string
+
+	// group is the name of the upstream group u belongs to, if any.
+	group agdc.UpstreamGroupName
+}
+
+// newObservedUpstream wraps u to report its statistics to m.
+func newObservedUpstream(
+	u upstream.Upstream,
+	m Metrics,
+	upstreamType string,
+	group agdc.UpstreamGroupName,
+) (o *observedUpstream) {
+	return &observedUpstream{
+		Upstream:     u,
+		metrics:      m,
+		health:       &upstreamHealth{mu: &sync.Mutex{}},
+		upstreamType: upstreamType,
+		group:        group,
+	}
+}
+
+// type check
+var _ upstream.Upstream = (*observedUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *observedUpstream.
+func (u *observedUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	start := time.Now()
+	resp, err = u.Upstream.Exchange(req)
+	end := time.Now()
+
+	u.health.update(end, err)
+
+	// TODO(e.burkov):  Use the request's context when the upstreams start
+	// supporting it.
+	u.metrics.ObserveUpstream(context.TODO(), u.upstreamType, end.Sub(start), err)
+
+	return resp, err
+}
+
+// selectUpstreams returns the upstreams chosen for req from conf the same way
+// the proxy chooses them, as well as the domain these are reserved for, if
+// any.  req must have a question.
+func selectUpstreams(
+	conf *proxy.UpstreamConfig,
+	req *dns.Msg,
+) (ups []upstream.Upstream, domain string) {
+	ups = conf.Upstreams
+	for host := questionHost(req); host != ""; _, host, _ = strings.Cut(host, ".") {
+		reserved, ok := conf.DomainReservedUpstreams[host]
+		if ok {
+			if len(reserved) > 0 {
+				ups, domain = reserved, host
+			}
+
+			break
+		}
+	}
+
+	return ups, domain
+}
+
+// questionHost returns the lowercased FQDN the upstreams are chosen by for
+// req.  req must have a question.
+func questionHost(req *dns.Msg) (host string) {
+	q := req.Question[0]
+	host = strings.ToLower(q.Name)
+	if q.Qtype == dns.TypeDS {
+		// DS records are served by the parent zone.
+		_, host, _ = strings.Cut(host, ".")
+	}
+
+	return host
+}
+
+// upstreamGroup returns the name of the upstream group u belongs to, if any.
+func upstreamGroup(u upstream.Upstream) (name agdc.UpstreamGroupName) {
+	switch u := u.(type) {
+	case *observedUpstream:
+		return u.group
+	case *groupUpstream:
+		return u.group
+	case *fallbackUpstream:
+		return upstreamGroup(u.main)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,94 @@
+package dnssvc
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdcslog"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectUpstreams(t *testing.T) {
+	t.Parallel()
+
+	newUps := func(group agdc.UpstreamGroupName) (u upstream.Upstream) {
+		return newObservedUpstream(
+			&testUpstream{addr: string(group)},
+			EmptyMetrics{},
+			agdcslog.UpstreamTypeMain,
+			group,
+		)
+	}
+
+	conf := &proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{newUps(agdc.UpstreamGroupNameDefault)},
+		DomainReservedUpstreams: map[string][]upstream.Upstream{
+			"example.org.":          {newUps("domain")},
+			"excluded.example.org.": nil,
+		},
+	}
+
+	testCases := []struct {
+		want       agdc.UpstreamGroupName
+		name       string
+		host       string
+		wantDomain string
+		qtype      uint16
+	}{{
+		want:       agdc.UpstreamGroupNameDefault,
+		name:       "default",
+		host:       "example.com.",
+		wantDomain: "",
+		qtype:      dns.TypeA,
+	}, {
+		want:       "domain",
+		name:       "domain",
+		host:       "example.org.",
+		wantDomain: "example.org.",
+		qtype:      dns.TypeA,
+	}, {
+		want:       "domain",
+		name:       "subdomain_upper",
+		host:       "WWW.Example.ORG.",
+		wantDomain: "example.org.",
+		qtype:      dns.TypeAAAA,
+	}, {
+		want:       agdc.UpstreamGroupNameDefault,
+		name:       "excluded",
+		host:       "excluded.example.org.",
+		wantDomain: "",
+		qtype:      dns.TypeA,
+	}, {
+		want:       agdc.UpstreamGroupNameDefault,
+		name:       "ds_parent",
+		host:       "example.org.",
+		wantDomain: "",
+		qtype:      dns.TypeDS,
+	}, {
+		want:       "domain",
+		name:       "ds_child",
+		host:       "sub.example.org.",
+		wantDomain: "example.org.",
+		qtype:      dns.TypeDS,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := (&dns.Msg{}).SetQuestion(tc.host, tc.qtype)
+			ups, domain := selectUpstreams(conf, req)
+			require.Len(t, ups, 1)
+
+			assert.Equal(t, tc.want, upstreamGroup(ups[0]))
+			assert.Equal(t, tc.wantDomain, domain)
+		})
+	}
+
+	ups, _ := selectUpstreams(&proxy.UpstreamConfig{}, (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA))
+	assert.Empty(t, ups)
+}
@@ -0,0 +1,100 @@
+package dnssvc
+
+import "net/netip"
+
+// prefixTrie is a binary trie of IP prefixes, which looks values up by the
+// longest prefix containing the address.  IPv4 and IPv6 prefixes are stored
+// separately, so an IPv4 address never matches an IPv6 prefix and vice versa,
+// just like [netip.Prefix.Contains] does.  It must be created with
+// [newPrefixTrie].  It's safe for concurrent lookups, but not for concurrent
+// modifications.
+type prefixTrie[T any] struct {
+	ipv4 *prefixNode[T]
+	ipv6 *prefixNode[T]
+}
+
+// prefixNode is a single node of a [prefixTrie].  The path from the root of
+// the trie to the node represents the bits of the prefix.
+type prefixNode[T any] struct {
+	// children are the nodes for the next bit being 0 and 1 correspondingly.
+	children [2]*prefixNode[T]
+
+	// value is the value stored for the prefix of the node.  It's only
+	// meaningful if hasValue is true.
+	value T
+
+	// hasValue is true if the node represents an inserted prefix.
+	hasValue bool
+}
+
+// newPrefixTrie returns a new empty *prefixTrie.
+func newPrefixTrie[T any]() (t *prefixTrie[T]) {
+	return &prefixTrie[T]{
+		ipv4: &prefixNode[T]{},
+		ipv6: &prefixNode[T]{},
+	}
+}
+
+// ipv4BitsOffset is the offset of the IPv4 address bits within the 16-byte
+// representation of it.
+const ipv4BitsOffset = 96
+
+// rootFor returns the root node for the family of addr and the offset of the
+// meaningful bits within the 16-byte representation of addr.
+func (t *prefixTrie[T]) rootFor(addr netip.Addr) (root *prefixNode[T], offset int) {
+	if addr.Is4() {
+		return t.ipv4, ipv4BitsOffset
+	}
+
+	return t.ipv6, 0
+}
+
+// insert sets v as the value for p, replacing the previous one, if any.  The
+// bits of p beyond its length are ignored.  p must be valid.
+func (t *prefixTrie[T]) insert(p netip.Prefix, v T) {
+	addr := p.Addr()
+	n, offset := t.rootFor(addr)
+	ip := addr.As16()
+	for i := range p.Bits() {
+		b := bitAt(ip, offset+i)
+		next := n.children[b]
+		if next == nil {
+			next = &prefixNode[T]{}
+			n.children[b] = next
+		}
+
+		n = next
+	}
+
+	n.value, n.hasValue = v, true
+}
+
+// lookup returns the value for the longest prefix containing addr.  ok is
+// false if there is no such prefix or addr is not valid.
+func (t *prefixTrie[T]) lookup(addr netip.Addr) (v T, ok bool) {
+	if !addr.IsValid() {
+		return v, false
+	}
+
+	n, offset := t.rootFor(addr)
+	ip := addr.As16()
+	for i := offset; n != nil; i++ {
+		if n.hasValue {
+			v, ok = n.value, true
+		}
+
+		if i == len(ip)*8 {
+			break
+		}
+
+		n = n.children[bitAt(ip, i)]
+	}
+
+	return v, ok
+}
+
+// bitAt returns the bit of ip at the index i counting from the most
+// significant one.
+func bitAt(ip [16]byte, i int) (b byte) {
+	return ip[i/8] >> (7 - i%8) & 1
+}
@@ -0,0 +1,90 @@
+package dnssvc
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixTrie_lookup(t *testing.T) {
+	t.Parallel()
+
+	trie := newPrefixTrie[string]()
+	for _, p := range []string{
+		"0.0.0.0/0",
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.2.3/32",
+		"192.168.1.0/24",
+		"2001:db8::/32",
+		"2001:db8:1::/48",
+		"2001:db8:1::1/128",
+	} {
+		trie.insert(netip.MustParsePrefix(p), p)
+	}
+
+	testCases := []struct {
+		wantOK assert.BoolAssertionFunc
+		addr   netip.Addr
+		want   string
+	}{{
+		addr:   netip.MustParseAddr("1.1.1.1"),
+		want:   "0.0.0.0/0",
+		wantOK: assert.True,
+	}, {
+		addr:   netip.MustParseAddr("10.2.0.1"),
+		want:   "10.0.0.0/8",
+		wantOK: assert.True,
+	}, {
+		addr:   netip.MustParseAddr("10.1.2.4"),
+		want:   "10.1.0.0/16",
+		wantOK: assert.True,
+	}, {
+		addr:   netip.MustParseAddr("10.1.2.3"),
+		want:   "10.1.2.3/32",
+		wantOK: assert.True,
+	}, {
+		addr:   netip.MustParseAddr("192.168.1.255"),
+		want:   "192.168.1.0/24",
+		wantOK: assert.True,
+	}, {
+		addr:   netip.MustParseAddr("192.168.2.1"),
+		want:   "0.0.0.0/0",
+		wantOK: assert.True,
+	}, {
+		addr:   netip.MustParseAddr("2001:db8:2::1"),
+		want:   "2001:db8::/32",
+		wantOK: assert.True,
+	}, {
+		addr:   netip.MustParseAddr("2001:db8:1::2"),
+		want:   "2001:db8:1::/48",
+		wantOK: assert.True,
+	}, {
+		addr:   netip.MustParseAddr("2001:db8:1::1"),
+		want:   "2001:db8:1::1/128",
+		wantOK: assert.True,
+	}, {
+		addr:   netip.MustParseAddr("2001:db9::1"),
+		want:   "",
+		wantOK: assert.False,
+	}, {
+		addr:   netip.MustParseAddr("::ffff:10.1.2.3"),
+		want:   "",
+		wantOK: assert.False,
+	}, {
+		addr:   netip.Addr{},
+		want:   "",
+		wantOK: assert.False,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.addr.String(), func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := trie.lookup(tc.addr)
+			tc.wantOK(t, ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
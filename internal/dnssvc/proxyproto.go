@@ -0,0 +1,303 @@
+package dnssvc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/proxyproto"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// proxyProtoTimeout is the timeout for reading the PROXY protocol header and
+// for connecting to the internal listener.
+const proxyProtoTimeout = 5 * time.Second
+
+// proxyProtoListener is a TCP listener accepting the PROXY protocol.
+type proxyProtoListener struct {
+	// listener accepts the connections from the proxies.  It's nil until the
+	// server is started.
+	listener net.Listener
+
+	// addr is the address to listen on.
+	addr netip.AddrPort
+
+	// target is the address of the internal listener of the proxy to relay
+	// the connections to.  It's only valid after the server is started.
+	target netip.AddrPort
+
+	// index is the index of the internal listener among the TCP listeners of
+	// the proxy.
+	index int
+}
+
+// proxyProtoServer relays the TCP connections, which start with the PROXY
+// protocol header, from the trusted proxies to the internal listeners of the
+// proxy.  The original addresses of the clients are kept by the local
+// addresses of the relayed connections.
+type proxyProtoServer struct {
+	// logger is used to log the relaying errors.
+	logger *slog.Logger
+
+	// trusted is the set of the proxies allowed to connect.
+	trusted netutil.SubnetSet
+
+	// mu protects clients and conns.
+	mu *sync.Mutex
+
+	// clients maps the local addresses of the relayed connections to the
+	// addresses of the original clients.
+	clients map[netip.AddrPort]netip.AddrPort
+
+	// conns are the connections being relayed, which are closed on shutdown.
+	conns map[net.Conn]struct{}
+
+	// listeners are the listeners accepting the PROXY protocol.
+	listeners []*proxyProtoListener
+}
+
+// newProxyProtoServer returns a new *proxyProtoServer relaying the connections
+// accepted on addrs.  srv is nil if there are no such addresses.
+func newProxyProtoServer(
+	logger *slog.Logger,
+	trusted netutil.SubnetSet,
+	addrs *listenAddrs,
+) (srv *proxyProtoServer) {
+	if len(addrs.proxied) == 0 {
+		return nil
+	}
+
+	srv = &proxyProtoServer{
+		logger:  logger.With(slogutil.KeyPrefix, "proxy_protocol"),
+		trusted: trusted,
+		mu:      &sync.Mutex{},
+		clients: map[netip.AddrPort]netip.AddrPort{},
+		conns:   map[net.Conn]struct{}{},
+	}
+
+	for _, i := range slices.Sorted(maps.Keys(addrs.proxied)) {
+		srv.listeners = append(srv.listeners, &proxyProtoListener{
+			addr:  addrs.proxied[i],
+			index: i,
+		})
+	}
+
+	return srv
+}
+
+// start binds the listeners of srv and starts relaying the connections to the
+// internal listeners of prx, which must be started.  If err is not nil, the
+// listeners already bound are closed.  srv may be nil.
+func (srv *proxyProtoServer) start(ctx context.Context, prx *proxy.Proxy) (err error) {
+	if srv == nil {
+		return nil
+	}
+
+	internal := prx.Addrs(proxy.ProtoTCP)
+	lc := &net.ListenConfig{}
+	for _, l := range srv.listeners {
+		l.target = netutil.NetAddrToAddrPort(internal[l.index])
+		l.listener, err = lc.Listen(ctx, "tcp", l.addr.String())
+		if err != nil {
+			err = fmt.Errorf("listening proxy protocol on %s: %w", l.addr, err)
+
+			return errors.Join(err, srv.shutdown())
+		}
+
+		// The connections are served until the listener is closed.
+		go srv.serve(context.WithoutCancel(ctx), l)
+	}
+
+	return nil
+}
+
+// serve accepts the connections on l until it's closed.  It's intended to be
+// used as a goroutine.
+func (srv *proxyProtoServer) serve(ctx context.Context, l *proxyProtoListener) {
+	defer slogutil.RecoverAndLog(ctx, srv.logger)
+
+	srv.logger.InfoContext(ctx, "serving proxy protocol", "addr", l.listener.Addr())
+
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				srv.logger.ErrorContext(ctx, "accepting", slogutil.KeyError, err)
+			}
+
+			return
+		}
+
+		go srv.handle(ctx, conn, l.target)
+	}
+}
+
+// handle relays conn to target, if it comes from a trusted proxy and starts
+// with a valid header.  It's intended to be used as a goroutine.
+func (srv *proxyProtoServer) handle(ctx context.Context, conn net.Conn, target netip.AddrPort) {
+	defer slogutil.RecoverAndLog(ctx, srv.logger)
+	defer func() { _ = conn.Close() }()
+
+	peer := netutil.NetAddrToAddrPort(conn.RemoteAddr())
+	if !srv.trusted.Contains(peer.Addr()) {
+		srv.logger.DebugContext(ctx, "untrusted proxy", "addr", peer)
+
+		return
+	}
+
+	r, client, err := readProxyHeader(conn)
+	if err != nil {
+		srv.logger.DebugContext(ctx, "reading header", "addr", peer, slogutil.KeyError, err)
+
+		return
+	} else if !client.IsValid() {
+		// The connection is made by the proxy itself, e.g. for health checks.
+		client = peer
+	}
+
+	d := &net.Dialer{
+		Timeout: proxyProtoTimeout,
+	}
+	internal, err := d.DialContext(ctx, "tcp", target.String())
+	if err != nil {
+		srv.logger.ErrorContext(ctx, "connecting to listener", slogutil.KeyError, err)
+
+		return
+	}
+
+	srv.relay(conn, internal, r, client)
+}
+
+// readProxyHeader reads the PROXY protocol header from conn.  r contains the
+// data following the header.  client isn't valid if the header contains no
+// address of the original client.
+func readProxyHeader(conn net.Conn) (r io.Reader, client netip.AddrPort, err error) {
+	err = conn.SetReadDeadline(time.Now().Add(proxyProtoTimeout))
+	if err != nil {
+		return nil, netip.AddrPort{}, fmt.Errorf("setting deadline: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	h, err := proxyproto.ReadHeader(br)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, netip.AddrPort{}, err
+	}
+
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, netip.AddrPort{}, fmt.Errorf("resetting deadline: %w", err)
+	}
+
+	return br, h.Source, nil
+}
+
+// relay copies the data from r, which reads conn, to internal and from
+// internal to conn, until either side is closed.  client is reported as the
+// address of the original client for the requests from internal.
+func (srv *proxyProtoServer) relay(conn, internal net.Conn, r io.Reader, client netip.AddrPort) {
+	local := netutil.NetAddrToAddrPort(internal.LocalAddr())
+
+	srv.mu.Lock()
+	srv.clients[local] = client
+	srv.conns[conn], srv.conns[internal] = struct{}{}, struct{}{}
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.clients, local)
+		delete(srv.conns, conn)
+		delete(srv.conns, internal)
+		srv.mu.Unlock()
+
+		_ = internal.Close()
+	}()
+
+	go func() {
+		_, _ = io.Copy(internal, r)
+
+		// Make the proxy finish handling the connection after responding to
+		// the requests already sent.
+		if tc, ok := internal.(*net.TCPConn); ok {
+			_ = tc.CloseWrite()
+		}
+	}()
+
+	_, _ = io.Copy(conn, internal)
+}
+
+// clientAddr returns the address of the original client of the relayed
+// connection from addr, or addr itself if it's not a relayed one.  srv may be
+// nil.
+func (srv *proxyProtoServer) clientAddr(addr netip.AddrPort) (client netip.AddrPort) {
+	if srv == nil {
+		return addr
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	client, ok := srv.clients[addr]
+	if !ok {
+		return addr
+	}
+
+	return client
+}
+
+// listenAddr returns the address of the listener, which relays the connections
+// to the internal listener with the index among the TCP listeners of the
+// proxy.  ok is false if there is no such listener.  srv may be nil.
+func (srv *proxyProtoServer) listenAddr(index int) (addr netip.AddrPort, ok bool) {
+	if srv == nil {
+		return netip.AddrPort{}, false
+	}
+
+	for _, l := range srv.listeners {
+		if l.index == index && l.listener != nil {
+			return netutil.NetAddrToAddrPort(l.listener.Addr()), true
+		}
+	}
+
+	return netip.AddrPort{}, false
+}
+
+// shutdown closes the listeners and the connections being relayed.  srv may
+// be nil.
+func (srv *proxyProtoServer) shutdown() (err error) {
+	if srv == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, l := range srv.listeners {
+		if l.listener == nil {
+			continue
+		}
+
+		err = l.listener.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("closing proxy protocol listener: %w", err))
+		}
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for conn := range srv.conns {
+		_ = conn.Close()
+	}
+
+	return errors.Join(errs...)
+}
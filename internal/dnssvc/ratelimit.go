@@ -0,0 +1,207 @@
+package dnssvc
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// RejectReasonRateLimited means that the client has exceeded the rate limit.
+const RejectReasonRateLimited RejectReason = "rate_limited"
+
+// maxRateLimitBuckets is the maximum number of the subnets tracked by
+// [rateLimiter] at once.
+const maxRateLimitBuckets = 64 * 1024
+
+// RateLimitConfig is the configuration of the rate limiting of the clients.
+type RateLimitConfig struct {
+	// Mode is the way of handling the requests exceeding the limit.  It must
+	// be valid.
+	Mode RejectMode
+
+	// Allowlist are the subnets of the clients, which requests aren't
+	// limited.
+	Allowlist []netip.Prefix
+
+	// AllowlistClients are the names of the persistent clients, which
+	// requests aren't limited.
+	AllowlistClients []agdc.ClientName
+
+	// AllowlistGroups are the names of the upstream groups, which requests
+	// routed to aren't limited.  The group is chosen for the request the same
+	// way it's chosen for resolving it, but before the request is rewritten.
+	AllowlistGroups []agdc.UpstreamGroupName
+
+	// RPS is the number of requests per second allowed for each subnet.  It
+	// must be positive.
+	RPS uint
+
+	// Burst is the number of requests allowed for each subnet at once.  It
+	// must be positive.
+	Burst uint
+
+	// IPv4PrefixLen is the length of the IPv4 subnets the clients are
+	// aggregated by.  It must be in the range from 0 to 32.
+	IPv4PrefixLen int
+
+	// IPv6PrefixLen is the length of the IPv6 subnets the clients are
+	// aggregated by.  It must be in the range from 0 to 128.
+	IPv6PrefixLen int
+}
+
+// tokenBucket is the state of the rate limit of a single subnet.
+type tokenBucket struct {
+	// last is the time tokens have been updated last.
+	last time.Time
+
+	// tokens is the number of requests currently allowed.
+	tokens float64
+}
+
+// rateLimiter limits the rate of requests from the subnets of the clients
+// using token buckets.
+type rateLimiter struct {
+	// allowlist are the subnets of the clients, which requests aren't
+	// limited.
+	allowlist netutil.SubnetSet
+
+	// clients are the names of the persistent clients, which requests aren't
+	// limited.
+	clients *container.MapSet[agdc.ClientName]
+
+	// groups are the names of the upstream groups, which requests routed to
+	// aren't limited.
+	groups *container.MapSet[agdc.UpstreamGroupName]
+
+	// mu protects buckets.
+	mu *sync.Mutex
+
+	// buckets are the token buckets of the subnets of the clients.
+	buckets map[netip.Prefix]*tokenBucket
+
+	// mode is the way of handling the requests exceeding the limit.
+	mode RejectMode
+
+	// rps is the number of tokens added to a bucket per second.
+	rps float64
+
+	// burst is the maximum number of tokens in a bucket.
+	burst float64
+
+	// ipv4Len is the length of the IPv4 subnets to aggregate the clients by.
+	ipv4Len int
+
+	// ipv6Len is the length of the IPv6 subnets to aggregate the clients by.
+	ipv6Len int
+}
+
+// newRateLimiter returns a new *rateLimiter for conf.  l is nil if conf is
+// nil.
+func newRateLimiter(conf *RateLimitConfig) (l *rateLimiter) {
+	if conf == nil {
+		return nil
+	}
+
+	return &rateLimiter{
+		allowlist: netutil.SliceSubnetSet(conf.Allowlist),
+		clients:   container.NewMapSet(conf.AllowlistClients...),
+		groups:    container.NewMapSet(conf.AllowlistGroups...),
+		mu:        &sync.Mutex{},
+		buckets:   map[netip.Prefix]*tokenBucket{},
+		mode:      conf.Mode,
+		rps:       float64(conf.RPS),
+		burst:     float64(conf.Burst),
+		ipv4Len:   conf.IPv4PrefixLen,
+		ipv6Len:   conf.IPv6PrefixLen,
+	}
+}
+
+// exceeded returns true if the request from addr at now exceeds the limit of
+// its subnet, and takes a token from it otherwise.  l may be nil.
+func (l *rateLimiter) exceeded(addr netip.Addr, now time.Time) (ok bool) {
+	if l == nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+	if l.allowlist.Contains(addr) {
+		return false
+	}
+
+	bits := l.ipv6Len
+	if addr.Is4() {
+		bits = l.ipv4Len
+	}
+
+	// The error is only returned for the invalid bits, which are validated.
+	subnet, _ := addr.Prefix(bits)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets[subnet]
+	if b == nil {
+		l.cleanup(now)
+
+		b = &tokenBucket{
+			last:   now,
+			tokens: l.burst,
+		}
+		l.buckets[subnet] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+	if b.tokens < 1 {
+		return true
+	}
+
+	b.tokens--
+
+	return false
+}
+
+// allowlisted returns true if the request of dctx is sent by an allowlisted
+// persistent client or is routed to an allowlisted upstream group within st.
+// l must not be nil.  st may be nil.
+func (l *rateLimiter) allowlisted(st *upstreamState, dctx *proxy.DNSContext) (ok bool) {
+	if st == nil {
+		return false
+	}
+
+	if l.clients.Has(st.clients.name(dctx.Addr.Addr())) {
+		return true
+	}
+
+	if l.groups.Len() == 0 || len(dctx.Req.Question) != 1 {
+		return false
+	}
+
+	_, _, group, _ := st.route(dctx)
+
+	return l.groups.Has(group)
+}
+
+// cleanup removes the buckets, which are full at now, if there are too many
+// buckets.  If there are still too many of those, all the buckets are removed.
+// l.mu must be locked.
+func (l *rateLimiter) cleanup(now time.Time) {
+	if len(l.buckets) < maxRateLimitBuckets {
+		return
+	}
+
+	for subnet, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rps >= l.burst {
+			delete(l.buckets, subnet)
+		}
+	}
+
+	if len(l.buckets) >= maxRateLimitBuckets {
+		clear(l.buckets)
+	}
+}
@@ -0,0 +1,114 @@
+package dnssvc
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_exceeded(t *testing.T) {
+	t.Parallel()
+
+	l := newRateLimiter(&RateLimitConfig{
+		Mode:          RejectModeRefused,
+		Allowlist:     []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		RPS:           1,
+		Burst:         2,
+		IPv4PrefixLen: 24,
+		IPv6PrefixLen: 56,
+	})
+
+	start := time.Now()
+
+	// The steps are sequential, since each of them takes a token.
+	steps := []struct {
+		addr   netip.Addr
+		name   string
+		offset time.Duration
+		want   bool
+	}{{
+		addr:   netip.MustParseAddr("192.0.2.1"),
+		name:   "first",
+		offset: 0,
+		want:   false,
+	}, {
+		addr:   netip.MustParseAddr("192.0.2.2"),
+		name:   "same_subnet",
+		offset: 0,
+		want:   false,
+	}, {
+		addr:   netip.MustParseAddr("192.0.2.3"),
+		name:   "same_subnet_exceeded",
+		offset: 0,
+		want:   true,
+	}, {
+		addr:   netip.MustParseAddr("198.51.100.1"),
+		name:   "other_subnet",
+		offset: 0,
+		want:   false,
+	}, {
+		addr:   netip.MustParseAddr("127.0.0.1"),
+		name:   "allowlisted",
+		offset: 0,
+		want:   false,
+	}, {
+		addr:   netip.MustParseAddr("127.0.0.1"),
+		name:   "allowlisted_again",
+		offset: 0,
+		want:   false,
+	}, {
+		addr:   netip.MustParseAddr("127.0.0.1"),
+		name:   "allowlisted_once_more",
+		offset: 0,
+		want:   false,
+	}, {
+		addr:   netip.MustParseAddr("192.0.2.1"),
+		name:   "refilled",
+		offset: time.Second,
+		want:   false,
+	}, {
+		addr:   netip.MustParseAddr("192.0.2.1"),
+		name:   "refilled_exceeded",
+		offset: time.Second,
+		want:   true,
+	}, {
+		addr:   netip.MustParseAddr("::ffff:192.0.2.1"),
+		name:   "mapped",
+		offset: time.Second,
+		want:   true,
+	}, {
+		addr:   netip.MustParseAddr("2001:db8::1"),
+		name:   "ipv6",
+		offset: 0,
+		want:   false,
+	}, {
+		addr:   netip.MustParseAddr("2001:db8:0:ff::1"),
+		name:   "ipv6_same_subnet",
+		offset: 0,
+		want:   false,
+	}, {
+		addr:   netip.MustParseAddr("2001:db8:0:ff::2"),
+		name:   "ipv6_exceeded",
+		offset: 0,
+		want:   true,
+	}, {
+		addr:   netip.MustParseAddr("2001:db8:0:100::1"),
+		name:   "ipv6_other_subnet",
+		offset: 0,
+		want:   false,
+	}}
+
+	for _, s := range steps {
+		got := l.exceeded(s.addr, start.Add(s.offset))
+		assert.Equalf(t, s.want, got, "step %q", s.name)
+	}
+}
+
+func TestRateLimiter_exceeded_nil(t *testing.T) {
+	t.Parallel()
+
+	var l *rateLimiter
+	assert.False(t, l.exceeded(netip.MustParseAddr("192.0.2.1"), time.Now()))
+}
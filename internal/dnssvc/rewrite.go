@@ -0,0 +1,321 @@
+package dnssvc
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+)
+
+// RewritesConfig is the configuration of the rewrites of the requests.
+type RewritesConfig struct {
+	// Rules are the rewrite rules.  The rules with the same client subnet,
+	// domain, and domain match are combined.  Those must not be nil.
+	Rules []*RewriteRule
+
+	// TTL is the TTL of the synthesized answers.
+	TTL time.Duration
+}
+
+// RewriteRule is a single rewrite rule.  Exactly one of Addr and Target must be
+// set.
+type RewriteRule struct {
+	// Client is the subnet of the clients the rule applies to.  Zero value
+	// means any client.  The rules of the narrower subnets take precedence.
+	Client netip.Prefix
+
+	// Addr is the address to answer the matching A or AAAA requests with,
+	// depending on its family.
+	Addr netip.Addr
+
+	// Domain is the domain to match the question domain according to
+	// DomainMatch.  It must be a valid domain name.
+	Domain string
+
+	// Target is the hostname to answer the matching requests with the CNAME
+	// record for.  The target itself is rewritten or resolved using the
+	// upstreams.
+	Target string
+
+	// DomainMatch is the way Domain matches the question domain.
+	DomainMatch DomainMatch
+}
+
+// rewriteKey is the key to combine the rewrite rules by.
+type rewriteKey struct {
+	client      netip.Prefix
+	domain      string
+	domainMatch DomainMatch
+}
+
+// rewrite is the combined rewrite rules with the same client subnet, domain,
+// and domain match.
+type rewrite struct {
+	// client is the subnet of the clients the rewrite applies to.  Zero value
+	// means any client.
+	client netip.Prefix
+
+	// domain is the lowercased FQDN to match according to domainMatch.
+	domain string
+
+	// target is the FQDN to answer with the CNAME record for, if any.
+	target string
+
+	// addrs are the addresses to answer the A and AAAA requests with.
+	addrs []netip.Addr
+
+	// domainMatch is the way domain matches.
+	domainMatch DomainMatch
+}
+
+// match returns the specificity of the match of host by rw.  ok is false if rw
+// doesn't match host.  host must be a lowercased FQDN.  See [specificity].
+func (rw *rewrite) match(host string) (s [3]int, ok bool) {
+	if !matchesDomain(host, rw.domain, rw.domainMatch) {
+		return s, false
+	}
+
+	// Rank the exact and subdomains matches higher, just like the routes do.
+	rank := 0
+	if rw.domainMatch != DomainMatchSubtree {
+		rank = 2
+	}
+
+	return specificity(max(rw.client.Bits(), 0), len(rw.domain), rank), true
+}
+
+// rewrites answers the requests matching the rewrite rules.
+type rewrites struct {
+	// clients maps the client subnets of the rules to the rewrites applicable
+	// to the clients within those, including the ones of the wider subnets and
+	// the ones for any client.
+	clients *prefixTrie[[]*rewrite]
+
+	// general are the rewrites for any client.
+	general []*rewrite
+
+	// ttl is the TTL of the synthesized answers.
+	ttl time.Duration
+}
+
+// newRewrites returns a new *rewrites with the rules from conf.  rws is nil if
+// conf is nil.
+func newRewrites(conf *RewritesConfig) (rws *rewrites) {
+	if conf == nil {
+		return nil
+	}
+
+	all := combineRewrites(conf.Rules)
+
+	rws = &rewrites{
+		clients: newPrefixTrie[[]*rewrite](),
+		ttl:     conf.TTL,
+	}
+
+	for _, rw := range all {
+		if rw.client == (netip.Prefix{}) {
+			rws.general = append(rws.general, rw)
+		}
+	}
+
+	for _, rw := range all {
+		if rw.client != (netip.Prefix{}) {
+			rws.clients.insert(rw.client, applicable(all, rw.client))
+		}
+	}
+
+	return rws
+}
+
+// combineRewrites returns the rewrites made of rules combined by the client
+// subnet, domain, and domain match, in the order of their first appearance.
+func combineRewrites(rules []*RewriteRule) (all []*rewrite) {
+	byKey := map[rewriteKey]*rewrite{}
+	for _, r := range rules {
+		key := rewriteKey{
+			client:      r.Client,
+			domain:      normalizeDomain(r.Domain),
+			domainMatch: r.DomainMatch,
+		}
+
+		rw := byKey[key]
+		if rw == nil {
+			rw = &rewrite{
+				client:      key.client,
+				domain:      key.domain,
+				domainMatch: key.domainMatch,
+			}
+			byKey[key] = rw
+			all = append(all, rw)
+		}
+
+		if r.Target != "" {
+			rw.target = dns.Fqdn(r.Target)
+		} else {
+			rw.addrs = append(rw.addrs, r.Addr.Unmap())
+		}
+	}
+
+	return all
+}
+
+// applicable returns the rewrites from all applicable to the clients within
+// the subnet p.
+func applicable(all []*rewrite, p netip.Prefix) (rws []*rewrite) {
+	for _, rw := range all {
+		c := rw.client
+		if c == (netip.Prefix{}) || (c.Bits() <= p.Bits() && c.Contains(p.Addr())) {
+			rws = append(rws, rw)
+		}
+	}
+
+	return rws
+}
+
+// find returns the most specific rewrite matching host for the client with
+// addr, if any.  host must be a lowercased FQDN.
+func (rws *rewrites) find(addr netip.Addr, host string) (found *rewrite) {
+	candidates, ok := rws.clients.lookup(addr)
+	if !ok {
+		candidates = rws.general
+	}
+
+	var best [3]int
+	for _, rw := range candidates {
+		s, matched := rw.match(host)
+		if matched && (found == nil || slices.Compare(s[:], best[:]) > 0) {
+			found, best = rw, s
+		}
+	}
+
+	return found
+}
+
+// rewritten is the request rewritten to the CNAME target.
+type rewritten struct {
+	// req is the original request.
+	req *dns.Msg
+
+	// cnames are the CNAME records leading from the original question to the
+	// target.
+	cnames []dns.RR
+}
+
+// rewrite applies the rewrites matching the request of dctx.  The chains of
+// targets are followed up to [maxCNAMEChain] rewrites.  If the final rewrite
+// has addresses, the response is set.  Otherwise, the request of dctx is
+// replaced with the one for the final target, and rw is used to restore it
+// after resolving.  rw is nil if the request isn't replaced.  rws may be nil.
+func (rws *rewrites) rewrite(dctx *proxy.DNSContext) (rw *rewritten) {
+	if rws == nil {
+		return nil
+	}
+
+	addr := dctx.Addr.Addr()
+	q := dctx.Req.Question[0]
+	name := q.Name
+
+	var cnames []dns.RR
+	for range maxCNAMEChain {
+		found := rws.find(addr, strings.ToLower(name))
+		if found == nil {
+			break
+		}
+
+		if found.target == "" {
+			rws.answer(dctx, found.addrs, cnames)
+
+			return nil
+		}
+
+		cnames = append(cnames, &dns.CNAME{
+			Hdr:    rws.header(name, dns.TypeCNAME),
+			Target: found.target,
+		})
+		name = found.target
+
+		if q.Qtype == dns.TypeCNAME {
+			rws.answer(dctx, nil, cnames)
+
+			return nil
+		}
+	}
+
+	if len(cnames) == 0 {
+		return nil
+	}
+
+	rw = &rewritten{
+		req:    dctx.Req,
+		cnames: cnames,
+	}
+
+	req := dctx.Req.Copy()
+	req.Question[0].Name = name
+	dctx.Req = req
+
+	return rw
+}
+
+// answer sets the response to the request of dctx with cnames followed by the
+// addresses from addrs of the family requested.  Only the A and AAAA requests
+// are answered with the addresses, while the requests of other types are only
+// answered if there are cnames.
+func (rws *rewrites) answer(dctx *proxy.DNSContext, addrs []netip.Addr, cnames []dns.RR) {
+	q := dctx.Req.Question[0]
+	if len(cnames) == 0 && q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA {
+		return
+	}
+
+	resp := (&dns.Msg{}).SetReply(dctx.Req)
+	resp.RecursionAvailable = true
+	resp.Answer = slices.Clone(cnames)
+
+	name := q.Name
+	if len(cnames) > 0 {
+		name = cnames[len(cnames)-1].(*dns.CNAME).Target
+	}
+
+	for _, a := range addrs {
+		if q.Qtype == dns.TypeA && a.Is4() {
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: rws.header(name, q.Qtype), A: a.AsSlice()})
+		} else if q.Qtype == dns.TypeAAAA && a.Is6() {
+			resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: rws.header(name, q.Qtype), AAAA: a.AsSlice()})
+		}
+	}
+
+	dctx.Res = resp
+}
+
+// header returns the header of the synthesized answer for name of rrType.
+func (rws *rewrites) header(name string, rrType uint16) (hdr dns.RR_Header) {
+	return dns.RR_Header{
+		Name:   name,
+		Rrtype: rrType,
+		Class:  dns.ClassINET,
+		// #nosec G115 -- The TTL is validated to fit.
+		Ttl: uint32(rws.ttl.Seconds()),
+	}
+}
+
+// restore restores the original request of dctx and prepends the CNAME records
+// of rw to the response, if any.  rw may be nil.
+func (rw *rewritten) restore(dctx *proxy.DNSContext) {
+	if rw == nil {
+		return
+	}
+
+	dctx.Req = rw.req
+
+	resp := dctx.Res
+	if resp == nil {
+		return
+	}
+
+	resp.Id = rw.req.Id
+	resp.Question = rw.req.Question
+	resp.Answer = append(slices.Clone(rw.cnames), resp.Answer...)
+}
@@ -0,0 +1,127 @@
+package dnssvc
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewrites_find(t *testing.T) {
+	t.Parallel()
+
+	wide := netip.MustParsePrefix("192.168.0.0/16")
+	narrow := netip.MustParsePrefix("192.168.1.0/24")
+
+	rws := newRewrites(&RewritesConfig{
+		Rules: []*RewriteRule{{
+			Domain: "example.com",
+			Addr:   netip.MustParseAddr("198.51.100.1"),
+		}, {
+			Domain:      "git.example.com",
+			Addr:        netip.MustParseAddr("198.51.100.2"),
+			DomainMatch: DomainMatchExact,
+		}, {
+			Client: wide,
+			Domain: "example.com",
+			Addr:   netip.MustParseAddr("192.168.0.1"),
+		}, {
+			Client: wide,
+			Domain: "example.com",
+			Addr:   netip.MustParseAddr("fd00::1"),
+		}, {
+			Client: narrow,
+			Domain: "git.example.com",
+			Target: "git.lan",
+		}, {
+			Client:      narrow,
+			Domain:      "example.com",
+			DomainMatch: DomainMatchSubdomains,
+			Addr:        netip.MustParseAddr("192.168.1.1"),
+		}},
+	})
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantTarget string
+		addr       netip.Addr
+		wantAddrs  []netip.Addr
+	}{{
+		name:       "general",
+		host:       "www.example.com.",
+		wantTarget: "",
+		addr:       netip.MustParseAddr("10.0.0.1"),
+		wantAddrs:  []netip.Addr{netip.MustParseAddr("198.51.100.1")},
+	}, {
+		name:       "general_exact",
+		host:       "git.example.com.",
+		wantTarget: "",
+		addr:       netip.MustParseAddr("10.0.0.1"),
+		wantAddrs:  []netip.Addr{netip.MustParseAddr("198.51.100.2")},
+	}, {
+		name:       "wide_combined",
+		host:       "example.com.",
+		wantTarget: "",
+		addr:       netip.MustParseAddr("192.168.2.1"),
+		wantAddrs: []netip.Addr{
+			netip.MustParseAddr("192.168.0.1"),
+			netip.MustParseAddr("fd00::1"),
+		},
+	}, {
+		name:       "wide_over_general_exact",
+		host:       "git.example.com.",
+		wantTarget: "",
+		addr:       netip.MustParseAddr("192.168.2.1"),
+		wantAddrs: []netip.Addr{
+			netip.MustParseAddr("192.168.0.1"),
+			netip.MustParseAddr("fd00::1"),
+		},
+	}, {
+		name:       "narrow_target",
+		host:       "git.example.com.",
+		wantTarget: "git.lan.",
+		addr:       netip.MustParseAddr("192.168.1.2"),
+		wantAddrs:  nil,
+	}, {
+		name:       "narrow_subdomains",
+		host:       "www.example.com.",
+		wantTarget: "",
+		addr:       netip.MustParseAddr("192.168.1.2"),
+		wantAddrs:  []netip.Addr{netip.MustParseAddr("192.168.1.1")},
+	}, {
+		name:       "narrow_inherits_wide",
+		host:       "example.com.",
+		wantTarget: "",
+		addr:       netip.MustParseAddr("192.168.1.2"),
+		wantAddrs: []netip.Addr{
+			netip.MustParseAddr("192.168.0.1"),
+			netip.MustParseAddr("fd00::1"),
+		},
+	}, {
+		name:       "none",
+		host:       "example.org.",
+		wantTarget: "",
+		addr:       netip.MustParseAddr("192.168.1.2"),
+		wantAddrs:  nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rw := rws.find(tc.addr, tc.host)
+			if tc.wantTarget == "" && tc.wantAddrs == nil {
+				assert.Nil(t, rw)
+
+				return
+			}
+
+			require.NotNil(t, rw)
+
+			assert.Equal(t, tc.wantTarget, rw.target)
+			assert.Equal(t, tc.wantAddrs, rw.addrs)
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package dnssvc
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
+// DomainMatch is the way [MatchCriteria.QuestionDomain] matches the domain of
+// the request's question.
+type DomainMatch uint8
+
+// Valid domain matches.
+const (
+	// DomainMatchSubtree matches the domain itself and all its subdomains.
+	DomainMatchSubtree DomainMatch = iota
+
+	// DomainMatchExact only matches the domain itself.
+	DomainMatchExact
+
+	// DomainMatchSubdomains only matches the subdomains of the domain.
+	DomainMatchSubdomains
+)
+
+// route is a route of the requests to an upstream group, which can't be
+// expressed by [proxy.UpstreamConfig], i.e. the ones matching a question type,
+// matching a domain other than with [DomainMatchSubtree], or matching the
+// contents of a file.  Those are chosen by the service itself before the proxy
+// chooses the upstreams.
+type route struct {
+	// client is the configuration used for the matching requests.  Its prefix
+	// is the client subnet the route matches, which is zero for any client.
+	client *client
+
+	// domains, if not nil, is the list of domains the route matches along
+	// with their subdomains instead of domain.
+	domains *matchList
+
+	// clients, if not nil, is the list of client subnets the route matches
+	// instead of the prefix of client.
+	clients *matchList
+
+	// key is the key of the custom upstream configuration of client within
+	// [caches].
+	key cacheKey
+
+	// group is the name of the upstream group the route leads to.
+	group agdc.UpstreamGroupName
+
+	// domain is the lowercased FQDN the route matches according to
+	// domainMatch.  It's empty for any domain.
+	domain string
+
+	// exclusions are the lowercased FQDNs, which the route doesn't match along
+	// with their subdomains.
+	exclusions []string
+
+	// qtype is the question type the route matches.  Zero value matches any
+	// type.
+	qtype uint16
+
+	// domainMatch is the way domain matches.
+	domainMatch DomainMatch
+}
+
+// match returns the specificity of the match of the request of qtype for host
+// from addr by r.  ok is false if r doesn't match the request.  host must be a
+// lowercased FQDN.  See [specificity].
+func (r *route) match(addr netip.Addr, host string, qtype uint16) (s [3]int, ok bool) {
+	if r.qtype != 0 && r.qtype != qtype {
+		return s, false
+	}
+
+	bits, ok := r.matchClient(addr)
+	if !ok {
+		return s, false
+	}
+
+	domainLen, ok := r.matchDomain(host)
+	if !ok {
+		return s, false
+	}
+
+	for _, excl := range r.exclusions {
+		if matchesDomain(host, excl, DomainMatchSubtree) {
+			return s, false
+		}
+	}
+
+	return specificity(bits, domainLen, r.rank()), true
+}
+
+// matchClient returns the length of the subnet of r containing addr, if any.
+func (r *route) matchClient(addr netip.Addr) (bits int, ok bool) {
+	if r.clients != nil {
+		return r.clients.matchClient(addr)
+	}
+
+	p := r.client.prefix
+	if p != (netip.Prefix{}) && !p.Contains(addr) {
+		return 0, false
+	}
+
+	return max(p.Bits(), 0), true
+}
+
+// matchDomain returns the length of the domain of r matching host, if any.
+// host must be a lowercased FQDN.
+func (r *route) matchDomain(host string) (domainLen int, ok bool) {
+	if r.domains != nil {
+		return r.domains.matchDomain(host)
+	}
+
+	if !matchesDomain(host, r.domain, r.domainMatch) {
+		return 0, false
+	}
+
+	return len(r.domain), true
+}
+
+// matchesDomain returns true if host matches domain in the way m.  Empty
+// domain matches any host.  host and domain must be lowercased FQDNs.
+func matchesDomain(host, domain string, m DomainMatch) (ok bool) {
+	if domain == "" {
+		return true
+	}
+
+	isSub := strings.HasSuffix(host, "."+domain)
+	switch m {
+	case DomainMatchExact:
+		return host == domain
+	case DomainMatchSubdomains:
+		return isSub
+	default:
+		return host == domain || isSub
+	}
+}
+
+// specificity returns the values to compare the matches by.  A match with the
+// narrower client subnet of bits is more specific, and so is the one with the
+// longer matched domain for the same subnet.  For the same domain, the match
+// with the greater rank is more specific, see [route.rank].
+func specificity(bits, domainLen, rank int) (s [3]int) {
+	return [3]int{bits, domainLen, rank}
+}
+
+// rank returns the rank of r among the matches of the same client subnet and
+// domain.  The exact and subdomains matches rank higher than the subtree one,
+// and the match of a question type ranks higher than the one of any type.
+func (r *route) rank() (rank int) {
+	if r.domains == nil && r.domainMatch != DomainMatchSubtree {
+		rank += 2
+	}
+
+	if r.qtype != 0 {
+		rank++
+	}
+
+	return rank
+}
+
+// isRouted returns true if m can't be expressed by [proxy.UpstreamConfig] and
+// requires a route.
+func (m *MatchCriteria) isRouted() (ok bool) {
+	if m.Exclude {
+		return false
+	}
+
+	return m.QuestionType != 0 ||
+		m.DomainMatch != DomainMatchSubtree ||
+		m.DomainFile != "" ||
+		m.ClientFile != ""
+}
+
+// newRoutes creates the routes for the matches of ugc requiring those.  u is
+// the upstream of the group.  The files of the matches are read into lists,
+// unless those are already there.  The custom upstream configurations of the
+// routes are set later, see [route.key].
+func (ugc *UpstreamGroupConfig) newRoutes(
+	u upstream.Upstream,
+	lists matchLists,
+) (routes []*route, err error) {
+	for i, m := range ugc.Match {
+		if !m.isRouted() {
+			continue
+		}
+
+		var domains, clients *matchList
+		domains, clients, err = m.lists(lists)
+		if err != nil {
+			return routes, fmt.Errorf("match at index %d: %w", i, err)
+		}
+
+		r := &route{
+			client: &client{
+				upstreams: &proxy.UpstreamConfig{
+					Upstreams: []upstream.Upstream{u},
+				},
+				prefix: m.Client,
+			},
+			domains: domains,
+			clients: clients,
+			key: cacheKey{
+				prefix: m.Client,
+				group:  ugc.Name,
+				match:  i,
+			},
+			group:       ugc.Name,
+			domain:      normalizeDomain(m.QuestionDomain),
+			exclusions:  ugc.exclusions(m.Client),
+			qtype:       m.QuestionType,
+			domainMatch: m.DomainMatch,
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
+
+// lists returns the lists of domains and client subnets for the files of m, if
+// any, taking those from ls.
+func (m *MatchCriteria) lists(ls matchLists) (domains, clients *matchList, err error) {
+	if m.DomainFile != "" {
+		domains, err = ls.get(m.DomainFile, matchListDomains)
+		if err != nil {
+			return nil, nil, fmt.Errorf("domain file: %w", err)
+		}
+	}
+
+	if m.ClientFile != "" {
+		clients, err = ls.get(m.ClientFile, matchListClients)
+		if err != nil {
+			return nil, nil, fmt.Errorf("client file: %w", err)
+		}
+	}
+
+	return domains, clients, nil
+}
+
+// exclusions returns the normalized domains excluded from the matches of ugc
+// for the client subnet.
+func (ugc *UpstreamGroupConfig) exclusions(client netip.Prefix) (domains []string) {
+	for _, m := range ugc.Match {
+		if m.Exclude && m.Client == client {
+			domains = append(domains, normalizeDomain(m.QuestionDomain))
+		}
+	}
+
+	return domains
+}
+
+// normalizeDomain returns the lowercased FQDN for domain, or an empty string if
+// domain is empty.
+func normalizeDomain(domain string) (fqdn string) {
+	if domain == "" {
+		return ""
+	}
+
+	return dns.Fqdn(strings.ToLower(domain))
+}
+
+// findRoute returns the most specific route from routes matching req from
+// addr, if it's at least as specific as the match of the client's prefix and
+// domain chosen by the proxy.  Otherwise, it returns nil.  req must have a
+// question.
+func findRoute(
+	routes []*route,
+	addr netip.Addr,
+	req *dns.Msg,
+	prefix netip.Prefix,
+	domain string,
+) (found *route) {
+	host, qtype := questionHost(req), req.Question[0].Qtype
+	best := specificity(max(prefix.Bits(), 0), len(domain), 0)
+
+	for _, r := range routes {
+		s, ok := r.match(addr, host, qtype)
+		if ok && slices.Compare(s[:], best[:]) >= 0 {
+			found, best = r, s
+		}
+	}
+
+	return found
+}
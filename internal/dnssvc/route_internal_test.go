@@ -0,0 +1,243 @@
+package dnssvc
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindRoute(t *testing.T) {
+	t.Parallel()
+
+	cliPref := netip.MustParsePrefix("192.0.2.0/24")
+	cliAddr := netip.MustParseAddr("192.0.2.1")
+	otherAddr := netip.MustParseAddr("198.51.100.1")
+
+	newRoute := func(
+		group agdc.UpstreamGroupName,
+		prefix netip.Prefix,
+		domain string,
+		qtype uint16,
+	) (r *route) {
+		return &route{
+			client: &client{prefix: prefix},
+			group:  group,
+			domain: domain,
+			qtype:  qtype,
+		}
+	}
+
+	clients, err := parseMatchList(
+		strings.NewReader("198.51.100.0/24\n"),
+		"clients.txt",
+		matchListClients,
+	)
+	require.NoError(t, err)
+
+	clientList := &matchList{}
+	clientList.data.Store(clients)
+
+	routes := []*route{
+		newRoute("any_aaaa", netip.Prefix{}, "", dns.TypeAAAA),
+		newRoute("client_aaaa", cliPref, "", dns.TypeAAAA),
+		newRoute("domain_https", netip.Prefix{}, "example.org.", dns.TypeHTTPS),
+		newRoute("client_domain_https", cliPref, "example.org.", dns.TypeHTTPS),
+		newRoute("ptr", netip.Prefix{}, "", dns.TypePTR),
+		{
+			client:      &client{},
+			group:       "exact",
+			domain:      "exact.example.",
+			domainMatch: DomainMatchExact,
+		},
+		{
+			client:      &client{},
+			group:       "wildcard",
+			domain:      "wildcard.example.",
+			exclusions:  []string{"excluded.wildcard.example."},
+			domainMatch: DomainMatchSubdomains,
+		},
+		{
+			client:  &client{},
+			clients: clientList,
+			group:   "client_list",
+			qtype:   dns.TypeTXT,
+		},
+	}
+
+	testCases := []struct {
+		addr      netip.Addr
+		prefix    netip.Prefix
+		name      string
+		host      string
+		domain    string
+		wantGroup agdc.UpstreamGroupName
+		qtype     uint16
+	}{{
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "no_type",
+		host:      "example.com.",
+		domain:    "",
+		wantGroup: "",
+		qtype:     dns.TypeA,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "any_client",
+		host:      "example.com.",
+		domain:    "",
+		wantGroup: "any_aaaa",
+		qtype:     dns.TypeAAAA,
+	}, {
+		addr:      cliAddr,
+		prefix:    netip.Prefix{},
+		name:      "narrower_client",
+		host:      "example.com.",
+		domain:    "",
+		wantGroup: "client_aaaa",
+		qtype:     dns.TypeAAAA,
+	}, {
+		addr:      cliAddr,
+		prefix:    cliPref,
+		name:      "same_client",
+		host:      "example.com.",
+		domain:    "",
+		wantGroup: "client_aaaa",
+		qtype:     dns.TypeAAAA,
+	}, {
+		addr:      cliAddr,
+		prefix:    cliPref,
+		name:      "untyped_domain_wins",
+		host:      "www.example.net.",
+		domain:    "example.net.",
+		wantGroup: "",
+		qtype:     dns.TypeAAAA,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.PrefixFrom(otherAddr, 32),
+		name:      "untyped_client_wins",
+		host:      "example.com.",
+		domain:    "",
+		wantGroup: "",
+		qtype:     dns.TypePTR,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "domain",
+		host:      "www.example.org.",
+		domain:    "",
+		wantGroup: "domain_https",
+		qtype:     dns.TypeHTTPS,
+	}, {
+		addr:      cliAddr,
+		prefix:    netip.Prefix{},
+		name:      "client_domain",
+		host:      "example.org.",
+		domain:    "",
+		wantGroup: "client_domain_https",
+		qtype:     dns.TypeHTTPS,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "other_domain",
+		host:      "notexample.org.",
+		domain:    "",
+		wantGroup: "",
+		qtype:     dns.TypeHTTPS,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "exact",
+		host:      "exact.example.",
+		domain:    "exact.example.",
+		wantGroup: "exact",
+		qtype:     dns.TypeA,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "exact_subdomain",
+		host:      "www.exact.example.",
+		domain:    "exact.example.",
+		wantGroup: "",
+		qtype:     dns.TypeA,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "wildcard_domain",
+		host:      "wildcard.example.",
+		domain:    "",
+		wantGroup: "",
+		qtype:     dns.TypeA,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "wildcard_subdomain",
+		host:      "www.wildcard.example.",
+		domain:    "wildcard.example.",
+		wantGroup: "wildcard",
+		qtype:     dns.TypeA,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "wildcard_longer_domain",
+		host:      "www.sub.wildcard.example.",
+		domain:    "sub.wildcard.example.",
+		wantGroup: "",
+		qtype:     dns.TypeA,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "wildcard_excluded",
+		host:      "www.excluded.wildcard.example.",
+		domain:    "",
+		wantGroup: "",
+		qtype:     dns.TypeA,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.Prefix{},
+		name:      "client_list",
+		host:      "example.com.",
+		domain:    "",
+		wantGroup: "client_list",
+		qtype:     dns.TypeTXT,
+	}, {
+		addr:      otherAddr,
+		prefix:    netip.PrefixFrom(otherAddr, 32),
+		name:      "client_list_narrower_client",
+		host:      "example.com.",
+		domain:    "",
+		wantGroup: "",
+		qtype:     dns.TypeTXT,
+	}, {
+		addr:      cliAddr,
+		prefix:    netip.Prefix{},
+		name:      "client_list_other_client",
+		host:      "example.com.",
+		domain:    "",
+		wantGroup: "",
+		qtype:     dns.TypeTXT,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := (&dns.Msg{}).SetQuestion(tc.host, tc.qtype)
+			r := findRoute(routes, tc.addr, req, tc.prefix, tc.domain)
+			if tc.wantGroup == "" {
+				assert.Nil(t, r)
+
+				return
+			}
+
+			if assert.NotNil(t, r) {
+				assert.Equal(t, tc.wantGroup, r.group)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package dnssvc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/rpz"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+)
+
+// responsePolicies applies the response policy zones to the requests resolved
+// using the upstreams.
+type responsePolicies struct {
+	// logger is used to log the applied policies.
+	logger *slog.Logger
+
+	// policies matches the requests and the responses against the zones.
+	policies *rpz.Policies
+}
+
+// newResponsePolicies returns a new *responsePolicies with the zones from conf
+// loaded.  checkOnly overrides the CheckOnly property of conf.  rp is nil if
+// conf is nil.
+func newResponsePolicies(
+	ctx context.Context,
+	logger *slog.Logger,
+	conf *rpz.Config,
+	checkOnly bool,
+) (rp *responsePolicies, err error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	rpzConf := *conf
+	rpzConf.CheckOnly = checkOnly
+
+	ps, err := rpz.New(ctx, &rpzConf)
+	if err != nil {
+		return nil, fmt.Errorf("response policies: %w", err)
+	}
+
+	return &responsePolicies{
+		logger:   logger.With(slogutil.KeyPrefix, "rpz"),
+		policies: ps,
+	}, nil
+}
+
+// checkQName matches the question of dctx against the QNAME triggers and sets
+// the response to it according to the matched rule, unless it's a PASSTHRU
+// one.  m is nil if no rule matches the request.  rp may be nil.
+func (rp *responsePolicies) checkQName(dctx *proxy.DNSContext) (m *rpz.Match) {
+	if rp == nil {
+		return nil
+	}
+
+	m = rp.policies.MatchQName(dctx.Req.Question[0].Name)
+	rp.apply(dctx, m)
+
+	return m
+}
+
+// checkResponse matches the response of dctx against the response IP triggers
+// and replaces it according to the matched rule, unless it's a PASSTHRU one.
+// m is nil if no rule matches the response.  rp may be nil.
+func (rp *responsePolicies) checkResponse(dctx *proxy.DNSContext) (m *rpz.Match) {
+	if rp == nil || dctx.Res == nil {
+		return nil
+	}
+
+	m = rp.policies.MatchResponse(dctx.Res)
+	rp.apply(dctx, m)
+
+	return m
+}
+
+// apply sets the response to the request of dctx according to m and logs it.
+// m may be nil.
+func (rp *responsePolicies) apply(dctx *proxy.DNSContext, m *rpz.Match) {
+	if m == nil {
+		return
+	}
+
+	if resp := m.Response(dctx.Req); resp != nil {
+		dctx.Res = resp
+	}
+
+	// TODO(e.burkov):  Use the request's context when the proxy starts
+	// supporting it.
+	rp.logger.DebugContext(
+		context.TODO(),
+		"policy applied",
+		"policy", m.Policy,
+		"trigger", m.Trigger,
+		"action", m.Action,
+		"name", dctx.Req.Question[0].Name,
+		"client_ip", dctx.Addr.Addr(),
+	)
+}
+
+// refresh re-reads the modified zone files and transfers the zones due to
+// refresh.  rp may be nil.
+func (rp *responsePolicies) refresh(ctx context.Context) (err error) {
+	if rp == nil {
+		return nil
+	}
+
+	err = rp.policies.Refresh(ctx)
+	if err != nil {
+		return fmt.Errorf("refreshing response policies: %w", err)
+	}
+
+	return nil
+}
+
+// nextRefresh returns the time of the next transfer of the zones, if any.  rp
+// may be nil.
+func (rp *responsePolicies) nextRefresh() (next time.Time) {
+	if rp == nil {
+		return time.Time{}
+	}
+
+	return rp.policies.NextRefresh()
+}
@@ -0,0 +1,498 @@
+package dnssvc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"sync"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/filter"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/rpz"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/miekg/dns"
+)
+
+// upstreamState is the set of upstream configurations of [DNSService], which
+// is replaced as a whole on reconfiguration.  It's closed after it's retired
+// and no requests use it anymore.
+type upstreamState struct {
+	// logger is used to log the errors of closing the state when it's closed
+	// after processing a request.
+	logger *slog.Logger
+
+	// mu protects refs and retired.
+	mu *sync.Mutex
+
+	// general is the upstream configuration for the clients not matching any
+	// client-specific one.
+	general *proxy.UpstreamConfig
+
+	// generalCustom is the custom configuration using general with the common
+	// cache.
+	generalCustom *proxy.CustomUpstreamConfig
+
+	// private is the upstream configuration for private PTR requests.  It's
+	// nil if there are no private upstreams.
+	private *proxy.UpstreamConfig
+
+	// privateCustom is the custom configuration using private with the common
+	// cache.  It's nil if private is nil.
+	privateCustom *proxy.CustomUpstreamConfig
+
+	// configs are the upstream configurations used by the custom ones from
+	// [caches] by their keys.
+	configs map[cacheKey]*proxy.UpstreamConfig
+
+	// fallbacks is the upstream configuration used when the main upstreams of
+	// a group without its own fallbacks fail.
+	fallbacks *proxy.UpstreamConfig
+
+	// clients stores the client-specific upstream configurations.
+	clients *clientStorage
+
+	// lists are the match lists of the routes, which are re-read on
+	// [DNSService.Refresh].
+	lists matchLists
+
+	// filter filters the requests.  It's nil if the filtering is disabled.
+	filter *requestFilter
+
+	// local answers the requests for the local records.  It's nil if there
+	// are no local records.
+	local *localRecords
+
+	// zones answers the requests for the names within the authoritative
+	// zones.
+	zones zones
+
+	// rewrites answers or rewrites the requests matching the rewrite rules.
+	// It's nil if there are no rewrite rules.
+	rewrites *rewrites
+
+	// policies applies the response policy zones to the requests resolved
+	// using the upstreams.  It's nil if there are no response policy zones.
+	policies *responsePolicies
+
+	// closers are the group-specific bootstraps and fallbacks.
+	closers []io.Closer
+
+	// refs is the number of requests currently using the state.
+	refs uint
+
+	// cacheEnabled is true if the responses are cached.
+	cacheEnabled bool
+
+	// retired is true if the state has been replaced or the service has been
+	// shut down.
+	retired bool
+}
+
+// newUpstreamState creates a new upstream state from the upstream, fallback,
+// cache, filtering, local records, zones, rewrites, and response policies
+// configurations of conf using boot to resolve the upstreams' hostnames.  The
+// custom upstream configurations are taken from cs.  conf and cs must not be
+// nil.
+func newUpstreamState(
+	ctx context.Context,
+	conf *Config,
+	boot upstream.Resolver,
+	cs *caches,
+) (st *upstreamState, err error) {
+	local, err := newLocalRecords(ctx, conf.Logger, conf.LocalRecords)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	zs, err := newZones(conf.Zones)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	policies, err := newResponsePolicies(ctx, conf.Logger, conf.ResponsePolicies, conf.CheckOnly)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	flt, err := newRequestFilter(ctx, conf)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	falls, err := newFallbacks(conf.Fallbacks, conf.Logger, boot, conf.Metrics, "")
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	set, err := newUpstreams(
+		withClientMatches(conf.Upstreams, conf.Clients),
+		conf.Logger,
+		boot,
+		falls.Upstreams,
+		conf.Metrics,
+	)
+	if err != nil {
+		// Close the upstreams created so far, since they're not used anymore.
+		errs := []error{err, falls.Close()}
+		for _, c := range set.closers {
+			errs = append(errs, c.Close())
+		}
+
+		return nil, errors.Join(errs...)
+	}
+
+	// Use the upstream configuration with no client specification as the
+	// general one.  Also remove it from the map, to build the clients list.
+	ups := set.configs
+	general := ups[netip.Prefix{}]
+	delete(ups, netip.Prefix{})
+
+	cs.update(conf.Cache)
+	st = &upstreamState{
+		logger:        conf.Logger,
+		mu:            &sync.Mutex{},
+		general:       general,
+		generalCustom: cs.get(cacheKey{}),
+		cacheEnabled:  conf.Cache.Enabled,
+		private:       set.private,
+		configs:       map[cacheKey]*proxy.UpstreamConfig{{}: general},
+		fallbacks:     falls,
+		closers:       set.closers,
+		lists:         set.lists,
+		filter:        flt,
+		local:         local,
+		zones:         zs,
+		rewrites:      newRewrites(conf.Rewrites),
+		policies:      policies,
+	}
+
+	clients := ups.clients(cs, general)
+	st.setCustomConfigs(cs, set, clients)
+	st.clients = newClientStorage(clients, set.routes, conf.Clients)
+
+	return st, nil
+}
+
+// setCustomConfigs takes the custom upstream configurations of the private
+// upstreams and the routes of set from cs and registers those along with the
+// ones of clients within st, so that [cacheKey.choose] finds their upstreams.
+func (st *upstreamState) setCustomConfigs(cs *caches, set *upstreamSet, clients []*client) {
+	if set.private != nil {
+		st.privateCustom = cs.get(keyPrivate)
+		st.configs[keyPrivate] = set.private
+	}
+
+	for _, r := range set.routes {
+		r.client.conf = cs.get(r.key)
+		st.configs[r.key] = r.client.upstreams
+	}
+
+	for _, c := range clients {
+		st.configs[cacheKey{prefix: c.prefix}] = c.upstreams
+	}
+}
+
+// acquire marks st as used by a request.  ok is false if st is retired and
+// must not be used.  If ok is true, [upstreamState.release] must be called
+// after the request is processed.
+func (st *upstreamState) acquire() (ok bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.retired {
+		return false
+	}
+
+	st.refs++
+
+	return true
+}
+
+// release marks a request using st as finished.  It closes st if it's retired
+// and it's the last request using it.
+func (st *upstreamState) release() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.refs--
+	if !st.retired || st.refs > 0 {
+		return
+	}
+
+	// TODO(e.burkov):  Use the request's context when the proxy starts
+	// supporting it.
+	ctx := context.TODO()
+	if err := errors.Join(st.close()...); err != nil {
+		st.logger.ErrorContext(ctx, "closing retired upstreams", slogutil.KeyError, err)
+	}
+}
+
+// retire marks st as no longer used for new requests.  It closes st right away
+// if no requests are using it and returns the errors that occurred.
+// Otherwise, st is closed by the last request using it.
+func (st *upstreamState) retire() (errs []error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.retired = true
+	if st.refs > 0 {
+		return nil
+	}
+
+	return st.close()
+}
+
+// close closes the upstream configurations of st.  It returns a slice of
+// errors that occurred during the closing.  The custom upstream configurations
+// aren't closed, since those are kept in [caches] and only use the upstreams
+// of the current state.
+func (st *upstreamState) close() (errs []error) {
+	errs = st.clients.close()
+
+	err := st.general.Close()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("closing general upstreams: %w", err))
+	}
+
+	if st.private != nil {
+		err = st.private.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("closing private upstreams: %w", err))
+		}
+	}
+
+	err = st.fallbacks.Close()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("closing fallbacks: %w", err))
+	}
+
+	for i, c := range st.closers {
+		err = c.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("closing group upstreams at index %d: %w", i, err))
+		}
+	}
+
+	return errs
+}
+
+// requestInfo is the information about the request gathered during its
+// processing.
+type requestInfo struct {
+	// client is the matched client, if any.
+	client *client
+
+	// filter is the result of filtering the request, if any rule matched it.
+	filter *filter.Result
+
+	// policy is the matched response policy rule, if any.
+	policy *rpz.Match
+
+	// rewritten is the original request, if it has been rewritten to a CNAME
+	// target.
+	rewritten *rewritten
+
+	// upstream is the upstream chosen for the request, if any.  It's reported
+	// instead of the one used by the proxy, which only exchanges the requests
+	// through the current state.
+	upstream upstream.Upstream
+
+	// group is the name of the upstream group chosen for the request.
+	group agdc.UpstreamGroupName
+
+	// clientName is the name of the persistent client that sent the request, if
+	// any.
+	clientName agdc.ClientName
+}
+
+// routeRequest answers the request of dctx with the local records, the
+// authoritative zones, or the rewrites, or chooses the upstream configuration
+// for it, and applies the response policies and the filtering to it.  The
+// request rewritten to a CNAME target is routed, checked, and resolved for the
+// target.  The private PTR requests are neither rewritten nor matched against
+// the clients nor checked against the policies and the rule lists, and are only
+// resolved if usePrivateRDNS is true.  The response of dctx is set if the
+// request shouldn't be resolved using the upstreams.  ri is never nil.
+func (st *upstreamState) routeRequest(
+	dctx *proxy.DNSContext,
+	usePrivateRDNS bool,
+) (ri *requestInfo) {
+	ri = &requestInfo{
+		clientName: st.clients.name(dctx.Addr.Addr()),
+	}
+	if st.local.answer(dctx) || st.zones.answer(dctx) {
+		return ri
+	}
+
+	if dctx.RequestedPrivateRDNS == (netip.Prefix{}) {
+		ri.rewritten = st.rewrites.rewrite(dctx)
+		if dctx.Res != nil {
+			return ri
+		}
+
+		st.setCustomConfig(dctx, ri)
+		ri.policy = st.policies.checkQName(dctx)
+		if dctx.Res == nil {
+			ri.filter = st.filter.filterRequest(dctx, ri.group)
+		}
+
+		return ri
+	}
+
+	switch {
+	case st.private == nil:
+		// Make the proxy respond with NXDOMAIN, just like it does when the
+		// private upstreams are disabled.
+		dctx.IsPrivateClient = false
+	case usePrivateRDNS:
+		// Use the custom configuration with the common cache, since the proxy
+		// neither caches the private PTR requests nor uses the custom
+		// configurations for those.
+		dctx.CustomUpstreamConfig = st.privateCustom
+		dctx.RequestedPrivateRDNS = netip.Prefix{}
+		if selected, _ := selectUpstreams(st.private, dctx.Req); len(selected) > 0 {
+			ri.upstream = selected[0]
+		}
+	}
+
+	ri.group = agdc.UpstreamGroupNamePrivate
+
+	return ri
+}
+
+// resolve resolves the request of dctx using the upstreams and checks the
+// response against the response IP triggers, unless a response policy rule has
+// already matched the request.  ri must not be nil.
+func (st *upstreamState) resolve(
+	p *proxy.Proxy,
+	dctx *proxy.DNSContext,
+	ri *requestInfo,
+) (err error) {
+	err = p.Resolve(dctx)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	if ri.policy == nil && ri.group != agdc.UpstreamGroupNamePrivate {
+		ri.policy = st.policies.checkResponse(dctx)
+	}
+
+	return nil
+}
+
+// setCustomConfig sets the upstream configuration for the client of dctx, if
+// any, or the general one as the custom upstream configuration of dctx.  A
+// route by question type is used instead, if it's at least as specific.  The
+// matched client, if any, the name of the upstream group, and the upstream
+// chosen for the request are set into ri.  ri must not be nil.
+func (st *upstreamState) setCustomConfig(dctx *proxy.DNSContext, ri *requestInfo) {
+	ri.client, dctx.CustomUpstreamConfig, ri.group, ri.upstream = st.route(dctx)
+}
+
+// route returns the matched client, if any, the upstream configuration, the
+// name of the upstream group, and the upstream chosen for the request of dctx,
+// see [upstreamState.setCustomConfig].  The request of dctx must have a
+// question.
+func (st *upstreamState) route(dctx *proxy.DNSContext) (
+	c *client,
+	conf *proxy.CustomUpstreamConfig,
+	group agdc.UpstreamGroupName,
+	u upstream.Upstream,
+) {
+	conf, ups, prefix := st.generalCustom, st.general, netip.Prefix{}
+
+	addr := dctx.Addr.Addr()
+	c = st.clients.find(addr)
+	if c != nil {
+		conf, ups, prefix = c.conf, c.upstreams, c.prefix
+	}
+
+	selected, domain := selectUpstreams(ups, dctx.Req)
+	if r := findRoute(st.clients.routes, addr, dctx.Req, prefix, domain); r != nil {
+		return r.client, r.client.conf, r.group, r.client.upstreams.Upstreams[0]
+	}
+
+	if len(selected) > 0 {
+		u = selected[0]
+		group = upstreamGroup(u)
+	}
+
+	return c, conf, group, u
+}
+
+// errShutdown is returned when the service is used after it has been shut
+// down.
+const errShutdown errors.Error = "dns service is shut down"
+
+// stateUpstream is an [upstream.Upstream] that exchanges requests using the
+// upstreams chosen from the current upstream state of the service.  It's used
+// within the proxy's own upstream configurations, since those can't be
+// replaced after the proxy is created.
+type stateUpstream struct {
+	// svc is the service to get the current upstream state from.
+	svc *DNSService
+
+	// choose returns the upstreams to exchange req with from st.
+	choose func(st *upstreamState, req *dns.Msg) (ups []upstream.Upstream)
+
+	// name is used as the address of the upstream.
+	name string
+}
+
+// type check
+var _ upstream.Upstream = (*stateUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *stateUpstream.
+func (u *stateUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	st := u.svc.acquireState()
+	if st == nil {
+		return nil, errShutdown
+	}
+	defer st.release()
+
+	ups := u.choose(st, req)
+	if len(ups) == 0 {
+		return nil, upstream.ErrNoUpstreams
+	}
+
+	return exchangeInOrder(ups, req)
+}
+
+// exchangeInOrder exchanges req with each of ups in order until one of them
+// succeeds.  Each upstream implements the mode of its own group, so the
+// exchange itself isn't parallelized.  ups must not be empty.
+func exchangeInOrder(ups []upstream.Upstream, req *dns.Msg) (resp *dns.Msg, err error) {
+	var errs []error
+	for _, u := range ups {
+		resp, err = u.Exchange(req)
+		if err == nil {
+			return resp, nil
+		}
+
+		errs = append(errs, fmt.Errorf("upstream %s: %w", u.Address(), err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// Address implements the [upstream.Upstream] interface for *stateUpstream.
+func (u *stateUpstream) Address() (addr string) {
+	return u.name
+}
+
+// Close implements the [upstream.Upstream] interface for *stateUpstream.  It
+// does nothing, since the actual upstreams are closed with their state.
+func (u *stateUpstream) Close() (err error) {
+	return nil
+}
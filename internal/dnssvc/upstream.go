@@ -2,17 +2,18 @@ package dnssvc
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
 	"net/netip"
-	"strings"
+	"slices"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
 	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdcslog"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/filter"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/errors"
-	"github.com/miekg/dns"
 )
 
 // UpstreamConfig is the configuration for DNS upstream servers.
@@ -28,65 +29,206 @@ type UpstreamConfig struct {
 	Timeout time.Duration
 }
 
+// upstreamSet is the set of upstream configurations built from
+// [UpstreamConfig].
+type upstreamSet struct {
+	// private is the configuration for private PTR requests, if any.
+	private *proxy.UpstreamConfig
+
+	// configs contains the general configuration for the zero prefix and the
+	// client-specific ones.
+	configs upstreamConfigs
+
+	// routes are the routes for the matches requiring those, see [route].
+	routes []*route
+
+	// lists are the match lists read from the files referenced by the
+	// matches.
+	lists matchLists
+
+	// closers are the group-specific bootstraps and fallbacks to close along
+	// with the upstreams.
+	closers []io.Closer
+}
+
 // newUpstreams builds the general upstream configuration, client-specific ones,
-// and the private one, if any, from conf.  boot bootstraps the upstreams'
-// domain names.  conf and l must not be nil.
+// the routes along with their match lists, and the private configuration, if
+// any, from conf.  boot bootstraps the upstreams' domain names, unless a group
+// has its own bootstrap configuration, and falls are used by the groups without
+// their own fallback configuration.  The statistics of the upstreams are
+// reported to m.  conf, l, and m must not be nil.
 func newUpstreams(
 	conf *UpstreamConfig,
 	l *slog.Logger,
 	boot upstream.Resolver,
-) (ups upstreamConfigs, private *proxy.UpstreamConfig, err error) {
+	falls []upstream.Upstream,
+	m Metrics,
+) (set *upstreamSet, err error) {
 	defer func() { err = errors.Annotate(err, "creating upstreams: %w") }()
 
-	ups = upstreamConfigs{
-		// Init default group.
-		netip.Prefix{}: &proxy.UpstreamConfig{},
+	set = &upstreamSet{
+		configs: upstreamConfigs{
+			// Init default group.
+			netip.Prefix{}: &proxy.UpstreamConfig{},
+		},
+		lists: matchLists{},
+	}
+	b := &groupBuilder{
+		logger:    l,
+		boot:      boot,
+		metrics:   m,
+		cache:     map[upstreamKey]upstream.Upstream{},
+		fallbacks: falls,
+		timeout:   conf.Timeout,
 	}
-	upstreams := map[string]upstream.Upstream{}
 
 	var errs []error
 	for _, g := range conf.Groups {
-		opts := &upstream.Options{
-			Logger: l.With(
-				agdcslog.KeyUpstreamType, agdcslog.UpstreamTypeMain,
-				agdcslog.KeyUpstreamGroup, g.Name,
-			),
-			Timeout:   conf.Timeout,
-			Bootstrap: boot,
-		}
-
 		var u upstream.Upstream
-		u, err = newUpstreamOrCached(g.Address, upstreams, opts)
+		u, err = b.build(g)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("group %q: %w", g.Name, err))
 
 			continue
 		}
 
-		switch g.Name {
-		case agdc.UpstreamGroupNameDefault:
-			ups[netip.Prefix{}].Upstreams = append(ups[netip.Prefix{}].Upstreams, u)
-		case agdc.UpstreamGroupNamePrivate:
-			if private == nil {
-				private = &proxy.UpstreamConfig{}
-			}
-			private.Upstreams = append(private.Upstreams, u)
-		default:
-			g.addGroup(ups, u)
+		err = set.add(g, u)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("group %q: %w", g.Name, err))
+		}
+	}
+
+	set.closers = b.closers
+
+	return set, errors.Join(errs...)
+}
+
+// add adds u built for the group configured by ugc to s.  ugc must not be nil.
+func (s *upstreamSet) add(ugc *UpstreamGroupConfig, u upstream.Upstream) (err error) {
+	switch ugc.Name {
+	case agdc.UpstreamGroupNameDefault:
+		general := s.configs[netip.Prefix{}]
+		general.Upstreams = append(general.Upstreams, u)
+	case agdc.UpstreamGroupNamePrivate:
+		if s.private == nil {
+			s.private = &proxy.UpstreamConfig{}
+		}
+		s.private.Upstreams = append(s.private.Upstreams, u)
+	default:
+		ugc.addGroup(s.configs, u)
+
+		var routes []*route
+		routes, err = ugc.newRoutes(u, s.lists)
+		s.routes = append(s.routes, routes...)
+	}
+
+	return err
+}
+
+// upstreamKey is the key for the cache of upstreams created for the groups.
+// The upstreams with the same address are only shared between the groups with
+// the same options.
+type upstreamKey struct {
+	// bootstrap is the group-specific bootstrap configuration, if any.
+	bootstrap *BootstrapConfig
+
+	// addr is the address of the upstream.
+	addr string
+
+	// timeout is the timeout of the upstream.
+	timeout time.Duration
+}
+
+// groupBuilder creates the upstreams for the upstream groups.
+type groupBuilder struct {
+	// logger is the base logger for the upstreams.
+	logger *slog.Logger
+
+	// boot is the bootstrap for the groups without their own one.
+	boot upstream.Resolver
+
+	// metrics is used to report the statistics of the upstreams.
+	metrics Metrics
+
+	// cache contains the upstreams already created for the previous groups.
+	cache map[upstreamKey]upstream.Upstream
+
+	// fallbacks are the fallbacks for the groups without their own ones.
+	fallbacks []upstream.Upstream
+
+	// closers are the group-specific bootstraps and fallbacks.
+	closers []io.Closer
+
+	// timeout is the timeout for the groups without their own one.
+	timeout time.Duration
+}
+
+// build creates the upstream for the group configured by ugc.  The upstream
+// uses the fallbacks of the group, unless it's the private one, since the
+// proxy never uses fallbacks for private requests.  ugc must not be nil.
+func (b *groupBuilder) build(ugc *UpstreamGroupConfig) (u upstream.Upstream, err error) {
+	boot := b.boot
+	if ugc.Bootstrap != nil {
+		var bootClosers []io.Closer
+		boot, bootClosers, err = newResolvers(ugc.Bootstrap, b.logger, b.metrics)
+		b.closers = append(b.closers, bootClosers...)
+		if err != nil {
+			return nil, fmt.Errorf("bootstrap: %w", err)
+		}
+	}
+
+	timeout := b.timeout
+	if ugc.Timeout > 0 {
+		timeout = ugc.Timeout
+	}
+
+	opts := &upstream.Options{
+		Logger: b.logger.With(
+			agdcslog.KeyUpstreamType, agdcslog.UpstreamTypeMain,
+			agdcslog.KeyUpstreamGroup, ugc.Name,
+		),
+		Timeout:   timeout,
+		Bootstrap: boot,
+	}
+
+	u, err = ugc.newUpstream(b.cache, opts, b.metrics)
+	if err != nil || ugc.Name == agdc.UpstreamGroupNamePrivate {
+		return u, err
+	}
+
+	falls := b.fallbacks
+	if ugc.Fallback != nil {
+		var conf *proxy.UpstreamConfig
+		conf, err = newFallbacks(ugc.Fallback, b.logger, boot, b.metrics, ugc.Name)
+		if err != nil {
+			return nil, fmt.Errorf("fallback: %w", err)
 		}
+
+		b.closers = append(b.closers, conf)
+		falls = conf.Upstreams
 	}
 
-	return ups, private, errors.Join(errs...)
+	return &fallbackUpstream{
+		main:      u,
+		fallbacks: falls,
+	}, nil
 }
 
 // newUpstreamOrCached creates a new upstream or returns the cached one from
-// addrToUps.
+// cache.  bootstrap is the group-specific bootstrap configuration, if any.
 func newUpstreamOrCached(
 	addr string,
-	addrToUps map[string]upstream.Upstream,
+	bootstrap *BootstrapConfig,
+	cache map[upstreamKey]upstream.Upstream,
 	opts *upstream.Options,
 ) (u upstream.Upstream, err error) {
-	u, ok := addrToUps[addr]
+	key := upstreamKey{
+		bootstrap: bootstrap,
+		addr:      addr,
+		timeout:   opts.Timeout,
+	}
+
+	u, ok := cache[key]
 	if !ok {
 		u, err = upstream.AddressToUpstream(addr, opts)
 		if err != nil {
@@ -94,7 +236,7 @@ func newUpstreamOrCached(
 			return nil, err
 		}
 
-		addrToUps[addr] = u
+		cache[key] = u
 	}
 
 	return u, nil
@@ -102,14 +244,69 @@ func newUpstreamOrCached(
 
 // UpstreamGroupConfig is the configuration for a DNS upstream group.
 type UpstreamGroupConfig struct {
+	// Bootstrap is the group-specific configuration of the bootstrap servers
+	// resolving the hostnames of the group's upstreams and fallbacks.  If it's
+	// nil, the common bootstrap servers are used.
+	Bootstrap *BootstrapConfig
+
+	// Fallback is the group-specific configuration of the fallback servers.
+	// If it's nil, the common fallback servers are used.  If it contains no
+	// addresses, the requests routed to the group never use fallbacks.
+	Fallback *FallbackConfig
+
 	// Name is the name of the group.
 	Name agdc.UpstreamGroupName
 
-	// Address is the address of the server.  It should not be empty.
-	Address string
+	// Mode is the mode of using the upstreams of the group.  It's only used
+	// when there are several addresses.  Empty value means
+	// [UpstreamModeLoadBalance].
+	Mode UpstreamMode
+
+	// Addresses are the addresses of the servers.  It should contain at least
+	// one item, and the items should not be empty.
+	Addresses []string
 
 	// Match is the list of match criteria.
 	Match []MatchCriteria
+
+	// FilterLists are the names of the rule lists to filter the requests
+	// routed to the group with, see [FilteringConfig].
+	FilterLists []filter.ListName
+
+	// Timeout is the group-specific timeout for DNS requests.  Zero value
+	// means the common timeout of [UpstreamConfig].
+	Timeout time.Duration
+}
+
+// newUpstream creates an upstream exchanging requests with the servers of the
+// group according to its mode.  The upstreams created for the group's
+// addresses are cached in cache.  The statistics of each upstream are reported
+// to m.
+func (ugc *UpstreamGroupConfig) newUpstream(
+	cache map[upstreamKey]upstream.Upstream,
+	opts *upstream.Options,
+	m Metrics,
+) (u upstream.Upstream, err error) {
+	ups := make([]upstream.Upstream, 0, len(ugc.Addresses))
+	for _, addr := range ugc.Addresses {
+		u, err = newUpstreamOrCached(addr, ugc.Bootstrap, cache, opts)
+		if err != nil {
+			return nil, fmt.Errorf("address %q: %w", addr, err)
+		}
+
+		// Wrap the upstream for each group separately to distinguish the
+		// groups using the same address.
+		ups = append(ups, newObservedUpstream(u, m, agdcslog.UpstreamTypeMain, ugc.Name))
+	}
+
+	switch len(ups) {
+	case 0:
+		return nil, upstream.ErrNoUpstreams
+	case 1:
+		return ups[0], nil
+	default:
+		return newGroupUpstream(opts.Logger, ugc.Mode, ugc.Name, ups), nil
+	}
 }
 
 // MatchCriteria is the criteria for matching the upstream group to handle DNS
@@ -118,35 +315,95 @@ type MatchCriteria struct {
 	// Client is the prefix to match the client address.
 	Client netip.Prefix
 
-	// QuestionDomain is the suffix to match the question domain.
+	// QuestionDomain is the domain to match the question domain according to
+	// DomainMatch.
 	QuestionDomain string
+
+	// DomainFile is the path to the file containing the domains to match the
+	// question domain along with their subdomains.  If set, QuestionDomain
+	// and DomainMatch are ignored.  The file is re-read on
+	// [DNSService.Refresh] if it has changed.
+	DomainFile string
+
+	// ClientFile is the path to the file containing the IP addresses and
+	// subnets to match the client address.  If set, Client is ignored.  The
+	// file is re-read on [DNSService.Refresh] if it has changed.
+	ClientFile string
+
+	// QuestionType is the type of the question to match.  Zero value matches
+	// any type.
+	QuestionType uint16
+
+	// DomainMatch is the way QuestionDomain matches the question domain.
+	DomainMatch DomainMatch
+
+	// Exclude, if true, makes the criteria exclude the requests for
+	// QuestionDomain and its subdomains from the other criteria of the group
+	// with the same Client.  QuestionType and DomainMatch are ignored then.
+	Exclude bool
 }
 
-// addGroup adds u to the configuration of the corresponding client.
+// addGroup adds u to the configuration of the corresponding client for each
+// match not requiring a route.
 func (ugc *UpstreamGroupConfig) addGroup(configs upstreamConfigs, u upstream.Upstream) {
 	for _, m := range ugc.Match {
+		if m.isRouted() || (m.Exclude && !ugc.narrowsSubtree(m)) {
+			// The routed matches are chosen by the service itself, see
+			// [route], and so are the exclusions from those.
+			continue
+		}
+
 		conf := configs[m.Client]
 		if conf == nil {
 			conf = &proxy.UpstreamConfig{}
 			configs[m.Client] = conf
 		}
 
-		domain := m.QuestionDomain
-		if domain == "" {
+		domain := normalizeDomain(m.QuestionDomain)
+		switch {
+		case m.Exclude:
+			addDomainUpstream(conf, domain, nil)
+		case domain == "":
 			conf.Upstreams = append(conf.Upstreams, u)
-
-			continue
+		default:
+			addDomainUpstream(conf, domain, u)
 		}
+	}
+}
 
-		if conf.DomainReservedUpstreams == nil {
-			conf.DomainReservedUpstreams = map[string][]upstream.Upstream{}
-		}
-		if conf.SpecifiedDomainUpstreams == nil {
-			conf.SpecifiedDomainUpstreams = map[string][]upstream.Upstream{}
-		}
+// narrowsSubtree returns true if the domain of the exclusion excl is a
+// subdomain of the domain of a subtree match of ugc not requiring a route for
+// the same client subnet.
+func (ugc *UpstreamGroupConfig) narrowsSubtree(excl MatchCriteria) (ok bool) {
+	domain := normalizeDomain(excl.QuestionDomain)
+
+	return slices.ContainsFunc(ugc.Match, func(m MatchCriteria) (found bool) {
+		return m.Client == excl.Client &&
+			m.QuestionDomain != "" &&
+			!m.Exclude &&
+			!m.isRouted() &&
+			matchesDomain(domain, normalizeDomain(m.QuestionDomain), DomainMatchSubdomains)
+	})
+}
 
-		domain = dns.Fqdn(strings.ToLower(domain))
-		conf.DomainReservedUpstreams[domain] = append(conf.DomainReservedUpstreams[domain], u)
-		conf.SpecifiedDomainUpstreams[domain] = append(conf.SpecifiedDomainUpstreams[domain], u)
+// addDomainUpstream adds u to the upstreams reserved for domain within conf.
+// If u is nil, domain is excluded from the reserved ones, so that the default
+// upstreams of conf are used for it.  domain must be a lowercased FQDN.
+func addDomainUpstream(conf *proxy.UpstreamConfig, domain string, u upstream.Upstream) {
+	if conf.DomainReservedUpstreams == nil {
+		conf.DomainReservedUpstreams = map[string][]upstream.Upstream{}
 	}
+	if conf.SpecifiedDomainUpstreams == nil {
+		conf.SpecifiedDomainUpstreams = map[string][]upstream.Upstream{}
+	}
+
+	if u == nil {
+		conf.DomainReservedUpstreams[domain] = nil
+		conf.SpecifiedDomainUpstreams[domain] = nil
+
+		return
+	}
+
+	conf.DomainReservedUpstreams[domain] = append(conf.DomainReservedUpstreams[domain], u)
+	conf.SpecifiedDomainUpstreams[domain] = append(conf.SpecifiedDomainUpstreams[domain], u)
 }
@@ -0,0 +1,398 @@
+package dnssvc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// ZoneConfig is the configuration of a zone the service is authoritative for.
+type ZoneConfig struct {
+	// Name is the domain name of the zone's apex.  It's also used as the
+	// origin for the relative names within the file.  It must be a valid
+	// domain name.
+	Name string
+
+	// Path is the absolute path to the zone file in the RFC 1035 format.  The
+	// file is re-read by [DNSService.Refresh] when it changes.
+	Path string
+}
+
+// maxCNAMEChain is the maximum number of CNAME records followed within the
+// zones for a single request.
+const maxCNAMEChain = 8
+
+// ValidateZoneFile returns an error if the file at path can't be read or isn't
+// a valid zone file for the zone named name.
+func ValidateZoneFile(name, path string) (err error) {
+	_, err = readZone(normalizeDomain(name), path)
+
+	// Don't wrap the error, because it's informative enough as is.
+	return err
+}
+
+// zones is the set of zones the service is authoritative for.
+type zones map[string]*zone
+
+// newZones reads the zone files from confs and returns the zones indexed by
+// their lowercased FQDNs.
+func newZones(confs []*ZoneConfig) (zs zones, err error) {
+	zs = make(zones, len(confs))
+	for _, c := range confs {
+		z := &zone{
+			mu:   &sync.Mutex{},
+			name: normalizeDomain(c.Name),
+			path: c.Path,
+		}
+
+		z.modTime, err = zoneModTime(z.path)
+		if err != nil {
+			return nil, fmt.Errorf("zone %q: %w", c.Name, err)
+		}
+
+		var data *zoneData
+		data, err = readZone(z.name, z.path)
+		if err != nil {
+			return nil, fmt.Errorf("zone %q: %w", c.Name, err)
+		}
+
+		z.data.Store(data)
+		zs[z.name] = z
+	}
+
+	return zs, nil
+}
+
+// find returns the zone containing the lowercased FQDN name, if any.
+func (zs zones) find(name string) (z *zone) {
+	if len(zs) == 0 {
+		return nil
+	}
+
+	for d := name; d != ""; {
+		if z = zs[d]; z != nil {
+			return z
+		}
+
+		_, d, _ = strings.Cut(d, ".")
+	}
+
+	return nil
+}
+
+// answer sets the authoritative response to the request of dctx if its
+// question belongs to one of zs.  ok is true if the response is set.
+func (zs zones) answer(dctx *proxy.DNSContext) (ok bool) {
+	q := dctx.Req.Question[0]
+	z := zs.find(strings.ToLower(q.Name))
+	if z == nil {
+		return false
+	}
+
+	dctx.Res = z.data.Load().respond(dctx.Req)
+
+	return true
+}
+
+// refresh re-reads the files of zs modified since those were last read.  The
+// invalid files are reported, while the previous contents of those stay in
+// place until the files are modified again.
+func (zs zones) refresh(ctx context.Context, logger *slog.Logger) (err error) {
+	var errs []error
+	for _, z := range zs {
+		err = z.refresh(ctx, logger)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("zone %q: %w", z.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// zone is a single zone read from a file.
+type zone struct {
+	// data is the current content of the zone.  It's never nil.
+	data atomic.Pointer[zoneData]
+
+	// mu serializes the refreshes and protects modTime.
+	mu *sync.Mutex
+
+	// modTime is the modification time of the file at the time it was last
+	// read.
+	modTime time.Time
+
+	// name is the lowercased FQDN of the zone's apex.
+	name string
+
+	// path is the path to the zone file.
+	path string
+}
+
+// refresh re-reads the file of z if it has been modified since it was last
+// read.
+func (z *zone) refresh(ctx context.Context, logger *slog.Logger) (err error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	modTime, err := zoneModTime(z.path)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if modTime.Equal(z.modTime) {
+		return nil
+	}
+
+	// Remember the modification time before reading the file to not miss the
+	// changes made during the reading, and to not read an invalid file again
+	// until it's changed.
+	z.modTime = modTime
+
+	data, err := readZone(z.name, z.path)
+	if err != nil {
+		return fmt.Errorf("keeping previous zone: %w", err)
+	}
+
+	z.data.Store(data)
+
+	logger.InfoContext(ctx, "zone reloaded", "zone", z.name, "path", z.path)
+
+	return nil
+}
+
+// zoneModTime returns the modification time of the file at path.
+func zoneModTime(path string) (modTime time.Time, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("checking zone file: %w", err)
+	}
+
+	return fi.ModTime(), nil
+}
+
+// zoneData is the parsed content of a zone file.
+type zoneData struct {
+	// soa is the SOA record of the zone.
+	soa *dns.SOA
+
+	// records maps the lowercased owner names to their records.
+	records map[string][]dns.RR
+
+	// names are the lowercased names existing in the zone, including the empty
+	// non-terminals.
+	names *container.MapSet[string]
+
+	// origin is the lowercased FQDN of the zone's apex.
+	origin string
+}
+
+// readZone reads and parses the zone file at path for the zone with the
+// lowercased FQDN origin.
+func readZone(origin, path string) (data *zoneData, err error) {
+	// #nosec G304 -- Trust the path to the zone file that is set in the
+	// configuration file.
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening zone file: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	return parseZone(f, origin, path)
+}
+
+// parseZone parses the zone with the lowercased FQDN origin from r.  path is
+// used to report the positions of the errors.  The zone must have a single SOA
+// record at its apex, all the records must be within the zone, and the names
+// having a CNAME record must have no other records.
+func parseZone(r io.Reader, origin, path string) (data *zoneData, err error) {
+	data = &zoneData{
+		records: map[string][]dns.RR{},
+		names:   container.NewMapSet[string](),
+		origin:  origin,
+	}
+
+	var errs []error
+	zp := dns.NewZoneParser(r, origin, path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		err = data.add(rr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: record %q: %w", path, rr.Header().Name, err))
+		}
+	}
+
+	err = zp.Err()
+	if err != nil {
+		errs = append(errs, err)
+	} else if data.soa == nil {
+		errs = append(errs, fmt.Errorf("%s: no soa record for %q", path, origin))
+	}
+
+	errs = append(errs, data.validateCNAMEs(path)...)
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return data, nil
+}
+
+// add validates rr and adds it to data.
+func (data *zoneData) add(rr dns.RR) (err error) {
+	hdr := rr.Header()
+	owner := strings.ToLower(hdr.Name)
+	if !dns.IsSubDomain(data.origin, owner) {
+		return fmt.Errorf("out of zone %q", data.origin)
+	}
+
+	if soa, ok := rr.(*dns.SOA); ok {
+		if owner != data.origin {
+			return fmt.Errorf("soa record must be at the apex of zone %q", data.origin)
+		} else if data.soa != nil {
+			return fmt.Errorf("soa record: %w", errors.ErrDuplicated)
+		}
+
+		data.soa = soa
+	}
+
+	data.records[owner] = append(data.records[owner], rr)
+
+	// Add the name along with its empty non-terminal ancestors.
+	for d := owner; d != data.origin; _, d, _ = strings.Cut(d, ".") {
+		data.names.Add(d)
+	}
+	data.names.Add(data.origin)
+
+	return nil
+}
+
+// validateCNAMEs returns the errors for the names of data having a CNAME
+// record along with other records.  path is used to report the errors.
+func (data *zoneData) validateCNAMEs(path string) (errs []error) {
+	for owner, rrs := range data.records {
+		if len(rrs) > 1 && hasType(rrs, dns.TypeCNAME) {
+			errs = append(errs, fmt.Errorf("%s: record %q: cname along with other records", path, owner))
+		}
+	}
+
+	return errs
+}
+
+// hasType returns true if rrs contain a record of qtype.
+func hasType(rrs []dns.RR, qtype uint16) (ok bool) {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype {
+			return true
+		}
+	}
+
+	return false
+}
+
+// respond returns the authoritative response to req, which must have a
+// question within data.
+func (data *zoneData) respond(req *dns.Msg) (resp *dns.Msg) {
+	resp = (&dns.Msg{}).SetReply(req)
+	resp.Authoritative = true
+	resp.RecursionAvailable = true
+
+	q := req.Question[0]
+	name := q.Name
+	for range maxCNAMEChain {
+		rrs, exists := data.lookup(name)
+		if !exists {
+			resp.Rcode = dns.RcodeNameError
+			resp.Ns = append(resp.Ns, data.negativeSOA())
+
+			return resp
+		}
+
+		cname := cnameOf(rrs)
+		if cname == nil || q.Qtype == dns.TypeCNAME {
+			data.appendAnswers(resp, rrs, q.Qtype)
+
+			return resp
+		}
+
+		resp.Answer = append(resp.Answer, cname)
+
+		name = cname.Target
+		if !dns.IsSubDomain(data.origin, strings.ToLower(name)) {
+			// Let the client resolve the target out of the zone.
+			return resp
+		}
+	}
+
+	return resp
+}
+
+// lookup returns the records of name, synthesizing them from the wildcard
+// record, if there is one at the closest encloser of the missing name.  exists
+// is false if there is no such name within data.
+func (data *zoneData) lookup(name string) (rrs []dns.RR, exists bool) {
+	lowered := strings.ToLower(name)
+	if data.names.Has(lowered) {
+		return data.records[lowered], true
+	}
+
+	for _, enc, _ := strings.Cut(lowered, "."); enc != ""; _, enc, _ = strings.Cut(enc, ".") {
+		if !data.names.Has(enc) {
+			continue
+		}
+
+		wildcard := data.records["*."+enc]
+		for _, rr := range wildcard {
+			rr = dns.Copy(rr)
+			rr.Header().Name = name
+			rrs = append(rrs, rr)
+		}
+
+		return rrs, len(rrs) > 0
+	}
+
+	return nil, false
+}
+
+// appendAnswers appends the records of qtype from rrs to resp or, if there are
+// none, the SOA record for the negative response.
+func (data *zoneData) appendAnswers(resp *dns.Msg, rrs []dns.RR, qtype uint16) {
+	n := len(resp.Answer)
+	for _, rr := range rrs {
+		if qtype == dns.TypeANY || rr.Header().Rrtype == qtype {
+			resp.Answer = append(resp.Answer, rr)
+		}
+	}
+
+	if len(resp.Answer) == n {
+		resp.Ns = append(resp.Ns, data.negativeSOA())
+	}
+}
+
+// negativeSOA returns the SOA record for the negative responses with the TTL
+// set according to RFC 2308.
+func (data *zoneData) negativeSOA() (soa *dns.SOA) {
+	soa = dns.Copy(data.soa).(*dns.SOA)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+
+	return soa
+}
+
+// cnameOf returns the CNAME record from rrs, if any.
+func cnameOf(rrs []dns.RR) (cname *dns.CNAME) {
+	for _, rr := range rrs {
+		if c, ok := rr.(*dns.CNAME); ok {
+			return c
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package dnssvc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testZone is the content of the zone file for tests.
+const testZone = `$TTL 3600
+@        IN SOA   ns.lab.internal. admin.lab.internal. 1 3600 600 86400 300
+@        IN NS    ns
+@        IN MX    10 mail
+ns       IN A     192.168.10.1
+mail     IN A     192.168.10.2
+www      IN CNAME web.lab.internal.
+web      IN A     192.168.10.3
+ext      IN CNAME example.com.
+_ldap._tcp IN SRV 0 0 389 ldap
+ldap     IN A     192.168.10.4
+ldap     IN TXT   "v=ldap"
+*.dev    IN A     192.168.10.5
+`
+
+func TestParseZone(t *testing.T) {
+	t.Parallel()
+
+	const (
+		origin = "lab.internal."
+		path   = "lab.zone"
+	)
+
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+
+		data, err := parseZone(strings.NewReader(testZone), origin, path)
+		require.NoError(t, err)
+
+		assert.Equal(t, uint32(300), data.negativeSOA().Hdr.Ttl)
+		assert.True(t, data.names.Has("_tcp.lab.internal."))
+		assert.True(t, data.names.Has("dev.lab.internal."))
+	})
+
+	testCases := []struct {
+		name       string
+		content    string
+		wantErrMsg string
+	}{{
+		name:       "no_soa",
+		content:    "$TTL 60\nwww IN A 192.0.2.1\n",
+		wantErrMsg: `lab.zone: no soa record for "lab.internal."`,
+	}, {
+		name: "out_of_zone",
+		content: "$TTL 60\n@ IN SOA ns admin 1 1 1 1 1\n" +
+			"www.example.com. IN A 192.0.2.1\n",
+		wantErrMsg: `lab.zone: record "www.example.com.": out of zone "lab.internal."`,
+	}, {
+		name: "soa_not_at_apex",
+		content: "$TTL 60\n@ IN SOA ns admin 1 1 1 1 1\n" +
+			"sub IN SOA ns admin 1 1 1 1 1\n",
+		wantErrMsg: `lab.zone: record "sub.lab.internal.": ` +
+			`soa record must be at the apex of zone "lab.internal."`,
+	}, {
+		name: "cname_and_other",
+		content: "$TTL 60\n@ IN SOA ns admin 1 1 1 1 1\n" +
+			"www IN CNAME web\nwww IN TXT \"text\"\n",
+		wantErrMsg: `lab.zone: record "www.lab.internal.": cname along with other records`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := parseZone(strings.NewReader(tc.content), origin, path)
+			require.Error(t, err)
+
+			assert.Equal(t, tc.wantErrMsg, err.Error())
+		})
+	}
+}
+
+func TestZoneData_respond(t *testing.T) {
+	t.Parallel()
+
+	data, err := parseZone(strings.NewReader(testZone), "lab.internal.", "lab.zone")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantAnswer []string
+		wantRcode  int
+		qtype      uint16
+		wantSOA    bool
+	}{{
+		name:       "a",
+		host:       "mail.lab.internal.",
+		wantAnswer: []string{"mail.lab.internal.\t3600\tIN\tA\t192.168.10.2"},
+		wantRcode:  dns.RcodeSuccess,
+		qtype:      dns.TypeA,
+		wantSOA:    false,
+	}, {
+		name:       "mx",
+		host:       "LAB.internal.",
+		wantAnswer: []string{"lab.internal.\t3600\tIN\tMX\t10 mail.lab.internal."},
+		wantRcode:  dns.RcodeSuccess,
+		qtype:      dns.TypeMX,
+		wantSOA:    false,
+	}, {
+		name:       "srv",
+		host:       "_ldap._tcp.lab.internal.",
+		wantAnswer: []string{"_ldap._tcp.lab.internal.\t3600\tIN\tSRV\t0 0 389 ldap.lab.internal."},
+		wantRcode:  dns.RcodeSuccess,
+		qtype:      dns.TypeSRV,
+		wantSOA:    false,
+	}, {
+		name:       "txt",
+		host:       "ldap.lab.internal.",
+		wantAnswer: []string{"ldap.lab.internal.\t3600\tIN\tTXT\t\"v=ldap\""},
+		wantRcode:  dns.RcodeSuccess,
+		qtype:      dns.TypeTXT,
+		wantSOA:    false,
+	}, {
+		name: "cname",
+		host: "www.lab.internal.",
+		wantAnswer: []string{
+			"www.lab.internal.\t3600\tIN\tCNAME\tweb.lab.internal.",
+			"web.lab.internal.\t3600\tIN\tA\t192.168.10.3",
+		},
+		wantRcode: dns.RcodeSuccess,
+		qtype:     dns.TypeA,
+		wantSOA:   false,
+	}, {
+		name:       "cname_out_of_zone",
+		host:       "ext.lab.internal.",
+		wantAnswer: []string{"ext.lab.internal.\t3600\tIN\tCNAME\texample.com."},
+		wantRcode:  dns.RcodeSuccess,
+		qtype:      dns.TypeA,
+		wantSOA:    false,
+	}, {
+		name:       "wildcard",
+		host:       "box.dev.lab.internal.",
+		wantAnswer: []string{"box.dev.lab.internal.\t3600\tIN\tA\t192.168.10.5"},
+		wantRcode:  dns.RcodeSuccess,
+		qtype:      dns.TypeA,
+		wantSOA:    false,
+	}, {
+		name:       "nodata",
+		host:       "mail.lab.internal.",
+		wantAnswer: nil,
+		wantRcode:  dns.RcodeSuccess,
+		qtype:      dns.TypeAAAA,
+		wantSOA:    true,
+	}, {
+		name:       "empty_non_terminal",
+		host:       "_tcp.lab.internal.",
+		wantAnswer: nil,
+		wantRcode:  dns.RcodeSuccess,
+		qtype:      dns.TypeA,
+		wantSOA:    true,
+	}, {
+		name:       "nxdomain",
+		host:       "missing.lab.internal.",
+		wantAnswer: nil,
+		wantRcode:  dns.RcodeNameError,
+		qtype:      dns.TypeA,
+		wantSOA:    true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := data.respond((&dns.Msg{}).SetQuestion(tc.host, tc.qtype))
+			require.Equal(t, tc.wantRcode, resp.Rcode)
+
+			assert.True(t, resp.Authoritative)
+
+			var answer []string
+			for _, rr := range resp.Answer {
+				answer = append(answer, rr.String())
+			}
+
+			assert.Equal(t, tc.wantAnswer, answer)
+
+			if tc.wantSOA {
+				require.Len(t, resp.Ns, 1)
+
+				soa, ok := resp.Ns[0].(*dns.SOA)
+				require.True(t, ok)
+
+				assert.Equal(t, uint32(300), soa.Hdr.Ttl)
+			} else {
+				assert.Empty(t, resp.Ns)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Package filter contains the filtering engine of AdGuardDNSClient, which
+// matches the requested domains against the rule lists in the hosts format and
+// the AdGuard syntax.
+package filter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+)
+
+// ListName is the name of a rule list.
+type ListName string
+
+// ListConfig is the configuration of a single rule list.
+type ListConfig struct {
+	// URL is the HTTP or HTTPS URL to download the list from.  It's nil if the
+	// list is read from Path.
+	URL *url.URL
+
+	// Path is the absolute path to the file containing the list.  It's empty
+	// if the list is downloaded from URL.
+	Path string
+}
+
+// Config is the configuration for [Filter].
+type Config struct {
+	// Logger is used to log the loading of the lists.  It must not be nil.
+	Logger *slog.Logger
+
+	// Lists are the rule lists by their names.
+	Lists map[ListName]*ListConfig
+
+	// Timeout is the timeout for downloading a list.  Zero value disables the
+	// timeout.
+	Timeout time.Duration
+
+	// RefreshInterval is the interval between the downloads of a list from
+	// its URL.
+	RefreshInterval time.Duration
+
+	// CheckOnly, if true, makes [New] only read the lists from the files
+	// without downloading the ones from the URLs, which are left empty.
+	CheckOnly bool
+}
+
+// Filter matches the requested domains against the rule lists.
+type Filter struct {
+	// logger is used to log the loading of the lists.
+	logger *slog.Logger
+
+	// lists are the loaded rule lists by their names.
+	lists map[ListName]*list
+}
+
+// New returns a new *Filter with the lists from conf loaded.  The lists read
+// from the files must be valid, while the ones failed to download are logged
+// and left empty until they're downloaded by [Filter.Refresh].  conf must not
+// be nil.
+func New(ctx context.Context, conf *Config) (f *Filter, err error) {
+	f = &Filter{
+		logger: conf.Logger.With(slogutil.KeyPrefix, "filter"),
+		lists:  make(map[ListName]*list, len(conf.Lists)),
+	}
+
+	cli := &http.Client{
+		Timeout: conf.Timeout,
+	}
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(conf.Lists)) {
+		lc := conf.Lists[name]
+		l := newList(name, lc, cli, conf.RefreshInterval)
+		if conf.CheckOnly && lc.URL != nil {
+			f.lists[name] = l
+
+			continue
+		}
+
+		err = l.load(ctx, f.logger)
+		if err != nil && lc.URL == nil {
+			errs = append(errs, fmt.Errorf("list %q: %w", name, err))
+
+			continue
+		} else if err != nil {
+			f.logger.ErrorContext(ctx, "loading list", "list", name, slogutil.KeyError, err)
+		}
+
+		f.lists[name] = l
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return f, nil
+}
+
+// Result is the result of matching a request against the rule lists.
+type Result struct {
+	// List is the name of the list containing Rule.
+	List ListName
+
+	// Rule is the text of the rule matching the request.
+	Rule string
+
+	// Blocked is true if the request should be blocked, and false if it's
+	// allowed by an exception rule.
+	Blocked bool
+}
+
+// Match returns the result of matching host against the lists named names.
+// The exception rules take precedence over the blocking ones, and the rules
+// with the important modifier take precedence over the ones without it.  res
+// is nil if no rule matches host.  f must not be nil.
+func (f *Filter) Match(host string, names []ListName) (res *Result) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	var best *rule
+	var bestList ListName
+	for _, name := range names {
+		l := f.lists[name]
+		if l == nil {
+			continue
+		}
+
+		r := l.rules.Load().match(host)
+		if r != nil && (best == nil || r.priority() > best.priority()) {
+			best, bestList = r, name
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	return &Result{
+		List:    bestList,
+		Rule:    best.text,
+		Blocked: !best.allow,
+	}
+}
+
+// Refresh re-reads the lists from the files modified since those were last
+// read and re-downloads the lists due to refresh, see [Filter.NextRefresh].
+// The previous contents of the lists failed to update stay in place.  f must
+// not be nil.
+func (f *Filter) Refresh(ctx context.Context) (err error) {
+	var errs []error
+	for name, l := range f.lists {
+		err = l.refresh(ctx, f.logger)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("list %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// NextRefresh returns the earliest time of the next download among the lists
+// downloaded from the URLs.  next is zero if there are no such lists.  f must
+// not be nil.
+func (f *Filter) NextRefresh() (next time.Time) {
+	for _, l := range f.lists {
+		n, ok := l.nextDownload()
+		if ok && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+
+	return next
+}
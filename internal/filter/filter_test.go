@@ -0,0 +1,238 @@
+package filter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/filter"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is a timeout for tests.
+const testTimeout = 1 * time.Second
+
+// Names of the lists for tests.
+const (
+	fileListName filter.ListName = "file"
+	urlListName  filter.ListName = "url"
+)
+
+// newTestServer starts an HTTP server serving the content of body for tests.
+func newTestServer(t *testing.T, body *atomic.Pointer[string]) (u *url.URL) {
+	t.Helper()
+
+	pt := testutil.PanicT{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, err := w.Write([]byte(*body.Load()))
+		require.NoError(pt, err)
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	return u
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	listPath := filepath.Join(t.TempDir(), "list.txt")
+	require.NoError(t, os.WriteFile(listPath, []byte("0.0.0.0 file.example\n"), 0o600))
+
+	content := "||url.example^\n@@||file.example^\n"
+	body := &atomic.Pointer[string]{}
+	body.Store(&content)
+
+	f, err := filter.New(testutil.ContextWithTimeout(t, testTimeout), &filter.Config{
+		Logger: slogutil.NewDiscardLogger(),
+		Lists: map[filter.ListName]*filter.ListConfig{
+			fileListName: {
+				Path: listPath,
+			},
+			urlListName: {
+				URL: newTestServer(t, body),
+			},
+		},
+		Timeout:         testTimeout,
+		RefreshInterval: 0,
+	})
+	require.NoError(t, err)
+
+	both := []filter.ListName{fileListName, urlListName}
+
+	assert.Equal(t, &filter.Result{
+		List:    urlListName,
+		Rule:    "||url.example^",
+		Blocked: true,
+	}, f.Match("WWW.URL.EXAMPLE.", both))
+
+	assert.Equal(t, &filter.Result{
+		List:    urlListName,
+		Rule:    "@@||file.example^",
+		Blocked: false,
+	}, f.Match("file.example.", both))
+
+	assert.Equal(t, &filter.Result{
+		List:    fileListName,
+		Rule:    "0.0.0.0 file.example",
+		Blocked: true,
+	}, f.Match("file.example.", []filter.ListName{fileListName}))
+
+	assert.Nil(t, f.Match("url.example.", []filter.ListName{fileListName}))
+	assert.Nil(t, f.Match("other.example.", both))
+
+	// Set the modification time explicitly, since its resolution may be too
+	// coarse to notice the change.
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.WriteFile(listPath, []byte("||other.example^\n"), 0o600))
+	require.NoError(t, os.Chtimes(listPath, modTime, modTime))
+	content = ""
+	body.Store(&content)
+
+	require.NoError(t, f.Refresh(testutil.ContextWithTimeout(t, testTimeout)))
+
+	assert.Nil(t, f.Match("url.example.", both))
+	assert.Nil(t, f.Match("file.example.", both))
+	assert.Equal(t, &filter.Result{
+		List:    fileListName,
+		Rule:    "||other.example^",
+		Blocked: true,
+	}, f.Match("other.example.", both))
+}
+
+func TestNew_badFile(t *testing.T) {
+	t.Parallel()
+
+	listPath := filepath.Join(t.TempDir(), "missing.txt")
+
+	_, err := filter.New(testutil.ContextWithTimeout(t, testTimeout), &filter.Config{
+		Logger: slogutil.NewDiscardLogger(),
+		Lists: map[filter.ListName]*filter.ListConfig{
+			fileListName: {
+				Path: listPath,
+			},
+		},
+		Timeout:         testTimeout,
+		RefreshInterval: time.Hour,
+	})
+	testutil.AssertErrorMsg(
+		t,
+		`list "file": checking list file: stat `+listPath+`: no such file or directory`,
+		err,
+	)
+}
+
+func TestFilter_NextRefresh(t *testing.T) {
+	t.Parallel()
+
+	const refreshIvl = time.Hour
+
+	content := "||url.example^\n"
+	body := &atomic.Pointer[string]{}
+	body.Store(&content)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+
+	failingURL, err := url.Parse(failing.URL)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		url     *url.URL
+		name    string
+		wantIvl time.Duration
+	}{{
+		url:     newTestServer(t, body),
+		name:    "downloaded",
+		wantIvl: refreshIvl,
+	}, {
+		url:     failingURL,
+		name:    "failed",
+		wantIvl: time.Minute,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			start := time.Now()
+			f, newErr := filter.New(testutil.ContextWithTimeout(t, testTimeout), &filter.Config{
+				Logger: slogutil.NewDiscardLogger(),
+				Lists: map[filter.ListName]*filter.ListConfig{
+					urlListName: {
+						URL: tc.url,
+					},
+				},
+				Timeout:         testTimeout,
+				RefreshInterval: refreshIvl,
+			})
+			require.NoError(t, newErr)
+
+			next := f.NextRefresh()
+			assert.False(t, next.Before(start.Add(tc.wantIvl)))
+			assert.False(t, next.After(time.Now().Add(tc.wantIvl)))
+		})
+	}
+
+	t.Run("files_only", func(t *testing.T) {
+		t.Parallel()
+
+		listPath := filepath.Join(t.TempDir(), "list.txt")
+		require.NoError(t, os.WriteFile(listPath, []byte("||file.example^\n"), 0o600))
+
+		f, newErr := filter.New(testutil.ContextWithTimeout(t, testTimeout), &filter.Config{
+			Logger: slogutil.NewDiscardLogger(),
+			Lists: map[filter.ListName]*filter.ListConfig{
+				fileListName: {
+					Path: listPath,
+				},
+			},
+			Timeout:         testTimeout,
+			RefreshInterval: refreshIvl,
+		})
+		require.NoError(t, newErr)
+
+		assert.True(t, f.NextRefresh().IsZero())
+	})
+}
+
+func TestNew_checkOnly(t *testing.T) {
+	t.Parallel()
+
+	var reqNum atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		reqNum.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	_, err = filter.New(testutil.ContextWithTimeout(t, testTimeout), &filter.Config{
+		Logger: slogutil.NewDiscardLogger(),
+		Lists: map[filter.ListName]*filter.ListConfig{
+			urlListName: {
+				URL: u,
+			},
+		},
+		Timeout:         testTimeout,
+		RefreshInterval: time.Hour,
+		CheckOnly:       true,
+	})
+	require.NoError(t, err)
+
+	assert.Zero(t, reqNum.Load())
+}
@@ -0,0 +1,217 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/ioutil"
+)
+
+// maxListSize is the maximum size of a downloaded list in bytes.
+const maxListSize uint64 = 64 * 1024 * 1024
+
+// downloadRetryIvl is the maximum interval between the attempts to download a
+// list after a failed one.
+const downloadRetryIvl = 1 * time.Minute
+
+// list is a single rule list read from a file or downloaded from a URL.
+type list struct {
+	// rules are the current rules of the list.  It's never nil.
+	rules atomic.Pointer[ruleSet]
+
+	// client is used to download the list.
+	client *http.Client
+
+	// url is the URL to download the list from, if any.
+	url *url.URL
+
+	// mu serializes the updates and protects updated and next.
+	mu *sync.Mutex
+
+	// updated is the modification time of the file at the time it was last
+	// read.
+	updated time.Time
+
+	// next is the time of the next download of the list from its URL.
+	next time.Time
+
+	// name is the name of the list.
+	name ListName
+
+	// path is the path to the file with the list, if any.
+	path string
+
+	// refreshInterval is the interval between the successful downloads.
+	refreshInterval time.Duration
+}
+
+// newList returns a new empty *list configured by conf.  cli is used to
+// download the list, if it has a URL.
+func newList(
+	name ListName,
+	conf *ListConfig,
+	cli *http.Client,
+	refreshInterval time.Duration,
+) (l *list) {
+	l = &list{
+		client:          cli,
+		url:             conf.URL,
+		mu:              &sync.Mutex{},
+		name:            name,
+		path:            conf.Path,
+		refreshInterval: refreshInterval,
+	}
+	l.rules.Store(&ruleSet{})
+
+	return l
+}
+
+// load reads or downloads the rules of l and replaces the current ones.
+func (l *list) load(ctx context.Context, logger *slog.Logger) (err error) {
+	var rs *ruleSet
+	if l.url != nil {
+		rs, err = l.download(ctx)
+	} else {
+		rs, err = l.read()
+	}
+
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	l.rules.Store(rs)
+
+	logger.InfoContext(
+		ctx,
+		"list loaded",
+		"list", l.name,
+		"rules", rs.count,
+		"skipped", rs.skipped,
+	)
+
+	return nil
+}
+
+// refresh reloads the rules of l if the file has been modified since it was
+// last read or the last download happened earlier than the refresh interval
+// ago.
+func (l *list) refresh(ctx context.Context, logger *slog.Logger) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.url != nil {
+		if time.Now().Before(l.next) {
+			return nil
+		}
+	} else {
+		var modTime time.Time
+		modTime, err = fileModTime(l.path)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		} else if modTime.Equal(l.updated) {
+			return nil
+		}
+	}
+
+	err = l.load(ctx, logger)
+	if err != nil {
+		return fmt.Errorf("keeping previous rules: %w", err)
+	}
+
+	return nil
+}
+
+// nextDownload returns the time of the next download of l.  ok is false if l
+// isn't downloaded.
+func (l *list) nextDownload() (next time.Time, ok bool) {
+	if l.url == nil {
+		return time.Time{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.next, true
+}
+
+// read reads the rules from the file of l.  It remembers the modification time
+// of the file before reading it to not miss the changes made during the
+// reading, and to not read an invalid file again until it's changed.
+func (l *list) read() (rs *ruleSet, err error) {
+	l.updated, err = fileModTime(l.path)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	// #nosec G304 -- Trust the path to the list file that is set in the
+	// configuration file.
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("opening list file: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	rs, err = parseRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading list file: %w", err)
+	}
+
+	return rs, nil
+}
+
+// download downloads the rules from the URL of l and schedules the next
+// download.  The failed downloads are retried earlier than the refresh
+// interval, but no more often than [downloadRetryIvl].
+func (l *list) download(ctx context.Context) (rs *ruleSet, err error) {
+	defer func() {
+		ivl := l.refreshInterval
+		if err != nil {
+			ivl = min(ivl, downloadRetryIvl)
+		}
+
+		l.next = time.Now().Add(ivl)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("downloading list: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading list: unexpected status code %d", resp.StatusCode)
+	}
+
+	rs, err = parseRules(ioutil.LimitReader(resp.Body, maxListSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading list: %w", err)
+	}
+
+	return rs, nil
+}
+
+// fileModTime returns the modification time of the file at path.
+func fileModTime(path string) (modTime time.Time, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("checking list file: %w", err)
+	}
+
+	return fi.ModTime(), nil
+}
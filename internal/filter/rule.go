@@ -0,0 +1,238 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"net/netip"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// rule is a single parsed filtering rule.
+type rule struct {
+	// text is the original text of the rule.
+	text string
+
+	// allow is true for the exception rules, which unblock the domains.
+	allow bool
+
+	// important is true for the rules with the important modifier, which take
+	// precedence over the rules without it.
+	important bool
+
+	// exact is true if the rule only matches the domain itself and not its
+	// subdomains.
+	exact bool
+}
+
+// priority returns the priority of r among the rules matching the same
+// request.  The important exceptions have the highest priority, then go the
+// important blocking rules, the exceptions, and the blocking rules.
+func (r *rule) priority() (p int) {
+	if r.important {
+		p += 2
+	}
+
+	if r.allow {
+		p++
+	}
+
+	return p
+}
+
+// ruleSet is a set of the rules of a single list indexed by the domains they
+// match.
+type ruleSet struct {
+	// domains maps the lowercased domains without the trailing dot to the
+	// rules matching those.
+	domains map[string][]*rule
+
+	// count is the number of the rules in the set.
+	count int
+
+	// skipped is the number of the lines, which look like rules, but aren't
+	// supported.
+	skipped int
+}
+
+// Special characters and prefixes of the AdGuard rule syntax.
+const (
+	commentPrefix      = "!"
+	hostsCommentPrefix = "#"
+	exceptionPrefix    = "@@"
+	domainPrefix       = "||"
+	exactPrefix        = "|"
+	separatorSuffix    = "^"
+	startSuffix        = "^|"
+	modifiersSep       = "$"
+	modifierImportant  = "important"
+)
+
+// parseRules parses the rules in the hosts format and the AdGuard syntax from
+// r.  The unsupported rules are skipped.
+func parseRules(r io.Reader) (rs *ruleSet, err error) {
+	rs = &ruleSet{
+		domains: map[string][]*rule{},
+	}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if isComment(line) {
+			continue
+		}
+
+		domains, rl := parseLine(line)
+		if rl == nil {
+			rs.skipped++
+
+			continue
+		}
+
+		for _, d := range domains {
+			rs.domains[d] = append(rs.domains[d], rl)
+		}
+
+		rs.count++
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return rs, s.Err()
+}
+
+// isComment returns true if line is empty or a comment.
+func isComment(line string) (ok bool) {
+	return line == "" ||
+		strings.HasPrefix(line, commentPrefix) ||
+		strings.HasPrefix(line, hostsCommentPrefix)
+}
+
+// parseLine parses a single non-comment line and returns the rule and the
+// lowercased domains it applies to.  rl is nil if the line isn't a supported
+// rule.
+func parseLine(line string) (domains []string, rl *rule) {
+	fields := strings.Fields(line)
+	if len(fields) > 1 {
+		return parseHostsLine(line, fields)
+	}
+
+	rl = &rule{
+		text: line,
+	}
+
+	pattern, ok := strings.CutPrefix(line, exceptionPrefix)
+	rl.allow = ok
+
+	pattern, modifiers, hasModifiers := strings.Cut(pattern, modifiersSep)
+	if hasModifiers {
+		if modifiers != modifierImportant {
+			return nil, nil
+		}
+
+		rl.important = true
+	}
+
+	domain, ok := parsePattern(pattern, rl)
+	if !ok {
+		return nil, nil
+	}
+
+	return []string{domain}, rl
+}
+
+// parsePattern parses the pattern of an AdGuard-syntax rule and sets the way
+// it matches to rl.  ok is false if the pattern isn't supported.
+func parsePattern(pattern string, rl *rule) (domain string, ok bool) {
+	if p, cut := strings.CutPrefix(pattern, domainPrefix); cut {
+		domain, ok = cutSeparator(p)
+	} else if p, cut = strings.CutPrefix(pattern, exactPrefix); cut {
+		domain, ok = cutSeparator(p)
+		rl.exact = true
+	} else {
+		// A plain domain name, like in the lists of domains, matches the
+		// domain and all its subdomains.
+		domain, ok = pattern, true
+	}
+
+	if !ok || !isValidDomain(domain) {
+		return "", false
+	}
+
+	return strings.ToLower(domain), true
+}
+
+// cutSeparator removes the separator character from the end of the pattern p.
+// ok is false if p doesn't end with the separator.
+func cutSeparator(p string) (domain string, ok bool) {
+	if domain, ok = strings.CutSuffix(p, startSuffix); ok {
+		return domain, true
+	}
+
+	return strings.CutSuffix(p, separatorSuffix)
+}
+
+// parseHostsLine parses line in the hosts format with the fields split.  Each
+// hostname of the line only matches itself.  The IP address of the line is
+// ignored, since the blocked requests are answered according to the
+// configuration.
+func parseHostsLine(line string, fields []string) (domains []string, rl *rule) {
+	if _, err := netip.ParseAddr(fields[0]); err != nil {
+		return nil, nil
+	}
+
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, hostsCommentPrefix) {
+			break
+		}
+
+		if !isValidDomain(f) {
+			continue
+		}
+
+		domains = append(domains, strings.ToLower(f))
+	}
+
+	if len(domains) == 0 {
+		return nil, nil
+	}
+
+	return domains, &rule{
+		text:  line,
+		exact: true,
+	}
+}
+
+// match returns the rule of rs with the highest priority matching the
+// lowercased host without the trailing dot, if any.
+func (rs *ruleSet) match(host string) (best *rule) {
+	for d, isHost := host, true; ; isHost = false {
+		for _, r := range rs.domains[d] {
+			if isHost || !r.exact {
+				best = higher(best, r)
+			}
+		}
+
+		var ok bool
+		_, d, ok = strings.Cut(d, ".")
+		if !ok {
+			return best
+		}
+	}
+}
+
+// higher returns the rule with the higher priority of a and b, preferring a
+// for the same priority.  a may be nil.
+func higher(a, b *rule) (r *rule) {
+	if a == nil || b.priority() > a.priority() {
+		return b
+	}
+
+	return a
+}
+
+// isValidDomain returns true if s is a valid domain name, which is not an IP
+// address.
+func isValidDomain(s string) (ok bool) {
+	return netutil.ValidateDomainName(s) == nil && !netutil.IsValidIPString(s)
+}
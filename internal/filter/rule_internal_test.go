@@ -0,0 +1,139 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRules(t *testing.T) {
+	t.Parallel()
+
+	const content = "! Title: Test list\n" +
+		"# Hosts comment.\n" +
+		"\n" +
+		"||Ads.Example^\n" +
+		"|exact.example^\n" +
+		"@@||good.ads.example^\n" +
+		"||important.example^$important\n" +
+		"plain.example\n" +
+		"0.0.0.0 tracker.example metrics.example # Trackers.\n" +
+		"||third-party.example^$third-party\n" +
+		"/banner[0-9]+/\n" +
+		"127.0.0.1 localhost\n"
+
+	rs, err := parseRules(strings.NewReader(content))
+	require.NoError(t, err)
+
+	assert.Equal(t, 7, rs.count)
+	assert.Equal(t, 2, rs.skipped)
+
+	testCases := []struct {
+		name     string
+		host     string
+		wantRule string
+	}{{
+		name:     "domain",
+		host:     "ads.example",
+		wantRule: "||Ads.Example^",
+	}, {
+		name:     "subdomain",
+		host:     "www.ads.example",
+		wantRule: "||Ads.Example^",
+	}, {
+		name:     "exception",
+		host:     "www.good.ads.example",
+		wantRule: "@@||good.ads.example^",
+	}, {
+		name:     "exact",
+		host:     "exact.example",
+		wantRule: "|exact.example^",
+	}, {
+		name:     "exact_subdomain",
+		host:     "www.exact.example",
+		wantRule: "",
+	}, {
+		name:     "plain",
+		host:     "www.plain.example",
+		wantRule: "plain.example",
+	}, {
+		name:     "hosts",
+		host:     "metrics.example",
+		wantRule: "0.0.0.0 tracker.example metrics.example # Trackers.",
+	}, {
+		name:     "hosts_subdomain",
+		host:     "www.tracker.example",
+		wantRule: "",
+	}, {
+		name:     "unsupported",
+		host:     "third-party.example",
+		wantRule: "",
+	}, {
+		name:     "none",
+		host:     "example.org",
+		wantRule: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := rs.match(tc.host)
+			if tc.wantRule == "" {
+				assert.Nil(t, r)
+			} else {
+				require.NotNil(t, r)
+				assert.Equal(t, tc.wantRule, r.text)
+			}
+		})
+	}
+}
+
+func TestRuleSet_match_priority(t *testing.T) {
+	t.Parallel()
+
+	const content = "||example.org^\n" +
+		"@@||www.example.org^\n" +
+		"||www.example.org^$important\n" +
+		"@@||api.example.org^$important\n" +
+		"||v1.api.example.org^$important\n"
+
+	rs, err := parseRules(strings.NewReader(content))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		host      string
+		wantRule  string
+		wantAllow bool
+	}{{
+		name:      "blocking",
+		host:      "example.org",
+		wantRule:  "||example.org^",
+		wantAllow: false,
+	}, {
+		name:      "important_over_exception",
+		host:      "www.example.org",
+		wantRule:  "||www.example.org^$important",
+		wantAllow: false,
+	}, {
+		name:      "important_exception",
+		host:      "v1.api.example.org",
+		wantRule:  "@@||api.example.org^$important",
+		wantAllow: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := rs.match(tc.host)
+			require.NotNil(t, r)
+
+			assert.Equal(t, tc.wantRule, r.text)
+			assert.Equal(t, tc.wantAllow, r.allow)
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// Package metrics contains the Prometheus implementations of the interfaces
+// for collecting the statistics of AdGuardDNSClient.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdcslog"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace is the default namespace for the metrics of AdGuardDNSClient.
+const Namespace = "agdc"
+
+// Subsystems of the metrics.
+const (
+	subsystemCache    = "cache"
+	subsystemDNSSvc   = "dnssvc"
+	subsystemUpstream = "upstream"
+)
+
+// Label names of the metrics.
+const (
+	labelCacheType     = "cache_type"
+	labelClientPrefix  = "client_prefix"
+	labelRcode         = "rcode"
+	labelReason        = "reason"
+	labelResult        = "result"
+	labelUpstreamGroup = "upstream_group"
+	labelUpstreamType  = agdcslog.KeyUpstreamType
+)
+
+// Label values of the cache metrics.
+const (
+	cacheTypeClient = "client"
+	cacheTypeCommon = "common"
+
+	resultHit  = "hit"
+	resultMiss = "miss"
+)
+
+// DNSSvc is the Prometheus-based implementation of the [dnssvc.Metrics]
+// interface.
+type DNSSvc struct {
+	// queries is the total number of processed requests by the client prefix,
+	// upstream group, and response code.
+	queries *prometheus.CounterVec
+
+	// upstreamDuration is the duration of exchanges with upstreams by the
+	// upstream type.
+	upstreamDuration *prometheus.HistogramVec
+
+	// upstreamErrors is the total number of failed exchanges with upstreams by
+	// the upstream type.
+	upstreamErrors *prometheus.CounterVec
+
+	// cacheLookups is the total number of cache lookups by the cache type and
+	// the result.
+	cacheLookups *prometheus.CounterVec
+
+	// rejected is the total number of rejected requests by the reason.
+	rejected *prometheus.CounterVec
+}
+
+// NewDNSSvc registers the DNS service metrics in reg and returns a properly
+// initialized *DNSSvc.  reg must not be nil.
+func NewDNSSvc(namespace string, reg prometheus.Registerer) (m *DNSSvc, err error) {
+	const (
+		queriesTotal     = "queries_total"
+		upstreamDuration = "exchange_duration_seconds"
+		upstreamErrors   = "errors_total"
+		cacheLookups     = "lookups_total"
+		rejected         = "rejected_total"
+	)
+
+	m = &DNSSvc{
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:      queriesTotal,
+			Namespace: namespace,
+			Subsystem: subsystemDNSSvc,
+			Help: "The total number of processed DNS requests by client prefix, " +
+				"upstream group, and response code.",
+		}, []string{labelClientPrefix, labelUpstreamGroup, labelRcode}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:      upstreamDuration,
+			Namespace: namespace,
+			Subsystem: subsystemUpstream,
+			Help:      "The duration of exchanges with upstreams by upstream type.",
+			Buckets:   []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+		}, []string{labelUpstreamType}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:      upstreamErrors,
+			Namespace: namespace,
+			Subsystem: subsystemUpstream,
+			Help:      "The total number of failed exchanges with upstreams by upstream type.",
+		}, []string{labelUpstreamType}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:      cacheLookups,
+			Namespace: namespace,
+			Subsystem: subsystemCache,
+			Help:      "The total number of cache lookups by cache type and result.",
+		}, []string{labelCacheType, labelResult}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:      rejected,
+			Namespace: namespace,
+			Subsystem: subsystemDNSSvc,
+			Help:      "The total number of rejected DNS requests by reason.",
+		}, []string{labelReason}),
+	}
+
+	var errs []error
+	collectors := []struct {
+		collector prometheus.Collector
+		name      string
+	}{{
+		collector: m.queries,
+		name:      queriesTotal,
+	}, {
+		collector: m.upstreamDuration,
+		name:      upstreamDuration,
+	}, {
+		collector: m.upstreamErrors,
+		name:      upstreamErrors,
+	}, {
+		collector: m.cacheLookups,
+		name:      cacheLookups,
+	}, {
+		collector: m.rejected,
+		name:      rejected,
+	}}
+
+	for _, c := range collectors {
+		err = reg.Register(c.collector)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("registering metrics %q: %w", c.name, err))
+		}
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// type check
+var _ dnssvc.Metrics = (*DNSSvc)(nil)
+
+// ObserveQuery implements the [dnssvc.Metrics] interface for *DNSSvc.  The
+// client prefix label is empty for the requests processed using the general
+// configuration.
+func (m *DNSSvc) ObserveQuery(
+	_ context.Context,
+	client netip.Prefix,
+	group agdc.UpstreamGroupName,
+	rcode string,
+) {
+	var prefix string
+	if client.IsValid() {
+		prefix = client.String()
+	}
+
+	m.queries.WithLabelValues(prefix, string(group), rcode).Inc()
+}
+
+// ObserveUpstream implements the [dnssvc.Metrics] interface for *DNSSvc.
+func (m *DNSSvc) ObserveUpstream(
+	_ context.Context,
+	upstreamType string,
+	dur time.Duration,
+	err error,
+) {
+	m.upstreamDuration.WithLabelValues(upstreamType).Observe(dur.Seconds())
+	if err != nil {
+		m.upstreamErrors.WithLabelValues(upstreamType).Inc()
+	}
+}
+
+// ObserveCacheLookup implements the [dnssvc.Metrics] interface for *DNSSvc.
+func (m *DNSSvc) ObserveCacheLookup(_ context.Context, isClient, hit bool) {
+	cacheType, result := cacheTypeCommon, resultMiss
+	if isClient {
+		cacheType = cacheTypeClient
+	}
+
+	if hit {
+		result = resultHit
+	}
+
+	m.cacheLookups.WithLabelValues(cacheType, result).Inc()
+}
+
+// ObserveRejected implements the [dnssvc.Metrics] interface for *DNSSvc.
+func (m *DNSSvc) ObserveRejected(_ context.Context, reason dnssvc.RejectReason) {
+	m.rejected.WithLabelValues(string(reason)).Inc()
+}
@@ -0,0 +1,68 @@
+package metrics_test
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdcslog"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/dnssvc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/metrics"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSSvc(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	m, err := metrics.NewDNSSvc(metrics.Namespace, reg)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	m.ObserveQuery(ctx, netip.Prefix{}, agdc.UpstreamGroupNameDefault, "NOERROR")
+	m.ObserveQuery(ctx, netip.MustParsePrefix("192.0.2.0/24"), "client-group", "NXDOMAIN")
+	m.ObserveUpstream(ctx, agdcslog.UpstreamTypeMain, time.Millisecond, nil)
+	m.ObserveUpstream(ctx, agdcslog.UpstreamTypeFallback, time.Second, errors.Error("test"))
+	m.ObserveCacheLookup(ctx, false, true)
+	m.ObserveCacheLookup(ctx, true, false)
+	m.ObserveRejected(ctx, dnssvc.RejectReasonBlockedDomain)
+
+	const want = `
+# HELP agdc_cache_lookups_total The total number of cache lookups by cache type and result.
+# TYPE agdc_cache_lookups_total counter
+agdc_cache_lookups_total{cache_type="client",result="miss"} 1
+agdc_cache_lookups_total{cache_type="common",result="hit"} 1
+# HELP agdc_dnssvc_queries_total The total number of processed DNS requests by client prefix, upstream group, and response code.
+# TYPE agdc_dnssvc_queries_total counter
+agdc_dnssvc_queries_total{client_prefix="",rcode="NOERROR",upstream_group="default"} 1
+agdc_dnssvc_queries_total{client_prefix="192.0.2.0/24",rcode="NXDOMAIN",upstream_group="client-group"} 1
+# HELP agdc_dnssvc_rejected_total The total number of rejected DNS requests by reason.
+# TYPE agdc_dnssvc_rejected_total counter
+agdc_dnssvc_rejected_total{reason="blocked_domain"} 1
+# HELP agdc_upstream_errors_total The total number of failed exchanges with upstreams by upstream type.
+# TYPE agdc_upstream_errors_total counter
+agdc_upstream_errors_total{upstream_type="fallback"} 1
+`
+
+	err = promtestutil.GatherAndCompare(
+		reg,
+		strings.NewReader(want),
+		"agdc_cache_lookups_total",
+		"agdc_dnssvc_queries_total",
+		"agdc_dnssvc_rejected_total",
+		"agdc_upstream_errors_total",
+	)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, promtestutil.CollectAndCount(reg, "agdc_upstream_exchange_duration_seconds"))
+
+	_, err = metrics.NewDNSSvc(metrics.Namespace, reg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,222 @@
+// Package proxyproto contains the reader of the PROXY protocol headers, which
+// the load balancers prepend to the proxied connections to pass the original
+// client's address.
+//
+// See https://www.haproxy.org/download/3.0/doc/proxy-protocol.txt.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// ErrNoHeader is returned by [ReadHeader] if the data doesn't start with a
+// PROXY protocol header.
+const ErrNoHeader errors.Error = "no proxy protocol header"
+
+// Signatures of the protocol versions.
+const (
+	// signatureV1 is the beginning of the human-readable header.
+	signatureV1 = "PROXY "
+
+	// signatureV2 is the beginning of the binary header.
+	signatureV2 = "\r\n\r\n\x00\r\nQUIT\n"
+)
+
+// maxLenV1 is the maximum length of the human-readable header including the
+// CRLF.
+const maxLenV1 = 107
+
+// Header is a parsed PROXY protocol header.
+type Header struct {
+	// Source is the address of the original client.  It's not valid if the
+	// connection is made by the proxy itself, i.e. for the LOCAL command of
+	// the binary header and the UNKNOWN protocol of the human-readable one, or
+	// if the addresses aren't the IP ones.
+	Source netip.AddrPort
+
+	// Destination is the address the original client connected to.  It's
+	// only valid along with Source.
+	Destination netip.AddrPort
+}
+
+// ReadHeader reads the PROXY protocol header of either version from r.  The
+// data following the header stays in r.  It returns [ErrNoHeader] if the data
+// doesn't start with a header.
+func ReadHeader(r *bufio.Reader) (h *Header, err error) {
+	sig, err := r.Peek(len(signatureV1))
+	if err != nil {
+		return nil, fmt.Errorf("reading signature: %w", err)
+	}
+
+	if string(sig) == signatureV1 {
+		// Don't wrap the error, because it's informative enough as is.
+		return readV1(r)
+	}
+
+	sig, err = r.Peek(len(signatureV2))
+	if err != nil {
+		return nil, fmt.Errorf("reading signature: %w", err)
+	} else if string(sig) != signatureV2 {
+		return nil, ErrNoHeader
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return readV2(r)
+}
+
+// readV1 reads the human-readable header from r.  r must start with
+// [signatureV1].
+func readV1(r *bufio.Reader) (h *Header, err error) {
+	var line []byte
+	for len(line) < maxLenV1 {
+		var b byte
+		b, err = r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("reading v1 header: %w", err)
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	text, ok := bytes.CutSuffix(line, []byte("\r\n"))
+	if !ok {
+		return nil, fmt.Errorf("v1 header: no crlf within %d bytes", maxLenV1)
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return parseV1(string(text))
+}
+
+// parseV1 parses the human-readable header text without the trailing CRLF.
+func parseV1(text string) (h *Header, err error) {
+	fields := strings.Split(text, " ")
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("v1 header: too few fields: %q", text)
+	}
+
+	switch proto := fields[1]; proto {
+	case "UNKNOWN":
+		// The rest of the line is ignored.
+		return &Header{}, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("v1 header: want 6 fields, got %d", len(fields))
+		}
+
+		h = &Header{}
+		h.Source, err = parseAddrPortV1(fields[2], fields[4], proto == "TCP6")
+		if err != nil {
+			return nil, fmt.Errorf("v1 header: source: %w", err)
+		}
+
+		h.Destination, err = parseAddrPortV1(fields[3], fields[5], proto == "TCP6")
+		if err != nil {
+			return nil, fmt.Errorf("v1 header: destination: %w", err)
+		}
+
+		return h, nil
+	default:
+		return nil, fmt.Errorf("v1 header: protocol: %w: %q", errors.ErrBadEnumValue, proto)
+	}
+}
+
+// parseAddrPortV1 parses the address and the port fields of the
+// human-readable header.  is6 is true if the address must be an IPv6 one.
+func parseAddrPortV1(addrStr, portStr string, is6 bool) (addrPort netip.AddrPort, err error) {
+	addr, err := netip.ParseAddr(addrStr)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return netip.AddrPort{}, err
+	} else if addr.Is6() != is6 {
+		return netip.AddrPort{}, fmt.Errorf("address %s of wrong family", addr)
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("port: %w", err)
+	}
+
+	// #nosec G115 -- The port is parsed as a 16-bit value.
+	return netip.AddrPortFrom(addr, uint16(port)), nil
+}
+
+// Commands and address families of the binary header.
+const (
+	cmdLocal byte = 0x0
+	cmdProxy byte = 0x1
+
+	famInet  byte = 0x1
+	famInet6 byte = 0x2
+)
+
+// readV2 reads the binary header from r.  r must start with [signatureV2].
+func readV2(r *bufio.Reader) (h *Header, err error) {
+	fixed := make([]byte, len(signatureV2)+4)
+	_, err = io.ReadFull(r, fixed)
+	if err != nil {
+		return nil, fmt.Errorf("reading v2 header: %w", err)
+	}
+
+	verCmd, famProto := fixed[len(signatureV2)], fixed[len(signatureV2)+1]
+	if ver := verCmd >> 4; ver != 2 {
+		return nil, fmt.Errorf("v2 header: version: %w: %d", errors.ErrBadEnumValue, ver)
+	}
+
+	rest := make([]byte, binary.BigEndian.Uint16(fixed[len(signatureV2)+2:]))
+	_, err = io.ReadFull(r, rest)
+	if err != nil {
+		return nil, fmt.Errorf("reading v2 addresses: %w", err)
+	}
+
+	switch cmd := verCmd & 0xF; cmd {
+	case cmdLocal:
+		return &Header{}, nil
+	case cmdProxy:
+		// Don't wrap the error, because it's informative enough as is.
+		return parseAddrsV2(famProto>>4, rest)
+	default:
+		return nil, fmt.Errorf("v2 header: command: %w: %d", errors.ErrBadEnumValue, cmd)
+	}
+}
+
+// parseAddrsV2 parses the address block of the binary header of the address
+// family fam.  The addresses of the unsupported families are ignored.  The
+// type-length-value vectors following the addresses are ignored as well.
+func parseAddrsV2(fam byte, addrs []byte) (h *Header, err error) {
+	var addrLen int
+	switch fam {
+	case famInet:
+		addrLen = 4
+	case famInet6:
+		addrLen = 16
+	default:
+		// The unspecified family and the Unix sockets.
+		return &Header{}, nil
+	}
+
+	// Both addresses are followed by both ports.
+	if minLen := 2*addrLen + 4; len(addrs) < minLen {
+		return nil, fmt.Errorf("v2 header: addresses: want %d bytes, got %d", minLen, len(addrs))
+	}
+
+	src, _ := netip.AddrFromSlice(addrs[:addrLen])
+	dst, _ := netip.AddrFromSlice(addrs[addrLen : 2*addrLen])
+	ports := addrs[2*addrLen:]
+
+	return &Header{
+		Source:      netip.AddrPortFrom(src, binary.BigEndian.Uint16(ports)),
+		Destination: netip.AddrPortFrom(dst, binary.BigEndian.Uint16(ports[2:])),
+	}, nil
+}
@@ -0,0 +1,118 @@
+package proxyproto_test
+
+import (
+	"bufio"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadHeader(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sigV2   = "\r\n\r\n\x00\r\nQUIT\n"
+		payload = "payload"
+	)
+
+	var (
+		src4 = netip.MustParseAddrPort("192.0.2.1:56324")
+		dst4 = netip.MustParseAddrPort("198.51.100.1:53")
+		src6 = netip.MustParseAddrPort("[2001:db8::1]:56324")
+		dst6 = netip.MustParseAddrPort("[2001:db8::2]:53")
+	)
+
+	testCases := []struct {
+		want       *proxyproto.Header
+		name       string
+		in         string
+		wantErrMsg string
+	}{{
+		want:       &proxyproto.Header{Source: src4, Destination: dst4},
+		name:       "v1_tcp4",
+		in:         "PROXY TCP4 192.0.2.1 198.51.100.1 56324 53\r\n",
+		wantErrMsg: "",
+	}, {
+		want:       &proxyproto.Header{Source: src6, Destination: dst6},
+		name:       "v1_tcp6",
+		in:         "PROXY TCP6 2001:db8::1 2001:db8::2 56324 53\r\n",
+		wantErrMsg: "",
+	}, {
+		want:       &proxyproto.Header{},
+		name:       "v1_unknown",
+		in:         "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n",
+		wantErrMsg: "",
+	}, {
+		want:       nil,
+		name:       "v1_wrong_family",
+		in:         "PROXY TCP4 2001:db8::1 198.51.100.1 56324 53\r\n",
+		wantErrMsg: "v1 header: source: address 2001:db8::1 of wrong family",
+	}, {
+		want:       nil,
+		name:       "v1_no_crlf",
+		in:         "PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n",
+		wantErrMsg: "v1 header: no crlf within 107 bytes",
+	}, {
+		want: &proxyproto.Header{Source: src4, Destination: dst4},
+		name: "v2_inet",
+		in: sigV2 + "\x21\x11\x00\x0c" +
+			"\xc0\x00\x02\x01" + "\xc6\x33\x64\x01" + "\xdc\x04" + "\x00\x35",
+		wantErrMsg: "",
+	}, {
+		want: &proxyproto.Header{Source: src6, Destination: dst6},
+		name: "v2_inet6_tlv",
+		in: sigV2 + "\x21\x21\x00\x27" +
+			"\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x01" +
+			"\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x02" +
+			"\xdc\x04" + "\x00\x35" + "\x04\x00\x00",
+		wantErrMsg: "",
+	}, {
+		want:       &proxyproto.Header{},
+		name:       "v2_local",
+		in:         sigV2 + "\x20\x00\x00\x00",
+		wantErrMsg: "",
+	}, {
+		want:       nil,
+		name:       "v2_bad_version",
+		in:         sigV2 + "\x11\x11\x00\x00",
+		wantErrMsg: "v2 header: version: bad enum value: 1",
+	}, {
+		want:       nil,
+		name:       "v2_short",
+		in:         sigV2 + "\x21\x11\x00\x04" + "\xc0\x00\x02\x01",
+		wantErrMsg: "v2 header: addresses: want 12 bytes, got 4",
+	}, {
+		want:       nil,
+		name:       "no_header",
+		in:         "\x00\x1d\x00\x01\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00",
+		wantErrMsg: "no proxy protocol header",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := bufio.NewReader(strings.NewReader(tc.in + payload))
+			h, err := proxyproto.ReadHeader(r)
+			if tc.wantErrMsg != "" {
+				assert.EqualError(t, err, tc.wantErrMsg)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, h)
+
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+
+			assert.Equal(t, payload, string(rest))
+		})
+	}
+}
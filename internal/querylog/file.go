@@ -0,0 +1,377 @@
+package querylog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/service"
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// FileConfig is the configuration for [File].
+type FileConfig struct {
+	// Logger is used to log the operation of the query log.  It must not be
+	// nil.
+	Logger *slog.Logger
+
+	// Clock is the source of current time.  It must not be nil.
+	Clock timeutil.Clock
+
+	// Path is the path to the query log file.  It must not be empty.  Rotated
+	// files are placed in the same directory and have the name of the file
+	// with the time of rotation appended.
+	Path string
+
+	// MaxSize is the maximum size of the file in bytes, after which it's
+	// rotated.  If it's zero, the file isn't rotated by size.
+	MaxSize uint64
+
+	// MaxAge is the maximum age of the file, after which it's rotated.  The
+	// age is counted from the time the file has been opened or rotated.  If
+	// it's zero, the file isn't rotated by age.
+	MaxAge time.Duration
+
+	// MaxBackups is the maximum number of rotated files to keep.  The oldest
+	// ones are removed.  If it's zero, all rotated files are kept.
+	MaxBackups uint
+
+	// BufferSize is the maximum number of entries waiting to be written.
+	// Entries written when the buffer is full are dropped.  It must be
+	// positive.
+	BufferSize uint
+
+	// AnonymizeClientIP specifies if the client IP addresses should be
+	// anonymized using [AnonymizeIP].
+	AnonymizeClientIP bool
+}
+
+// File is the [Interface] implementation that writes the entries to a file in
+// the JSON Lines format.  The entries are written asynchronously, so that a
+// slow disk doesn't block the processing of requests.
+type File struct {
+	logger *slog.Logger
+	clock  timeutil.Clock
+
+	// entries is the buffer of entries waiting to be written.
+	entries chan *Entry
+
+	// stop is closed to stop the writing goroutine.
+	stop chan struct{}
+
+	// stopped is closed when the writing goroutine exits.
+	stopped chan struct{}
+
+	// file is the currently opened query log file.  It's only accessed by the
+	// writing goroutine after start.
+	file *os.File
+
+	// buf buffers the writes to file.
+	buf *bufio.Writer
+
+	// dropped is the number of entries dropped since the last report.
+	dropped *atomic.Uint64
+
+	// openedAt is the time file has been opened.
+	openedAt time.Time
+
+	path       string
+	maxAge     time.Duration
+	maxSize    uint64
+	size       uint64
+	maxBackups uint
+	anonymize  bool
+}
+
+// NewFile returns a new properly initialized *File.  c must not be nil and
+// must be valid.
+func NewFile(c *FileConfig) (f *File) {
+	return &File{
+		logger:     c.Logger,
+		clock:      c.Clock,
+		entries:    make(chan *Entry, c.BufferSize),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		dropped:    &atomic.Uint64{},
+		path:       c.Path,
+		maxAge:     c.MaxAge,
+		maxSize:    c.MaxSize,
+		maxBackups: c.MaxBackups,
+		anonymize:  c.AnonymizeClientIP,
+	}
+}
+
+// type check
+var _ Interface = (*File)(nil)
+
+// Write implements the [Interface] interface for *File.  It drops e if the
+// buffer is full.
+func (f *File) Write(_ context.Context, e *Entry) {
+	select {
+	case f.entries <- e:
+	default:
+		f.dropped.Add(1)
+	}
+}
+
+// type check
+var _ service.Interface = (*File)(nil)
+
+// Start implements the [service.Interface] interface for *File.  It opens the
+// file and starts writing the entries.
+func (f *File) Start(ctx context.Context) (err error) {
+	err = f.open()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	go f.writeLoop(ctx)
+
+	f.logger.InfoContext(ctx, "writing query log", "path", f.path)
+
+	return nil
+}
+
+// Shutdown implements the [service.Interface] interface for *File.  It writes
+// the entries remaining in the buffer and closes the file.
+func (f *File) Shutdown(ctx context.Context) (err error) {
+	close(f.stop)
+
+	select {
+	case <-f.stopped:
+		// Go on.
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for query log writes: %w", context.Cause(ctx))
+	}
+
+	return f.close()
+}
+
+// writeLoop writes the entries from the buffer until f is stopped.  It's
+// intended to be used as a goroutine.
+func (f *File) writeLoop(ctx context.Context) {
+	defer close(f.stopped)
+	defer slogutil.RecoverAndLog(ctx, f.logger)
+
+	for {
+		select {
+		case e := <-f.entries:
+			f.handle(ctx, e)
+		case <-f.stop:
+			f.drain(ctx)
+
+			return
+		}
+	}
+}
+
+// drain writes the entries remaining in the buffer.
+func (f *File) drain(ctx context.Context) {
+	for {
+		select {
+		case e := <-f.entries:
+			f.handle(ctx, e)
+		default:
+			return
+		}
+	}
+}
+
+// handle writes e and flushes the written data if there are no more entries
+// waiting.  It logs the errors and the number of dropped entries, if any.
+func (f *File) handle(ctx context.Context, e *Entry) {
+	err := f.writeEntry(e)
+	if err != nil {
+		f.logger.ErrorContext(ctx, "writing entry", slogutil.KeyError, err)
+	}
+
+	if len(f.entries) > 0 {
+		return
+	}
+
+	err = f.buf.Flush()
+	if err != nil {
+		f.logger.ErrorContext(ctx, "flushing entries", slogutil.KeyError, err)
+	}
+
+	if n := f.dropped.Swap(0); n > 0 {
+		f.logger.WarnContext(ctx, "buffer is full, entries dropped", "count", n)
+	}
+}
+
+// writeEntry writes e to the file, rotating it beforehand if necessary.
+func (f *File) writeEntry(e *Entry) (err error) {
+	b, err := json.Marshal(e.toJSON(f.anonymize))
+	if err != nil {
+		return fmt.Errorf("encoding entry: %w", err)
+	}
+
+	b = append(b, '\n')
+
+	if f.needsRotation(uint64(len(b))) {
+		err = f.rotate()
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return err
+		}
+	}
+
+	n, err := f.buf.Write(b)
+	// #nosec G115 -- The number of written bytes is never negative.
+	f.size += uint64(n)
+	if err != nil {
+		return fmt.Errorf("writing entry: %w", err)
+	}
+
+	return nil
+}
+
+// needsRotation returns true if the file should be rotated before writing n
+// more bytes to it.
+func (f *File) needsRotation(n uint64) (ok bool) {
+	if f.size == 0 {
+		// Don't rotate an empty file, even if a single entry exceeds the size.
+		return false
+	}
+
+	if f.maxSize > 0 && f.size+n > f.maxSize {
+		return true
+	}
+
+	return f.maxAge > 0 && f.clock.Now().Sub(f.openedAt) >= f.maxAge
+}
+
+// open opens the file for appending and records its current size.
+func (f *File) open() (err error) {
+	// #nosec G304 -- Trust the path to the query log file that is set in the
+	// configuration.
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening query log file: %w", err)
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		return errors.WithDeferred(fmt.Errorf("getting query log file info: %w", err), file.Close())
+	}
+
+	f.file = file
+	f.buf = bufio.NewWriter(file)
+	// #nosec G115 -- The size of a file is never negative.
+	f.size = uint64(fi.Size())
+	f.openedAt = f.clock.Now()
+
+	return nil
+}
+
+// close flushes the buffered data and closes the file.
+func (f *File) close() (err error) {
+	err = f.buf.Flush()
+	if err != nil {
+		err = fmt.Errorf("flushing query log file: %w", err)
+	}
+
+	closeErr := f.file.Close()
+	if closeErr != nil {
+		closeErr = fmt.Errorf("closing query log file: %w", closeErr)
+	}
+
+	return errors.Join(err, closeErr)
+}
+
+// rotationTimeFormat is the format of the time appended to the names of the
+// rotated files.  It's sortable and doesn't contain characters forbidden in
+// file names on any supported OS.
+const rotationTimeFormat = "20060102T150405.000000000"
+
+// rotate closes the file, renames it, removes the excessive rotated files, and
+// opens a new file.
+func (f *File) rotate() (err error) {
+	defer func() { err = errors.Annotate(err, "rotating query log: %w") }()
+
+	err = f.close()
+	if err != nil {
+		// Don't wrap the error since there is already an annotation deferred.
+		return err
+	}
+
+	rotated := f.path + "." + f.clock.Now().UTC().Format(rotationTimeFormat)
+	err = os.Rename(f.path, rotated)
+	if err != nil {
+		return errors.WithDeferred(err, f.open())
+	}
+
+	return errors.Join(f.open(), f.removeBackups())
+}
+
+// removeBackups removes the oldest rotated files exceeding the maximum number
+// of backups.
+func (f *File) removeBackups() (err error) {
+	if f.maxBackups == 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(f.path)
+	backups, err := listBackups(dir, filepath.Base(f.path))
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	} else if uint(len(backups)) <= f.maxBackups {
+		return nil
+	}
+
+	var errs []error
+	for _, name := range backups[:uint(len(backups))-f.maxBackups] {
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("removing rotated file: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// listBackups returns the sorted names of the files rotated from the file with
+// base name within dir.  Since the names only differ in the rotation time, the
+// files are sorted from the oldest to the newest.
+func listBackups(dir, base string) (names []string, err error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing rotated files: %w", err)
+	}
+
+	for _, de := range dirEntries {
+		name := de.Name()
+		if de.Type().IsRegular() && isRotated(name, base) {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	return names, nil
+}
+
+// isRotated returns true if name is the name of a file rotated from the file
+// with base name.
+func isRotated(name, base string) (ok bool) {
+	suffix, ok := strings.CutPrefix(name, base+".")
+	if !ok {
+		return false
+	}
+
+	_, err := time.Parse(rotationTimeFormat, suffix)
+
+	return err == nil
+}
@@ -0,0 +1,165 @@
+// Package querylog contains the query log of AdGuardDNSClient, which records
+// the processed DNS requests.
+package querylog
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+)
+
+// Interface is the query log.
+type Interface interface {
+	// Write records e.  It must not block and must not retain e after it's
+	// written.  e must not be nil and must not be modified after the call.
+	Write(ctx context.Context, e *Entry)
+}
+
+// Empty is the [Interface] implementation that does nothing.
+type Empty struct{}
+
+// type check
+var _ Interface = Empty{}
+
+// Write implements the [Interface] interface for Empty.
+func (Empty) Write(_ context.Context, _ *Entry) {}
+
+// Entry is a single record of the query log.
+type Entry struct {
+	// Time is the time when the request has been received.
+	Time time.Time
+
+	// ClientIP is the IP address of the client.
+	ClientIP netip.Addr
+
+	// ClientName is the name of the persistent client that sent the request, if
+	// any.
+	ClientName agdc.ClientName
+
+	// Name is the domain name from the question of the request.
+	Name string
+
+	// QType is the textual representation of the question type of the request.
+	QType string
+
+	// Proto is the protocol of the request.
+	Proto string
+
+	// Group is the name of the upstream group chosen for the request, if any.
+	Group agdc.UpstreamGroupName
+
+	// Upstream is the address of the upstream that answered the request, if
+	// any.
+	Upstream string
+
+	// Rcode is the textual representation of the response code, if there is a
+	// response.
+	Rcode string
+
+	// FilterList is the name of the rule list containing Rule, if any.
+	FilterList string
+
+	// Rule is the text of the filtering rule matched the request, if any.
+	Rule string
+
+	// Policy is the name of the response policy zone containing the rule
+	// matched the request or its response, if any.
+	Policy string
+
+	// PolicyTrigger is the owner name of the response policy rule matched the
+	// request or its response, if any.
+	PolicyTrigger string
+
+	// PolicyAction is the action of the response policy rule matched the
+	// request or its response, if any.
+	PolicyAction string
+
+	// Elapsed is the time spent on processing the request.
+	Elapsed time.Duration
+
+	// Cached is true if the response has been taken from the cache.
+	Cached bool
+
+	// Blocked is true if the request has been blocked by Rule.
+	Blocked bool
+}
+
+// jsonEntry is the JSON representation of [Entry].
+type jsonEntry struct {
+	Time          string  `json:"time"`
+	ClientIP      string  `json:"client_ip"`
+	ClientName    string  `json:"client_name,omitempty"`
+	Name          string  `json:"name"`
+	QType         string  `json:"qtype"`
+	Proto         string  `json:"proto"`
+	Group         string  `json:"group,omitempty"`
+	Upstream      string  `json:"upstream,omitempty"`
+	Rcode         string  `json:"rcode,omitempty"`
+	FilterList    string  `json:"filter_list,omitempty"`
+	Rule          string  `json:"rule,omitempty"`
+	Policy        string  `json:"policy,omitempty"`
+	PolicyTrigger string  `json:"policy_trigger,omitempty"`
+	PolicyAction  string  `json:"policy_action,omitempty"`
+	ElapsedMs     float64 `json:"elapsed_ms"`
+	Cached        bool    `json:"cached"`
+	Blocked       bool    `json:"blocked,omitempty"`
+}
+
+// toJSON converts e into its JSON representation.  If anonymize is true, the
+// client IP address is replaced with the anonymized one.
+func (e *Entry) toJSON(anonymize bool) (j *jsonEntry) {
+	ip := e.ClientIP
+	if anonymize {
+		ip = AnonymizeIP(ip)
+	}
+
+	return &jsonEntry{
+		Time:          e.Time.UTC().Format(time.RFC3339Nano),
+		ClientIP:      ip.String(),
+		ClientName:    string(e.ClientName),
+		Name:          e.Name,
+		QType:         e.QType,
+		Proto:         e.Proto,
+		Group:         string(e.Group),
+		Upstream:      e.Upstream,
+		Rcode:         e.Rcode,
+		FilterList:    e.FilterList,
+		Rule:          e.Rule,
+		Policy:        e.Policy,
+		PolicyTrigger: e.PolicyTrigger,
+		PolicyAction:  e.PolicyAction,
+		ElapsedMs:     float64(e.Elapsed) / float64(time.Millisecond),
+		Cached:        e.Cached,
+		Blocked:       e.Blocked,
+	}
+}
+
+// Lengths of the prefixes kept by [AnonymizeIP].
+const (
+	anonymizedIPv4Bits = 16
+	anonymizedIPv6Bits = 48
+)
+
+// AnonymizeIP returns ip with the trailing bits zeroed: only the first 16 bits
+// of IPv4 addresses and the first 48 bits of IPv6 addresses are kept.  IPv4
+// addresses mapped to IPv6 ones are handled as IPv4 ones.  Invalid ip is
+// returned as is.
+func AnonymizeIP(ip netip.Addr) (anon netip.Addr) {
+	if !ip.IsValid() {
+		return ip
+	}
+
+	ip = ip.Unmap()
+
+	bits := anonymizedIPv6Bits
+	if ip.Is4() {
+		bits = anonymizedIPv4Bits
+	}
+
+	// Don't check the error, since bits is always valid for the family.
+	p, _ := ip.Prefix(bits)
+
+	return p.Addr()
+}
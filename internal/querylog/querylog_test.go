@@ -0,0 +1,217 @@
+package querylog_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/agdc"
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/querylog"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/testutil/faketime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// testClientIP is the client IP address for tests.
+var testClientIP = netip.MustParseAddr("192.0.2.123")
+
+// newTestEntry returns a new entry for tests with the given name.
+func newTestEntry(name string) (e *querylog.Entry) {
+	return &querylog.Entry{
+		Time:       time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		ClientIP:   testClientIP,
+		ClientName: "laptop",
+		Name:       name,
+		QType:      "A",
+		Proto:      "udp",
+		Group:      agdc.UpstreamGroupNameDefault,
+		Upstream:   "udp://192.0.2.1:53",
+		Rcode:      "NOERROR",
+		Elapsed:    1500 * time.Microsecond,
+		Cached:     false,
+	}
+}
+
+// newTestFile creates, starts, and writes entries with names to a query log
+// file configured by c, then shuts it down.  c.Logger, c.Clock, and
+// c.BufferSize are set if not already.
+func newTestFile(tb testing.TB, c *querylog.FileConfig, names ...string) {
+	tb.Helper()
+
+	if c.Logger == nil {
+		c.Logger = slogutil.NewDiscardLogger()
+	}
+
+	if c.Clock == nil {
+		c.Clock = &faketime.Clock{
+			OnNow: func() (now time.Time) { return time.Now() },
+		}
+	}
+
+	if c.BufferSize == 0 {
+		c.BufferSize = uint(len(names))
+	}
+
+	f := querylog.NewFile(c)
+	require.NoError(tb, f.Start(testutil.ContextWithTimeout(tb, testTimeout)))
+
+	ctx := context.Background()
+	for _, n := range names {
+		f.Write(ctx, newTestEntry(n))
+	}
+
+	require.NoError(tb, f.Shutdown(testutil.ContextWithTimeout(tb, testTimeout)))
+}
+
+// readEntries reads the JSON Lines entries from the file at path.
+func readEntries(tb testing.TB, path string) (entries []map[string]any) {
+	tb.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(tb, err)
+	testutil.CleanupAndRequireSuccess(tb, f.Close)
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		e := map[string]any{}
+		require.NoError(tb, json.Unmarshal(s.Bytes(), &e))
+
+		entries = append(entries, e)
+	}
+
+	require.NoError(tb, s.Err())
+
+	return entries
+}
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		wantClient string
+		anonymize  bool
+	}{{
+		name:       "plain",
+		wantClient: testClientIP.String(),
+		anonymize:  false,
+	}, {
+		name:       "anonymized",
+		wantClient: "192.0.0.0",
+		anonymize:  true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "querylog.jsonl")
+			newTestFile(t, &querylog.FileConfig{
+				Path:              path,
+				AnonymizeClientIP: tc.anonymize,
+			}, "first.example.", "second.example.")
+
+			entries := readEntries(t, path)
+			require.Len(t, entries, 2)
+
+			assert.Equal(t, map[string]any{
+				"time":        "2025-01-01T00:00:00Z",
+				"client_ip":   tc.wantClient,
+				"client_name": "laptop",
+				"name":        "first.example.",
+				"qtype":       "A",
+				"proto":       "udp",
+				"group":       "default",
+				"upstream":    "udp://192.0.2.1:53",
+				"rcode":       "NOERROR",
+				"elapsed_ms":  1.5,
+				"cached":      false,
+			}, entries[0])
+			assert.Equal(t, "second.example.", entries[1]["name"])
+		})
+	}
+}
+
+func TestFile_rotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "querylog.jsonl")
+
+	// Make the clock advance on each call, so that the rotated files have
+	// different names.
+	cur := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &faketime.Clock{
+		OnNow: func() (now time.Time) {
+			cur = cur.Add(time.Millisecond)
+
+			return cur
+		},
+	}
+
+	// An unrelated file that must not be removed.
+	unrelated := path + ".bak"
+	require.NoError(t, os.WriteFile(unrelated, nil, 0o600))
+
+	// Each entry is larger than the maximum size, so each one is written to a
+	// separate file.
+	newTestFile(t, &querylog.FileConfig{
+		Clock:      clock,
+		Path:       path,
+		MaxSize:    1,
+		MaxBackups: 2,
+	}, "1.example.", "2.example.", "3.example.", "4.example.")
+
+	rotated, err := filepath.Glob(path + ".2025*")
+	require.NoError(t, err)
+	require.Len(t, rotated, 2)
+
+	assert.Equal(t, "2.example.", readEntries(t, rotated[0])[0]["name"])
+	assert.Equal(t, "3.example.", readEntries(t, rotated[1])[0]["name"])
+	assert.Equal(t, "4.example.", readEntries(t, path)[0]["name"])
+	assert.FileExists(t, unrelated)
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		in   netip.Addr
+		want netip.Addr
+		name string
+	}{{
+		in:   netip.MustParseAddr("192.0.2.123"),
+		want: netip.MustParseAddr("192.0.0.0"),
+		name: "ipv4",
+	}, {
+		in:   netip.MustParseAddr("::ffff:192.0.2.123"),
+		want: netip.MustParseAddr("192.0.0.0"),
+		name: "ipv4_mapped",
+	}, {
+		in:   netip.MustParseAddr("2001:db8:1:2:3:4:5:6"),
+		want: netip.MustParseAddr("2001:db8:1::"),
+		name: "ipv6",
+	}, {
+		in:   netip.Addr{},
+		want: netip.Addr{},
+		name: "invalid",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, querylog.AnonymizeIP(tc.in))
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package rpz
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// Special names of the RPZ format.
+const (
+	// targetNXDOMAIN is the CNAME target of the NXDOMAIN action.
+	targetNXDOMAIN = "."
+
+	// targetNODATA is the CNAME target of the NODATA action.
+	targetNODATA = "*."
+
+	// targetPassthru is the CNAME target of the PASSTHRU action.
+	//
+	// #nosec G101 -- This is not a credential.
+	targetPassthru = "rpz-passthru."
+
+	// labelIP is the label of the response IP triggers.
+	labelIP = "rpz-ip"
+
+	// labelClientIP is the label of the client IP triggers, which aren't
+	// supported.
+	labelClientIP = "rpz-client-ip"
+
+	// labelNSDName is the label of the NS name triggers, which aren't
+	// supported.
+	labelNSDName = "rpz-nsdname"
+
+	// labelNSIP is the label of the NS IP triggers, which aren't supported.
+	labelNSIP = "rpz-nsip"
+
+	// wildcardPrefix is the prefix of the wildcard QNAME triggers.
+	wildcardPrefix = "*."
+
+	// zeroesLabel replaces the longest run of zero groups within the IPv6
+	// addresses of the response IP triggers.
+	zeroesLabel = "zz"
+)
+
+// rule is a single policy rule of a zone.
+type rule struct {
+	// trigger is the owner name of the rule's records.
+	trigger string
+
+	// action is the action of the rule.
+	action Action
+
+	// records are the records to respond with for the [ActionLocalData].
+	records []dns.RR
+}
+
+// ipRule is a response IP trigger with its rule.
+type ipRule struct {
+	*rule
+
+	// prefix is the subnet of the addresses the rule is triggered by.
+	prefix netip.Prefix
+}
+
+// policyData is the parsed content of a policy zone.
+type policyData struct {
+	// soa is the SOA record of the zone.  It's nil if the zone hasn't been
+	// loaded yet.
+	soa *dns.SOA
+
+	// qnames maps the lowercased FQDNs relative to the zone's origin to the
+	// QNAME rules.  Wildcard triggers are stored as is.
+	qnames map[string]*rule
+
+	// records are all the records of the zone except for the SOA one.  Those
+	// are kept to apply the incremental transfers.
+	records []dns.RR
+
+	// ips are the response IP rules.
+	ips []*ipRule
+
+	// skipped is the number of the triggers, which aren't supported.
+	skipped int
+}
+
+// newEmptyData returns a new *policyData with no rules.
+func newEmptyData() (data *policyData) {
+	return &policyData{
+		qnames: map[string]*rule{},
+	}
+}
+
+// parseFile parses the zone in the RFC 1035 format with the lowercased FQDN
+// origin from r.  path is used to report the positions of the errors.
+func parseFile(r io.Reader, origin, path string) (data *policyData, err error) {
+	var rrs []dns.RR
+	zp := dns.NewZoneParser(r, origin, path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+
+	err = zp.Err()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return newPolicyData(origin, rrs)
+}
+
+// newPolicyData builds the rules of the zone with the lowercased FQDN origin
+// from rrs.  The zone must have a single SOA record at its apex and all the
+// records must be within the zone.
+func newPolicyData(origin string, rrs []dns.RR) (data *policyData, err error) {
+	data = newEmptyData()
+
+	owners := map[string][]dns.RR{}
+	var names []string
+	var errs []error
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+		err = data.add(origin, owner, rr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("record %q: %w", owner, err))
+		} else if owner != origin {
+			if _, ok := owners[owner]; !ok {
+				names = append(names, owner)
+			}
+
+			owners[owner] = append(owners[owner], rr)
+		}
+	}
+
+	if data.soa == nil {
+		errs = append(errs, fmt.Errorf("no soa record for %q", origin))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	for _, owner := range names {
+		data.addRule(strings.TrimSuffix(owner, "."+origin), owner, owners[owner])
+	}
+
+	return data, nil
+}
+
+// add validates rr owned by the lowercased FQDN owner and adds it to the
+// records of data.
+func (data *policyData) add(origin, owner string, rr dns.RR) (err error) {
+	if !dns.IsSubDomain(origin, owner) {
+		return fmt.Errorf("out of zone %q", origin)
+	}
+
+	soa, ok := rr.(*dns.SOA)
+	if !ok {
+		data.records = append(data.records, rr)
+
+		return nil
+	}
+
+	if owner != origin {
+		return fmt.Errorf("soa record must be at the apex of zone %q", origin)
+	} else if data.soa != nil {
+		return fmt.Errorf("soa record: %w", errors.ErrDuplicated)
+	}
+
+	data.soa = soa
+
+	return nil
+}
+
+// addRule adds the rule made of rrs owned by owner to data.  rel is owner
+// relative to the zone's origin.  The unsupported triggers and actions are
+// counted as skipped.
+func (data *policyData) addRule(rel, owner string, rrs []dns.RR) {
+	r := newRule(owner, rrs)
+	if r == nil {
+		data.skipped++
+
+		return
+	}
+
+	labels := strings.Split(rel, ".")
+	switch labels[len(labels)-1] {
+	case labelIP:
+		prefix, err := parseIPTrigger(labels[:len(labels)-1])
+		if err != nil {
+			data.skipped++
+
+			return
+		}
+
+		data.ips = append(data.ips, &ipRule{
+			rule:   r,
+			prefix: prefix,
+		})
+	case labelClientIP, labelNSDName, labelNSIP:
+		data.skipped++
+	default:
+		data.qnames[rel+"."] = r
+	}
+}
+
+// newRule returns the rule with the records rrs of the trigger owner.  r is nil
+// if the action isn't supported.
+func newRule(owner string, rrs []dns.RR) (r *rule) {
+	r = &rule{
+		trigger: owner,
+		action:  ActionLocalData,
+	}
+
+	cname, ok := rrs[0].(*dns.CNAME)
+	if len(rrs) > 1 || !ok {
+		r.records = rrs
+
+		return r
+	}
+
+	switch target := strings.ToLower(cname.Target); {
+	case target == targetNXDOMAIN:
+		r.action = ActionNXDOMAIN
+	case target == targetNODATA:
+		r.action = ActionNODATA
+	case target == targetPassthru:
+		r.action = ActionPassthru
+	case strings.HasPrefix(target, "rpz-"):
+		// Other special actions, like rpz-drop and rpz-tcp-only.
+		return nil
+	default:
+		r.records = rrs
+	}
+
+	return r
+}
+
+// parseIPTrigger parses the labels of a response IP trigger without the
+// rpz-ip label.  The first label is the prefix length, and the rest are the
+// labels of the address in the reverse order.
+func parseIPTrigger(labels []string) (prefix netip.Prefix, err error) {
+	if len(labels) < 2 {
+		return netip.Prefix{}, errors.Error("too few labels")
+	}
+
+	bits, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("prefix length: %w", err)
+	}
+
+	addrLabels := slices.Clone(labels[1:])
+	slices.Reverse(addrLabels)
+
+	var addrStr string
+	if len(addrLabels) == 4 && !slices.Contains(addrLabels, zeroesLabel) {
+		addrStr = strings.Join(addrLabels, ".")
+	} else {
+		addrStr = ipv6String(addrLabels)
+	}
+
+	addr, err := netip.ParseAddr(addrStr)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return netip.Prefix{}, err
+	}
+
+	prefix, err = addr.Prefix(bits)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return netip.Prefix{}, err
+	}
+
+	return prefix, nil
+}
+
+// ipv6String returns the textual representation of the IPv6 address made of
+// the groups, which may contain the [zeroesLabel].
+func ipv6String(groups []string) (s string) {
+	s = strings.Join(groups, ":")
+
+	const colon = ":"
+	switch {
+	case s == zeroesLabel:
+		return colon + colon
+	case strings.HasPrefix(s, zeroesLabel+colon):
+		return colon + strings.TrimPrefix(s, zeroesLabel)
+	case strings.HasSuffix(s, colon+zeroesLabel):
+		return strings.TrimSuffix(s, zeroesLabel) + colon
+	default:
+		return strings.Replace(s, zeroesLabel, "", 1)
+	}
+}
+
+// matchQName returns the QNAME rule of data matching the lowercased FQDN name,
+// if any.  The exact triggers take precedence over the wildcard ones, and the
+// longer wildcard triggers take precedence over the shorter ones.
+func (data *policyData) matchQName(name string) (r *rule) {
+	if r = data.qnames[name]; r != nil {
+		return r
+	}
+
+	for _, d, _ := strings.Cut(name, "."); d != ""; _, d, _ = strings.Cut(d, ".") {
+		if r = data.qnames[wildcardPrefix+d]; r != nil {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// matchIPs returns the response IP rule of data with the longest prefix
+// matching any of addrs, if any.
+func (data *policyData) matchIPs(addrs []netip.Addr) (best *ipRule) {
+	for _, r := range data.ips {
+		if best != nil && r.prefix.Bits() <= best.prefix.Bits() {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if r.prefix.Contains(addr) {
+				best = r
+
+				break
+			}
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,300 @@
+package rpz
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testOrigin is the origin of the policy zone for tests.
+const testOrigin = "rpz.example."
+
+// testZone is the content of the policy zone file for tests.
+const testZone = `$TTL 300
+@                        IN SOA   ns.rpz.example. admin.rpz.example. 1 3600 600 86400 60
+@                        IN NS    ns.rpz.example.
+bad.example              IN CNAME .
+*.bad.example            IN CNAME *.
+good.bad.example         IN CNAME rpz-passthru.
+walled.example           IN A     192.0.2.10
+walled.example           IN AAAA  2001:db8::10
+redirect.example         IN CNAME garden.example.
+drop.example             IN CNAME rpz-drop.
+24.0.2.0.192.rpz-ip      IN CNAME .
+32.1.2.0.192.rpz-ip      IN CNAME *.
+128.1.zz.db8.2001.rpz-ip IN CNAME .
+32.1.2.0.192.rpz-client-ip IN CNAME .
+ns.bad.example.rpz-nsdname IN CNAME .
+`
+
+func TestParseIPTrigger(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		trigger    string
+		wantErrMsg string
+		want       netip.Prefix
+	}{{
+		name:       "ipv4",
+		trigger:    "24.0.2.0.192",
+		wantErrMsg: "",
+		want:       netip.MustParsePrefix("192.0.2.0/24"),
+	}, {
+		name:       "ipv4_masked",
+		trigger:    "8.1.2.0.192",
+		wantErrMsg: "",
+		want:       netip.MustParsePrefix("192.0.0.0/8"),
+	}, {
+		name:       "ipv6_zeroes_middle",
+		trigger:    "128.1.zz.db8.2001",
+		wantErrMsg: "",
+		want:       netip.MustParsePrefix("2001:db8::1/128"),
+	}, {
+		name:       "ipv6_zeroes_end",
+		trigger:    "32.zz.db8.2001",
+		wantErrMsg: "",
+		want:       netip.MustParsePrefix("2001:db8::/32"),
+	}, {
+		name:       "ipv6_zeroes_start",
+		trigger:    "128.1.zz",
+		wantErrMsg: "",
+		want:       netip.MustParsePrefix("::1/128"),
+	}, {
+		name:       "ipv6_full",
+		trigger:    "64.8.7.6.5.4.3.2.1",
+		wantErrMsg: "",
+		want:       netip.MustParsePrefix("1:2:3:4::/64"),
+	}, {
+		name:       "bad_length",
+		trigger:    "33.1.2.0.192",
+		wantErrMsg: "prefix length 33 too large for IPv4",
+		want:       netip.Prefix{},
+	}, {
+		name:       "too_few",
+		trigger:    "32",
+		wantErrMsg: "too few labels",
+		want:       netip.Prefix{},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseIPTrigger(strings.Split(tc.trigger, "."))
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPolicyData_match(t *testing.T) {
+	t.Parallel()
+
+	data, err := parseFile(strings.NewReader(testZone), testOrigin, "rpz.zone")
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(1), data.soa.Serial)
+	assert.Len(t, data.qnames, 5)
+	assert.Len(t, data.ips, 3)
+	assert.Equal(t, 3, data.skipped)
+
+	qnameCases := []struct {
+		name        string
+		host        string
+		wantTrigger string
+		wantAction  Action
+	}{{
+		name:        "exact",
+		host:        "bad.example.",
+		wantTrigger: "bad.example.rpz.example.",
+		wantAction:  ActionNXDOMAIN,
+	}, {
+		name:        "wildcard",
+		host:        "www.bad.example.",
+		wantTrigger: "*.bad.example.rpz.example.",
+		wantAction:  ActionNODATA,
+	}, {
+		name:        "exact_over_wildcard",
+		host:        "good.bad.example.",
+		wantTrigger: "good.bad.example.rpz.example.",
+		wantAction:  ActionPassthru,
+	}, {
+		name:        "local_data",
+		host:        "walled.example.",
+		wantTrigger: "walled.example.rpz.example.",
+		wantAction:  ActionLocalData,
+	}, {
+		name:        "unsupported_action",
+		host:        "drop.example.",
+		wantTrigger: "",
+		wantAction:  "",
+	}, {
+		name:        "none",
+		host:        "example.",
+		wantTrigger: "",
+		wantAction:  "",
+	}}
+
+	for _, tc := range qnameCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := data.matchQName(tc.host)
+			if tc.wantTrigger == "" {
+				assert.Nil(t, r)
+
+				return
+			}
+
+			require.NotNil(t, r)
+
+			assert.Equal(t, tc.wantTrigger, r.trigger)
+			assert.Equal(t, tc.wantAction, r.action)
+		})
+	}
+
+	ipCases := []struct {
+		name        string
+		wantTrigger string
+		addrs       []netip.Addr
+	}{{
+		name:        "subnet",
+		wantTrigger: "24.0.2.0.192.rpz-ip.rpz.example.",
+		addrs:       []netip.Addr{netip.MustParseAddr("192.0.2.2")},
+	}, {
+		name:        "longest_prefix",
+		wantTrigger: "32.1.2.0.192.rpz-ip.rpz.example.",
+		addrs: []netip.Addr{
+			netip.MustParseAddr("192.0.2.2"),
+			netip.MustParseAddr("192.0.2.1"),
+		},
+	}, {
+		name:        "ipv6",
+		wantTrigger: "128.1.zz.db8.2001.rpz-ip.rpz.example.",
+		addrs:       []netip.Addr{netip.MustParseAddr("2001:db8::1")},
+	}, {
+		name:        "none",
+		wantTrigger: "",
+		addrs:       []netip.Addr{netip.MustParseAddr("198.51.100.1")},
+	}}
+
+	for _, tc := range ipCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := data.matchIPs(tc.addrs)
+			if tc.wantTrigger == "" {
+				assert.Nil(t, r)
+
+				return
+			}
+
+			require.NotNil(t, r)
+
+			assert.Equal(t, tc.wantTrigger, r.trigger)
+		})
+	}
+}
+
+func TestParseFile_bad(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		content    string
+		wantErrMsg string
+	}{{
+		name:       "no_soa",
+		content:    "$TTL 60\nbad.example IN CNAME .\n",
+		wantErrMsg: `no soa record for "rpz.example."`,
+	}, {
+		name: "out_of_zone",
+		content: "$TTL 60\n@ IN SOA ns admin 1 1 1 1 1\n" +
+			"bad.example. IN CNAME .\n",
+		wantErrMsg: `record "bad.example.": out of zone "rpz.example."`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := parseFile(strings.NewReader(tc.content), testOrigin, "rpz.zone")
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestApplyTransfer(t *testing.T) {
+	t.Parallel()
+
+	newRR := func(s string) (rr dns.RR) {
+		rr, err := dns.NewRR(s)
+		require.NoError(t, err)
+
+		return rr
+	}
+
+	soa1 := newRR("rpz.example. 300 IN SOA ns.rpz.example. admin.rpz.example. 1 3600 600 86400 60")
+	soa2 := newRR("rpz.example. 300 IN SOA ns.rpz.example. admin.rpz.example. 2 3600 600 86400 60")
+	first := newRR("first.example.rpz.example. 300 IN CNAME .")
+	second := newRR("second.example.rpz.example. 300 IN CNAME .")
+
+	cur, err := applyTransfer(testOrigin, newEmptyData(), []dns.RR{soa1, first, soa1})
+	require.NoError(t, err)
+	require.NotNil(t, cur.matchQName("first.example."))
+
+	t.Run("up_to_date", func(t *testing.T) {
+		t.Parallel()
+
+		data, applyErr := applyTransfer(testOrigin, cur, []dns.RR{soa1})
+		require.NoError(t, applyErr)
+
+		assert.Nil(t, data)
+	})
+
+	t.Run("incremental", func(t *testing.T) {
+		t.Parallel()
+
+		data, applyErr := applyTransfer(testOrigin, cur, []dns.RR{
+			soa2,
+			soa1,
+			first,
+			soa2,
+			second,
+			soa2,
+		})
+		require.NoError(t, applyErr)
+		require.NotNil(t, data)
+
+		assert.Equal(t, uint32(2), data.soa.Serial)
+		assert.Nil(t, data.matchQName("first.example."))
+		assert.NotNil(t, data.matchQName("second.example."))
+
+		// The current content must stay intact.
+		assert.NotNil(t, cur.matchQName("first.example."))
+	})
+
+	t.Run("full", func(t *testing.T) {
+		t.Parallel()
+
+		data, applyErr := applyTransfer(testOrigin, cur, []dns.RR{soa2, second, soa2})
+		require.NoError(t, applyErr)
+		require.NotNil(t, data)
+
+		assert.Nil(t, data.matchQName("first.example."))
+		assert.NotNil(t, data.matchQName("second.example."))
+	})
+
+	t.Run("no_soa", func(t *testing.T) {
+		t.Parallel()
+
+		_, applyErr := applyTransfer(testOrigin, cur, []dns.RR{first})
+		testutil.AssertErrorMsg(t, "first record: want soa, got CNAME", applyErr)
+	})
+}
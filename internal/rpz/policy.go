@@ -0,0 +1,344 @@
+package rpz
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// Intervals between the transfers of a zone.
+const (
+	// defaultRetry is the interval between the attempts to transfer a zone,
+	// which has never been transferred, so that its SOA record is unknown.
+	defaultRetry = 1 * time.Minute
+
+	// minTransferInterval is the minimum interval between the transfers of a
+	// zone, which protects the primary from the zones with too small refresh
+	// and retry intervals.
+	minTransferInterval = 10 * time.Second
+)
+
+// policy is a single response policy zone read from a file or transferred from
+// a primary server.
+type policy struct {
+	// data is the current content of the zone.  It's never nil.
+	data atomic.Pointer[policyData]
+
+	// mu serializes the updates and protects modTime and next.
+	mu *sync.Mutex
+
+	// modTime is the modification time of the file at the time it was last
+	// read.
+	modTime time.Time
+
+	// next is the time of the next transfer of the zone from the primary.
+	next time.Time
+
+	// name is the name of the policy.
+	name string
+
+	// zone is the lowercased FQDN of the zone's apex.
+	zone string
+
+	// path is the path to the zone file, if any.
+	path string
+
+	// primary is the address of the primary server, if any.
+	primary netip.AddrPort
+
+	// timeout is the timeout of the transfer operations.
+	timeout time.Duration
+}
+
+// newPolicy returns a new empty *policy configured by conf.  timeout is used
+// for the transfers.
+func newPolicy(conf *PolicyConfig, timeout time.Duration) (p *policy) {
+	p = &policy{
+		mu:      &sync.Mutex{},
+		name:    conf.Name,
+		zone:    normalizeDomain(conf.Zone),
+		path:    conf.Path,
+		primary: conf.Primary,
+		timeout: timeout,
+	}
+	p.data.Store(newEmptyData())
+
+	return p
+}
+
+// load reads or transfers the zone of p and replaces the current content.
+func (p *policy) load(ctx context.Context, logger *slog.Logger) (err error) {
+	var data *policyData
+	if p.primary.IsValid() {
+		data, err = p.transfer()
+	} else {
+		data, err = p.read()
+	}
+
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if data == nil {
+		logger.DebugContext(ctx, "zone is up to date", "policy", p.name)
+
+		return nil
+	}
+
+	p.data.Store(data)
+
+	logger.InfoContext(
+		ctx,
+		"zone loaded",
+		"policy", p.name,
+		"serial", data.soa.Serial,
+		"qname_triggers", len(data.qnames),
+		"ip_triggers", len(data.ips),
+		"skipped", data.skipped,
+	)
+
+	return nil
+}
+
+// refresh reloads the zone of p if the file has been modified since it was last
+// read or the time of the next transfer has come.
+func (p *policy) refresh(ctx context.Context, logger *slog.Logger) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.primary.IsValid() {
+		if time.Now().Before(p.next) {
+			return nil
+		}
+	} else {
+		var modTime time.Time
+		modTime, err = fileModTime(p.path)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		} else if modTime.Equal(p.modTime) {
+			return nil
+		}
+	}
+
+	err = p.load(ctx, logger)
+	if err != nil {
+		return fmt.Errorf("keeping previous zone: %w", err)
+	}
+
+	return nil
+}
+
+// nextTransfer returns the time of the next transfer of p.  ok is false if p
+// isn't transferred.
+func (p *policy) nextTransfer() (next time.Time, ok bool) {
+	if !p.primary.IsValid() {
+		return time.Time{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.next, true
+}
+
+// read reads the zone from the file of p.  It remembers the modification time
+// of the file before reading it to not miss the changes made during the
+// reading, and to not read an invalid file again until it's changed.
+func (p *policy) read() (data *policyData, err error) {
+	p.modTime, err = fileModTime(p.path)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	// Don't wrap the error, because it's informative enough as is.
+	return readFile(p.zone, p.path)
+}
+
+// readFile reads and parses the policy zone with the lowercased FQDN origin
+// from the file at path.
+func readFile(origin, path string) (data *policyData, err error) {
+	// #nosec G304 -- Trust the path to the zone file that is set in the
+	// configuration file.
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening zone file: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	data, err = parseFile(f, origin, path)
+	if err != nil {
+		return nil, fmt.Errorf("reading zone file: %w", err)
+	}
+
+	return data, nil
+}
+
+// fileModTime returns the modification time of the file at path.
+func fileModTime(path string) (modTime time.Time, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("checking zone file: %w", err)
+	}
+
+	return fi.ModTime(), nil
+}
+
+// transfer transfers the zone of p from the primary and schedules the next
+// transfer.  The incremental transfer is requested, if the zone has been
+// transferred before.  data is nil if the zone is up to date.
+func (p *policy) transfer() (data *policyData, err error) {
+	cur := p.data.Load()
+	defer func() {
+		soa := cur.soa
+		if data != nil {
+			soa = data.soa
+		}
+
+		p.schedule(soa, err != nil)
+	}()
+
+	req := &dns.Msg{}
+	if cur.soa != nil {
+		req.SetIxfr(p.zone, cur.soa.Serial, cur.soa.Ns, cur.soa.Mbox)
+	} else {
+		req.SetAxfr(p.zone)
+	}
+
+	rrs, err := p.exchange(req)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	data, err = applyTransfer(p.zone, cur, rrs)
+	if err != nil {
+		return nil, fmt.Errorf("applying transfer: %w", err)
+	}
+
+	return data, nil
+}
+
+// exchange sends the transfer request req to the primary of p and returns the
+// transferred records.
+func (p *policy) exchange(req *dns.Msg) (rrs []dns.RR, err error) {
+	tr := &dns.Transfer{
+		DialTimeout:  p.timeout,
+		ReadTimeout:  p.timeout,
+		WriteTimeout: p.timeout,
+	}
+
+	envs, err := tr.In(req, p.primary.String())
+	if err != nil {
+		if tr.Conn != nil {
+			err = errors.WithDeferred(err, tr.Close())
+		}
+
+		return nil, fmt.Errorf("requesting transfer: %w", err)
+	}
+
+	for env := range envs {
+		if env.Error != nil {
+			// The channel is closed after the error.
+			return nil, fmt.Errorf("transferring zone: %w", env.Error)
+		}
+
+		rrs = append(rrs, env.RR...)
+	}
+
+	return rrs, nil
+}
+
+// schedule sets the time of the next transfer according to the refresh or, if
+// the transfer has failed, the retry interval of soa.  soa may be nil.
+func (p *policy) schedule(soa *dns.SOA, failed bool) {
+	interval := defaultRetry
+	if soa != nil {
+		secs := soa.Refresh
+		if failed {
+			secs = soa.Retry
+		}
+
+		interval = max(time.Duration(secs)*time.Second, minTransferInterval)
+	}
+
+	p.next = time.Now().Add(interval)
+}
+
+// applyTransfer returns the new content of the zone with the lowercased FQDN
+// origin from the records rrs transferred using AXFR or IXFR and the current
+// content cur.  data is nil if the zone is up to date.
+func applyTransfer(origin string, cur *policyData, rrs []dns.RR) (data *policyData, err error) {
+	if len(rrs) == 0 {
+		return nil, errors.Error("no records")
+	}
+
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, fmt.Errorf("first record: want soa, got %s", dns.TypeToString[rrs[0].Header().Rrtype])
+	}
+
+	switch {
+	case len(rrs) == 1 && cur.soa != nil:
+		return nil, nil
+	case isIncremental(cur, rrs):
+		records := applyDiffs(cur.records, rrs[1:len(rrs)-1])
+
+		// Don't wrap the error, because it's informative enough as is.
+		return newPolicyData(origin, append([]dns.RR{soa}, records...))
+	default:
+		// The full zone is terminated with the repeated SOA record.
+		//
+		// Don't wrap the error, because it's informative enough as is.
+		return newPolicyData(origin, rrs[:len(rrs)-1])
+	}
+}
+
+// isIncremental returns true if rrs are the incremental transfer of the zone
+// with the current content cur, as opposed to the full one.  rrs must start
+// with the SOA record.
+func isIncremental(cur *policyData, rrs []dns.RR) (ok bool) {
+	if cur.soa == nil || len(rrs) < 3 {
+		return false
+	}
+
+	old, ok := rrs[1].(*dns.SOA)
+
+	return ok && old.Serial == cur.soa.Serial
+}
+
+// applyDiffs returns records with the differences of the incremental transfer
+// applied.  diffs are the sequences of the old SOA record followed by the
+// deleted records and the new SOA record followed by the added ones.  records
+// aren't modified.
+func applyDiffs(records, diffs []dns.RR) (res []dns.RR) {
+	res = slices.Clone(records)
+
+	deleting := false
+	for _, rr := range diffs {
+		if _, ok := rr.(*dns.SOA); ok {
+			deleting = !deleting
+
+			continue
+		}
+
+		if deleting {
+			res = slices.DeleteFunc(res, func(r dns.RR) (del bool) {
+				return dns.IsDuplicate(r, rr)
+			})
+		} else {
+			res = append(res, rr)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,129 @@
+package rpz
+
+import (
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is a timeout for tests.
+const testTimeout = 1 * time.Second
+
+// startPrimary starts a DNS server on localhost serving the zone transfers
+// with h.
+func startPrimary(t *testing.T, h dns.Handler) (addr netip.AddrPort) {
+	t.Helper()
+
+	startCh := make(chan netip.AddrPort)
+	errCh := make(chan error)
+
+	srv := &dns.Server{
+		Addr:         netip.AddrPortFrom(netutil.IPv4Localhost(), 0).String(),
+		Net:          "tcp",
+		Handler:      h,
+		ReadTimeout:  testTimeout,
+		WriteTimeout: testTimeout,
+	}
+	srv.NotifyStartedFunc = func() {
+		startCh <- netutil.NetAddrToAddrPort(srv.Listener.Addr())
+	}
+
+	go func() { errCh <- srv.ListenAndServe() }()
+
+	select {
+	case addr = <-startCh:
+		testutil.CleanupAndRequireSuccess(t, func() (err error) { return <-errCh })
+		testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-time.After(testTimeout):
+		require.FailNow(t, "timeout exceeded")
+	}
+
+	return addr
+}
+
+func TestPolicy_transfer(t *testing.T) {
+	t.Parallel()
+
+	newRR := func(s string) (rr dns.RR) {
+		rr, err := dns.NewRR(s)
+		require.NoError(t, err)
+
+		return rr
+	}
+
+	soa1 := newRR("rpz.example. 300 IN SOA ns.rpz.example. admin.rpz.example. 1 3600 600 86400 60")
+	soa2 := newRR("rpz.example. 300 IN SOA ns.rpz.example. admin.rpz.example. 2 3600 600 86400 60")
+	first := newRR("first.example.rpz.example. 300 IN CNAME .")
+	second := newRR("second.example.rpz.example. 300 IN CNAME *.")
+
+	// The first request transfers the full zone, and the next ones transfer
+	// the differences from the first version.
+	reqTypes := make(chan uint16, 2)
+	serial2 := &atomic.Bool{}
+
+	pt := testutil.PanicT{}
+	addr := startPrimary(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		qtype := req.Question[0].Qtype
+		reqTypes <- qtype
+
+		resp := (&dns.Msg{}).SetReply(req)
+		switch {
+		case qtype == dns.TypeAXFR:
+			resp.Answer = []dns.RR{soa1, first, soa1}
+		case serial2.Load():
+			resp.Answer = []dns.RR{soa2, soa1, first, soa2, second, soa2}
+		default:
+			resp.Answer = []dns.RR{soa1}
+		}
+
+		require.NoError(pt, w.WriteMsg(resp))
+	}))
+
+	p := newPolicy(&PolicyConfig{
+		Name:    "transferred",
+		Zone:    "RPZ.example",
+		Primary: addr,
+	}, testTimeout)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	logger := slogutil.NewDiscardLogger()
+
+	require.NoError(t, p.load(ctx, logger))
+	assert.Equal(t, dns.TypeAXFR, <-reqTypes)
+	assert.NotNil(t, p.data.Load().matchQName("first.example."))
+
+	next, ok := p.nextTransfer()
+	require.True(t, ok)
+
+	assert.WithinDuration(t, time.Now().Add(time.Hour), next, time.Minute)
+
+	// The refresh interval of the zone hasn't passed yet.
+	require.NoError(t, p.refresh(ctx, logger))
+	require.Empty(t, reqTypes)
+
+	p.next = time.Time{}
+	require.NoError(t, p.refresh(ctx, logger))
+	assert.Equal(t, dns.TypeIXFR, <-reqTypes)
+	assert.Equal(t, uint32(1), p.data.Load().soa.Serial)
+
+	serial2.Store(true)
+	p.next = time.Time{}
+	require.NoError(t, p.refresh(ctx, logger))
+	assert.Equal(t, dns.TypeIXFR, <-reqTypes)
+
+	data := p.data.Load()
+	assert.Equal(t, uint32(2), data.soa.Serial)
+	assert.Nil(t, data.matchQName("first.example."))
+	assert.NotNil(t, data.matchQName("second.example."))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), p.next, time.Minute)
+}
@@ -0,0 +1,277 @@
+// Package rpz contains the response policy zones of AdGuardDNSClient, which
+// rewrite the responses to the requests for the listed domain names and to the
+// ones resolved to the listed addresses.
+package rpz
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/miekg/dns"
+)
+
+// Action is the action of a policy rule.
+type Action string
+
+// Valid actions.
+const (
+	// ActionNXDOMAIN makes the service respond with NXDOMAIN.
+	ActionNXDOMAIN Action = "nxdomain"
+
+	// ActionNODATA makes the service respond with an empty NOERROR response.
+	ActionNODATA Action = "nodata"
+
+	// ActionPassthru makes the service respond as usual and stops the
+	// matching against the rest of the policies.
+	ActionPassthru Action = "passthru"
+
+	// ActionLocalData makes the service respond with the records of the rule.
+	ActionLocalData Action = "local_data"
+)
+
+// PolicyConfig is the configuration of a single response policy zone.
+type PolicyConfig struct {
+	// Name is the name of the policy used in logs.  It must not be empty.
+	Name string
+
+	// Zone is the domain name of the policy zone's apex.  It's also used as
+	// the origin for the relative names within the file.  It must be a valid
+	// domain name.
+	Zone string
+
+	// Path is the absolute path to the zone file in the RFC 1035 format.  The
+	// file is re-read by [Policies.Refresh] when it changes.  It's empty if
+	// the zone is transferred from Primary.
+	Path string
+
+	// Primary is the address of the primary server to transfer the zone from
+	// using AXFR and IXFR.  The zone is transferred again by
+	// [Policies.Refresh] according to the refresh and retry intervals of its
+	// SOA record.  It's only valid if Path is empty.
+	Primary netip.AddrPort
+}
+
+// Config is the configuration for [Policies].
+type Config struct {
+	// Logger is used to log the loading of the zones.  It must not be nil.
+	Logger *slog.Logger
+
+	// Policies are the response policy zones in the order of precedence.
+	Policies []*PolicyConfig
+
+	// Timeout is the timeout for each of connecting to the primary, writing
+	// the request, and reading the response during a transfer.  It must be
+	// positive.
+	Timeout time.Duration
+
+	// CheckOnly, if true, makes [New] only read the zones from the files
+	// without transferring the ones from the primaries, which are left empty.
+	CheckOnly bool
+}
+
+// ValidateFile returns an error if the file at path can't be read or isn't a
+// valid policy zone named zone.
+func ValidateFile(zone, path string) (err error) {
+	_, err = readFile(normalizeDomain(zone), path)
+
+	// Don't wrap the error, because it's informative enough as is.
+	return err
+}
+
+// Policies matches the requests and the responses against the response policy
+// zones.
+type Policies struct {
+	// logger is used to log the loading of the zones.
+	logger *slog.Logger
+
+	// policies are the loaded zones in the order of precedence.
+	policies []*policy
+}
+
+// New returns a new *Policies with the zones from conf loaded.  The zones read
+// from the files must be valid, while the ones failed to transfer are logged
+// and left empty until they're transferred by [Policies.Refresh].  conf must
+// not be nil.
+func New(ctx context.Context, conf *Config) (ps *Policies, err error) {
+	ps = &Policies{
+		logger:   conf.Logger.With(slogutil.KeyPrefix, "rpz"),
+		policies: make([]*policy, 0, len(conf.Policies)),
+	}
+
+	var errs []error
+	for _, pc := range conf.Policies {
+		p := newPolicy(pc, conf.Timeout)
+		if conf.CheckOnly && p.primary.IsValid() {
+			ps.policies = append(ps.policies, p)
+
+			continue
+		}
+
+		err = p.load(ctx, ps.logger)
+		if err != nil && !p.primary.IsValid() {
+			errs = append(errs, fmt.Errorf("policy %q: %w", p.name, err))
+
+			continue
+		} else if err != nil {
+			ps.logger.ErrorContext(ctx, "transferring zone", "policy", p.name, slogutil.KeyError, err)
+		}
+
+		ps.policies = append(ps.policies, p)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return ps, nil
+}
+
+// Match is the result of matching a request or a response against the
+// policies.
+type Match struct {
+	// Policy is the name of the policy containing the matched rule.
+	Policy string
+
+	// Trigger is the owner name of the matched rule within the policy zone.
+	Trigger string
+
+	// Action is the action of the matched rule.
+	Action Action
+
+	// records are the records to respond with for the [ActionLocalData].
+	records []dns.RR
+}
+
+// newMatch returns the match of r within p.
+func newMatch(p *policy, r *rule) (m *Match) {
+	return &Match{
+		Policy:  p.name,
+		Trigger: r.trigger,
+		Action:  r.action,
+		records: r.records,
+	}
+}
+
+// MatchQName returns the first QNAME rule matching the domain name from the
+// question of a request, if any.  ps must not be nil.
+func (ps *Policies) MatchQName(name string) (m *Match) {
+	name = normalizeDomain(name)
+	for _, p := range ps.policies {
+		if r := p.data.Load().matchQName(name); r != nil {
+			return newMatch(p, r)
+		}
+	}
+
+	return nil
+}
+
+// MatchResponse returns the first response IP rule matching any of the
+// addresses within the A and AAAA answers of resp, if any.  Within a policy,
+// the rule with the longest prefix wins.  ps must not be nil.
+func (ps *Policies) MatchResponse(resp *dns.Msg) (m *Match) {
+	var addrs []netip.Addr
+	for _, rr := range resp.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, _ := netip.AddrFromSlice(rr.A)
+			addrs = append(addrs, addr.Unmap())
+		case *dns.AAAA:
+			addr, _ := netip.AddrFromSlice(rr.AAAA)
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	for _, p := range ps.policies {
+		if r := p.data.Load().matchIPs(addrs); r != nil {
+			return newMatch(p, r.rule)
+		}
+	}
+
+	return nil
+}
+
+// Response returns the response to req according to the action of m.  resp is
+// nil for the [ActionPassthru].  req must have a question.
+func (m *Match) Response(req *dns.Msg) (resp *dns.Msg) {
+	if m.Action == ActionPassthru {
+		return nil
+	}
+
+	resp = (&dns.Msg{}).SetReply(req)
+	resp.RecursionAvailable = true
+
+	switch m.Action {
+	case ActionNXDOMAIN:
+		resp.Rcode = dns.RcodeNameError
+	case ActionLocalData:
+		resp.Answer = m.answers(req.Question[0])
+	default:
+		// Respond with NOERROR and no answers.
+	}
+
+	return resp
+}
+
+// answers returns the local data of m answering q with the owner names
+// replaced with the name from q.  The CNAME record answers any type of
+// question.
+func (m *Match) answers(q dns.Question) (rrs []dns.RR) {
+	for _, rr := range m.records {
+		rrType := rr.Header().Rrtype
+		if rrType != q.Qtype && rrType != dns.TypeCNAME && q.Qtype != dns.TypeANY {
+			continue
+		}
+
+		rr = dns.Copy(rr)
+		rr.Header().Name = q.Name
+		rrs = append(rrs, rr)
+	}
+
+	return rrs
+}
+
+// Refresh re-reads the zones from the files modified since those were last
+// read and transfers the zones, which are due to refresh according to their
+// SOA records.  The previous contents of the zones failed to update stay in
+// place.  ps must not be nil.
+func (ps *Policies) Refresh(ctx context.Context) (err error) {
+	var errs []error
+	for _, p := range ps.policies {
+		err = p.refresh(ctx, ps.logger)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("policy %q: %w", p.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// NextRefresh returns the earliest time of the next transfer among the zones
+// transferred from the primaries, which is scheduled according to the refresh
+// and retry intervals of their SOA records.  next is zero if there are no such
+// zones.  ps must not be nil.
+func (ps *Policies) NextRefresh() (next time.Time) {
+	for _, p := range ps.policies {
+		n, ok := p.nextTransfer()
+		if ok && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+
+	return next
+}
+
+// normalizeDomain returns the lowercased FQDN of name.
+func normalizeDomain(name string) (fqdn string) {
+	return dns.Fqdn(strings.ToLower(name))
+}
@@ -0,0 +1,181 @@
+package rpz_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardDNSClient/internal/rpz"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is a timeout for tests.
+const testTimeout = 1 * time.Second
+
+// Names of the policies for tests.
+const (
+	firstPolicy  = "first"
+	secondPolicy = "second"
+)
+
+// writeZone writes the policy zone with content to a file in dir and returns
+// its path.
+func writeZone(t *testing.T, dir, name, content string) (path string) {
+	t.Helper()
+
+	path = filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+// newRequest returns a new request for name of qtype.
+func newRequest(name string, qtype uint16) (req *dns.Msg) {
+	return (&dns.Msg{}).SetQuestion(name, qtype)
+}
+
+func TestPolicies(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	firstPath := writeZone(t, dir, "first.zone", `$TTL 300
+@                   IN SOA   ns admin 1 3600 600 86400 60
+allowed.example     IN CNAME rpz-passthru.
+walled.example      IN A     192.0.2.10
+walled.example      IN AAAA  2001:db8::10
+32.1.2.0.192.rpz-ip IN CNAME *.
+`)
+	secondPath := writeZone(t, dir, "second.zone", `$TTL 300
+@                   IN SOA   ns admin 1 3600 600 86400 60
+allowed.example     IN CNAME .
+*.bad.example       IN CNAME .
+24.0.2.0.192.rpz-ip IN CNAME .
+`)
+
+	ps, err := rpz.New(testutil.ContextWithTimeout(t, testTimeout), &rpz.Config{
+		Logger: slogutil.NewDiscardLogger(),
+		Policies: []*rpz.PolicyConfig{{
+			Name: firstPolicy,
+			Zone: "first.rpz",
+			Path: firstPath,
+		}, {
+			Name: secondPolicy,
+			Zone: "second.rpz",
+			Path: secondPath,
+		}},
+		Timeout: testTimeout,
+	})
+	require.NoError(t, err)
+
+	// The zones read from the files are only checked for changes.
+	assert.True(t, ps.NextRefresh().IsZero())
+
+	t.Run("passthru", func(t *testing.T) {
+		m := ps.MatchQName("Allowed.Example.")
+		require.NotNil(t, m)
+
+		assert.Equal(t, firstPolicy, m.Policy)
+		assert.Equal(t, "allowed.example.first.rpz.", m.Trigger)
+		assert.Equal(t, rpz.ActionPassthru, m.Action)
+		assert.Nil(t, m.Response(newRequest("allowed.example.", dns.TypeA)))
+	})
+
+	t.Run("nxdomain", func(t *testing.T) {
+		m := ps.MatchQName("www.bad.example.")
+		require.NotNil(t, m)
+
+		assert.Equal(t, secondPolicy, m.Policy)
+		assert.Equal(t, rpz.ActionNXDOMAIN, m.Action)
+
+		resp := m.Response(newRequest("www.bad.example.", dns.TypeA))
+		require.NotNil(t, resp)
+
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+		assert.Empty(t, resp.Answer)
+	})
+
+	t.Run("local_data", func(t *testing.T) {
+		m := ps.MatchQName("walled.example.")
+		require.NotNil(t, m)
+
+		assert.Equal(t, rpz.ActionLocalData, m.Action)
+
+		resp := m.Response(newRequest("walled.example.", dns.TypeAAAA))
+		require.NotNil(t, resp)
+		require.Len(t, resp.Answer, 1)
+
+		aaaa := testutil.RequireTypeAssert[*dns.AAAA](t, resp.Answer[0])
+		assert.Equal(t, "walled.example.", aaaa.Hdr.Name)
+		assert.Equal(t, net.ParseIP("2001:db8::10"), aaaa.AAAA)
+
+		resp = m.Response(newRequest("walled.example.", dns.TypeTXT))
+		require.NotNil(t, resp)
+
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Empty(t, resp.Answer)
+	})
+
+	t.Run("response_ip", func(t *testing.T) {
+		req := newRequest("resolved.example.", dns.TypeA)
+		resp := (&dns.Msg{}).SetReply(req)
+		resp.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: "resolved.example.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+			A:   net.ParseIP("192.0.2.1"),
+		}}
+
+		m := ps.MatchResponse(resp)
+		require.NotNil(t, m)
+
+		assert.Equal(t, firstPolicy, m.Policy)
+		assert.Equal(t, rpz.ActionNODATA, m.Action)
+
+		resp.Answer[0].(*dns.A).A = net.ParseIP("192.0.2.2")
+		m = ps.MatchResponse(resp)
+		require.NotNil(t, m)
+
+		assert.Equal(t, secondPolicy, m.Policy)
+		assert.Equal(t, rpz.ActionNXDOMAIN, m.Action)
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		// Set the modification time explicitly, since its resolution may be
+		// too coarse to notice the change.
+		modTime := time.Now().Add(time.Minute)
+		writeZone(t, dir, "first.zone", "$TTL 300\n@ IN SOA ns admin 2 3600 600 86400 60\n")
+		require.NoError(t, os.Chtimes(firstPath, modTime, modTime))
+
+		require.NoError(t, ps.Refresh(testutil.ContextWithTimeout(t, testTimeout)))
+
+		m := ps.MatchQName("allowed.example.")
+		require.NotNil(t, m)
+
+		assert.Equal(t, secondPolicy, m.Policy)
+	})
+}
+
+func TestNew_badFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "missing.zone")
+
+	_, err := rpz.New(testutil.ContextWithTimeout(t, testTimeout), &rpz.Config{
+		Logger: slogutil.NewDiscardLogger(),
+		Policies: []*rpz.PolicyConfig{{
+			Name: firstPolicy,
+			Zone: "first.rpz",
+			Path: path,
+		}},
+		Timeout: testTimeout,
+	})
+	testutil.AssertErrorMsg(
+		t,
+		`policy "first": checking zone file: stat `+path+`: no such file or directory`,
+		err,
+	)
+}
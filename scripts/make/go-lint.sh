@@ -56,6 +56,10 @@ set -f -u
 # schemas, which use package reflect.  If your project needs more exceptions,
 # add and document them.
 #
+# The file internal/cmd/yamlfields.go uses package reflect to collect the YAML
+# keys of the configuration structures for suggesting the misspelled ones, since
+# package gopkg.in/yaml.v3 doesn't expose them.
+#
 # NOTE:  Flag -H for grep is non-POSIX but all of Busybox, GNU, macOS, and
 # OpenBSD support it.
 blocklist_imports() {
@@ -64,7 +68,11 @@ blocklist_imports() {
 
 	find_with_ignore \
 		-type 'f' \
-		'(' -name '*.go' '!' -name '*.pb.go' ')' \
+		'(' \
+		-name '*.go' \
+		'!' -name '*.pb.go' \
+		'!' -path './internal/cmd/yamlfields.go' \
+		')' \
 		-exec \
 		'grep' \
 		'-H' \